@@ -0,0 +1,196 @@
+// Package api provides a ready-made REST interface to a dlfetch.Fetcher, so any
+// application can offer a download manager over HTTP:
+//
+//	GET    /tasks       the MonitorSnapshot, optionally filtered with ?status=
+//	GET    /tasks/{id}  one DownloadTask
+//	POST   /downloads   enqueue a download, see Download
+//	DELETE /tasks/{id}  cancel a queued, running or paused download
+//	GET    /events      live progress as server-sent events or a WebSocket, see Events
+//
+// Mount the Handler under a prefix with http.StripPrefix, and put it behind
+// authentication: whoever can reach it can make the Fetcher download anything.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/hritikr/dlfetch"
+	"github.com/hritikr/dlfetch/internal/remote"
+)
+
+// maxBodySize bounds the JSON body of POST /downloads.
+const maxBodySize = 1 << 20
+
+// Download is the JSON body of POST /downloads.
+type Download struct {
+	ID       int               `json:"id,omitempty"` // 0 to let the handler pick a free ID
+	URL      string            `json:"url"`
+	FileName string            `json:"fileName,omitempty"`
+	Path     string            `json:"path,omitempty"` // Relative to the target directory
+	Headers  map[string]string `json:"headers,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Preset   string            `json:"preset,omitempty"`
+	Sink     string            `json:"sink,omitempty"`
+	Mirrors  []string          `json:"mirrors,omitempty"`
+	MaxSpeed int64             `json:"maxSpeed,omitempty"` // Bytes per second
+}
+
+// Handler serves the REST interface of Fetcher. The routes are set up on first use.
+type Handler struct {
+	Fetcher *dlfetch.Fetcher
+	Monitor dlfetch.Monitor // The monitor of Fetcher
+
+	// Prepare, if set, adjusts the requests posted to /downloads before they are
+	// enqueued, e.g. to set their Tenant from the authenticated user. An error
+	// rejects the request with status 400.
+	Prepare func(r *http.Request, req *dlfetch.DownloadRequest) error
+
+	once sync.Once
+	mux  *http.ServeMux
+	ids  remote.IDs
+}
+
+// errorBody is the JSON body of error responses.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// enqueued is the JSON body of a successful POST /downloads.
+type enqueued struct {
+	ID      int  `json:"id"`
+	Skipped bool `json:"skipped,omitempty"` // The file exists and the overwrite policy skips it
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.mux = http.NewServeMux()
+		h.mux.HandleFunc("GET /tasks", h.listTasks)
+		h.mux.HandleFunc("GET /tasks/{id}", h.getTask)
+		h.mux.HandleFunc("DELETE /tasks/{id}", h.cancelTask)
+		h.mux.HandleFunc("POST /downloads", h.enqueue)
+		h.mux.Handle("GET /events", &Events{Monitor: h.Monitor})
+	})
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) listTasks(w http.ResponseWriter, r *http.Request) {
+	snapshot := h.Monitor.GetSnapshot()
+	if status := r.URL.Query().Get("status"); status != "" {
+		snapshot.Tasks = slices.DeleteFunc(snapshot.Tasks, func(t dlfetch.DownloadTask) bool {
+			return string(t.Status) != status
+		})
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func (h *Handler) getTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := taskID(w, r)
+	if !ok {
+		return
+	}
+	task, ok := remote.Task(h.Monitor, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %d", dlfetch.ErrUnknownID, id))
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (h *Handler) cancelTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := taskID(w, r)
+	if !ok {
+		return
+	}
+	err := h.Fetcher.Cancel(id)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case !errors.Is(err, dlfetch.ErrUnknownID):
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		if _, known := remote.Task(h.Monitor, id); known {
+			writeError(w, http.StatusConflict, fmt.Errorf("download %d is already finished", id))
+			return
+		}
+		writeError(w, http.StatusNotFound, err)
+	}
+}
+
+func (h *Handler) enqueue(w http.ResponseWriter, r *http.Request) {
+	var d Download
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid download: %w", err))
+		return
+	}
+
+	req := dlfetch.DownloadRequest{
+		ID:       d.ID,
+		URL:      d.URL,
+		FileName: d.FileName,
+		Path:     d.Path,
+		Headers:  d.Headers,
+		Vars:     d.Vars,
+		Preset:   d.Preset,
+		Sink:     d.Sink,
+		Mirrors:  d.Mirrors,
+		MaxSpeed: d.MaxSpeed,
+	}
+	if err := remote.CheckRequest(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if h.Prepare != nil {
+		if err := h.Prepare(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	id, result := h.ids.Enqueue(h.Fetcher, h.Monitor, req)
+	if result.Error != nil {
+		writeError(w, enqueueStatus[remote.KindOf(result.Error)], result.Error)
+		return
+	}
+	if result.Skipped {
+		writeJSON(w, http.StatusOK, enqueued{ID: id, Skipped: true})
+		return
+	}
+	w.Header().Set("Location", "tasks/"+strconv.Itoa(id))
+	writeJSON(w, http.StatusAccepted, enqueued{ID: id})
+}
+
+// enqueueStatus is the response status of an Enqueue error by kind.
+var enqueueStatus = map[remote.ErrorKind]int{
+	remote.Invalid:     http.StatusBadRequest,
+	remote.Conflict:    http.StatusConflict,
+	remote.Exhausted:   http.StatusTooManyRequests,
+	remote.Forbidden:   http.StatusForbidden,
+	remote.Unavailable: http.StatusServiceUnavailable,
+}
+
+func taskID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid task id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hritikr/dlfetch"
+)
+
+func TestEnqueuePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain", `{"url":"http://127.0.0.1:1/x","fileName":"x.bin"}`, http.StatusAccepted},
+		{"subdirectory", `{"url":"http://127.0.0.1:1/x","path":"a/b","fileName":"x.bin"}`, http.StatusAccepted},
+		{"name from url", `{"url":"http://127.0.0.1:1/x"}`, http.StatusAccepted},
+		{"dot dot name", `{"url":"http://127.0.0.1:1/x","fileName":"../x.bin"}`, http.StatusBadRequest},
+		{"dot dot path", `{"url":"http://127.0.0.1:1/x","path":"a/../../b","fileName":"x.bin"}`, http.StatusBadRequest},
+		{"template name", `{"url":"http://127.0.0.1:1/x","fileName":"{{.Vars.n}}","vars":{"n":"../../../etc/evil"}}`, http.StatusBadRequest},
+		{"template path", `{"url":"http://127.0.0.1:1/x","path":"{{.Vars.n}}","fileName":"x","vars":{"n":".."}}`, http.StatusBadRequest},
+		{"target directory", `{"url":"http://127.0.0.1:1/x","path":"a","fileName":".."}`, http.StatusBadRequest},
+		{"stdout", `{"url":"http://127.0.0.1:1/x","fileName":"-"}`, http.StatusBadRequest},
+		{"no url", `{"fileName":"x.bin"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			monitor := dlfetch.NewMonitor()
+			h := &Handler{Fetcher: dlfetch.New(dlfetch.WithTargetDir(dir), dlfetch.WithMonitor(monitor)), Monitor: monitor}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/downloads", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			for _, task := range monitor.GetSnapshot().Tasks {
+				if rel, err := filepath.Rel(dir, task.FilePath); err != nil || !filepath.IsLocal(rel) {
+					t.Errorf("task %d writes to %s, outside of %s", task.ID, task.FilePath, dir)
+				}
+			}
+		})
+	}
+}
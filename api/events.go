@@ -0,0 +1,249 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+	"github.com/hritikr/dlfetch/internal/remote"
+)
+
+const (
+	// defaultEventInterval is the default of Events.Interval.
+	defaultEventInterval = 250 * time.Millisecond
+	// keepaliveInterval is how often an idle stream sends a comment or ping, so
+	// proxies do not close it and missed signals are caught up on.
+	keepaliveInterval = 15 * time.Second
+)
+
+// Update is a message of the event stream. The first one of a stream carries all
+// tasks, the following ones only what changed since the previous one.
+type Update struct {
+	Fetcher string                  `json:"fetcher,omitempty"`
+	Full    bool                    `json:"full,omitempty"`    // Tasks holds all tasks, replace what you have
+	Tasks   []dlfetch.DownloadTask  `json:"tasks,omitempty"`   // Tasks added or changed, by ID
+	Removed []int                   `json:"removed,omitempty"` // IDs of tasks removed, e.g. by TaskMonitor.Clear
+	Count   dlfetch.TaskStatusCount `json:"count"`
+}
+
+// Events streams the progress of the tasks of Monitor to web UIs as Updates, so
+// they can render it live without polling. Requests asking for a WebSocket
+// upgrade get one JSON text message per Update, all others a text/event-stream
+// of "update" events with the JSON in their data.
+type Events struct {
+	Monitor dlfetch.Monitor
+
+	// Interval is the minimum time between two Updates of a stream, 250ms if 0.
+	// Changes in between are merged into the next one.
+	Interval time.Duration
+
+	// CheckOrigin, if set, decides whether a WebSocket upgrade from the origin of
+	// the request is accepted. By default only requests without an Origin header
+	// or from the host serving them are.
+	CheckOrigin func(r *http.Request) bool
+
+	mu     sync.Mutex
+	subs   map[chan struct{}]struct{} // Streams waiting for changes
+	cancel context.CancelFunc         // Stops the relay, once no stream is left
+}
+
+func (e *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		e.serveWebSocket(w, r)
+		return
+	}
+	e.serveSSE(w, r)
+}
+
+func (e *Events) serveSSE(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	seq := 0
+	send := func(u Update) error {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		seq++
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", seq, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	keepalive := func() error {
+		if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	_ = e.stream(r.Context(), send, keepalive)
+}
+
+func (e *Events) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	checkOrigin := e.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("origin %q is not allowed", r.Header.Get("Origin")))
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	// The stream ends when the client closes the connection
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		_ = conn.readLoop()
+	}()
+
+	send := func(u Update) error {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return conn.writeFrame(opText, data)
+	}
+	keepalive := func() error {
+		return conn.writeFrame(opPing, nil)
+	}
+	if err := e.stream(ctx, send, keepalive); err == nil || ctx.Err() != nil {
+		_ = conn.writeFrame(opClose, closePayload(closeGoingAway))
+	}
+}
+
+// stream sends an Update with all tasks, and then one whenever tasks change,
+// until ctx is done or sending fails.
+func (e *Events) stream(ctx context.Context, send func(Update) error, keepalive func() error) error {
+	changed, unsubscribe := e.subscribe()
+	defer unsubscribe()
+	throttle := time.NewTimer(0)
+	defer throttle.Stop()
+	idle := time.NewTicker(keepaliveInterval)
+	defer idle.Stop()
+
+	var known map[int]dlfetch.DownloadTask
+	for {
+		update, tasks := diffSnapshot(known, e.Monitor.GetSnapshot())
+		if known == nil || len(update.Tasks) > 0 || len(update.Removed) > 0 {
+			if err := send(update); err != nil {
+				return err
+			}
+			idle.Reset(keepaliveInterval)
+		}
+		known = tasks
+
+		// Wait at least Interval, then for the next change
+		throttle.Reset(cmp.Or(e.Interval, defaultEventInterval))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-throttle.C:
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-idle.C:
+			// Signals can be missed, as other readers of EventSignal compete for them
+			if err := keepalive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscribe registers a stream for changes of the monitor. The returned channel
+// receives a value after changes, and unsubscribe must be called once the stream
+// ends.
+func (e *Events) subscribe() (changed chan struct{}, unsubscribe func()) {
+	changed = make(chan struct{}, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.subs) == 0 {
+		var ctx context.Context
+		ctx, e.cancel = context.WithCancel(context.Background())
+		go e.relay(ctx)
+	}
+	if e.subs == nil {
+		e.subs = make(map[chan struct{}]struct{})
+	}
+	e.subs[changed] = struct{}{}
+
+	return changed, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs, changed)
+		if len(e.subs) == 0 {
+			e.cancel()
+		}
+	}
+}
+
+// relay passes the EventSignal of the monitor on to every stream, as only one
+// reader receives each signal.
+func (e *Events) relay(ctx context.Context) {
+	for {
+		signal := e.Monitor.EventSignal()
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-signal:
+			if !ok {
+				// The Fetcher stopped; once it starts again, the monitor has a new channel
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}
+
+		e.mu.Lock()
+		for changed := range e.subs {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// diffSnapshot returns the Update from the tasks known to a stream, nil before the
+// first Update, to snapshot, and the tasks of snapshot by ID.
+func diffSnapshot(known map[int]dlfetch.DownloadTask, snapshot dlfetch.MonitorSnapshot) (Update, map[int]dlfetch.DownloadTask) {
+	update := Update{Fetcher: snapshot.Fetcher, Full: known == nil, Count: snapshot.Count}
+	tasks := make(map[int]dlfetch.DownloadTask, len(snapshot.Tasks))
+	for _, task := range snapshot.Tasks {
+		tasks[task.ID] = task
+		if prev, ok := known[task.ID]; !ok || !remote.SameTask(prev, task) {
+			update.Tasks = append(update.Tasks, task)
+		}
+	}
+	for id := range known {
+		if _, ok := tasks[id]; !ok {
+			update.Removed = append(update.Removed, id)
+		}
+	}
+	slices.Sort(update.Removed)
+	return update, tasks
+}
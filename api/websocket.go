@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The server side of RFC 6455, as much as Events needs: it writes unfragmented
+// messages and reads only to answer pings and closes.
+
+// websocketGUID is appended to the client's key to compute the accept header.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxFrameSize bounds the frames read from clients, which have nothing to send.
+const maxFrameSize = 64 << 10
+
+// Opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// Close status codes
+const (
+	closeGoingAway     = 1001
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+// wsConn is an upgraded WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex // Serializes writes
+	done bool       // A close frame was sent, nothing may follow
+}
+
+// isWebSocketUpgrade reports whether r asks for a WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// sameOrigin accepts requests without an Origin header, which do not come from
+// browsers, and those from the host serving them.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		err := errors.New("invalid websocket handshake")
+		writeError(w, http.StatusBadRequest, err)
+		return nil, err
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// writeFrame sends payload as a single unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return net.ErrClosed
+	}
+	c.done = opcode == opClose
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// readLoop reads frames until the client closes the connection or breaks the
+// protocol, answering pings along the way.
+func (c *wsConn) readLoop() error {
+	var header [2]byte
+	for {
+		if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+			return err
+		}
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+		n := int64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return err
+			}
+			n = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return err
+			}
+			n = int64(binary.BigEndian.Uint64(ext[:]))
+		}
+		if !masked {
+			// Clients must mask their frames
+			_ = c.writeFrame(opClose, closePayload(closeProtocolError))
+			return errors.New("unmasked websocket frame")
+		}
+		if n < 0 || n > maxFrameSize {
+			_ = c.writeFrame(opClose, closePayload(closeTooBig))
+			return errors.New("websocket frame too large")
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+			return err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.rw, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return err
+			}
+		case opClose:
+			// Echo the status code, then the connection is done
+			_ = c.writeFrame(opClose, payload[:min(len(payload), 2)])
+			return io.EOF
+		}
+	}
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
+
+func closePayload(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
+
+// headerHasToken reports whether the comma separated header name contains token,
+// ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
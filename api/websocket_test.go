@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientFrame encodes a frame as a client sends it, masked unless mask is nil.
+func clientFrame(opcode byte, payload []byte, mask []byte) []byte {
+	frame := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWriteFrame(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0xffff, []byte{0x81, 126, 0xff, 0xff}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		c := &wsConn{rw: bufio.NewReadWriter(nil, bufio.NewWriter(&out))}
+		payload := bytes.Repeat([]byte("x"), tt.size)
+		if err := c.writeFrame(opText, payload); err != nil {
+			t.Fatal(err)
+		}
+		if want := append(tt.header, payload...); !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("frame of %d bytes starts with % x, want % x", tt.size, out.Bytes()[:min(out.Len(), 10)], tt.header)
+		}
+	}
+
+	var out bytes.Buffer
+	c := &wsConn{rw: bufio.NewReadWriter(nil, bufio.NewWriter(&out))}
+	if err := c.writeFrame(opClose, closePayload(closeGoingAway)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.writeFrame(opText, []byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close: %v", err)
+	}
+}
+
+func TestReadLoop(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	frames := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	bigPing := bytes.Repeat([]byte("p"), 200)
+	tests := []struct {
+		name  string
+		in    []byte
+		out   []byte
+		err   string
+		isEOF bool
+	}{
+		{
+			name:  "ping and close",
+			in:    frames(clientFrame(opPing, []byte("hi"), mask), clientFrame(opClose, closePayload(1000), mask)),
+			out:   frames(clientFrame(opPong, []byte("hi"), nil), clientFrame(opClose, closePayload(1000), nil)),
+			isEOF: true,
+		},
+		{
+			name:  "extended length",
+			in:    clientFrame(opPing, bigPing, mask),
+			out:   clientFrame(opPong, bigPing, nil),
+			isEOF: true,
+		},
+		{
+			name:  "close with reason",
+			in:    clientFrame(opClose, append(closePayload(1000), "bye"...), mask),
+			out:   clientFrame(opClose, closePayload(1000), nil),
+			isEOF: true,
+		},
+		{
+			name:  "text ignored",
+			in:    clientFrame(opText, []byte("hello"), mask),
+			isEOF: true,
+		},
+		{
+			name: "unmasked",
+			in:   clientFrame(opPing, []byte("hi"), nil),
+			out:  clientFrame(opClose, closePayload(closeProtocolError), nil),
+			err:  "unmasked",
+		},
+		{
+			name: "too large",
+			in:   []byte{0x89, 0x80 | 127, 0, 0, 0, 0, 0, 1, 0, 1},
+			out:  clientFrame(opClose, closePayload(closeTooBig), nil),
+			err:  "too large",
+		},
+		{
+			name: "negative length",
+			in:   []byte{0x89, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 0},
+			out:  clientFrame(opClose, closePayload(closeTooBig), nil),
+			err:  "too large",
+		},
+		{
+			name: "truncated payload",
+			in:   clientFrame(opPing, []byte("hi"), mask)[:7],
+			err:  io.ErrUnexpectedEOF.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &wsConn{rw: bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(tt.in)), bufio.NewWriter(&out))}
+			err := c.readLoop()
+			if tt.isEOF && !errors.Is(err, io.EOF) || !tt.isEOF && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.out) {
+				t.Errorf("wrote % x, want % x", out.Bytes(), tt.out)
+			}
+		})
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.close()
+		conn.writeFrame(opText, []byte("hello"))
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The example handshake of RFC 6455
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("status %d, accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	frame, _ := io.ReadAll(br)
+	if want := clientFrame(opText, []byte("hello"), nil); !bytes.Equal(frame, want) {
+		t.Errorf("read % x, want % x", frame, want)
+	}
+
+	for _, header := range []string{"Sec-WebSocket-Key", "Sec-WebSocket-Version"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Del(header)
+		rec := httptest.NewRecorder()
+		if _, err := upgradeWebSocket(rec, r); err == nil || rec.Code != http.StatusBadRequest {
+			t.Errorf("handshake without %s: status %d, %v", header, rec.Code, err)
+		}
+	}
+}
+
+func TestWebSocketHeaders(t *testing.T) {
+	tests := []struct {
+		connection, upgrade, origin, host string
+		upgrades, sameOrigin              bool
+	}{
+		{"Upgrade", "websocket", "", "h", true, true},
+		{"keep-alive, upgrade", "WebSocket", "http://h", "h", true, true},
+		{"keep-alive", "websocket", "https://H", "h", false, true},
+		{"upgrade", "h2c", "http://evil", "h", false, false},
+		{"upgrade", "websocket", "http://h:8080", "h", true, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tt.host
+		r.Header.Set("Connection", tt.connection)
+		r.Header.Set("Upgrade", tt.upgrade)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := isWebSocketUpgrade(r); got != tt.upgrades {
+			t.Errorf("upgrade with %q, %q: %v", tt.connection, tt.upgrade, got)
+		}
+		if got := sameOrigin(r); got != tt.sameOrigin {
+			t.Errorf("origin %q on %q: %v", tt.origin, tt.host, got)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tailOverlap is how many of the last bytes of the local copy Tail fetches again
+// to tell a grown remote file from a replaced one.
+const tailOverlap = 4 << 10
+
+// Tail keeps a local copy of a growing remote file (log files, CSV exports) up to date.
+// Every interval it requests only the bytes past the current local size using a Range
+// header and appends them to the file. The ETag of the previous fetch is sent as
+// If-None-Match so an unchanged remote file costs a single 304 round-trip.
+// The range starts a few KiB before the end of the local copy, and the remote file
+// counts as replaced if these bytes differ or it got shorter, as happens when a log
+// is rotated or truncated. A replaced file, like a server without range support,
+// gets the local file rewritten from scratch; an ETag would not tell replaced from
+// grown, as it changes with every append.
+//
+// Each fetch that writes new data triggers onComplete, failed fetches trigger onError
+// and are retried on the next tick. Tail blocks until ctx is cancelled, interval
+// must be positive.
+func (f *Fetcher) Tail(ctx context.Context, req DownloadRequest, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid tail interval %v: must be positive", interval)
+	}
+	if err := f.resolvePath(&req); err != nil {
+		return err
+	}
+
+	if err := ensureDir(req.FullPath); err != nil {
+		return err
+	}
+
+	if err := f.claimPath(req.FullPath); err != nil {
+		return err
+	}
+	defer f.releasePath(req.FullPath)
+
+	if err := f.claimID(req.ID); err != nil {
+		return err
+	}
+	defer f.releaseID(req.ID)
+
+	if err := f.monitor.add(req); err != nil {
+		return err
+	}
+	f.monitor.setQueued(req.ID, false)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var etag string
+	for {
+		result, newETag, err := f.appendOnce(ctx, req, etag, false)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Cancelled mid-fetch, not a download failure
+		case err != nil:
+			f.monitor.markAsFailed(req.ID, err)
+			if f.onError != nil {
+				f.onError(req, err)
+			}
+		case result != nil:
+			etag = newETag
+			f.monitor.markAsCompleted(req.ID)
+			if f.onComplete != nil {
+				f.onComplete(*result)
+			}
+		default:
+			etag = newETag
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// appendOnce fetches whatever was added to the remote file since the last call,
+// or all of it if fresh is set. It returns a nil result when there was nothing new
+// to write.
+func (f *Fetcher) appendOnce(ctx context.Context, req DownloadRequest, etag string, fresh bool) (*DownloadResult, string, error) {
+	var localSize int64
+	if info, err := os.Stat(req.FullPath); err == nil && !fresh {
+		localSize = info.Size()
+	}
+	from := max(0, localSize-tailOverlap)
+
+	httpReq, err := http.NewRequestWithContext(withProxy(ctx, req), http.MethodGet, req.URL, nil)
+	if err != nil {
+		return nil, etag, err
+	}
+	setHeaders(httpReq, req.Headers)
+	if localSize > 0 {
+		httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-", from))
+	}
+	if etag != "" && !fresh {
+		httpReq.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return nil, etag, err
+	}
+	defer resp.Body.Close()
+
+	mw := &monitorWriter{
+		id:      req.ID,
+		written: localSize,
+		monitor: f.monitor,
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		// Nothing new since the last fetch
+		return nil, etag, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		if localSize == 0 {
+			return nil, etag, nil
+		}
+		// Shorter than the local copy, the remote file was truncated or rotated
+		resp.Body.Close()
+		return f.appendOnce(ctx, req, etag, true)
+
+	case http.StatusPartialContent:
+		start, ok := parseContentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != from {
+			return nil, etag, fmt.Errorf("unexpected content range for append: %q, requested start: %d", resp.Header.Get("Content-Range"), from)
+		}
+		same, err := sameTail(req.FullPath, resp.Body, from, localSize-from)
+		if err != nil {
+			return nil, etag, err
+		}
+		if !same {
+			// The bytes already held changed, the remote file was replaced
+			resp.Body.Close()
+			return f.appendOnce(ctx, req, etag, true)
+		}
+		mw.total = UnknownSize
+		if resp.ContentLength > 0 {
+			mw.total = from + resp.ContentLength
+		}
+
+		out, err := os.OpenFile(req.FullPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, etag, err
+		}
+		n, err := io.Copy(out, io.TeeReader(throttle(ctx, resp.Body, f.speedLimits(req)), mw))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, etag, err
+		}
+		if n == 0 {
+			return nil, resp.Header.Get("ETag"), nil
+		}
+
+	case http.StatusOK:
+		// Full body, rewrite the local copy
+		mw.written = 0
+		mw.total = resolveFileSize(resp)
+
+		tmpPath := f.currentPolicy().stagingPath(req.FullPath)
+		out, err := os.Create(tmpPath)
+		if err != nil {
+			return nil, etag, err
+		}
+		if _, err := io.Copy(out, io.TeeReader(throttle(ctx, resp.Body, f.speedLimits(req)), mw)); err != nil {
+			out.Close()
+			_ = os.Remove(tmpPath)
+			return nil, etag, err
+		}
+		if err := out.Close(); err != nil {
+			_ = os.Remove(tmpPath)
+			return nil, etag, err
+		}
+		if err := os.Rename(tmpPath, req.FullPath); err != nil {
+			return nil, etag, err
+		}
+
+	default:
+		return nil, etag, fmt.Errorf("failed to fetch appended data: %s, status code: %d", req.URL, resp.StatusCode)
+	}
+
+	return &DownloadResult{
+		ID:       req.ID,
+		FileName: req.FileName,
+		Path:     req.FullPath,
+		MimeType: f.detectMimeType(req, resp.Header.Get("Content-Type"), req.FullPath),
+	}, resp.Header.Get("ETag"), nil
+}
+
+// sameTail reports whether the next n bytes of r match the n bytes at off of the
+// file at path. A remote file that ends before them does not match.
+func sameTail(path string, r io.Reader, off, n int64) (bool, error) {
+	if n == 0 {
+		return true, nil
+	}
+	local := make([]byte, n)
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.ReadAt(local, off); err != nil {
+		return false, err
+	}
+
+	remote := make([]byte, n)
+	if _, err := io.ReadFull(r, remote); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(local, remote), nil
+}
+
+// parseContentRangeStart returns the first byte position of a
+// "bytes start-end/total" Content-Range header.
+func parseContentRangeStart(cr string) (int64, bool) {
+	cr = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cr), "bytes"))
+	dash := strings.Index(cr, "-")
+	if dash == -1 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(cr[:dash]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
@@ -0,0 +1,175 @@
+package dlfetch
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxThrottleChunk bounds single reads of a throttled body so the rate stays smooth.
+const maxThrottleChunk = 32 << 10
+
+// WithMaxBandwidth limits the aggregate download speed of all workers to bytesPerSec,
+// e.g. when dlfetch shares a network link. Zero or less means unlimited.
+func WithMaxBandwidth(bytesPerSec int64) FetcherOption {
+	return func(f *Fetcher) {
+		f.bandwidth.setRate(bytesPerSec)
+	}
+}
+
+// tokenBucket is a rate limiter shared by the readers it throttles.
+// A rate of zero or less lets everything through.
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64 // Bytes per second
+	tokens  float64
+	last    time.Time
+	used    int64     // Bytes taken since the last takeUsed
+	lastUse time.Time // When bytes were last taken
+}
+
+func newTokenBucket(bytesPerSec int64) *tokenBucket {
+	b := &tokenBucket{}
+	b.setRate(bytesPerSec)
+	return b
+}
+
+// setRate changes the rate, readers waiting on the bucket pick it up with their next read.
+func (b *tokenBucket) setRate(bytesPerSec int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(bytesPerSec)
+	b.tokens = 0
+	b.last = time.Now()
+}
+
+// limit returns the current rate, zero or less when unlimited.
+func (b *tokenBucket) limit() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.rate)
+}
+
+// retune changes the rate like setRate, but keeps the tokens collected so far
+// so readers are not held up by the change.
+func (b *tokenBucket) retune(bytesPerSec float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		b.tokens = 0
+		b.last = time.Now()
+	}
+	b.rate = bytesPerSec
+	b.tokens = min(b.tokens, b.rate)
+}
+
+// limitRate returns the current rate, zero or less when unlimited.
+func (b *tokenBucket) limitRate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// takeUsed returns how many bytes were taken from the bucket since the last call.
+func (b *tokenBucket) takeUsed() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := b.used
+	b.used = 0
+	return used
+}
+
+// usage returns the bytes taken since the last takeUsed and when bytes were last taken.
+func (b *tokenBucket) usage() (int64, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used, b.lastUse
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before using them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += int64(n)
+	if n > 0 {
+		b.lastUse = time.Now()
+	}
+	if b.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	// Allow bursts of up to a second's worth
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttledReader limits how fast r is read through a set of token buckets.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*tokenBucket
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > maxThrottleChunk {
+		p = p[:maxThrottleChunk]
+	}
+	n, err := t.r.Read(p)
+
+	// Every bucket is charged, the strictest one decides the wait
+	var delay time.Duration
+	for _, b := range t.buckets {
+		delay = max(delay, b.reserve(n))
+	}
+	if delay <= 0 {
+		return n, err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-t.ctx.Done():
+		if err == nil {
+			err = t.ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// speedLimits returns the token buckets a download of req is subject to: the global
+// limit, the one shared with other Fetchers, the throttle hook's, its tenant's, its
+// fair share and, with MaxSpeed set, one of its own shared by all its connections.
+func (f *Fetcher) speedLimits(req DownloadRequest) []*tokenBucket {
+	limits := []*tokenBucket{f.bandwidth}
+	if f.shared != nil {
+		limits = append(limits, f.shared.bandwidth)
+	}
+	if f.throttle != nil {
+		limits = append(limits, f.throttle.bandwidth)
+	}
+	if req.MaxSpeed > 0 {
+		limits = append(limits, newTokenBucket(req.MaxSpeed))
+	}
+	if req.share != nil {
+		limits = append(limits, req.share.bucket)
+	}
+	if req.activity != nil {
+		// Unlimited, counts the bytes for DebugState
+		limits = append(limits, req.activity.counter)
+	}
+	if f.tenants != nil {
+		if b := f.tenants.bucket(req.Tenant); b != nil {
+			limits = append(limits, b)
+		}
+	}
+	return limits
+}
+
+// throttle limits how fast a response body is read.
+func throttle(ctx context.Context, r io.Reader, limits []*tokenBucket) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, buckets: limits}
+}
@@ -0,0 +1,108 @@
+package dlfetch
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseBatch reads a list of downloads: one URL per line, blank lines and lines
+// starting with "#" ignored. The aria2 input file format is supported as well:
+// indented "key=value" lines after a URL set options for it.
+//
+//	https://example.com/file.iso
+//	  out=ubuntu.iso
+//	  dir=isos
+//	  checksum=sha-256=<hex>
+//
+// "out" sets the file name and "dir" the path relative to the target directory;
+// values leading out of it are refused. "checksum" sets the expected digest, one of
+// md5, sha-1, sha-256 and sha-512; downloads that do not match it fail with
+// ErrChecksumMismatch. Additional tab separated URIs on the URL line become the
+// request's Mirrors. Other aria2 options are ignored. Request IDs are assigned
+// from 1 in file order.
+func ParseBatch(r io.Reader) ([]DownloadRequest, error) {
+	var reqs []DownloadRequest
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			uris := strings.FieldsFunc(trimmed, func(r rune) bool { return r == '\t' })
+			reqs = append(reqs, DownloadRequest{ID: len(reqs) + 1, URL: uris[0], Mirrors: uris[1:]})
+			continue
+		}
+
+		// Option line for the previous URL
+		if len(reqs) == 0 {
+			return nil, fmt.Errorf("invalid batch line %d: option before any URL", lineNo)
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid batch line %d: expected key=value", lineNo)
+		}
+		if err := applyBatchOption(&reqs[len(reqs)-1], strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("invalid batch line %d: %w", lineNo, err)
+		}
+	}
+	return reqs, scanner.Err()
+}
+
+// LoadBatch reads a batch file, see ParseBatch.
+func LoadBatch(path string) ([]DownloadRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseBatch(file)
+}
+
+func applyBatchOption(req *DownloadRequest, key, value string) error {
+	switch key {
+	case "out":
+		dir, name := filepath.Split(filepath.FromSlash(value))
+		if name == "" || !filepath.IsLocal(filepath.FromSlash(value)) {
+			return fmt.Errorf("out must be a file name below the target directory: %s", value)
+		}
+		req.FileName = name
+		if dir != "" {
+			req.Path = filepath.Join(req.Path, dir)
+		}
+	case "dir":
+		// Unlike aria2, absolute directories are refused: files stay in the target directory
+		if !filepath.IsLocal(filepath.FromSlash(value)) {
+			return fmt.Errorf("dir must be relative to the target directory: %s", value)
+		}
+		req.Path = filepath.Join(filepath.FromSlash(value), req.Path)
+	case "checksum":
+		algo, sum, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("checksum must be <algorithm>=<digest>: %s", value)
+		}
+		// aria2 spells SHA-1 as "sha-1", digest headers as "sha"
+		algo = strings.ToLower(algo)
+		if algo == "sha-1" {
+			algo = "sha"
+		}
+		sum = strings.ToLower(sum)
+		h, ok := digestAlgorithms[algo]
+		if !ok {
+			return fmt.Errorf("unsupported checksum algorithm: %s", value)
+		}
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != h().Size() {
+			return fmt.Errorf("invalid checksum: %s", value)
+		}
+		req.checksum, req.checksumAlg = sum, algo
+	}
+	return nil
+}
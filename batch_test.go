@@ -0,0 +1,68 @@
+package dlfetch
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBatch(t *testing.T) {
+	sha256Hex := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		input   string
+		want    []DownloadRequest
+		wantErr string
+	}{
+		{
+			name:  "urls",
+			input: "# list\nhttps://a/1\n\n  \nhttps://a/2\thttps://b/2\thttps://c/2\r\n",
+			want: []DownloadRequest{
+				{ID: 1, URL: "https://a/1", Mirrors: []string{}},
+				{ID: 2, URL: "https://a/2", Mirrors: []string{"https://b/2", "https://c/2"}},
+			},
+		},
+		{
+			name:  "aria2 options",
+			input: "https://a/file.iso\n  out=sub/ubuntu.iso\n\tdir=isos\n  checksum=SHA-256=" + strings.ToUpper(sha256Hex) + "\n  split=4\n",
+			want: []DownloadRequest{{
+				ID: 1, URL: "https://a/file.iso", Mirrors: []string{},
+				Path: filepath.Join("isos", "sub"), FileName: "ubuntu.iso",
+				checksum: sha256Hex, checksumAlg: "sha-256",
+			}},
+		},
+		{
+			name:  "sha-1",
+			input: "https://a/f\n  checksum=sha-1=" + strings.Repeat("0", 40) + "\n",
+			want:  []DownloadRequest{{ID: 1, URL: "https://a/f", Mirrors: []string{}, checksum: strings.Repeat("0", 40), checksumAlg: "sha"}},
+		},
+		{name: "option first", input: "  out=x\nhttps://a/f\n", wantErr: "line 1: option before any URL"},
+		{name: "no value", input: "https://a/f\n  out\n", wantErr: "line 2: expected key=value"},
+		{name: "out leaves", input: "https://a/f\n\n  out=../x\n", wantErr: "line 3: out must be"},
+		{name: "out absolute", input: "https://a/f\n  out=/etc/passwd\n", wantErr: "line 2: out must be"},
+		{name: "out directory", input: "https://a/f\n  out=sub/\n", wantErr: "line 2: out must be"},
+		{name: "dir leaves", input: "https://a/f\n  dir=a/../..\n", wantErr: "line 2: dir must be"},
+		{name: "dir absolute", input: "https://a/f\n  dir=/tmp\n", wantErr: "line 2: dir must be"},
+		{name: "checksum format", input: "https://a/f\n  checksum=" + sha256Hex + "\n", wantErr: "line 2: checksum must be"},
+		{name: "checksum algorithm", input: "https://a/f\n  checksum=crc32=00000000\n", wantErr: "line 2: unsupported checksum algorithm"},
+		{name: "checksum length", input: "https://a/f\n  checksum=sha-256=abcd\n", wantErr: "line 2: invalid checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := ParseBatch(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reqs, tt.want) {
+				t.Errorf("requests\n%+v\nwant\n%+v", reqs, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package dlfetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// CacheEntry describes the last completed download of a URL.
+type CacheEntry struct {
+	Path         string    `json:"path"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+// CacheIndex maps URLs to their last completed download so periodic sync jobs
+// can skip files that did not change. It is persisted as a JSON object in a file,
+// rewritten on every change.
+type CacheIndex struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]CacheEntry
+}
+
+// OpenCacheIndex loads the cache index at path. A missing file is an empty index.
+func OpenCacheIndex(path string) (*CacheIndex, error) {
+	c := &CacheIndex{
+		path:    path,
+		entries: make(map[string]CacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithCacheIndex records every completed download in the index, which
+// EnqueueIfChanged consults to skip unchanged files.
+func WithCacheIndex(c *CacheIndex) FetcherOption {
+	return func(f *Fetcher) {
+		f.cache = c
+	}
+}
+
+// Lookup returns the entry recorded for the URL.
+func (c *CacheIndex) Lookup(url string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[url]
+	return e, ok
+}
+
+// Forget removes the entry of the URL, so it is downloaded again by EnqueueIfChanged.
+func (c *CacheIndex) Forget(url string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, url)
+	return c.save()
+}
+
+// record stores the completed download of the URL.
+func (c *CacheIndex) record(url string, result DownloadResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[url] = CacheEntry{
+		Path:         result.Path,
+		ETag:         result.ETag,
+		LastModified: result.LastModified,
+		Size:         result.Size,
+		SHA256:       result.SHA256,
+		DownloadedAt: time.Now(),
+	}
+	return c.save()
+}
+
+// save writes the index through a temporary file so a crash never leaves it truncated.
+func (c *CacheIndex) save() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// EnqueueIfChanged enqueues the request unless the cache index shows that the
+// URL was already downloaded, the file is still in place and the server confirms
+// through a conditional HEAD request that it did not change. Unchanged requests are
+// not queued and report ErrNotModified. A changed file replaces the previous copy,
+// whatever the overwrite setting.
+// Without a cache index (WithCacheIndex) it behaves like Enqueue.
+func (f *Fetcher) EnqueueIfChanged(req DownloadRequest) EnqueueResult {
+	if f.cache == nil {
+		return f.Enqueue(req)
+	}
+
+	entry, ok := f.cache.Lookup(req.URL)
+	if !ok {
+		return f.Enqueue(req)
+	}
+
+	if info, err := os.Stat(entry.Path); err == nil && info.Size() == entry.Size {
+		changed, err := f.remoteChanged(req, entry)
+		if err != nil {
+			return EnqueueResult{Queued: false, Error: err}
+		}
+		if !changed {
+			return EnqueueResult{Queued: false, Error: fmt.Errorf("%w: %s", ErrNotModified, req.URL)}
+		}
+	}
+
+	req.overwrite = true
+	return f.Enqueue(req)
+}
+
+// remoteChanged asks the server whether the file differs from the cached entry.
+func (f *Fetcher) remoteChanged(req DownloadRequest, entry CacheEntry) (bool, error) {
+	if entry.ETag == "" && entry.LastModified == "" {
+		// Nothing to compare against
+		return true, nil
+	}
+
+	httpReq, err := http.NewRequestWithContext(withProxy(req.context(), req), http.MethodHead, req.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	setHeaders(httpReq, req.Headers)
+	if entry.ETag != "" {
+		httpReq.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		httpReq.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// Some servers ignore conditional headers on HEAD requests
+		if entry.ETag != "" && resp.Header.Get("ETag") != "" {
+			return resp.Header.Get("ETag") != entry.ETag, nil
+		}
+		if entry.LastModified != "" && resp.Header.Get("Last-Modified") != "" {
+			return resp.Header.Get("Last-Modified") != entry.LastModified, nil
+		}
+		return true, nil
+	default:
+		return false, &HTTPStatusError{URL: req.URL, StatusCode: resp.StatusCode}
+	}
+}
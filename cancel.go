@@ -0,0 +1,115 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+)
+
+// Cancel aborts the request with the given ID, whether it is queued, downloading or
+// paused. Its staging file is removed, the task is marked as cancelled in the
+// monitor and onError receives ErrCancelled. It returns ErrUnknownID if no queued,
+// running or paused request has the ID.
+//
+// A request cancelled while its post-processors or upload run is aborted through
+// their context and ends up cancelled as well. Its file is left wherever the steps
+// that finished put it; no done marker is written and nothing is saved to the ResultStore.
+func (f *Fetcher) Cancel(id int) error {
+	return f.cancelRequest(id, ErrCancelled)
+}
+
+// cancelRequest aborts the request with the given ID like Cancel, reporting cause.
+func (f *Fetcher) cancelRequest(id int, cause error) error {
+	f.cancelsMu.Lock()
+	h, ok := f.cancels[id]
+	paused, isPaused := f.paused[id]
+	if !ok && isPaused {
+		delete(f.paused, id)
+	}
+	f.cancelsMu.Unlock()
+
+	switch {
+	case ok:
+		h.cancel(cause)
+	case isPaused:
+		removeStaging(f.currentPolicy().stagingPath(paused.FullPath))
+		f.monitor.markAsCancelled(id, cause)
+		f.record(paused, DownloadResult{}, cause, 0)
+		f.releaseID(id)
+		f.notify(paused, DownloadResult{}, cause)
+		f.untrack()
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownID, id)
+	}
+	return nil
+}
+
+// postProcessContext returns the context for the steps after the file is in place.
+// It follows ctx but ignores Pause, since a download cannot be resumed once it has
+// been moved into place: a request paused during post-processing simply completes.
+func postProcessContext(ctx context.Context, req DownloadRequest) (context.Context, func()) {
+	postCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if !isPaused(req) {
+			cancel(context.Cause(ctx))
+		}
+	})
+	return postCtx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// claimID reserves the ID of a request while it is queued, running or paused, so
+// that Cancel, Pause and the monitor can tell requests apart whatever the monitor.
+// It returns ErrDuplicateID if another request holds the ID.
+func (f *Fetcher) claimID(id int) error {
+	f.cancelsMu.Lock()
+	defer f.cancelsMu.Unlock()
+	if _, ok := f.activeIDs[id]; ok {
+		return fmt.Errorf("%w: %d", ErrDuplicateID, id)
+	}
+	if f.activeIDs == nil {
+		f.activeIDs = make(map[int]struct{})
+	}
+	f.activeIDs[id] = struct{}{}
+	return nil
+}
+
+// releaseID frees an ID claimed with claimID for the next request.
+func (f *Fetcher) releaseID(id int) {
+	f.cancelsMu.Lock()
+	defer f.cancelsMu.Unlock()
+	delete(f.activeIDs, id)
+}
+
+// cancelHandle allows cancelling a queued or running request.
+type cancelHandle struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// makeCancellable gives the request a context that Cancel can cancel.
+func (f *Fetcher) makeCancellable(req *DownloadRequest) {
+	if req.parent == nil {
+		req.parent = req.context()
+	}
+	req.ctx, req.cancel = context.WithCancelCause(req.parent)
+
+	f.cancelsMu.Lock()
+	defer f.cancelsMu.Unlock()
+	if f.cancels == nil {
+		f.cancels = make(map[int]cancelHandle)
+	}
+	f.cancels[req.ID] = cancelHandle{ctx: req.ctx, cancel: req.cancel}
+}
+
+// forgetCancel releases the context of a request that finished or was not queued.
+func (f *Fetcher) forgetCancel(req DownloadRequest) {
+	f.cancelsMu.Lock()
+	// The ID may have been reused already, e.g. by a callback enqueuing it again
+	if h, ok := f.cancels[req.ID]; ok && h.ctx == req.ctx {
+		delete(f.cancels, req.ID)
+	}
+	f.cancelsMu.Unlock()
+	req.cancel(nil)
+}
@@ -0,0 +1,101 @@
+package dlfetch
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Category is the broad kind of a downloaded file.
+type Category string
+
+const (
+	CategoryImage    Category = "image"
+	CategoryVideo    Category = "video"
+	CategoryAudio    Category = "audio"
+	CategoryArchive  Category = "archive"
+	CategoryDocument Category = "document"
+	CategoryText     Category = "text"
+	CategoryOther    Category = "other"
+)
+
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-tar":            true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+}
+
+var archiveExtensions = []string{
+	".zip", ".tar", ".gz", ".tgz", ".bz2", ".tbz2", ".xz", ".txz", ".zst", ".7z", ".rar",
+}
+
+var documentTypes = map[string]bool{
+	"application/pdf":                                 true,
+	"application/msword":                              true,
+	"application/rtf":                                 true,
+	"application/vnd.ms-excel":                        true,
+	"application/vnd.ms-powerpoint":                   true,
+	"application/vnd.oasis.opendocument.text":         true,
+	"application/vnd.oasis.opendocument.spreadsheet":  true,
+	"application/vnd.oasis.opendocument.presentation": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/epub+zip": true,
+}
+
+var documentExtensions = []string{
+	".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".rtf", ".epub",
+}
+
+// IsArchive reports whether the file is a compressed file or archive such as zip, tar or gzip.
+func (d *DownloadResult) IsArchive() bool {
+	return d.matches(archiveTypes, archiveExtensions)
+}
+
+// IsDocument reports whether the file is an office document, PDF or e-book.
+func (d *DownloadResult) IsDocument() bool {
+	return d.matches(documentTypes, documentExtensions)
+}
+
+// Category returns the broad kind of the file, CategoryOther if none applies.
+func (d *DownloadResult) Category() Category {
+	switch {
+	case d.IsImage():
+		return CategoryImage
+	case d.IsVideo():
+		return CategoryVideo
+	case d.IsAudio():
+		return CategoryAudio
+	case d.IsDocument():
+		return CategoryDocument
+	case d.IsArchive():
+		return CategoryArchive
+	case d.isOfType("text"):
+		return CategoryText
+	}
+	return CategoryOther
+}
+
+// matches checks the MIME type against types and, failing that, the file name against extensions.
+func (d *DownloadResult) matches(types map[string]bool, extensions []string) bool {
+	if mediaType, _, err := mime.ParseMediaType(d.MimeType); err == nil && types[mediaType] {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(d.FileName))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
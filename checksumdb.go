@@ -0,0 +1,81 @@
+package dlfetch
+
+import (
+	"cmp"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ChecksumLookup finds the known-good SHA-256 of a file before it is downloaded,
+// e.g. in a database or an internal service that an organization uses to manage
+// the integrity of its artifacts centrally, see WithChecksumLookup.
+type ChecksumLookup interface {
+	// LookupChecksum returns the hex encoded SHA-256 of the file at url, or an
+	// empty string if it is not known. fileName is the name the file is saved as,
+	// empty if it is only known once the server answers. An error fails the
+	// attempt, it is retried like a failed download.
+	LookupChecksum(ctx context.Context, url, fileName string) (string, error)
+}
+
+// ChecksumLookupFunc adapts a function to a ChecksumLookup.
+type ChecksumLookupFunc func(ctx context.Context, url, fileName string) (string, error)
+
+func (fn ChecksumLookupFunc) LookupChecksum(ctx context.Context, url, fileName string) (string, error) {
+	return fn(ctx, url, fileName)
+}
+
+// WithChecksumLookup looks up the expected SHA-256 of every download with l before
+// it starts, and fails downloads that do not match with ErrChecksumMismatch.
+// Downloads of a mirror are looked up by the URL of the request; downloads of a
+// Range are not checked.
+func WithChecksumLookup(l ChecksumLookup) FetcherOption {
+	return func(f *Fetcher) {
+		f.checksums = l
+	}
+}
+
+// lookupChecksum sets the expected SHA-256 of req, if known. A checksum that came
+// with the request, e.g. from a batch file, is kept.
+func (f *Fetcher) lookupChecksum(ctx context.Context, req *DownloadRequest) error {
+	if f.checksums == nil || req.Range != nil || req.checksumAlg != "" {
+		return nil
+	}
+	url := cmp.Or(req.mirrorOf, req.URL)
+	fileName := req.FileName
+	if req.nameFromURL {
+		// A Content-Disposition name may still replace it
+		fileName = ""
+	}
+	sum, err := f.checksums.LookupChecksum(ctx, url, fileName)
+	if err != nil {
+		return fmt.Errorf("checksum lookup for %s failed: %w", url, err)
+	}
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if sum == "" {
+		return nil
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return fmt.Errorf("checksum lookup for %s returned an invalid SHA-256: %q", url, sum)
+	}
+	req.checksum = sum
+	return nil
+}
+
+// checkChecksum compares the digest of the content of req with the one looked up
+// or given in a batch file. sha256Sum is the SHA-256 of the content, digests
+// computed the others.
+func checkChecksum(req DownloadRequest, digests *digester, sha256Sum []byte) error {
+	if req.checksum == "" {
+		return nil
+	}
+	alg, sum := cmp.Or(req.checksumAlg, "sha-256"), sha256Sum
+	if alg != "sha-256" {
+		sum = digests.hashes[alg].Sum(nil)
+	}
+	if got := hex.EncodeToString(sum); got != req.checksum {
+		return fmt.Errorf("%w: %s of %s is %s, expected %s", ErrChecksumMismatch, alg, req.URL, got, req.checksum)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package dlfetch
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// chromeEpochOffset is the number of seconds between 1601-01-01, the epoch of
+// Chrome's timestamps, and the Unix epoch.
+const chromeEpochOffset = 11644473600
+
+// ReadChromeCookies reads the cookies of a Chrome or Chromium profile directory,
+// e.g. ~/.config/google-chrome/Default, with the sqlite3 command line tool like
+// ReadFirefoxCookies.
+//
+// Chrome encrypts the values with a key the operating system keeps: on macOS it is
+// read from the keychain with the security tool, which may ask for permission; on
+// Linux from the Secret Service with secret-tool, or Chrome's fixed key when it runs
+// without a keyring; on Windows it is unprotected with DPAPI. Values under the
+// app-bound encryption of Chrome 127 and later on Windows can only be read by
+// Chrome itself. Cookies that cannot be decrypted are left out, and the returned
+// error tells how many; the others are returned all the same.
+func ReadChromeCookies(profileDir string) ([]*http.Cookie, error) {
+	// Chrome 96 moved the database into the Network directory
+	path := filepath.Join(profileDir, "Network", "Cookies")
+	if !checkFileExists(path) {
+		path = filepath.Join(profileDir, "Cookies")
+	}
+	rows, err := querySQLite(path, 9,
+		"SELECT (SELECT value FROM meta WHERE key = 'version'), host_key, path, is_secure, is_httponly, expires_utc, name, hex(encrypted_value), value FROM cookies")
+	if err != nil {
+		return nil, err
+	}
+
+	keys, keysErr := loadChromeKeys(profileDir)
+	var cookies []*http.Cookie
+	var failed int
+	var decryptErr error
+	for _, fields := range rows {
+		value := fields[8]
+		if encrypted, _ := hex.DecodeString(fields[7]); len(encrypted) > 0 {
+			version, _ := strconv.Atoi(fields[0])
+			if value, err = keys.decrypt(encrypted, version); err != nil {
+				failed++
+				decryptErr = err
+				continue
+			}
+		}
+
+		// Microseconds since 1601, 0 for session cookies
+		expires, _ := strconv.ParseInt(fields[5], 10, 64)
+		if expires > 0 {
+			expires = max(1, expires/1e6-chromeEpochOffset)
+		}
+		cookies = append(cookies, newImportedCookie(
+			fields[1], strings.HasPrefix(fields[1], "."), fields[2],
+			fields[3] == "1", fields[4] == "1", expires, fields[6], value,
+		))
+	}
+	if failed > 0 {
+		// The key may be missing for a reason, e.g. secret-tool not being installed
+		return cookies, fmt.Errorf("%d of %d Chrome cookies could not be decrypted: %w", failed, len(rows), errors.Join(keysErr, decryptErr))
+	}
+	return cookies, nil
+}
+
+// chromeKeys are the keys Chrome encrypts cookie values with.
+type chromeKeys struct {
+	cbc map[string][]byte // AES-128-CBC keys by value prefix, on Linux and macOS
+	gcm []byte            // AES-256-GCM key of "v10" values, on Windows
+}
+
+// chromeProduct returns the name Chrome or Chromium stores its key under, judging
+// by the profile directory.
+func chromeProduct(profileDir string) string {
+	if strings.Contains(strings.ToLower(profileDir), "chromium") {
+		return "Chromium"
+	}
+	return "Chrome"
+}
+
+// chromeCBCKey derives the AES-128-CBC key of Linux and macOS from password.
+func chromeCBCKey(password string, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha1.New, password, []byte("saltysalt"), iterations, 16)
+}
+
+// decrypt decrypts an encrypted_value of the cookies table. Since version 24 of the
+// database the value is preceded by the SHA-256 of the cookie's domain.
+func (k chromeKeys) decrypt(value []byte, version int) (string, error) {
+	prefix, data := string(value[:min(3, len(value))]), value[min(3, len(value)):]
+	var plain []byte
+	var err error
+	switch key, ok := k.cbc[prefix]; {
+	case ok:
+		plain, err = decryptCBC(key, data)
+	case prefix == "v10" && k.gcm != nil:
+		plain, err = decryptGCM(k.gcm, data)
+	case prefix == "v20":
+		return "", errors.New("app-bound encryption is not supported")
+	case prefix == "v10" || prefix == "v11":
+		return "", fmt.Errorf("no key for %s values", prefix)
+	default:
+		// Values stored before Chrome 80 on Windows are protected with DPAPI alone
+		plain, err = dpapiDecrypt(value)
+	}
+	if err != nil {
+		return "", err
+	}
+	if version >= 24 {
+		if len(plain) < 32 {
+			return "", errors.New("decrypted value too short")
+		}
+		plain = plain[32:]
+	}
+	return string(plain), nil
+}
+
+func decryptCBC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted value size")
+	}
+	plain := make([]byte, len(data))
+	iv := bytes.Repeat([]byte{' '}, aes.BlockSize)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// PKCS #7 padding, which a wrong key garbles
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("wrong key or corrupt value")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+func decryptGCM(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted value size")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
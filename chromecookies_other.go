@@ -0,0 +1,46 @@
+//go:build !windows
+
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// loadChromeKeys derives the keys of the cookie values from the password Chrome
+// keeps in the macOS keychain or the Secret Service. On Linux "v10" values use a
+// fixed password, "v11" values the one in the Secret Service.
+func loadChromeKeys(profileDir string) (chromeKeys, error) {
+	product := chromeProduct(profileDir)
+	if runtime.GOOS == "darwin" {
+		out, err := exec.Command("security", "find-generic-password", "-w", "-s", product+" Safe Storage").Output()
+		if err != nil {
+			return chromeKeys{}, fmt.Errorf("reading the %s Safe Storage password from the keychain failed: %w", product, err)
+		}
+		key, err := chromeCBCKey(strings.TrimSpace(string(out)), 1003)
+		return chromeKeys{cbc: map[string][]byte{"v10": key}}, err
+	}
+
+	key, err := chromeCBCKey("peanuts", 1)
+	if err != nil {
+		return chromeKeys{}, err
+	}
+	keys := chromeKeys{cbc: map[string][]byte{"v10": key}}
+	out, err := exec.Command("secret-tool", "lookup", "application", strings.ToLower(product)).Output()
+	if err != nil {
+		return keys, fmt.Errorf("reading the %s Safe Storage password with secret-tool failed: %w", product, err)
+	}
+	if key, err = chromeCBCKey(strings.TrimSpace(string(out)), 1); err != nil {
+		return keys, err
+	}
+	keys.cbc["v11"] = key
+	return keys, nil
+}
+
+// dpapiDecrypt is only available on Windows.
+func dpapiDecrypt([]byte) ([]byte, error) {
+	return nil, errors.New("unencrypted or DPAPI protected value outside of Windows")
+}
@@ -0,0 +1,71 @@
+//go:build windows
+
+package dlfetch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCryptUnprotectData = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptUnprotectData")
+	procLocalFree          = syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+)
+
+// loadChromeKeys reads the key of the cookie values from the Local State file of
+// the user data directory, which holds it protected with DPAPI.
+func loadChromeKeys(profileDir string) (chromeKeys, error) {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Clean(profileDir)), "Local State"))
+	if err != nil {
+		return chromeKeys{}, err
+	}
+	var state struct {
+		OSCrypt struct {
+			EncryptedKey string `json:"encrypted_key"`
+		} `json:"os_crypt"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return chromeKeys{}, fmt.Errorf("invalid Local State: %w", err)
+	}
+	blob, err := base64.StdEncoding.DecodeString(state.OSCrypt.EncryptedKey)
+	if err != nil {
+		return chromeKeys{}, fmt.Errorf("invalid Local State: %w", err)
+	}
+	blob, ok := bytes.CutPrefix(blob, []byte("DPAPI"))
+	if !ok {
+		return chromeKeys{}, errors.New("invalid Local State: key is not protected with DPAPI")
+	}
+	key, err := dpapiDecrypt(blob)
+	if err != nil {
+		return chromeKeys{}, err
+	}
+	return chromeKeys{gcm: key}, nil
+}
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+// dpapiDecrypt unprotects data with the credentials of the current user.
+func dpapiDecrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty DPAPI blob")
+	}
+	in := dataBlob{size: uint32(len(data)), data: &data[0]}
+	var out dataBlob
+	ok, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+	if ok == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData failed: %w", err)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+	return slices.Clone(unsafe.Slice(out.data, out.size)), nil
+}
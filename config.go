@@ -0,0 +1,200 @@
+package dlfetch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a Fetcher in a YAML or JSON file, so command line tools
+// and daemons can share one configuration format. Zero values keep the defaults.
+type Config struct {
+	Workers           int      `json:"workers" yaml:"workers"`
+	TargetDir         string   `json:"targetDir" yaml:"targetDir"`
+	Overwrite         bool     `json:"overwrite" yaml:"overwrite"`
+	OverwritePolicy   string   `json:"overwritePolicy" yaml:"overwritePolicy"` // error, skip, overwrite or rename; takes precedence over Overwrite
+	TmpSuffix         string   `json:"tmpSuffix" yaml:"tmpSuffix"`
+	HiddenStaging     bool     `json:"hiddenStaging" yaml:"hiddenStaging"`
+	DoneMarker        string   `json:"doneMarker" yaml:"doneMarker"`
+	IncompleteMarker  string   `json:"incompleteMarker" yaml:"incompleteMarker"`
+	MirrorRemotePath  bool     `json:"mirrorRemotePath" yaml:"mirrorRemotePath"`
+	OrderedCompletion bool     `json:"orderedCompletion" yaml:"orderedCompletion"`
+	Relocate          string   `json:"relocate" yaml:"relocate"` // Destination template, see Relocate
+	Segments          int      `json:"segments" yaml:"segments"`
+	PartSize          int64    `json:"partSize" yaml:"partSize"`           // Split downloads into parts of this many bytes
+	SparseFiles       bool     `json:"sparseFiles" yaml:"sparseFiles"`     // Write downloads in place, readable while they download
+	DecodeContent     bool     `json:"decodeContent" yaml:"decodeContent"` // Request gzip and deflate and report network and file progress apart
+	MaxBandwidth      int64    `json:"maxBandwidth" yaml:"maxBandwidth"`   // Bytes per second
+	FairBandwidth     bool     `json:"fairBandwidth" yaml:"fairBandwidth"` // Share MaxBandwidth evenly between running downloads
+	CorrectExtensions bool     `json:"correctExtensions" yaml:"correctExtensions"`
+	SniffMimeType     bool     `json:"sniffMimeType" yaml:"sniffMimeType"` // Prefer magic bytes over Content-Type, see SniffMimeDetector
+	Proxy             string   `json:"proxy" yaml:"proxy"`                 // http, https or socks5 proxy URL
+	MaxPerHost        int      `json:"maxPerHost" yaml:"maxPerHost"`       // Simultaneous downloads per host
+	Retries           int      `json:"retries" yaml:"retries"`             // Retries of transient failures per download
+	CheckDiskSpace    bool     `json:"checkDiskSpace" yaml:"checkDiskSpace"`
+	DiskSpaceMargin   int64    `json:"diskSpaceMargin" yaml:"diskSpaceMargin"` // Bytes to keep free, implies CheckDiskSpace
+	AllowedTypes      []string `json:"allowedTypes" yaml:"allowedTypes"`       // Accepted Content-Types, e.g. image/*
+	DeniedTypes       []string `json:"deniedTypes" yaml:"deniedTypes"`
+	BlockPrivate      bool     `json:"blockPrivate" yaml:"blockPrivate"` // Refuse loopback and private network addresses
+	MaxRedirects      int      `json:"maxRedirects" yaml:"maxRedirects"` // 0 for the client's default, negative to follow none
+	SameHostRedirects bool     `json:"sameHostRedirects" yaml:"sameHostRedirects"`
+	WebhookURL        string   `json:"webhookURL" yaml:"webhookURL"`       // Receives completed and failed downloads, see WithWebhook
+	WebhookSecret     string   `json:"webhookSecret" yaml:"webhookSecret"` // Signs the webhook deliveries
+}
+
+// LoadConfig reads a Config from a file. Files ending in .yaml or .yml are
+// parsed as YAML, everything else as JSON. Unknown keys are rejected.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Options converts the configuration into FetcherOptions.
+func (c Config) Options() ([]FetcherOption, error) {
+	var options []FetcherOption
+
+	if c.Workers > 0 {
+		options = append(options, WithMaxWorkers(c.Workers))
+	}
+	if c.TargetDir != "" {
+		options = append(options, WithTargetDir(c.TargetDir))
+	}
+	if c.Overwrite {
+		options = append(options, WithEnableOverwrite(true))
+	}
+	if c.OverwritePolicy != "" {
+		p, err := ParseOverwritePolicy(c.OverwritePolicy)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithOverwritePolicy(p))
+	}
+	if c.TmpSuffix != "" {
+		options = append(options, WithTmpSuffix(c.TmpSuffix))
+	}
+	if c.HiddenStaging {
+		options = append(options, WithHiddenStaging(true))
+	}
+	if c.DoneMarker != "" {
+		options = append(options, WithDoneMarker(c.DoneMarker))
+	}
+	if c.IncompleteMarker != "" {
+		options = append(options, WithIncompleteMarker(c.IncompleteMarker))
+	}
+	if c.MirrorRemotePath {
+		options = append(options, WithMirrorRemotePath())
+	}
+	if c.OrderedCompletion {
+		options = append(options, WithOrderedCompletion())
+	}
+	if c.SniffMimeType {
+		options = append(options, WithMimeDetector(SniffMimeDetector))
+	}
+	if c.CorrectExtensions {
+		options = append(options, WithExtensionCorrection(true))
+	}
+	if c.MaxBandwidth > 0 {
+		options = append(options, WithMaxBandwidth(c.MaxBandwidth))
+	}
+	if c.FairBandwidth {
+		options = append(options, WithFairBandwidth())
+	}
+	if c.Retries > 0 {
+		options = append(options, WithRetries(c.Retries))
+	}
+	if c.CheckDiskSpace || c.DiskSpaceMargin > 0 {
+		options = append(options, WithDiskSpaceCheck(c.DiskSpaceMargin))
+	}
+	if len(c.AllowedTypes) > 0 {
+		options = append(options, WithAllowedContentTypes(c.AllowedTypes...))
+	}
+	if len(c.DeniedTypes) > 0 {
+		options = append(options, WithDeniedContentTypes(c.DeniedTypes...))
+	}
+	if c.BlockPrivate {
+		options = append(options, WithBlockPrivateNetworks())
+	}
+	if c.MaxRedirects != 0 {
+		options = append(options, WithMaxRedirects(c.MaxRedirects))
+	}
+	if c.SameHostRedirects {
+		options = append(options, WithSameHostRedirects())
+	}
+	if c.MaxPerHost > 0 {
+		options = append(options, WithMaxPerHost(c.MaxPerHost))
+	}
+	if c.PartSize > 0 {
+		options = append(options, WithSplitParts(c.PartSize))
+	}
+	if c.Segments > 0 {
+		options = append(options, WithSegments(c.Segments))
+	}
+	if c.SparseFiles {
+		options = append(options, WithSparseFiles())
+	}
+	if c.DecodeContent {
+		options = append(options, WithContentDecoders(nil))
+	}
+	if c.Proxy != "" {
+		if _, err := parseProxy(c.Proxy); err != nil {
+			return nil, err
+		}
+		options = append(options, WithProxy(c.Proxy))
+	}
+	if c.WebhookURL != "" {
+		options = append(options, WithWebhook(Webhook{URL: c.WebhookURL, Secret: c.WebhookSecret}))
+	}
+	if c.Relocate != "" {
+		relocate, err := Relocate(c.Relocate)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithPostProcessors(relocate))
+	}
+
+	return options, nil
+}
+
+// NewFromConfig creates a Fetcher configured from a YAML or JSON file.
+// Additional options, such as callbacks that cannot be expressed in a file,
+// are applied after the file's settings.
+func NewFromConfig(path string, options ...FetcherOption) (*Fetcher, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configOptions, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	f := New(append(configOptions, options...)...)
+	f.config = cfg
+	return f, nil
+}
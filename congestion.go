@@ -0,0 +1,167 @@
+package dlfetch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// congestionControl limits the number of downloads running at once across all hosts.
+// It backs off when errors spike or throughput collapses, as happens on flaky
+// mobile and satellite links, and ramps back up one download at a time.
+type congestionControl struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	max    int
+
+	windowStart   time.Time
+	windowDone    int
+	windowErrors  int
+	windowBytes   int64
+	peakRate      float64 // Best recent aggregate bytes per second, reset after backing off
+	justBackedOff bool
+
+	initial   int  // Limit after Start when slow start is enabled, 0 when it is not
+	slowStart bool // Still in the slow start phase, the limit doubles after a clean window
+}
+
+// WithCongestionControl makes the Fetcher back off globally when downloads start
+// failing with signs of congestion or the aggregate throughput collapses: the number
+// of simultaneous downloads is halved and then ramped back up towards the worker count.
+func WithCongestionControl() FetcherOption {
+	return func(f *Fetcher) {
+		f.congestion = newCongestionControl()
+	}
+}
+
+// WithSlowStart makes a started Fetcher run only initial downloads at once and
+// double that after every round of downloads without signs of congestion, up to the
+// worker count, instead of hitting origins with strict rate limits with every worker
+// at once. On errors it falls back to the behaviour of WithCongestionControl,
+// which it enables.
+func WithSlowStart(initial int) FetcherOption {
+	return func(f *Fetcher) {
+		if f.congestion == nil {
+			f.congestion = newCongestionControl()
+		}
+		f.congestion.initial = max(1, initial)
+	}
+}
+
+func newCongestionControl() *congestionControl {
+	c := &congestionControl{windowStart: time.Now()}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// setMax updates the upper bound, used when the worker count changes.
+func (c *congestionControl) setMax(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.max = max(1, n)
+	if c.limit == 0 || c.limit > c.max {
+		c.limit = c.max
+	}
+	c.cond.Broadcast()
+}
+
+// restart enters slow start again, used when the Fetcher is started.
+func (c *congestionControl) restart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.initial == 0 {
+		return
+	}
+	c.limit = min(c.initial, c.max)
+	c.slowStart = c.limit < c.max
+	c.peakRate = 0
+	c.justBackedOff = false
+	c.windowStart = time.Now()
+	c.windowDone = 0
+	c.windowErrors = 0
+	c.windowBytes = 0
+}
+
+// acquire waits until a download may start. It returns the error of ctx if ctx
+// is done first.
+func (c *congestionControl) acquire(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := waitCond(ctx, c.cond, func() bool { return c.active < c.limit }); err != nil {
+		return err
+	}
+	c.active++
+	return nil
+}
+
+// releaseSlot frees a slot taken by acquire without counting a download, for
+// requests that only probe.
+func (c *congestionControl) releaseSlot() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active--
+	c.cond.Broadcast()
+}
+
+// release records the outcome of a download and re-evaluates the limit
+// once a window of downloads has finished.
+func (c *congestionControl) release(bytes int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active--
+	defer c.cond.Broadcast()
+
+	c.windowDone++
+	c.windowBytes += bytes
+	if err != nil && isCongestionError(err) {
+		c.windowErrors++
+	}
+
+	if c.windowDone < max(c.limit, 4) {
+		return
+	}
+	elapsed := time.Since(c.windowStart).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(c.windowBytes) / elapsed
+	}
+
+	errorSpike := float64(c.windowErrors)/float64(c.windowDone) >= 0.25
+	collapsed := !c.justBackedOff && c.peakRate > 0 && rate < c.peakRate*0.5
+
+	switch {
+	case errorSpike || collapsed:
+		c.limit = max(1, c.limit/2)
+		c.peakRate = 0
+		c.justBackedOff = true
+		c.slowStart = false
+	case c.slowStart:
+		c.limit = min(c.max, c.limit*2)
+		c.slowStart = c.limit < c.max
+		c.peakRate = max(rate, c.peakRate)
+		c.justBackedOff = false
+	default:
+		c.limit = min(c.max, c.limit+1)
+		c.peakRate = max(rate, c.peakRate*0.95)
+		c.justBackedOff = false
+	}
+
+	c.windowStart = time.Now()
+	c.windowDone = 0
+	c.windowErrors = 0
+	c.windowBytes = 0
+}
+
+// currentLimit returns the number of downloads currently allowed at once.
+func (c *congestionControl) currentLimit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
@@ -0,0 +1,70 @@
+package dlfetch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// defaultCheckBytes is how much of the content a ContentCheck looks at by default.
+const defaultCheckBytes = 1024
+
+// ContentCheck asserts what the start of a download looks like, e.g. that it begins
+// with the magic number of the expected format or is not an HTML error page served
+// with status 200. It is evaluated on the first bytes as they arrive, so a wrong
+// download fails before anything is written instead of after storing gigabytes.
+type ContentCheck struct {
+	Bytes    int            // How many bytes from the start to check, 0 for 1 KiB
+	Match    *regexp.Regexp // Must match the start, nil to skip
+	NotMatch *regexp.Regexp // Must not match the start, nil to skip
+}
+
+// WithContentCheck applies c to every download without a ContentCheck of its own.
+func WithContentCheck(c ContentCheck) FetcherOption {
+	return func(f *Fetcher) {
+		f.contentCheck = &c
+	}
+}
+
+// contentCheckFor returns the check that applies to req, nil if none.
+func (f *Fetcher) contentCheckFor(req DownloadRequest) *ContentCheck {
+	if req.ContentCheck != nil {
+		return req.ContentCheck
+	}
+	return f.contentCheck
+}
+
+// check reads the start of body and verifies it. It returns a body that yields
+// the complete content again, including the bytes already read.
+func (c *ContentCheck) check(url string, body io.ReadCloser) (io.ReadCloser, error) {
+	n := c.Bytes
+	if n <= 0 {
+		n = defaultCheckBytes
+	}
+	head := make([]byte, n)
+	read, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:read]
+
+	if c.Match != nil && !c.Match.Match(head) {
+		return nil, fmt.Errorf("%w: start of %s does not match %s", ErrContentMismatch, url, c.Match)
+	}
+	if c.NotMatch != nil && c.NotMatch.Match(head) {
+		return nil, fmt.Errorf("%w: start of %s matches %s", ErrContentMismatch, url, c.NotMatch)
+	}
+	return &prefixedBody{Reader: io.MultiReader(bytes.NewReader(head), body), body: body}, nil
+}
+
+// prefixedBody is a response body with the bytes read ahead put back in front.
+type prefixedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (b *prefixedBody) Close() error {
+	return b.body.Close()
+}
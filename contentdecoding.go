@@ -0,0 +1,124 @@
+package dlfetch
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// ContentDecoder decodes a response body of a Content-Encoding, see
+// WithContentDecoders.
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+// WithContentDecoders makes the Fetcher decode compressed responses itself instead
+// of leaving gzip to the HTTP transport, so the monitor can tell the compressed
+// bytes received from the decoded bytes written: DownloadTask.NetworkBytes and
+// NetworkTotal follow the network, on which DownloadSpeed and ETA are based, while
+// DoneBytes follows the file.
+//
+// gzip and deflate are built in; decoders adds further encodings or replaces
+// them, e.g. zstd or br with a decoder from a third-party package. Requests with
+// a Range or their own Accept-Encoding header are left alone.
+func WithContentDecoders(decoders map[string]ContentDecoder) FetcherOption {
+	return func(f *Fetcher) {
+		t := &decodingTransport{decoders: map[string]ContentDecoder{
+			"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+			"deflate": func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+		}}
+		for encoding, dec := range decoders {
+			t.decoders[strings.ToLower(encoding)] = dec
+		}
+		encodings := make([]string, 0, len(t.decoders))
+		for encoding := range t.decoders {
+			encodings = append(encodings, encoding)
+		}
+		slices.Sort(encodings)
+		t.accept = strings.Join(encodings, ", ")
+
+		f.transportWrappers = append(f.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			d := *t
+			d.base = base
+			return &d
+		})
+	}
+}
+
+// wireCounter counts the compressed bytes of a response decoded by the
+// decodingTransport, for the monitor.
+type wireCounter struct {
+	received atomic.Int64
+	total    atomic.Int64 // Compressed size, -1 if unknown
+	decoded  atomic.Bool  // The response was decoded, the counts are valid
+}
+
+func (c *wireCounter) Write(p []byte) (int, error) {
+	c.received.Add(int64(len(p)))
+	return len(p), nil
+}
+
+type wireCounterKey struct{}
+
+// withWireCounter returns a context whose requests count their compressed bytes in c.
+func withWireCounter(ctx context.Context, c *wireCounter) context.Context {
+	return context.WithValue(ctx, wireCounterKey{}, c)
+}
+
+// decodingTransport asks for compressed responses and decodes them.
+type decodingTransport struct {
+	base     http.RoundTripper
+	decoders map[string]ContentDecoder
+	accept   string // Accept-Encoding header
+}
+
+func (t *decodingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" || r.Header.Get("Accept-Encoding") != "" {
+		return t.base.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Accept-Encoding", t.accept)
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, ok := t.decoders[strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))]
+	if !ok || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	body := io.Reader(resp.Body)
+	if c, ok := r.Context().Value(wireCounterKey{}).(*wireCounter); ok {
+		c.total.Store(resp.ContentLength)
+		c.decoded.Store(true)
+		body = io.TeeReader(resp.Body, c)
+	}
+	decoded, err := dec(body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// Like the transport's own gzip support, the response then looks uncompressed
+	resp.Body = &decodedBody{Reader: decoded, decoder: decoded, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// decodedBody closes both the decoder and the compressed body.
+type decodedBody struct {
+	io.Reader
+	decoder io.Closer
+	body    io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	b.decoder.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,73 @@
+package dlfetch
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// WithAllowedContentTypes only accepts downloads whose Content-Type matches one of
+// the patterns, e.g. "image/*" and "video/mp4". Other downloads are aborted with a
+// *ContentTypeError before anything is written. A response without a Content-Type
+// counts as application/octet-stream.
+func WithAllowedContentTypes(patterns ...string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.allowedTypes = append([]string(nil), patterns...)
+	}
+}
+
+// WithDeniedContentTypes rejects downloads whose Content-Type matches one of the
+// patterns, e.g. "text/html" for error pages served with status 200, with a
+// *ContentTypeError. It takes precedence over WithAllowedContentTypes.
+func WithDeniedContentTypes(patterns ...string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.deniedTypes = append([]string(nil), patterns...)
+	}
+}
+
+// ContentTypeError is returned when the Content-Type of a response is not accepted,
+// see WithAllowedContentTypes and WithDeniedContentTypes.
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("content type %s of %s is not accepted", e.ContentType, e.URL)
+}
+
+// checkContentType verifies the Content-Type of resp against the allow and deny lists.
+func (p policy) checkContentType(url string, resp *http.Response) error {
+	if len(p.allowedTypes) == 0 && len(p.deniedTypes) == 0 {
+		return nil
+	}
+	contentType := "application/octet-stream"
+	if header := resp.Header.Get("Content-Type"); header != "" {
+		contentType = header
+		if mediaType, _, err := mime.ParseMediaType(header); err == nil {
+			contentType = mediaType
+		}
+	}
+
+	if matchesContentType(p.deniedTypes, contentType) ||
+		len(p.allowedTypes) > 0 && !matchesContentType(p.allowedTypes, contentType) {
+		return &ContentTypeError{URL: url, ContentType: contentType}
+	}
+	return nil
+}
+
+// matchesContentType reports whether the media type matches one of the patterns.
+// A pattern is a full media type, "type/*" or "*/*".
+func matchesContentType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*/*" || pattern == strings.ToLower(mediaType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(strings.ToLower(mediaType), prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
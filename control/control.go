@@ -0,0 +1,446 @@
+// Package control serves the gRPC control service of control.proto, so other
+// processes, in any language with a gRPC client, can enqueue, cancel, pause and
+// resume the downloads of a dlfetch daemon, list its tasks and watch their
+// progress.
+//
+// Server is an http.Handler speaking gRPC over HTTP/2 without generated code or
+// dependencies. It needs a server with HTTP/2 enabled: one serving TLS, or for
+// plain-text gRPC on a local socket one with unencrypted HTTP/2:
+//
+//	srv := &http.Server{Addr: "127.0.0.1:50051", Handler: &control.Server{Fetcher: f, Monitor: monitor}}
+//	srv.Protocols = new(http.Protocols)
+//	srv.Protocols.SetUnencryptedHTTP2(true)
+//	err := srv.ListenAndServe()
+//
+// Messages must not be compressed. Put the service behind authentication, e.g.
+// mutual TLS: whoever can reach it can make the Fetcher download anything.
+package control
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+	"github.com/hritikr/dlfetch/internal/remote"
+)
+
+// serviceName is the full name of the service in control.proto.
+const serviceName = "dlfetch.control.v1.Control"
+
+const (
+	// maxMessageSize bounds the messages clients send, like the default of gRPC.
+	maxMessageSize = 4 << 20
+	// defaultInterval is how often WatchProgress looks for changes by default.
+	defaultInterval = 500 * time.Millisecond
+)
+
+// Server implements the Control service for Fetcher.
+type Server struct {
+	Fetcher *dlfetch.Fetcher
+	Monitor dlfetch.Monitor // The monitor of Fetcher
+
+	// Prepare, if set, adjusts enqueued requests before they are queued, e.g. to
+	// set their Tenant from the metadata in the headers of r. An error rejects the
+	// call with status INVALID_ARGUMENT.
+	Prepare func(r *http.Request, req *dlfetch.DownloadRequest) error
+
+	// Interval is how often WatchProgress looks for changes, 500ms if 0.
+	Interval time.Duration
+
+	ids remote.IDs
+}
+
+// gRPC status codes
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codeAlreadyExists      = 6
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+)
+
+// statusError is an error ending a call with a gRPC status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+func statusErrorf(code int, format string, args ...any) error {
+	return &statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+proto") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := r.Context()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	var err error
+	switch r.URL.Path {
+	case "/" + serviceName + "/Enqueue":
+		err = s.unary(w, r, s.enqueue)
+	case "/" + serviceName + "/Cancel":
+		err = s.unary(w, r, s.taskCall(s.Fetcher.Cancel))
+	case "/" + serviceName + "/Pause":
+		err = s.unary(w, r, s.taskCall(s.Fetcher.Pause))
+	case "/" + serviceName + "/Resume":
+		err = s.unary(w, r, s.taskCall(s.Fetcher.Resume))
+	case "/" + serviceName + "/ListTasks":
+		err = s.unary(w, r, s.listTasks)
+	case "/" + serviceName + "/WatchProgress":
+		err = s.watchProgress(w, r)
+	default:
+		err = statusErrorf(codeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+	writeStatus(ctx, w, err)
+}
+
+// unary reads the request message of a unary call and writes the response of
+// handle.
+func (s *Server) unary(w http.ResponseWriter, r *http.Request, handle func(r *http.Request, msg []byte) ([]byte, error)) error {
+	msg, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	resp, err := handle(r, msg)
+	if err != nil {
+		return err
+	}
+	return writeMessage(w, resp)
+}
+
+func (s *Server) enqueue(r *http.Request, msg []byte) ([]byte, error) {
+	var req dlfetch.DownloadRequest
+	err := decode(msg, func(f field) error {
+		var err error
+		var n int64
+		switch f.num {
+		case 1:
+			n, err = f.int64()
+			req.ID = int(n)
+		case 2:
+			req.URL, err = f.string()
+		case 3:
+			req.FileName, err = f.string()
+		case 4:
+			req.Path, err = f.string()
+		case 5, 6:
+			var key, value string
+			if key, value, err = f.mapEntry(); err != nil {
+				break
+			}
+			m := &req.Headers
+			if f.num == 6 {
+				m = &req.Vars
+			}
+			if *m == nil {
+				*m = make(map[string]string)
+			}
+			(*m)[key] = value
+		case 7:
+			req.Preset, err = f.string()
+		case 8:
+			req.Sink, err = f.string()
+		case 9:
+			var mirror string
+			mirror, err = f.string()
+			req.Mirrors = append(req.Mirrors, mirror)
+		case 10:
+			req.MaxSpeed, err = f.int64()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, statusErrorf(codeInvalidArgument, "invalid download: %v", err)
+	}
+	if err := remote.CheckRequest(req); err != nil {
+		return nil, statusErrorf(codeInvalidArgument, "%v", err)
+	}
+	if s.Prepare != nil {
+		if err := s.Prepare(r, &req); err != nil {
+			return nil, statusErrorf(codeInvalidArgument, "%v", err)
+		}
+	}
+
+	id, result := s.ids.Enqueue(s.Fetcher, s.Monitor, req)
+	if result.Error != nil {
+		return nil, &statusError{code: enqueueCode[remote.KindOf(result.Error)], msg: result.Error.Error()}
+	}
+
+	var resp encoder
+	resp.int64(1, int64(id))
+	resp.bool(2, result.Skipped)
+	return resp.b, nil
+}
+
+// taskCall returns the handler of a call taking a TaskRequest and returning Empty.
+func (s *Server) taskCall(fn func(id int) error) func(r *http.Request, msg []byte) ([]byte, error) {
+	return func(r *http.Request, msg []byte) ([]byte, error) {
+		id, err := taskID(msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(id); err != nil {
+			if !errors.Is(err, dlfetch.ErrUnknownID) {
+				return nil, statusErrorf(codeInternal, "%v", err)
+			}
+			if task, known := remote.Task(s.Monitor, id); known {
+				return nil, statusErrorf(codeFailedPrecondition, "download %d is %s", id, task.Status)
+			}
+			return nil, statusErrorf(codeNotFound, "%v", err)
+		}
+		return nil, nil
+	}
+}
+
+func (s *Server) listTasks(r *http.Request, msg []byte) ([]byte, error) {
+	var status string
+	err := decode(msg, func(f field) error {
+		var err error
+		if f.num == 1 {
+			status, err = f.string()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, statusErrorf(codeInvalidArgument, "%v", err)
+	}
+
+	snapshot := s.Monitor.GetSnapshot()
+	var resp encoder
+	resp.string(1, snapshot.Fetcher)
+	for _, task := range snapshot.Tasks {
+		if status == "" || string(task.Status) == status {
+			resp.message(2, func(m *encoder) { encodeTask(m, task) })
+		}
+	}
+	return resp.b, nil
+}
+
+func (s *Server) watchProgress(w http.ResponseWriter, r *http.Request) error {
+	msg, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	watched := make(map[int]bool)
+	err = decode(msg, func(f field) error {
+		if f.num != 1 {
+			return nil
+		}
+		ids, err := f.int64s()
+		for _, id := range ids {
+			watched[int(id)] = true
+		}
+		return err
+	})
+	if err != nil {
+		return statusErrorf(codeInvalidArgument, "%v", err)
+	}
+	for id := range watched {
+		if _, known := remote.Task(s.Monitor, id); !known {
+			return statusErrorf(codeNotFound, "%v: %d", dlfetch.ErrUnknownID, id)
+		}
+	}
+
+	// Send the headers right away, so the client knows the stream is open
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sent := make(map[int]dlfetch.DownloadTask)
+	for {
+		finished := true
+		for _, task := range s.Monitor.GetSnapshot().Tasks {
+			if len(watched) > 0 && !watched[task.ID] {
+				continue
+			}
+			if !remote.IsFinished(task.Status) {
+				finished = false
+			}
+			if prev, ok := sent[task.ID]; ok && remote.SameTask(prev, task) {
+				continue
+			}
+			var m encoder
+			encodeTask(&m, task)
+			if err := writeMessage(w, m.b); err != nil {
+				return err
+			}
+			sent[task.ID] = task
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+		if len(watched) > 0 && finished {
+			return nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// taskID decodes a TaskRequest.
+func taskID(msg []byte) (int, error) {
+	var id int64
+	err := decode(msg, func(f field) error {
+		var err error
+		if f.num == 1 {
+			id, err = f.int64()
+		}
+		return err
+	})
+	if err != nil {
+		return 0, statusErrorf(codeInvalidArgument, "%v", err)
+	}
+	return int(id), nil
+}
+
+// encodeTask encodes a Task message.
+func encodeTask(m *encoder, t dlfetch.DownloadTask) {
+	m.int64(1, int64(t.ID))
+	m.string(2, t.FileName)
+	m.string(3, t.FilePath)
+	m.int64(4, t.TotalBytes)
+	m.int64(5, t.DoneBytes)
+	m.string(6, string(t.Status))
+	m.string(7, t.Error)
+	m.timestamp(8, t.StartedAt)
+	if t.CompletedAt != nil {
+		m.timestamp(9, *t.CompletedAt)
+	}
+	m.double(10, t.DownloadSpeed)
+	m.string(11, t.ETA)
+	m.int64(12, int64(t.QueuePosition))
+	m.timestamp(13, t.EnqueuedAt)
+	m.int64(14, t.NetworkBytes)
+	m.int64(15, t.NetworkTotal)
+}
+
+// enqueueCode is the status code of an Enqueue error by kind.
+var enqueueCode = map[remote.ErrorKind]int{
+	remote.Invalid:     codeInvalidArgument,
+	remote.Conflict:    codeAlreadyExists,
+	remote.Exhausted:   codeResourceExhausted,
+	remote.Forbidden:   codePermissionDenied,
+	remote.Unavailable: codeUnavailable,
+}
+
+// readMessage reads the single message of a call from body.
+func readMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, statusErrorf(codeInvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, statusErrorf(codeUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, statusErrorf(codeResourceExhausted, "request of %d bytes exceeds %d", size, maxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, statusErrorf(codeInvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+// writeMessage writes a length-prefixed message.
+func writeMessage(w io.Writer, msg []byte) error {
+	prefix := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	if _, err := w.Write(append(prefix, msg...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeStatus ends a call with the status of err in the trailers.
+func writeStatus(ctx context.Context, w http.ResponseWriter, err error) {
+	code, msg := codeOK, ""
+	var statusErr *statusError
+	switch {
+	case err == nil:
+	case errors.As(err, &statusErr):
+		code, msg = statusErr.code, statusErr.msg
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+		code, msg = codeDeadlineExceeded, err.Error()
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		code, msg = codeCanceled, err.Error()
+	default:
+		code, msg = codeUnknown, err.Error()
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", percentEncode(msg))
+	}
+}
+
+// percentEncode encodes a grpc-message header value.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout parses a grpc-timeout header, e.g. "100m" for 100 milliseconds.
+func parseTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[s[len(s)-1]]
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
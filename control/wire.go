@@ -0,0 +1,182 @@
+package control
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The protobuf wire format, as much as the messages of control.proto need, so the
+// package does without generated code and its dependencies.
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// encoder appends fields to a message. Fields with zero values are left out, as
+// proto3 does.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *encoder) int64(field int, v int64) {
+	if v != 0 {
+		e.tag(field, wireVarint)
+		e.b = binary.AppendUvarint(e.b, uint64(v))
+	}
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.tag(field, wireVarint)
+		e.b = append(e.b, 1)
+	}
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v != 0 {
+		e.tag(field, wireFixed64)
+		e.b = binary.LittleEndian.AppendUint64(e.b, math.Float64bits(v))
+	}
+}
+
+func (e *encoder) string(field int, v string) {
+	if v != "" {
+		e.tag(field, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+		e.b = append(e.b, v...)
+	}
+}
+
+// message appends the message fn encodes, even if it is empty.
+func (e *encoder) message(field int, fn func(*encoder)) {
+	var m encoder
+	fn(&m)
+	e.tag(field, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(m.b)))
+	e.b = append(e.b, m.b...)
+}
+
+// timestamp appends a google.protobuf.Timestamp, nothing for the zero time.
+func (e *encoder) timestamp(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	e.message(field, func(m *encoder) {
+		m.int64(1, t.Unix())
+		m.int64(2, int64(t.Nanosecond()))
+	})
+}
+
+// field is a field read by decode.
+type field struct {
+	num      int
+	wireType int
+	varint   uint64 // Value of varint and fixed fields
+	data     []byte // Value of length-delimited fields
+}
+
+func (f field) int64() (int64, error) {
+	if f.wireType != wireVarint {
+		return 0, fmt.Errorf("field %d is not a varint", f.num)
+	}
+	return int64(f.varint), nil
+}
+
+func (f field) string() (string, error) {
+	if f.wireType != wireBytes {
+		return "", fmt.Errorf("field %d is not a string", f.num)
+	}
+	return string(f.data), nil
+}
+
+// int64s returns the values of a repeated int64 field, which is packed or not.
+func (f field) int64s() ([]int64, error) {
+	if f.wireType == wireVarint {
+		return []int64{int64(f.varint)}, nil
+	}
+	if f.wireType != wireBytes {
+		return nil, fmt.Errorf("field %d is not a repeated varint", f.num)
+	}
+	var values []int64
+	for b := f.data; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		values = append(values, int64(v))
+		b = b[n:]
+	}
+	return values, nil
+}
+
+// mapEntry returns the key and value of a map<string, string> entry.
+func (f field) mapEntry() (key, value string, err error) {
+	if f.wireType != wireBytes {
+		return "", "", fmt.Errorf("field %d is not a map", f.num)
+	}
+	err = decode(f.data, func(e field) error {
+		var err error
+		switch e.num {
+		case 1:
+			key, err = e.string()
+		case 2:
+			value, err = e.string()
+		}
+		return err
+	})
+	return key, value, err
+}
+
+// decode calls fn with every field of the message b, unknown ones included.
+func decode(b []byte, fn func(field) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		f := field{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			f.varint, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f.varint, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errTruncated
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", f.wireType)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
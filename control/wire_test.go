@@ -0,0 +1,256 @@
+package control
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	var e encoder
+	e.int64(1, 300)
+	e.int64(2, -1)
+	e.int64(3, 0) // Left out
+	e.bool(4, true)
+	e.bool(5, false) // Left out
+	e.double(6, 1.5)
+	e.string(7, "héllo")
+	e.string(8, "") // Left out
+	e.timestamp(9, at)
+	e.timestamp(10, time.Time{}) // Left out
+	e.message(11, func(m *encoder) {
+		m.string(1, "key")
+		m.string(2, "value")
+	})
+	e.message(12, func(*encoder) {})
+
+	var got []int
+	err := decode(e.b, func(f field) error {
+		got = append(got, f.num)
+		var err error
+		switch f.num {
+		case 1, 2:
+			var v int64
+			v, err = f.int64()
+			if want := map[int]int64{1: 300, 2: -1}[f.num]; v != want {
+				t.Errorf("field %d = %d, want %d", f.num, v, want)
+			}
+		case 4:
+			if f.wireType != wireVarint || f.varint != 1 {
+				t.Errorf("bool = %d (wire type %d)", f.varint, f.wireType)
+			}
+		case 6:
+			if v := math.Float64frombits(f.varint); f.wireType != wireFixed64 || v != 1.5 {
+				t.Errorf("double = %v (wire type %d)", v, f.wireType)
+			}
+		case 7:
+			var s string
+			if s, err = f.string(); s != "héllo" {
+				t.Errorf("string = %q", s)
+			}
+		case 9:
+			var sec, nsec int64
+			err = decode(f.data, func(f field) error {
+				var err error
+				switch f.num {
+				case 1:
+					sec, err = f.int64()
+				case 2:
+					nsec, err = f.int64()
+				}
+				return err
+			})
+			if !time.Unix(sec, nsec).Equal(at) {
+				t.Errorf("timestamp = %v, want %v", time.Unix(sec, nsec), at)
+			}
+		case 11:
+			var key, value string
+			if key, value, err = f.mapEntry(); key != "key" || value != "value" {
+				t.Errorf("map entry = %q: %q", key, value)
+			}
+		case 12:
+			if len(f.data) != 0 {
+				t.Errorf("empty message has %d bytes", len(f.data))
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 4, 6, 7, 9, 11, 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+}
+
+func TestInt64s(t *testing.T) {
+	var packed encoder
+	packed.message(1, func(m *encoder) {
+		// Packed values are bare varints, which message has no method for
+		m.b = append(m.b, 1, 0xac, 0x02, 0x7f)
+	})
+	var unpacked encoder
+	unpacked.int64(1, 1)
+	unpacked.int64(1, 300)
+	unpacked.int64(1, 127)
+
+	for name, b := range map[string][]byte{"packed": packed.b, "unpacked": unpacked.b} {
+		t.Run(name, func(t *testing.T) {
+			var ids []int64
+			err := decode(b, func(f field) error {
+				v, err := f.int64s()
+				ids = append(ids, v...)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []int64{1, 300, 127}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("ids %v, want %v", ids, want)
+			}
+		})
+	}
+
+	// A packed field ending in the middle of a varint
+	err := decode([]byte{0x0a, 0x01, 0x80}, func(f field) error {
+		_, err := f.int64s()
+		return err
+	})
+	if !errors.Is(err, errTruncated) {
+		t.Errorf("truncated packed field: %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"tag", []byte{0x80}},
+		{"varint", []byte{0x08, 0x80}},
+		{"fixed64", []byte{0x09, 1, 2, 3}},
+		{"fixed32", []byte{0x0d, 1, 2}},
+		{"length", []byte{0x12, 0x80}},
+		{"bytes", []byte{0x12, 0x05, 'a', 'b'}},
+		{"huge length", []byte{0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"start group", []byte{0x0b}},
+		{"wire type 7", []byte{0x0f}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := decode(tt.msg, func(field) error { return nil }); err == nil {
+				t.Error("decoded")
+			}
+		})
+	}
+
+	// Reading a field as the wrong type
+	err := decode([]byte{0x0a, 0x00}, func(f field) error {
+		_, err := f.int64()
+		return err
+	})
+	if err == nil {
+		t.Error("read a string as int64")
+	}
+	err = decode([]byte{0x08, 0x01}, func(f field) error {
+		_, _, err := f.mapEntry()
+		return err
+	})
+	if err == nil {
+		t.Error("read a varint as map entry")
+	}
+}
+
+func TestMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("framed as %v, want %v", buf.Bytes(), want)
+	}
+	msg, err := readMessage(&buf)
+	if err != nil || string(msg) != "abc" {
+		t.Fatalf("read %q, %v", msg, err)
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"short prefix", []byte{0, 0, 0}, codeInvalidArgument},
+		{"short message", []byte{0, 0, 0, 0, 3, 'a'}, codeInvalidArgument},
+		{"compressed", []byte{1, 0, 0, 0, 1, 'a'}, codeUnimplemented},
+		{"too large", []byte{0, 0xff, 0xff, 0xff, 0xff}, codeResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readMessage(bytes.NewReader(tt.frame))
+			var serr *statusError
+			if !errors.As(err, &serr) || serr.code != tt.code {
+				t.Errorf("error %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestEnqueuePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		fileName string
+		vars     map[string]string
+		code     int
+	}{
+		{"plain", "", "x.bin", nil, codeOK},
+		{"subdirectory", "a/b", "x.bin", nil, codeOK},
+		{"dot dot name", "", "../x.bin", nil, codeInvalidArgument},
+		{"dot dot path", "a/../../b", "x.bin", nil, codeInvalidArgument},
+		{"template name", "", "{{.Vars.n}}", map[string]string{"n": "../../etc/evil"}, codeInvalidArgument},
+		{"template path", "{{.Vars.n}}", "x", map[string]string{"n": ".."}, codeInvalidArgument},
+		{"stdout", "", dlfetch.StdoutFileName, nil, codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			monitor := dlfetch.NewMonitor()
+			s := &Server{Fetcher: dlfetch.New(dlfetch.WithTargetDir(dir), dlfetch.WithMonitor(monitor)), Monitor: monitor}
+
+			var msg encoder
+			msg.string(2, "http://127.0.0.1:1/x")
+			msg.string(3, tt.fileName)
+			msg.string(4, tt.path)
+			for k, v := range tt.vars {
+				msg.message(6, func(m *encoder) {
+					m.string(1, k)
+					m.string(2, v)
+				})
+			}
+			_, err := s.enqueue(httptest.NewRequest(http.MethodPost, "/", nil), msg.b)
+			code := codeOK
+			if serr := (*statusError)(nil); errors.As(err, &serr) {
+				code = serr.code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Fatalf("code %d, want %d: %v", code, tt.code, err)
+			}
+			for _, task := range monitor.GetSnapshot().Tasks {
+				if rel, err := filepath.Rel(dir, task.FilePath); err != nil || !filepath.IsLocal(rel) {
+					t.Errorf("task %d writes to %s, outside of %s", task.ID, task.FilePath, dir)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package dlfetch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseCookiesTxt reads cookies in the Netscape cookies.txt format, as exported by
+// browser extensions, curl and wget. Cookies valid for subdomains get a Domain with a
+// leading dot, host-only cookies a Domain without one.
+func ParseCookiesTxt(r io.Reader) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid cookies.txt line %d: expected 7 tab separated fields, got %d", lineNo, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookies.txt line %d: bad expiry %q", lineNo, fields[4])
+		}
+
+		cookies = append(cookies, newImportedCookie(
+			fields[0], strings.EqualFold(fields[1], "TRUE"), fields[2],
+			strings.EqualFold(fields[3], "TRUE"), httpOnly, expires, fields[5], fields[6],
+		))
+	}
+	return cookies, scanner.Err()
+}
+
+// ReadFirefoxCookies reads the cookies of a Firefox profile directory from its
+// cookies.sqlite database. It runs the sqlite3 command line tool on a copy of the
+// database, since Firefox keeps it locked while running. For Chrome profiles see
+// ReadChromeCookies.
+func ReadFirefoxCookies(profileDir string) ([]*http.Cookie, error) {
+	rows, err := querySQLite(filepath.Join(profileDir, "cookies.sqlite"), 7,
+		"SELECT host, path, isSecure, isHttpOnly, expiry, name, value FROM moz_cookies")
+	if err != nil {
+		return nil, err
+	}
+
+	var cookies []*http.Cookie
+	for _, fields := range rows {
+		expires, _ := strconv.ParseInt(fields[4], 10, 64)
+		// Firefox stores expiry in seconds, newer versions in milliseconds
+		if expires > 1e12 {
+			expires /= 1000
+		}
+		cookies = append(cookies, newImportedCookie(
+			fields[0], strings.HasPrefix(fields[0], "."), fields[1],
+			fields[2] == "1", fields[3] == "1", expires, fields[5], fields[6],
+		))
+	}
+	return cookies, nil
+}
+
+// querySQLite runs query with the sqlite3 command line tool on a copy of the
+// database at path, since browsers keep their databases locked while running. The
+// write-ahead log is copied along, it holds the latest changes until the browser
+// merges them into the database. It returns the rows split into columns, the last
+// column taking the rest of the row.
+func querySQLite(path string, columns int, query string) ([][]string, error) {
+	dir, err := os.MkdirTemp("", "dlfetch-cookies-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	db := filepath.Join(dir, filepath.Base(path))
+	if err := copyFileTo(path, db); err != nil {
+		return nil, err
+	}
+	if err := copyFileTo(path+"-wal", db+"-wal"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sqlite3", "-separator", "\t", db, query)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var rows [][]string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.SplitN(line, "\t", columns); len(fields) == columns {
+			rows = append(rows, fields)
+		}
+	}
+	return rows, nil
+}
+
+func newImportedCookie(domain string, subdomains bool, path string, secure, httpOnly bool, expires int64, name, value string) *http.Cookie {
+	domain = strings.TrimPrefix(domain, ".")
+	if subdomains {
+		domain = "." + domain
+	}
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   domain,
+		Path:     path,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+	}
+	if expires > 0 {
+		c.Expires = time.Unix(expires, 0)
+	}
+	return c
+}
+
+// ImportCookies adds cookies to the cookie jar of the Fetcher's HTTP client, so
+// downloads that require an existing browser session can be made. If the client
+// has no jar, the client is copied and given a new one. Call before Start.
+func (f *Fetcher) ImportCookies(cookies []*http.Cookie) error {
+	if f.requestClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		client := *f.requestClient
+		client.Jar = jar
+		f.requestClient = &client
+	}
+
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" {
+			continue
+		}
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+
+		cookie := *c
+		if !strings.HasPrefix(c.Domain, ".") {
+			// Host-only cookie
+			cookie.Domain = ""
+		}
+		u := &url.URL{Scheme: scheme, Host: host, Path: c.Path}
+		f.requestClient.Jar.SetCookies(u, []*http.Cookie{&cookie})
+	}
+	return nil
+}
+
+// ImportCookiesTxt loads a Netscape cookies.txt file into the Fetcher's cookie jar.
+func (f *Fetcher) ImportCookiesTxt(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cookies, err := ParseCookiesTxt(file)
+	if err != nil {
+		return err
+	}
+	return f.ImportCookies(cookies)
+}
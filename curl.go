@@ -0,0 +1,476 @@
+package dlfetch
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ClientOptions holds the transfer settings of an imported curl command that a
+// DownloadRequest cannot express. Apply them with WithClientOptions.
+type ClientOptions struct {
+	URL      string         // The imported URL, Method and Body only apply to it
+	Method   string         // Empty means GET
+	Body     string         // Request body from -d/--data*
+	Header   http.Header    // Headers from -H, -A, -e
+	Cookies  []*http.Cookie // Cookies from -b
+	Username string         // From -u
+	Password string
+	Insecure bool   // -k, skip TLS certificate verification of the imported URL's host
+	Proxy    string // -x, proxy URL
+}
+
+// curlBoolFlags are curl flags without a value that do not affect the imported request.
+var curlBoolFlags = map[string]bool{
+	"-s": true, "--silent": true, "-S": true, "--show-error": true, "-v": true, "--verbose": true,
+	"-i": true, "--include": true, "-f": true, "--fail": true, "-#": true, "--progress-bar": true,
+	"-g": true, "--globoff": true, "--compressed": true, "-L": true, "--location": true,
+	"-O": true, "--remote-name": true, "-J": true, "--remote-header-name": true,
+	"--http1.1": true, "--http2": true, "-k": true, "--insecure": true,
+}
+
+// ParseCurlCommand converts a "Copy as cURL" command line (bash quoting, including
+// $'...' strings and line continuations) into a DownloadRequest and the ClientOptions
+// needed to replay it. The request's ID is left for the caller to set.
+// Accept-Encoding is dropped so compressed responses are decoded transparently.
+func ParseCurlCommand(s string) (DownloadRequest, ClientOptions, error) {
+	var req DownloadRequest
+	opts := ClientOptions{Header: http.Header{}}
+
+	args, err := splitShellWords(s)
+	if err != nil {
+		return req, opts, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return req, opts, errors.New("not a curl command")
+	}
+	args = args[1:]
+
+	value := func(i *int, flag string) (string, error) {
+		if *i+1 >= len(args) {
+			return "", fmt.Errorf("curl flag %s needs a value", flag)
+		}
+		*i++
+		return args[*i], nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// Expand combined short flags like -sSL
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && allBoolFlags(arg) {
+			if strings.ContainsRune(arg[1:], 'k') {
+				opts.Insecure = true
+			}
+			continue
+		}
+
+		switch arg {
+		case "-X", "--request":
+			if opts.Method, err = value(&i, arg); err != nil {
+				return req, opts, err
+			}
+		case "-H", "--header":
+			h, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			name, val, ok := strings.Cut(h, ":")
+			if !ok {
+				return req, opts, fmt.Errorf("invalid curl header: %s", h)
+			}
+			name, val = strings.TrimSpace(name), strings.TrimSpace(val)
+			if strings.EqualFold(name, "Cookie") {
+				opts.Cookies = append(opts.Cookies, parseCookieHeader(val)...)
+				continue
+			}
+			opts.Header.Add(name, val)
+		case "-b", "--cookie":
+			c, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			if !strings.Contains(c, "=") {
+				return req, opts, fmt.Errorf("curl cookie files are not supported, use ImportCookiesTxt: %s", c)
+			}
+			opts.Cookies = append(opts.Cookies, parseCookieHeader(c)...)
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--data-urlencode":
+			d, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			if arg == "--data-urlencode" {
+				if d, err = urlencodeCurlData(d); err != nil {
+					return req, opts, err
+				}
+			}
+			if opts.Body != "" {
+				opts.Body += "&"
+			}
+			opts.Body += d
+		case "-u", "--user":
+			u, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			opts.Username, opts.Password, _ = strings.Cut(u, ":")
+		case "-A", "--user-agent":
+			ua, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			opts.Header.Set("User-Agent", ua)
+		case "-e", "--referer":
+			ref, err := value(&i, arg)
+			if err != nil {
+				return req, opts, err
+			}
+			opts.Header.Set("Referer", ref)
+		case "-x", "--proxy":
+			if opts.Proxy, err = value(&i, arg); err != nil {
+				return req, opts, err
+			}
+			if _, err := parseProxy(curlProxyURL(opts.Proxy)); err != nil {
+				return req, opts, err
+			}
+		case "-o", "--output":
+			if req.FileName, err = value(&i, arg); err != nil {
+				return req, opts, err
+			}
+		case "--url":
+			if req.URL, err = value(&i, arg); err != nil {
+				return req, opts, err
+			}
+		case "-k", "--insecure":
+			opts.Insecure = true
+		default:
+			if curlBoolFlags[arg] {
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				return req, opts, fmt.Errorf("unsupported curl flag: %s", arg)
+			}
+			if req.URL != "" {
+				return req, opts, errors.New("curl commands with multiple URLs are not supported")
+			}
+			req.URL = arg
+		}
+	}
+
+	if req.URL == "" {
+		return req, opts, errors.New("curl command has no URL")
+	}
+	if opts.Body != "" && opts.Method == "" {
+		opts.Method = http.MethodPost
+		if opts.Header.Get("Content-Type") == "" {
+			opts.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if strings.EqualFold(opts.Method, http.MethodGet) {
+		opts.Method = ""
+	}
+	opts.Header.Del("Accept-Encoding")
+	opts.URL = req.URL
+
+	if req.FileName == "" {
+		if u, err := url.Parse(req.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			req.FileName = path.Base(u.Path)
+		}
+	}
+
+	return req, opts, nil
+}
+
+// WithClientOptions replays imported curl settings on the Fetcher's requests.
+// Headers, cookies and credentials are only sent to the host of the imported URL,
+// never to hosts it redirects to. Method and Body only apply to the imported URL itself.
+//
+// Certificates are only left unverified for the host of the imported URL. The proxy
+// becomes the Fetcher's proxy, as if set with WithProxy, and is refused the same way
+// while private networks are blocked. Both need the client to use an *http.Transport:
+// with another transport the Fetcher's requests fail instead of going out without them.
+func WithClientOptions(opts ClientOptions) FetcherOption {
+	return func(f *Fetcher) {
+		if opts.Insecure {
+			if u, err := url.Parse(opts.URL); err == nil && u.Hostname() != "" {
+				f.insecureHosts = append(f.insecureHosts, u.Hostname())
+			}
+			f.transportOptions = append(f.transportOptions, "-k")
+		}
+		if opts.Proxy != "" {
+			f.policy.proxy = curlProxyURL(opts.Proxy)
+			f.transportOptions = append(f.transportOptions, "-x")
+		}
+		f.transportWrappers = append(f.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			return &clientOptionsTransport{base: base, opts: opts}
+		})
+	}
+}
+
+// curlProxyURL returns the URL of a curl proxy, which defaults to the http scheme.
+func curlProxyURL(proxy string) string {
+	if !strings.Contains(proxy, "://") {
+		return "http://" + proxy
+	}
+	return proxy
+}
+
+// wrapClientTLS makes base skip the verification of certificates for the insecure
+// hosts of WithClientOptions. With a transport other than *http.Transport, imported
+// settings cannot be applied and every request fails.
+func (f *Fetcher) wrapClientTLS(base http.RoundTripper) http.RoundTripper {
+	if len(f.transportOptions) == 0 {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return errorTransport{fmt.Errorf("curl options %s need the client to use an *http.Transport, not %T", strings.Join(f.transportOptions, ", "), base)}
+	}
+	if len(f.insecureHosts) == 0 || (t.TLSClientConfig != nil && t.TLSClientConfig.InsecureSkipVerify) {
+		return t
+	}
+	insecure := t.Clone()
+	if insecure.TLSClientConfig == nil {
+		insecure.TLSClientConfig = &tls.Config{}
+	}
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+	return &hostTransport{base: t, insecure: insecure, hosts: f.insecureHosts}
+}
+
+// hostTransport sends the requests to hosts through insecure, and the others
+// through base. Each keeps its own connections, so a connection made without
+// verification is never reused for another host.
+type hostTransport struct {
+	base, insecure *http.Transport
+	hosts          []string
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if slices.Contains(t.hosts, req.URL.Hostname()) {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *hostTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+	t.insecure.CloseIdleConnections()
+}
+
+// errorTransport fails every request with err.
+type errorTransport struct {
+	err error
+}
+
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
+
+type clientOptionsTransport struct {
+	base http.RoundTripper
+	opts ClientOptions
+}
+
+func (t *clientOptionsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	imported, err := url.Parse(t.opts.URL)
+	if err != nil || req.URL.Host != imported.Host {
+		return base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if req.URL.String() == t.opts.URL && t.opts.Method != "" && req.Method == http.MethodGet {
+		req.Method = t.opts.Method
+		body := t.opts.Body
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(body)), nil
+		}
+		req.ContentLength = int64(len(body))
+	}
+	for name, values := range t.opts.Header {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	for _, c := range t.opts.Cookies {
+		req.AddCookie(c)
+	}
+	if t.opts.Username != "" || t.opts.Password != "" {
+		req.SetBasicAuth(t.opts.Username, t.opts.Password)
+	}
+	return base.RoundTrip(req)
+}
+
+// urlencodeCurlData encodes the value of --data-urlencode like curl: "content" and
+// "=content" encode content, "name=content" only the content. Reading it from a
+// file with "@file" or "name@file" is not supported.
+func urlencodeCurlData(d string) (string, error) {
+	escape := func(s string) string {
+		// curl escapes spaces as %20 rather than +
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	i := strings.IndexAny(d, "=@")
+	switch {
+	case i == -1:
+		return escape(d), nil
+	case d[i] == '@':
+		return "", fmt.Errorf("curl data files are not supported: --data-urlencode %s", d)
+	case i == 0:
+		return escape(d[1:]), nil
+	}
+	return d[:i+1] + escape(d[i+1:]), nil
+}
+
+// allBoolFlags reports whether a combined short flag like "-sSL" consists only of known flags.
+func allBoolFlags(arg string) bool {
+	for _, c := range arg[1:] {
+		if !curlBoolFlags["-"+string(c)] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCookieHeader parses "a=1; b=2" into cookies.
+func parseCookieHeader(s string) []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// splitShellWords splits a command line the way bash would for the quoting
+// styles browsers emit: '...', "...", $'...', backslash escapes and line continuations.
+func splitShellWords(s string) ([]string, error) {
+	var (
+		words   []string
+		current strings.Builder
+		inWord  bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '\n' || (s[i+1] == '\r' && i+2 < len(s) && s[i+2] == '\n')):
+			// Line continuation
+			if s[i+1] == '\r' {
+				i++
+			}
+			i++
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			inWord = true
+			if i+1 < len(s) {
+				i++
+				current.WriteByte(s[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return nil, errors.New("unterminated single quote")
+			}
+			current.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			inWord = true
+			n, err := readANSIQuoted(s[i+2:], &current)
+			if err != nil {
+				return nil, err
+			}
+			i += n + 2
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) != -1 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				current.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, errors.New("unterminated double quote")
+			}
+		default:
+			inWord = true
+			current.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
+
+// readANSIQuoted decodes the body of a $'...' string up to and including the closing
+// quote, writing the result to out. It returns the number of bytes consumed.
+func readANSIQuoted(s string, out *strings.Builder) (int, error) {
+	escapes := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', '\\': '\\', '\'': '\'', '"': '"', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v', 'e': 0x1b}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			return i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			if e, ok := escapes[s[i]]; ok {
+				out.WriteByte(e)
+				continue
+			}
+			if s[i] == 'x' && i+2 < len(s) {
+				if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					out.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			if s[i] == 'u' && i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					out.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
@@ -0,0 +1,131 @@
+package dlfetch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCurlCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		req     DownloadRequest
+		opts    ClientOptions
+		wantErr string
+	}{
+		{
+			name: "plain",
+			cmd:  "curl https://example.com/a/file.zip",
+			req:  DownloadRequest{URL: "https://example.com/a/file.zip", FileName: "file.zip"},
+			opts: ClientOptions{URL: "https://example.com/a/file.zip", Header: http.Header{}},
+		},
+		{
+			name: "browser copy",
+			cmd: `curl 'https://example.com/f?x=1' \
+  -H 'Accept-Encoding: gzip' \
+  -H $'X-Note: it\'s\tfine' \
+  -H "Cookie: a=1; b=2" \
+  --compressed -sSLk`,
+			req: DownloadRequest{URL: "https://example.com/f?x=1", FileName: "f"},
+			opts: ClientOptions{
+				URL:      "https://example.com/f?x=1",
+				Header:   http.Header{"X-Note": {"it's\tfine"}},
+				Cookies:  []*http.Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+				Insecure: true,
+			},
+		},
+		{
+			name: "form",
+			cmd:  `curl -d a=1 --data-urlencode 'q=x y&z+' --data-urlencode '=é' --data-urlencode plain -u me:secret -o out.bin https://example.com/post`,
+			req:  DownloadRequest{URL: "https://example.com/post", FileName: "out.bin"},
+			opts: ClientOptions{
+				URL:      "https://example.com/post",
+				Method:   http.MethodPost,
+				Body:     "a=1&q=x%20y%26z%2B&%C3%A9&plain",
+				Header:   http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+				Username: "me",
+				Password: "secret",
+			},
+		},
+		{
+			name: "proxy",
+			cmd:  "curl -x 127.0.0.1:8080 -X GET -A agent https://example.com/",
+			req:  DownloadRequest{URL: "https://example.com/"},
+			opts: ClientOptions{URL: "https://example.com/", Header: http.Header{"User-Agent": {"agent"}}, Proxy: "127.0.0.1:8080"},
+		},
+		{name: "not curl", cmd: "wget https://example.com/", wantErr: "not a curl command"},
+		{name: "no url", cmd: "curl -s", wantErr: "no URL"},
+		{name: "two urls", cmd: "curl https://a/ https://b/", wantErr: "multiple URLs"},
+		{name: "missing value", cmd: "curl https://a/ -H", wantErr: "needs a value"},
+		{name: "unknown flag", cmd: "curl --upload-file x https://a/", wantErr: "unsupported curl flag"},
+		{name: "cookie file", cmd: "curl -b cookies.txt https://a/", wantErr: "cookie files"},
+		{name: "urlencode file", cmd: "curl --data-urlencode name@file https://a/", wantErr: "data files"},
+		{name: "proxy scheme", cmd: "curl -x ftp://proxy https://a/", wantErr: "unsupported scheme"},
+		{name: "quote", cmd: `curl 'https://a/`, wantErr: "unterminated single quote"},
+		{name: "ansi quote", cmd: `curl $'https://a/`, wantErr: "unterminated $' quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, opts, err := ParseCurlCommand(tt.cmd)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(req, tt.req) {
+				t.Errorf("request %+v, want %+v", req, tt.req)
+			}
+			if !reflect.DeepEqual(opts, tt.opts) {
+				t.Errorf("options %+v, want %+v", opts, tt.opts)
+			}
+		})
+	}
+}
+
+func TestClientOptionsInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	f := New(WithClientOptions(ClientOptions{URL: srv.URL, Insecure: true}))
+	resp, err := f.requestClient.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("imported host: %v", err)
+	}
+	resp.Body.Close()
+
+	// The same server under another name is verified
+	if _, err := f.requestClient.Get("https://localhost:" + u.Port()); err == nil {
+		t.Error("certificate of another host not verified")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
+
+func TestClientOptionsTransport(t *testing.T) {
+	client := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("sent")
+	})}
+	f := New(WithHTTPClient(client), WithClientOptions(ClientOptions{URL: "https://example.com/", Insecure: true, Proxy: "proxy:3128"}))
+	_, err := f.requestClient.Get("https://example.com/")
+	if err == nil || !strings.Contains(err.Error(), "-k, -x need the client to use an *http.Transport") {
+		t.Errorf("error %v", err)
+	}
+
+	// The proxy is refused like the one of WithProxy
+	f = New(WithBlockPrivateNetworks(), WithClientOptions(ClientOptions{URL: "https://example.com/", Proxy: "proxy:3128"}))
+	if res := f.Enqueue(DownloadRequest{ID: 1, URL: "https://example.com/", FileName: "x"}); !errors.Is(res.Error, ErrURLBlocked) {
+		t.Errorf("enqueue with a proxy: %v", res.Error)
+	}
+}
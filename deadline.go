@@ -0,0 +1,158 @@
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeadlinePolicy decides what happens to the downloads of a batch that are still
+// running when its deadline passes.
+type DeadlinePolicy int
+
+const (
+	// DeadlineFinishRunning lets running downloads complete; only queued and
+	// paused ones are dropped. This is the default.
+	DeadlineFinishRunning DeadlinePolicy = iota
+
+	// DeadlineAbortRunning cancels running downloads as well, so nothing is
+	// transferred after the deadline.
+	DeadlineAbortRunning
+)
+
+// Batch is a group of requests enqueued together with EnqueueBatch.
+type Batch struct {
+	fetcher *Fetcher
+	policy  DeadlinePolicy
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	done    *sync.Cond
+	pending map[int]struct{} // Queued and not finished yet
+	started map[int]bool     // Began transferring before the deadline
+	expired bool
+	report  BatchReport
+}
+
+// BatchReport tells what became of the requests of a batch.
+type BatchReport struct {
+	Completed  []DownloadResult
+	Failed     []DownloadOutcome // Failed or cancelled after they started
+	NotStarted []DownloadRequest // Dropped at the deadline before they started
+	Rejected   []EnqueueResult   // Not queued, e.g. invalid or skipped
+}
+
+// EnqueueBatch enqueues the requests as one batch with a wall-clock deadline, for
+// time-boxed jobs like grabbing as much as possible before a maintenance window.
+// Once the deadline passes no download of the batch is started anymore: queued and
+// paused requests are cancelled with ErrBatchDeadline, and running ones complete or,
+// with DeadlineAbortRunning, are cancelled as well. A zero deadline means none.
+// Use the returned Batch to wait for the outcome.
+func (f *Fetcher) EnqueueBatch(reqs []DownloadRequest, deadline time.Time, policy DeadlinePolicy) *Batch {
+	b := &Batch{
+		fetcher: f,
+		policy:  policy,
+		pending: make(map[int]struct{}),
+		started: make(map[int]bool),
+	}
+	b.done = sync.NewCond(&b.mu)
+
+	for _, req := range reqs {
+		req.batch = b
+		// Registered first, the request may finish before Enqueue returns
+		b.mu.Lock()
+		if _, ok := b.pending[req.ID]; ok {
+			req.batch = nil
+			b.report.Rejected = append(b.report.Rejected, EnqueueResult{Request: req, Error: fmt.Errorf("%w: %d", ErrDuplicateID, req.ID)})
+			b.mu.Unlock()
+			continue
+		}
+		b.pending[req.ID] = struct{}{}
+		b.mu.Unlock()
+
+		result := f.Enqueue(req)
+		if !result.Queued {
+			result.Request = req
+			result.Request.batch = nil
+			b.mu.Lock()
+			delete(b.pending, req.ID)
+			b.report.Rejected = append(b.report.Rejected, result)
+			b.mu.Unlock()
+		}
+	}
+
+	if !deadline.IsZero() {
+		b.mu.Lock()
+		if len(b.pending) > 0 {
+			b.timer = time.AfterFunc(time.Until(deadline), b.expire)
+		}
+		b.mu.Unlock()
+	}
+	return b
+}
+
+// Wait blocks until every queued request of the batch has completed, failed or was
+// dropped at the deadline, and returns the report.
+func (b *Batch) Wait() BatchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.pending) > 0 {
+		b.done.Wait()
+	}
+	return b.report
+}
+
+// start records that the request got its slots and begins transferring. A request
+// still waiting for one is not started. After the deadline it is not recorded; the
+// request was cancelled and ends without transferring anything.
+func (b *Batch) start(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.expired {
+		b.started[id] = true
+	}
+}
+
+// finish adds the outcome of a request to the report.
+func (b *Batch) finish(req DownloadRequest, result DownloadResult, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	req.batch = nil
+	switch {
+	case err == nil:
+		b.report.Completed = append(b.report.Completed, result)
+	case errors.Is(err, ErrBatchDeadline) && !b.started[req.ID]:
+		b.report.NotStarted = append(b.report.NotStarted, req)
+	default:
+		b.report.Failed = append(b.report.Failed, DownloadOutcome{Fetcher: b.fetcher.name, Request: req, Result: result, Err: err})
+	}
+
+	delete(b.pending, req.ID)
+	if len(b.pending) == 0 {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.done.Broadcast()
+	}
+}
+
+// expire cancels the requests the deadline stops.
+func (b *Batch) expire() {
+	b.mu.Lock()
+	b.expired = true
+	var ids []int
+	for id := range b.pending {
+		if b.policy == DeadlineFinishRunning && b.started[id] && !b.fetcher.isPausedID(id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+
+	for _, id := range ids {
+		// Requests that finished in the meantime are no longer known
+		_ = b.fetcher.cancelRequest(id, ErrBatchDeadline)
+	}
+}
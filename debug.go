@@ -0,0 +1,197 @@
+package dlfetch
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Stages of a request being worked on, as reported by DebugState.
+const (
+	stageStarting   = "starting"
+	stageHostSlot   = "waiting for host slot"
+	stageThrottle   = "waiting for throttle"
+	stageCongestion = "waiting for congestion window"
+	stageDownload   = "downloading"
+	stageRetry      = "waiting to retry"
+	stagePostProc   = "post-processing"
+)
+
+// DebugState is a snapshot of the internals of a Fetcher for diagnosing downloads
+// that stopped progressing, e.g. exposed on an admin endpoint as JSON.
+type DebugState struct {
+	Fetcher       string         `json:"fetcher,omitempty"` // Name of the Fetcher, see WithName
+	State         string         `json:"state"`             // idle, running, draining or stopped
+	Workers       int            `json:"workers"`
+	Queued        int            `json:"queued"` // Requests waiting in the queue
+	QueueCapacity int            `json:"queueCapacity"`
+	Outstanding   int            `json:"outstanding"` // Queued, running and paused requests, as counted by Wait
+	Paused        int            `json:"paused"`
+	Bandwidth     int64          `json:"bandwidth"` // Bytes per second, 0 for no limit
+	Tasks         []DebugTask    `json:"tasks"`     // Requests being worked on, longest in their stage first
+	Limiters      []DebugLimiter `json:"limiters,omitempty"`
+}
+
+// DebugTask describes a request a worker, or Download, is working on.
+type DebugTask struct {
+	ID           int           `json:"id"`
+	URL          string        `json:"url"`
+	Stage        string        `json:"stage"` // e.g. "waiting for host slot", "downloading" or "waiting to retry"
+	Started      time.Time     `json:"started"`
+	StageSince   time.Time     `json:"stageSince"`
+	StageAge     time.Duration `json:"stageAge"`              // How long the task is in its stage already
+	Bytes        int64         `json:"bytes"`                 // Bytes read so far, over all attempts
+	LastProgress time.Time     `json:"lastProgress,omitzero"` // When the last bytes arrived
+}
+
+// DebugLimiter describes how busy one of the concurrency limits is.
+type DebugLimiter struct {
+	Name      string `json:"name"` // e.g. "host example.com", "congestion", "throttle" or "tenant alice"
+	Active    int    `json:"active"`
+	Limit     int    `json:"limit"`     // 0 for no limit
+	Saturated bool   `json:"saturated"` // Further downloads have to wait
+}
+
+// DebugState returns a snapshot of the queue, the requests being worked on and the
+// concurrency limits. It is safe to call at any time.
+func (f *Fetcher) DebugState() DebugState {
+	f.stateMu.RLock()
+	state := map[fetcherState]string{stateIdle: "idle", stateRunning: "running", stateStopped: "stopped"}[f.state]
+	if f.draining && f.state == stateRunning {
+		state = "draining"
+	}
+	f.stateMu.RUnlock()
+
+	f.outstandingMu.Lock()
+	outstanding := f.outstanding
+	f.outstandingMu.Unlock()
+
+	f.cancelsMu.Lock()
+	paused := len(f.paused)
+	f.cancelsMu.Unlock()
+
+	s := DebugState{
+		Fetcher:       f.name,
+		State:         state,
+		Workers:       f.workerCount(),
+		Queued:        len(f.queue),
+		QueueCapacity: cap(f.queue),
+		Outstanding:   outstanding,
+		Paused:        paused,
+		Bandwidth:     max(0, f.bandwidth.limit()),
+		Tasks:         f.activity.snapshot(),
+	}
+
+	if f.hostLimiter != nil {
+		s.Limiters = append(s.Limiters, f.hostLimiter.debug("host ")...)
+	}
+	if f.shared != nil && f.shared.hosts != nil {
+		s.Limiters = append(s.Limiters, f.shared.hosts.debug("shared host ")...)
+	}
+	if f.congestion != nil {
+		f.congestion.mu.Lock()
+		s.Limiters = append(s.Limiters, debugLimiter("congestion", f.congestion.active, f.congestion.limit))
+		f.congestion.mu.Unlock()
+	}
+	if f.throttle != nil {
+		f.throttle.mu.Lock()
+		s.Limiters = append(s.Limiters, debugLimiter("throttle", f.throttle.active, f.throttle.limit))
+		f.throttle.mu.Unlock()
+	}
+	if f.tenants != nil {
+		f.tenants.mu.Lock()
+		for name, st := range f.tenants.tenants {
+			s.Limiters = append(s.Limiters, debugLimiter("tenant "+name, st.active, f.tenants.quota(name).MaxActive))
+		}
+		f.tenants.mu.Unlock()
+	}
+	slices.SortFunc(s.Limiters, func(a, b DebugLimiter) int { return cmp.Compare(a.Name, b.Name) })
+	return s
+}
+
+func debugLimiter(name string, active, limit int) DebugLimiter {
+	return DebugLimiter{Name: name, Active: active, Limit: limit, Saturated: limit > 0 && active >= limit}
+}
+
+// debug describes the hosts with running downloads.
+func (l *hostLimiter) debug(prefix string) []DebugLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var limiters []DebugLimiter
+	for host, s := range l.hosts {
+		if s.active > 0 {
+			limiters = append(limiters, debugLimiter(prefix+host, s.active, s.limit))
+		}
+	}
+	return limiters
+}
+
+// activityTracker follows the requests being worked on for DebugState.
+type activityTracker struct {
+	mu    sync.Mutex
+	tasks map[*taskActivity]bool
+}
+
+// taskActivity is the state of one request being worked on.
+type taskActivity struct {
+	id         int
+	url        string
+	started    time.Time
+	stage      string
+	stageSince time.Time
+	counter    *tokenBucket // Unlimited, counts the bytes read
+}
+
+// begin starts following req.
+func (a *activityTracker) begin(req DownloadRequest) *taskActivity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	t := &taskActivity{id: req.ID, url: req.URL, started: now, stage: stageStarting, stageSince: now, counter: newTokenBucket(0)}
+	if a.tasks == nil {
+		a.tasks = make(map[*taskActivity]bool)
+	}
+	a.tasks[t] = true
+	return t
+}
+
+// end stops following a request.
+func (a *activityTracker) end(t *taskActivity) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.tasks, t)
+}
+
+// setStage records that the request of t entered stage. t may be nil.
+func (a *activityTracker) setStage(t *taskActivity, stage string) {
+	if t == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t.stage = stage
+	t.stageSince = time.Now()
+}
+
+func (a *activityTracker) snapshot() []DebugTask {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	tasks := make([]DebugTask, 0, len(a.tasks))
+	for t := range a.tasks {
+		bytes, last := t.counter.usage()
+		tasks = append(tasks, DebugTask{
+			ID:           t.id,
+			URL:          t.url,
+			Stage:        t.stage,
+			Started:      t.started,
+			StageSince:   t.stageSince,
+			StageAge:     now.Sub(t.stageSince),
+			Bytes:        bytes,
+			LastProgress: last,
+		})
+	}
+	slices.SortFunc(tasks, func(a, b DebugTask) int { return a.StageSince.Compare(b.StageSince) })
+	return tasks
+}
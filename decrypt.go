@@ -0,0 +1,102 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Decrypter is a post-processor that decrypts downloaded ".age" files with the age
+// binary and ".gpg" and ".pgp" files with gpg, e.g. for encrypted artifacts served
+// from a public CDN. Other files are left alone. Use it with WithPostProcessors:
+//
+//	dlfetch.WithPostProcessors(&dlfetch.Decrypter{AgeIdentities: []string{"/etc/keys/age.txt"}})
+//
+// The plaintext is written next to the download without the extension, and the
+// result then points to it, with its Size and SHA256 describing the plaintext. The
+// keys for gpg are taken from its keyring, see GPGArgs.
+type Decrypter struct {
+	AgeBinary     string   // Path to the age binary, defaults to "age" on PATH
+	AgeIdentities []string // Identity files passed to age with -i
+	GPGBinary     string   // Path to the gpg binary, defaults to "gpg" on PATH
+	GPGArgs       []string // Extra gpg flags, e.g. "--homedir", "/etc/keys" or "--passphrase-file", "/etc/pass"
+	KeepEncrypted bool     // Keep the encrypted file beside the plaintext instead of removing it
+}
+
+// Process decrypts the file of result if it is encrypted.
+func (d *Decrypter) Process(ctx context.Context, result *DownloadResult) error {
+	ext := strings.ToLower(filepath.Ext(result.Path))
+	var cmd func(ctx context.Context, src, dst string) *exec.Cmd
+	switch ext {
+	case ".age":
+		cmd = d.ageCommand
+	case ".gpg", ".pgp":
+		cmd = d.gpgCommand
+	default:
+		return nil
+	}
+
+	dst := strings.TrimSuffix(result.Path, filepath.Ext(result.Path))
+	if checkFileExists(dst) {
+		return fmt.Errorf("%w: %s", ErrFileExists, dst)
+	}
+	// Decrypt into a temporary file, a failure must not leave half a plaintext
+	tmp := dst + ".decrypting"
+	var stderr bytes.Buffer
+	c := cmd(ctx, result.Path, tmp)
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("decrypting %s failed: %w: %s", result.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	sum, size, err := hashFile(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if !d.KeepEncrypted {
+		if err := os.Remove(result.Path); err != nil {
+			return err
+		}
+	}
+
+	result.Path = dst
+	result.Size = size
+	result.SHA256 = sum
+	result.FileName = strings.TrimSuffix(result.FileName, filepath.Ext(result.FileName))
+	if t := mime.TypeByExtension(filepath.Ext(dst)); t != "" {
+		result.MimeType = t
+	}
+	return nil
+}
+
+func (d *Decrypter) ageCommand(ctx context.Context, src, dst string) *exec.Cmd {
+	binary := d.AgeBinary
+	if binary == "" {
+		binary = "age"
+	}
+	args := []string{"--decrypt", "--output", dst}
+	for _, id := range d.AgeIdentities {
+		args = append(args, "--identity", id)
+	}
+	return exec.CommandContext(ctx, binary, append(args, src)...)
+}
+
+func (d *Decrypter) gpgCommand(ctx context.Context, src, dst string) *exec.Cmd {
+	binary := d.GPGBinary
+	if binary == "" {
+		binary = "gpg"
+	}
+	args := append([]string{"--batch", "--yes", "--quiet"}, d.GPGArgs...)
+	args = append(args, "--output", dst, "--decrypt", src)
+	return exec.CommandContext(ctx, binary, args...)
+}
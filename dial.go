@@ -0,0 +1,188 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// dialer connects to hosts with configurable dual-stack fallback. Like net.Dialer it
+// tries the addresses of the preferred family one after another and, after the fallback
+// delay, races the other family against them (Happy Eyeballs). Unlike net.Dialer every
+// address gets its own connect timeout instead of a share of an overall one, so a
+// black-holed IPv6 address costs at most that timeout.
+type dialer struct {
+	fallbackDelay  time.Duration // 0 uses net.Dialer's default, negative disables the race
+	connectTimeout time.Duration // Per address, 0 means no limit besides the request context
+	blockPrivate   bool          // Refuse addresses outside the public internet, see WithBlockPrivateNetworks
+}
+
+// WithFallbackDelay sets how long to wait for an IPv6 connection before racing IPv4
+// against it. Lower it on networks with broken IPv6; a negative delay disables the race
+// and tries the addresses strictly in order.
+// It only applies when the client uses an *http.Transport.
+func WithFallbackDelay(d time.Duration) FetcherOption {
+	return func(f *Fetcher) {
+		if f.dialer == nil {
+			f.dialer = &dialer{}
+		}
+		f.dialer.fallbackDelay = d
+	}
+}
+
+// WithConnectTimeout limits how long connecting to a single address of a host may take
+// before the next address is tried.
+// It only applies when the client uses an *http.Transport.
+func WithConnectTimeout(d time.Duration) FetcherOption {
+	return func(f *Fetcher) {
+		if f.dialer == nil {
+			f.dialer = &dialer{}
+		}
+		f.dialer.connectTimeout = d
+	}
+}
+
+// wrap returns a copy of base that dials through d.
+// Transports other than *http.Transport are returned unchanged.
+func (d *dialer) wrap(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	t = t.Clone()
+	t.DialContext = d.dialContext
+	return t
+}
+
+func (d *dialer) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	// The family of the first address is preferred, as in net.Dialer
+	var primary, fallback []net.IPAddr
+	for _, a := range addrs {
+		if (a.IP.To4() == nil) == (addrs[0].IP.To4() == nil) {
+			primary = append(primary, a)
+		} else {
+			fallback = append(fallback, a)
+		}
+	}
+
+	if len(fallback) == 0 || d.fallbackDelay < 0 {
+		return d.dialSerial(ctx, network, port, append(primary, fallback...))
+	}
+
+	delay := d.fallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, 2)
+	race := func(addrs []net.IPAddr) {
+		conn, err := d.dialSerial(ctx, network, port, addrs)
+		results <- dialResult{conn, err}
+	}
+
+	go race(primary)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	started, pending := 1, 1
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			go race(fallback)
+			started++
+			pending++
+			continue
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					// Close the connection of the slower family if it succeeds too
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if started == 1 {
+				// The primary family failed early, try the fallback right away
+				timer.Stop()
+				go race(fallback)
+				started++
+				pending++
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial tries each address in turn, each with its own connect timeout.
+func (d *dialer) dialSerial(ctx context.Context, network, port string, addrs []net.IPAddr) (net.Conn, error) {
+	var nd net.Dialer
+	var firstErr error
+	for _, a := range addrs {
+		if addr, ok := netip.AddrFromSlice(a.IP); d.blockPrivate && (!ok || !isPublicAddr(addr)) {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: address %s is not public", ErrURLBlocked, a.IP)
+			}
+			continue
+		}
+		attemptCtx := ctx
+		cancel := context.CancelFunc(func() {})
+		if d.connectTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, d.connectTimeout)
+		}
+		conn, err := nd.DialContext(attemptCtx, network, net.JoinHostPort(a.String(), port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no addresses to dial")
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,157 @@
+package dlfetch
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// digestAlgorithms are the digest algorithms checked, by their name in the
+// Repr-Digest, Content-Digest and Digest headers.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha":     sha1.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// digester computes the digests a response announces and the one the checksum of
+// the request needs, so they can be checked once the body is read. SHA-256 is left
+// to the caller, which computes it anyway.
+//
+// Servers announce digests with Content-MD5, Repr-Digest and Content-Digest
+// (RFC 9530) or Digest (RFC 3230), as headers or as trailers. Digests of other
+// algorithms than SHA-256 that only show up in undeclared trailers are not checked,
+// since the content was not hashed with them.
+type digester struct {
+	hashes map[string]hash.Hash
+}
+
+func newDigester(resp *http.Response, req DownloadRequest) *digester {
+	d := &digester{hashes: make(map[string]hash.Hash)}
+	algs := announcedDigests(resp)
+	if req.checksumAlg != "" {
+		algs[req.checksumAlg] = true
+	}
+	for alg := range algs {
+		if alg != "sha-256" {
+			d.hashes[alg] = digestAlgorithms[alg]()
+		}
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify checks the content against the digests the server sent, given its
+// SHA-256. whole tells whether the content is the whole file rather than a range of
+// it. It returns the algorithms checked, sorted, and an error matching
+// ErrDigestMismatch if a digest does not match.
+func (d *digester) verify(resp *http.Response, whole bool, sha256Sum []byte) ([]string, error) {
+	if resp.Uncompressed {
+		// The digests cover the encoded content, which is gone
+		return nil, nil
+	}
+
+	var checked []string
+	for alg, want := range serverDigests(resp, whole) {
+		var got []byte
+		if alg == "sha-256" {
+			got = sha256Sum
+		} else if h, ok := d.hashes[alg]; ok {
+			got = h.Sum(nil)
+		} else {
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			return nil, fmt.Errorf("%w: %s is %s, server sent %s", ErrDigestMismatch, alg, hex.EncodeToString(got), hex.EncodeToString(want))
+		}
+		checked = append(checked, alg)
+	}
+	slices.Sort(checked)
+	return checked, nil
+}
+
+// announcedDigests returns the supported algorithms of the digests in the headers
+// of resp and in its declared trailers, whose values are not known yet.
+func announcedDigests(resp *http.Response) map[string]bool {
+	algs := make(map[string]bool)
+	for alg := range serverDigests(resp, true) {
+		algs[alg] = true
+	}
+	for key := range resp.Trailer {
+		switch key {
+		case "Content-Md5":
+			algs["md5"] = true
+		case "Repr-Digest", "Content-Digest", "Digest":
+			// The algorithms are only known once the trailer arrived
+			for alg := range digestAlgorithms {
+				algs[alg] = true
+			}
+		}
+	}
+	return algs
+}
+
+// serverDigests returns the digests the server sent for resp by algorithm. whole
+// tells whether the download is the whole file; a download of a byte range is not
+// covered by any of them.
+func serverDigests(resp *http.Response, whole bool) map[string][]byte {
+	digests := make(map[string][]byte)
+	if !whole {
+		return digests
+	}
+	for _, h := range []http.Header{resp.Header, resp.Trailer} {
+		// The body of a resumed download is only the rest of the file
+		if resp.StatusCode != http.StatusPartialContent {
+			if v := h.Get("Content-MD5"); v != "" {
+				if sum, err := base64.StdEncoding.DecodeString(v); err == nil {
+					digests["md5"] = sum
+				}
+			}
+			parseDigestFields(h.Get("Content-Digest"), true, digests)
+		}
+		parseDigestFields(h.Get("Repr-Digest"), true, digests)
+		parseDigestFields(h.Get("Digest"), false, digests)
+	}
+	return digests
+}
+
+// parseDigestFields parses the value of a Repr-Digest or Content-Digest header,
+// a structured field like "sha-256=:base64:", or with structured false the value
+// of a Digest header like "SHA-256=base64", into digests.
+func parseDigestFields(value string, structured bool, digests map[string][]byte) {
+	for _, field := range strings.Split(value, ",") {
+		alg, encoded, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		alg = strings.ToLower(alg)
+		if _, supported := digestAlgorithms[alg]; !supported {
+			continue
+		}
+		if structured {
+			if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+				continue
+			}
+			encoded = encoded[1 : len(encoded)-1]
+		}
+		if sum, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			digests[alg] = sum
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package dlfetch
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// WithDiskSpaceCheck makes downloads of a known size fail fast with
+// ErrInsufficientSpace when the file system of the target has less free space than
+// the remaining bytes plus margin, instead of running out of space mid-copy.
+// The check is skipped where the free space cannot be determined.
+func WithDiskSpaceCheck(margin int64) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.checkSpace = true
+		f.policy.spaceMargin = margin
+	}
+}
+
+// checkDiskSpace verifies that need bytes plus the margin fit next to path.
+// Unknown sizes (need < 0) are not checked.
+func (p policy) checkDiskSpace(path string, need int64) error {
+	if !p.checkSpace || need < 0 {
+		return nil
+	}
+	free, ok := freeSpace(filepath.Dir(path))
+	if !ok {
+		return nil
+	}
+	if want := need + p.spaceMargin; want > 0 && uint64(want) > free {
+		return fmt.Errorf("%w: %s needs %d bytes, %d free", ErrInsufficientSpace, path, want, free)
+	}
+	return nil
+}
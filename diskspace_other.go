@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package dlfetch
+
+// freeSpace is not implemented on this platform, the disk space check is skipped.
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
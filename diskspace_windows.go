@@ -0,0 +1,21 @@
+//go:build windows
+
+package dlfetch
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns the bytes available to the current user on the volume of dir.
+func freeSpace(dir string) (uint64, bool) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var available uint64
+	ok, _, _ := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	return available, ok != 0
+}
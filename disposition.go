@@ -0,0 +1,88 @@
+package dlfetch
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileNameFromURL derives a file name from the last path segment of a URL, without
+// query string or fragment and percent-decoded. URLs without one, like
+// "https://example.com/?id=42", are named after their host.
+func fileNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		rawURL, _, _ = strings.Cut(rawURL, "?")
+		return sanitizeFileName(path.Base(rawURL))
+	}
+	if name := sanitizeFileName(path.Base(u.Path)); name != "" {
+		return name
+	}
+	if name := sanitizeFileName(u.Hostname()); name != "" {
+		return name
+	}
+	return "download"
+}
+
+// dispositionFileName returns the file name suggested by the Content-Disposition
+// header of resp, empty if there is none. RFC 5987 "filename*" values are decoded.
+func dispositionFileName(resp *http.Response) string {
+	header := resp.Header.Get("Content-Disposition")
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return sanitizeFileName(params["filename"])
+}
+
+// sanitizeFileName strips directories and characters that are unsafe in file names,
+// returning an empty string when nothing usable is left.
+func sanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// applyDispositionName renames the file of a request that was named after its URL
+// to the name the server suggested. The file keeps its name if that one is taken.
+func (f *Fetcher) applyDispositionName(req DownloadRequest, result *DownloadResult) error {
+	name := result.ServerFileName
+	if !req.nameFromURL || name == "" || name == result.FileName {
+		return nil
+	}
+
+	dest := filepath.Join(filepath.Dir(result.Path), name)
+	if f.claimPath(dest) != nil {
+		return nil
+	}
+	defer f.releasePath(dest)
+	// Fails rather than replacing a file that is there
+	if err := tryCommit(result.Path, dest); err != nil {
+		if errors.Is(err, ErrFileExists) {
+			return nil
+		}
+		return err
+	}
+
+	result.OriginalFileName = result.FileName
+	result.FileName = name
+	result.Path = dest
+	return nil
+}
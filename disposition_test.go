@@ -0,0 +1,101 @@
+package dlfetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDispositionFileName(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"inline", ""},
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{"attachment; filename=report.pdf", "report.pdf"},
+		{`attachment; filename="a;b.txt"`, "a;b.txt"},
+		{`attachment; filename="say \"hi\".txt"`, "say _hi_.txt"},
+		{`attachment; filename="my report.pdf"`, "my report.pdf"},
+		{"attachment; filename=my report.pdf", ""},
+		{"attachment; filename*=UTF-8''%E2%82%AC%20rates.pdf", "€ rates.pdf"},
+		{`attachment; filename="fallback.pdf"; filename*=UTF-8''%C3%A9t%C3%A9.pdf`, "été.pdf"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="..\\..\\boot.ini"`, "boot.ini"},
+		{`attachment; filename=".."`, ""},
+		{`attachment; filename="dir\name.txt"`, "name.txt"},
+		{"attachment; filename=\"a\x01b<c>.txt\"", "a_b_c_.txt"},
+		{`attachment; filename="  padded.txt  "`, "padded.txt"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Content-Disposition", tt.header)
+		}
+		if got := dispositionFileName(resp); got != tt.want {
+			t.Errorf("dispositionFileName(%s) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestFileNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/files/a.tar.gz", "a.tar.gz"},
+		{"https://example.com/files/a.bin?sig=abc#top", "a.bin"},
+		{"https://example.com/files/my%20file.txt", "my file.txt"},
+		{"https://example.com/files/a%2F..%2Fb", "b"},
+		{"https://example.com/?id=42", "example.com"},
+		{"https://[::1]/", "__1"},
+		{"file:///", "download"},
+		{"http://h/%zz?x", "%zz"},
+	}
+	for _, tt := range tests {
+		if got := fileNameFromURL(tt.url); got != tt.want {
+			t.Errorf("fileNameFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDispositionRename(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="real.bin"`)
+		w.Write([]byte("downloaded"))
+	}))
+	defer srv.Close()
+
+	for _, taken := range []bool{false, true} {
+		dir := t.TempDir()
+		if taken {
+			if err := os.WriteFile(filepath.Join(dir, "real.bin"), []byte("theirs"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f := New(WithTargetDir(dir))
+		result, err := f.Download(context.Background(), DownloadRequest{ID: 1, URL: srv.URL + "/get"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, wantReal := "real.bin", "downloaded"
+		if taken {
+			// The file keeps the name of its URL
+			want, wantReal = "get", "theirs"
+		}
+		if result.FileName != want || result.Path != filepath.Join(dir, want) {
+			t.Errorf("taken %v: saved as %s at %s, want %s", taken, result.FileName, result.Path, want)
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "real.bin")); string(b) != wantReal {
+			t.Errorf("taken %v: real.bin has %q, want %q", taken, b, wantReal)
+		}
+		if b, _ := os.ReadFile(result.Path); string(b) != "downloaded" {
+			t.Errorf("taken %v: download has %q", taken, b)
+		}
+	}
+}
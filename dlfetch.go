@@ -1,11 +1,20 @@
 package dlfetch
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Default configuration values
@@ -13,23 +22,107 @@ const (
 	defaultTargetDir = "./downloads"
 	defaultWorkers   = 4
 	defaultQueueSize = 100
+	defaultTmpSuffix = ".tmp"
 )
 
 // Fetcher is responsible for managing download requests and processing them.
 // It supports configuration through functional options.
 type Fetcher struct {
-	requestClient   *http.Client                 // HTTP client to make requests
-	maxWorkers      int                          // Maximum number of concurrent workers
-	targetDir       string                       // Directory to save downloaded files
-	queue           chan DownloadRequest         // Channel to queue download requests
-	wg              sync.WaitGroup               // WaitGroup to manage goroutines
-	stopChan        chan struct{}                // Channel to signal stopping of fetcher
-	onComplete      func(DownloadResult)         // Callback function on download completion
-	onError         func(DownloadRequest, error) // Callback function on error
-	monitor         Monitor                      // Monitor to track download progress and status
-	enableOverwrite bool                         // Enable Overwriting when file is already available
+	requestClient     *http.Client                                // HTTP client to make requests
+	maxWorkers        int                                         // Maximum number of concurrent workers
+	queue             chan DownloadRequest                        // Channel to queue download requests
+	wg                sync.WaitGroup                              // WaitGroup to manage goroutines
+	stopChan          chan struct{}                               // Channel to signal stopping of fetcher
+	onComplete        func(DownloadResult)                        // Callback function on download completion
+	onError           func(DownloadRequest, error)                // Callback function on error
+	monitor           Monitor                                     // Monitor to track download progress and status
+	orderer           *completionOrderer                          // Delivers callbacks in enqueue order when set
+	nextSeq           atomic.Uint64                               // Sequence number assigned to the next queued request
+	pathsMu           sync.Mutex                                  // Guards paths
+	paths             map[string]struct{}                         // Target paths claimed by queued or running requests
+	lifecycleMu       sync.Mutex                                  // Serializes Start and Stop
+	stateMu           sync.RWMutex                                // Guards state and stopChan
+	state             fetcherState                                // Current lifecycle state
+	postProcessors    []PostProcessor                             // Steps run on completed downloads
+	sinks             map[string]Sink                             // Named upload targets selectable per request
+	rclone            *Rclone                                     // Handles rclone:// request URLs when set
+	policyMu          sync.RWMutex                                // Guards policy
+	policy            policy                                      // Settings that can be changed while running, see Reload
+	workerQuits       []chan struct{}                             // Per-worker quit channels, used to scale the pool
+	presets           map[string]Preset                           // Named request presets
+	transportWrappers []func(http.RoundTripper) http.RoundTripper // Applied to the client's transport in New
+	urlPolicies       []URLPolicy                                 // Checked for every URL requested, see WithURLPolicy
+	redirects         *redirectPolicy                             // Limits on followed redirects, nil for the client's policy
+	probes            *probePool                                  // Probes queued requests for their size, nil when disabled, see WithProbeOnEnqueue
+	checksums         ChecksumLookup                              // Looks up the expected SHA-256 of downloads, nil for none
+	logger            *slog.Logger                                // Logs the life of downloads, discards by default
+	webhooks          webhooks                                    // Notified of completed and failed downloads, see WithWebhook
+	tracer            Tracer                                      // Traces downloads, nil for none
+	contentCheck      *ContentCheck                               // Default check of the start of downloads, nil for none
+	fairShare         *fairShare                                  // Splits the bandwidth between running downloads, nil for first come, first served
+	activity          activityTracker                             // Requests being worked on, see DebugState
+	report            reportLog                                   // Outcome of every processed request
+	hostLimiter       *hostLimiter                                // Per-host download limits, nil when disabled
+	congestion        *congestionControl                          // Global backoff under congestion, nil when disabled
+	throttle          *throttleHook                               // Battery or thermal throttling, nil when disabled
+	dialer            *dialer                                     // Custom dual-stack dialing, nil uses the transport's own dialer
+	cache             *CacheIndex                                 // Records completed downloads for EnqueueIfChanged, nil when disabled
+	bandwidth         *tokenBucket                                // Shared limit on the aggregate download speed
+	errorSink         ErrorSink                                   // Receives a record of every failed download
+	tenants           *tenantLimiter                              // Per-tenant quotas, nil when none are set
+	cancelsMu         sync.Mutex
+	cancels           map[int]cancelHandle    // Queued and running requests by ID, for Cancel
+	paused            map[int]DownloadRequest // Requests stopped by Pause, guarded by cancelsMu
+	activeIDs         map[int]struct{}        // IDs of the requests being worked on, guarded by cancelsMu, see claimID
+	mimeDetector      MimeDetector            // Sets DownloadResult.MimeType, nil for HeaderMimeDetector
+	draining          bool                    // Set by Drain, guarded by stateMu
+	outstandingMu     sync.Mutex
+	outstanding       int        // Queued, running and paused requests, for Wait
+	idle              *sync.Cond // Signalled when outstanding drops to zero
+	resultsMu         sync.RWMutex
+	results           chan DownloadOutcome // Created by Results, nil until then
+	resultStore       ResultStore          // Persists completed downloads
+	name              string               // Identifies the Fetcher in snapshots, reports and events, see WithName
+	shared            *SharedLimiter       // Limits shared with other Fetchers, nil when none
+	hostPacer         *hostPacer           // Spaces out requests per host, nil when disabled
+	insecureHosts     []string             // Hosts whose TLS certificates are not verified, see WithClientOptions
+	transportOptions  []string             // Imported settings that need an *http.Transport, see WithClientOptions
+	config            Config               // Of NewFromConfig or the last Reload, guarded by policyMu
 }
 
+// policy holds the settings that can be changed on a running Fetcher.
+// Readers take a snapshot with currentPolicy so a download sees consistent values.
+type policy struct {
+	targetDir        string          // Directory to save downloaded files
+	overwrite        OverwritePolicy // What to do when the target file already exists
+	tmpSuffix        string          // Suffix of in-progress staging files
+	hiddenStaging    bool            // Dot-prefix staging file names
+	doneMarker       string          // Suffix of the marker written after completion, empty to disable
+	incompleteMarker string          // Suffix of the marker present during download, empty to disable
+	mirrorRemotePath bool            // Reproduce the URL's host and path hierarchy under targetDir
+	segments         int             // Maximum number of concurrent ranges per download, see WithSegments
+	minSegment       int64           // Bounds of the segment size WithAutoTune finds, 0 for the defaults
+	maxSegment       int64
+	correctExtension bool     // Add the extension of their MIME type to files without one
+	partSize         int64    // Write downloads as parts of this size, 0 to disable, see WithSplitParts
+	checkSpace       bool     // Check free disk space before writing, see WithDiskSpaceCheck
+	spaceMargin      int64    // Bytes to keep free on top of the download
+	allowedTypes     []string // Accepted Content-Type patterns, empty for all, see WithAllowedContentTypes
+	deniedTypes      []string // Rejected Content-Type patterns
+	sparse           bool     // Write downloads in place with a completion map, see WithSparseFiles
+	proxy            string   // Proxy URL for all downloads, see WithProxy
+	retries          int      // Retries of transient failures per download, see WithRetries
+}
+
+// fetcherState describes where a Fetcher is in its lifecycle.
+type fetcherState int
+
+const (
+	stateIdle    fetcherState = iota // Created, not started yet; requests are queued
+	stateRunning                     // Workers are processing the queue
+	stateStopped                     // Stopped; requests are rejected until started again
+)
+
 // FetcherOption defines a function type for configuring the Fetcher.
 // Each option function modifies the Fetcher's fields.
 type FetcherOption func(*Fetcher)
@@ -51,7 +144,7 @@ func WithMaxWorkers(max int) FetcherOption {
 // WithTargetDir sets the target directory for downloaded files.
 func WithTargetDir(dir string) FetcherOption {
 	return func(f *Fetcher) {
-		f.targetDir = dir
+		f.policy.targetDir = dir
 	}
 }
 
@@ -69,6 +162,20 @@ func WithOnError(callback func(DownloadRequest, error)) FetcherOption {
 	}
 }
 
+// WithName names the Fetcher, so that several Fetchers in one process, e.g. separate
+// pools for images and videos, can be told apart in monitor snapshots, reports,
+// failure records and Results outcomes.
+func WithName(name string) FetcherOption {
+	return func(f *Fetcher) {
+		f.name = name
+	}
+}
+
+// Name returns the name set with WithName, empty if none was set.
+func (f *Fetcher) Name() string {
+	return f.name
+}
+
 // WithMonitor sets the Monitor for the Fetcher.
 func WithMonitor(m Monitor) FetcherOption {
 	return func(f *Fetcher) {
@@ -76,11 +183,76 @@ func WithMonitor(m Monitor) FetcherOption {
 	}
 }
 
-// WithEnableOverwrite sets the enableOverwrite for the Fetcher
-// When enabled the files gets overwritten if they exists
+// WithEnableOverwrite sets the overwrite policy of the Fetcher.
+// When enabled the files gets overwritten if they exists (OverwriteReplace),
+// otherwise requests for existing files fail (OverwriteError).
 func WithEnableOverwrite(eo bool) FetcherOption {
 	return func(f *Fetcher) {
-		f.enableOverwrite = eo
+		f.policy.overwrite = OverwriteError
+		if eo {
+			f.policy.overwrite = OverwriteReplace
+		}
+	}
+}
+
+// WithOrderedCompletion makes the Fetcher fire onComplete and onError callbacks
+// in the order the requests were enqueued. Downloads that finish early are
+// buffered until every request enqueued before them has been reported. Paused
+// requests do not hold back the others: they are reported once they finish.
+func WithOrderedCompletion() FetcherOption {
+	return func(f *Fetcher) {
+		f.orderer = newCompletionOrderer()
+	}
+}
+
+// WithTmpSuffix sets the suffix appended to the names of in-progress files.
+// The default is ".tmp".
+func WithTmpSuffix(suffix string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.tmpSuffix = suffix
+	}
+}
+
+// WithHiddenStaging makes in-progress files dot-prefixed (".name.tmp"),
+// so file-watchers in the target directory can ignore them.
+func WithHiddenStaging(hidden bool) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.hiddenStaging = hidden
+	}
+}
+
+// WithDoneMarker writes an empty "<file><suffix>" marker (e.g. ".done") once the
+// file has been moved into place, so external systems polling the directory
+// can detect readiness without racing the rename.
+func WithDoneMarker(suffix string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.doneMarker = suffix
+	}
+}
+
+// WithIncompleteMarker creates an empty "<file><suffix>" marker (e.g. ".incomplete")
+// when a download starts and removes it once the file is in place or the download failed.
+func WithIncompleteMarker(suffix string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.incompleteMarker = suffix
+	}
+}
+
+// WithMirrorRemotePath reproduces the URL's host and directory hierarchy under
+// the target directory (like wget -x), so "https://example.com/a/b/file.zip" is
+// saved as "<targetDir>/<Path>/example.com/a/b/file.zip". This avoids filename
+// collisions in recursive and sitemap downloads.
+func WithMirrorRemotePath() FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.mirrorRemotePath = true
+	}
+}
+
+// WithPostProcessors adds steps that run on every completed download,
+// in the given order, before onComplete is called.
+func WithPostProcessors(p ...PostProcessor) FetcherOption {
+	return func(f *Fetcher) {
+		f.postProcessors = append(f.postProcessors, p...)
 	}
 }
 
@@ -88,34 +260,169 @@ func WithEnableOverwrite(eo bool) FetcherOption {
 func New(options ...FetcherOption) *Fetcher {
 	// Default values
 	fetcher := &Fetcher{
-		requestClient:   http.DefaultClient,
-		maxWorkers:      defaultWorkers,
-		targetDir:       defaultTargetDir,
-		queue:           make(chan DownloadRequest, defaultQueueSize),
-		stopChan:        make(chan struct{}),
-		monitor:         &noopMonitor{},
-		enableOverwrite: false,
+		requestClient: http.DefaultClient,
+		maxWorkers:    defaultWorkers,
+		queue:         make(chan DownloadRequest, defaultQueueSize),
+		stopChan:      make(chan struct{}),
+		monitor:       &noopMonitor{},
+		resultStore:   NopResultStore{},
+		paths:         make(map[string]struct{}),
+		bandwidth:     newTokenBucket(0),
+		report:        reportLog{limit: defaultReportLimit},
+		policy: policy{
+			targetDir: defaultTargetDir,
+			overwrite: OverwriteError,
+			tmpSuffix: defaultTmpSuffix,
+		},
 	}
 
+	fetcher.idle = sync.NewCond(&fetcher.outstandingMu)
+
 	// Apply provided options
 	for _, option := range options {
 		option(fetcher)
 	}
 
+	fetcher.monitor.setName(fetcher.name)
+
+	if fetcher.logger == nil {
+		fetcher.logger = slog.New(slog.DiscardHandler)
+	} else if fetcher.name != "" {
+		fetcher.logger = fetcher.logger.With("fetcher", fetcher.name)
+	}
+
+	if fetcher.rclone != nil {
+		fetcher.transportWrappers = append(fetcher.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			return &schemeTransport{base: base, scheme: "rclone", handler: fetcher.rclone}
+		})
+	}
+
+	if len(fetcher.urlPolicies) > 0 {
+		// Outermost, so nothing is sent for a blocked URL
+		fetcher.transportWrappers = append(fetcher.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			return &policyTransport{base: base, fetcher: fetcher}
+		})
+	}
+
+	// Proxy and dialing are configured on the underlying *http.Transport, before anything wraps it
+	transportSetup := []func(http.RoundTripper) http.RoundTripper{fetcher.wrapProxy, fetcher.wrapClientTLS}
+	if fetcher.dialer != nil {
+		transportSetup = append([]func(http.RoundTripper) http.RoundTripper{fetcher.dialer.wrap}, transportSetup...)
+	}
+	fetcher.transportWrappers = append(transportSetup, fetcher.transportWrappers...)
+
+	if len(fetcher.transportWrappers) > 0 || fetcher.redirects != nil {
+		// Copy the client so the caller's client is left untouched
+		client := *fetcher.requestClient
+		for _, wrap := range fetcher.transportWrappers {
+			client.Transport = wrap(client.Transport)
+		}
+		if fetcher.redirects != nil {
+			client.CheckRedirect = fetcher.redirects.checkRedirect(client.CheckRedirect)
+		}
+		fetcher.requestClient = &client
+	}
+
 	return fetcher
 }
 
 // Enqueue adds a download request to the Fetcher's queue.
+// Requests can be enqueued before Start; after Stop they are rejected with ErrStopped.
 func (f *Fetcher) Enqueue(req DownloadRequest) EnqueueResult {
+	if f.isStopped() {
+		return EnqueueResult{Queued: false, Error: ErrStopped}
+	}
+
 	if err := f.validateRequest(&req); err != nil {
+		if errors.Is(err, errSkipped) {
+			return EnqueueResult{Queued: false, Skipped: true}
+		}
+		return EnqueueResult{Queued: false, Error: err}
+	}
+
+	if err := f.claimTarget(&req, f.currentPolicy().overwriteFor(req) == OverwriteRename); err != nil {
+		return EnqueueResult{Queued: false, Error: err}
+	}
+
+	if err := f.claimID(req.ID); err != nil {
+		f.releasePath(req.FullPath)
+		return EnqueueResult{Queued: false, Error: err}
+	}
+
+	if err := f.monitor.add(req); err != nil {
+		f.releaseID(req.ID)
+		f.releasePath(req.FullPath)
 		return EnqueueResult{Queued: false, Error: err}
 	}
 
-	f.monitor.add(req)
-	f.queue <- req
+	if f.tenants != nil {
+		if err := f.tenants.admit(req.Tenant); err != nil {
+			f.monitor.remove(req.ID)
+			f.releaseID(req.ID)
+			f.releasePath(req.FullPath)
+			return EnqueueResult{Queued: false, Error: err}
+		}
+	}
+
+	req.seq = f.nextSeq.Add(1) - 1
+	f.makeCancellable(&req)
+	f.track()
+	if err := f.send(req); err != nil {
+		f.untrack()
+		f.forgetCancel(req)
+		if f.tenants != nil {
+			f.tenants.leave(req.Tenant)
+		}
+		f.monitor.remove(req.ID)
+		f.releaseID(req.ID)
+		f.releasePath(req.FullPath)
+		if f.orderer != nil {
+			// Nothing will be reported for this request, don't hold back later ones
+			f.orderer.skip(req.seq)
+		}
+		return EnqueueResult{Queued: false, Error: err}
+	}
+	f.logRequest(req).Debug("download enqueued", "path", req.FullPath)
+	if f.probes != nil {
+		f.probeQueued(req)
+	}
 	return EnqueueResult{Queued: true, Error: nil}
 }
 
+// EnqueueCtx adds a download request to the Fetcher's queue like Enqueue, tied to ctx.
+// Cancelling ctx aborts the download, queued or in flight: the staging file is
+// removed, the task is marked as cancelled in the monitor and onError receives ctx's error.
+func (f *Fetcher) EnqueueCtx(ctx context.Context, req DownloadRequest) EnqueueResult {
+	req.ctx = ctx
+	return f.Enqueue(req)
+}
+
+// send puts the request on the queue. If the queue is full it blocks until a
+// worker makes room or the Fetcher is stopped.
+func (f *Fetcher) send(req DownloadRequest) error {
+	f.stateMu.RLock()
+	if f.state == stateStopped {
+		f.stateMu.RUnlock()
+		return ErrStopped
+	}
+	stopChan := f.stopChan
+	f.stateMu.RUnlock()
+
+	select {
+	case f.queue <- req:
+		return nil
+	case <-stopChan:
+		return ErrStopped
+	}
+}
+
+// isStopped reports whether the Fetcher has been stopped or is draining.
+func (f *Fetcher) isStopped() bool {
+	f.stateMu.RLock()
+	defer f.stateMu.RUnlock()
+	return f.state == stateStopped || f.draining
+}
+
 // EnqueueMany adds multiple download requests to the Fetcher's queue.
 func (f *Fetcher) EnqueueMany(reqs []DownloadRequest) []EnqueueResult {
 	results := make([]EnqueueResult, 0, len(reqs))
@@ -129,119 +436,600 @@ func (f *Fetcher) EnqueueMany(reqs []DownloadRequest) []EnqueueResult {
 }
 
 // Start begins processing download requests with the configured number of workers.
+// A stopped Fetcher can be started again; requests still in the queue are picked up.
+// Calling Start on a running Fetcher does nothing.
 func (f *Fetcher) Start() {
+	f.lifecycleMu.Lock()
+	defer f.lifecycleMu.Unlock()
+
+	f.stateMu.Lock()
+	switch f.state {
+	case stateRunning:
+		f.stateMu.Unlock()
+		return
+	case stateStopped:
+		f.stopChan = make(chan struct{})
+		f.monitor.open()
+	}
+	f.draining = false
+	f.state = stateRunning
+	stopChan := f.stopChan
+	f.stateMu.Unlock()
+
+	if f.congestion != nil {
+		f.congestion.setMax(f.maxWorkers)
+		f.congestion.restart()
+	}
+
+	if f.throttle != nil {
+		f.throttle.apply(f.throttle.fn(), f.maxWorkers)
+		go f.throttle.run(stopChan, f.workerCount)
+	}
+
 	for i := 0; i < f.maxWorkers; i++ {
-		f.wg.Add(1)
-		go f.worker()
+		f.spawnWorker(stopChan)
+	}
+	f.requeueParkedHosts()
+
+	if f.tenants != nil {
+		// Requests parked when the Fetcher stopped have no download left to wake them
+		if parked := f.tenants.takeParked(); len(parked) > 0 {
+			go func() {
+				for _, req := range parked {
+					if err := f.send(req); err != nil {
+						f.tenants.park(req)
+					}
+				}
+			}()
+		}
 	}
 }
 
 // Stop signals the Fetcher to stop processing and waits for all workers to finish.
-// Closes the monitor's event signal
+// Closes the monitor's event signal and the Results channel
+// Calling Stop on a stopped Fetcher does nothing.
 func (f *Fetcher) Stop() {
+	f.lifecycleMu.Lock()
+	defer f.lifecycleMu.Unlock()
+
+	f.stateMu.Lock()
+	if f.state == stateStopped {
+		f.stateMu.Unlock()
+		return
+	}
+	f.state = stateStopped
 	close(f.stopChan)
+	f.stateMu.Unlock()
+
 	f.wg.Wait()
+	f.webhooks.wait()
+	f.workerQuits = nil
 	f.monitor.close()
+	f.closeResults()
 }
 
-func (f *Fetcher) worker() {
+func (f *Fetcher) worker(stopChan, quit <-chan struct{}) {
 	defer f.wg.Done()
 
 	for {
 		select {
 		case req := <-f.queue:
-			result, err := f.processDownload(req)
-			if err != nil {
-				if f.onError != nil {
-					f.onError(req, err)
+			if !f.admitHosts(req) {
+				// Parked until its host has a free slot
+				continue
+			}
+			if f.tenants == nil {
+				f.handle(req)
+				continue
+			}
+			if !f.tenants.acquire(req) {
+				// Parked until a download of the same tenant finishes
+				continue
+			}
+			for {
+				f.handle(req)
+				next, ok := f.tenants.release(req.Tenant)
+				if !ok {
+					break
 				}
+				if isClosed(stopChan) {
+					f.tenants.unpark(next)
+					break
+				}
+				req = next
+			}
+		case <-stopChan:
+			return
+		case <-quit:
+			return
+		}
+	}
+}
+
+// handle processes one request and reports its outcome.
+func (f *Fetcher) handle(req DownloadRequest) {
+	f.monitor.setQueued(req.ID, false)
+	result, err := f.run(req)
+	if errors.Is(err, ErrPaused) {
+		f.forgetCancel(req)
+		f.parkPaused(req)
+		if f.orderer != nil {
+			// It may stay paused for good, later requests are reported meanwhile
+			f.orderer.skip(req.seq)
+		}
+		return
+	}
+	// Callbacks may enqueue the ID again, e.g. to try once more
+	f.releaseID(req.ID)
+	f.notify(req, result, err)
+	if f.fairShare != nil {
+		f.fairShare.forget(req.ID)
+	}
+	f.forgetCancel(req)
+	f.untrack()
+}
+
+// run downloads the request, retrying it as configured, and records the outcome.
+// Paused requests are not recorded, they run again once resumed.
+func (f *Fetcher) run(req DownloadRequest) (result DownloadResult, err error) {
+	req.activity = f.activity.begin(req)
+	defer f.activity.end(req.activity)
+
+	firstStarted := time.Now()
+	attempts := 0
+	endSpan := f.startSpan(&req, "dlfetch.download", map[string]any{"dlfetch.id": req.ID, "url.full": redactedURL(req.URL)})
+	defer func() {
+		endSpan(err, downloadSpanAttributes(result, attempts))
+		f.logOutcome(req, result, err, attempts, firstStarted)
+	}()
+
+	f.logRequest(req).Debug("download started")
+	for attempt := 1; ; attempt++ {
+		attempts = attempt
+		started := time.Now()
+		a := req
+		endAttempt := f.startSpan(&a, "dlfetch.attempt", map[string]any{"dlfetch.attempt": attempt})
+		result, err := f.attemptMirrors(a)
+		endAttempt(err, nil)
+		if errors.Is(err, ErrPaused) {
+			return result, err
+		}
+		if err != nil {
+			var retry bool
+			if retry, err = f.awaitRetry(req, attempt, err); retry {
 				continue
 			}
-			if f.onComplete != nil {
-				f.onComplete(result)
+			if errors.Is(err, ErrPaused) {
+				return result, err
+			}
+		}
+		f.record(req, result, err, time.Since(started))
+		if err != nil {
+			f.reportFailure(req, err, firstStarted, attempt)
+		}
+		return result, err
+	}
+}
+
+// attempt downloads the request once within the host and congestion limits.
+func (f *Fetcher) attempt(req DownloadRequest) (DownloadResult, error) {
+	release, err := f.acquireSlots(req)
+	if err != nil {
+		// Cancelled while waiting for a slot, fails like a request cancelled in the queue
+		return f.processDownload(req)
+	}
+	f.activity.setStage(req.activity, stageDownload)
+	if req.batch != nil {
+		req.batch.start(req.ID)
+	}
+	if f.fairShare != nil {
+		req.share = f.fairShare.join(req)
+		defer f.fairShare.leave(req.share)
+	}
+	result, err := f.processDownload(req)
+	release(result.Size, err)
+	return result, err
+}
+
+// acquireSlots waits for a slot of every limit that applies to the request and
+// returns a function that frees them with the outcome of the download. If the
+// request is cancelled while waiting, the slots taken so far are freed and the
+// error of its context is returned.
+func (f *Fetcher) acquireSlots(req DownloadRequest) (func(bytes int64, err error), error) {
+	ctx := req.context()
+	host := hostOf(req.URL)
+	var releases []func(int64, error)
+	release := func(bytes int64, err error) {
+		for _, r := range slices.Backward(releases) {
+			r(bytes, err)
+		}
+	}
+
+	if f.shared != nil && f.shared.hosts != nil || f.hostLimiter != nil {
+		f.activity.setStage(req.activity, stageHostSlot)
+	}
+	if f.shared != nil && f.shared.hosts != nil {
+		if err := f.shared.hosts.acquire(ctx, host); err != nil {
+			return nil, err
+		}
+		releases = append(releases, func(bytes int64, err error) { f.shared.hosts.release(host, bytes, err) })
+	}
+	if f.hostLimiter != nil {
+		if err := f.hostLimiter.acquire(ctx, host); err != nil {
+			release(0, err)
+			return nil, err
+		}
+		releases = append(releases, func(bytes int64, err error) { f.hostLimiter.release(host, bytes, err) })
+	}
+	if f.throttle != nil {
+		f.activity.setStage(req.activity, stageThrottle)
+		if err := f.throttle.acquire(ctx); err != nil {
+			release(0, err)
+			return nil, err
+		}
+		releases = append(releases, func(int64, error) { f.throttle.release() })
+	}
+	if f.congestion != nil {
+		f.activity.setStage(req.activity, stageCongestion)
+		if err := f.congestion.acquire(ctx); err != nil {
+			release(0, err)
+			return nil, err
+		}
+		releases = append(releases, func(bytes int64, err error) { f.congestion.release(bytes, err) })
+	}
+	return release, nil
+}
+
+// isClosed reports whether ch is closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// notify reports the outcome of a processed request to the registered callbacks.
+func (f *Fetcher) notify(req DownloadRequest, result DownloadResult, err error) {
+	if req.batch != nil {
+		req.batch.finish(req, result, err)
+	}
+	f.webhooks.send(f, req, result, err)
+	deliver := func() {
+		f.publish(DownloadOutcome{Fetcher: f.name, Request: req, Result: result, Err: err})
+		if err != nil {
+			if f.onError != nil {
+				f.onError(req, err)
 			}
-		case <-f.stopChan:
 			return
 		}
+		if f.onComplete != nil {
+			f.onComplete(result)
+		}
+	}
+
+	if f.orderer != nil {
+		f.orderer.deliver(req.seq, deliver)
+		return
 	}
+	deliver()
 }
 
 // processDownload handles the actual downloading of a file based on the DownloadRequest.
 // It returns a DownloadResult or an error if the download fails.
 func (f *Fetcher) processDownload(req DownloadRequest) (DownloadResult, error) {
+	defer f.releasePath(req.FullPath)
+
+	p := f.currentPolicy()
+	ctx := withProxy(req.context(), req)
+
+	if err := ctx.Err(); err != nil {
+		// Cancelled while waiting in the queue
+		return DownloadResult{}, f.fail(req, err)
+	}
 
-	// Check if file already exists
+	if err := f.lookupChecksum(ctx, &req); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if isStreamTarget(req) {
+		return f.streamDownload(ctx, req)
+	}
+
+	if p.partSize > 0 {
+		return f.splitDownload(ctx, req, p)
+	}
+
+	// Decide how the target is written
 	// To make sure another program / process has not created the file
-	if !f.enableOverwrite && checkFileExists(req.FullPath) {
-		err := fmt.Errorf("file already exists: id=%d, name=%s, path=%s", req.ID, req.FileName, req.FullPath)
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+	sparse := p.sparse && req.Range == nil && p.overwriteFor(req) != OverwriteRename
+	var mode writeMode
+	var err error
+	if sparse && checkFileExists(req.FullPath+SparseMapSuffix) {
+		// A partial download written in place, continue it
+		mode = writeOverwrite
+	} else if mode, err = checkPreconditions(req, p.overwriteFor(req)); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	if mode == writeSkip {
+		f.monitor.markAsCompleted(req.ID)
+		return f.skippedResult(req), nil
 	}
 
 	// Ensure directory exists
-	err := ensureDir(req.FullPath)
+	err = ensureDir(req.FullPath)
 	if err != nil {
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if p.doneMarker != "" && mode == writeOverwrite {
+		// A stale marker must not announce the file while it is replaced
+		_ = os.Remove(req.FullPath + p.doneMarker)
+	}
+
+	if p.incompleteMarker != "" {
+		marker := req.FullPath + p.incompleteMarker
+		if err := writeMarker(marker); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+		defer os.Remove(marker)
 	}
 
 	// Perform the download
-	resp, err := f.requestClient.Get(req.URL)
+	// Write to a tmp file first
+	// To prevent incomplete files in case of failure
+	url := req.URL
+	if req.URLProvider != nil {
+		if url, err = req.URLProvider(ctx); err != nil {
+			return DownloadResult{}, f.fail(req, fmt.Errorf("failed to get download url: %w", err))
+		}
+	}
+
+	tmpPath := p.stagingPath(req.FullPath)
+	if sparse {
+		tmpPath = req.FullPath
+		if err := prepareSparse(tmpPath); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+	wire := new(wireCounter)
+	resp, offset, err := f.openDownload(withWireCounter(ctx, wire), url, tmpPath, req.Range, req.Headers)
 	if err != nil {
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+		return DownloadResult{}, f.fail(req, err)
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("failed to download file: %s, status code: %d", req.URL, resp.StatusCode)
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		err = &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return DownloadResult{}, f.fail(req, err)
 	}
 
-	// Write to a tmp file first
-	// To prevent incomplete files in case of failure
-	tmpPath := req.FullPath + ".tmp"
-	out, err := os.Create(tmpPath)
+	if err := p.checkContentType(url, resp); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	if c := f.contentCheckFor(req); c != nil && offset == 0 {
+		// A resumed download continues content that was checked before
+		checked, err := c.check(url, resp.Body)
+		if err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+		resp.Body = checked
+	}
+
+	need := resolveFileSize(resp)
+	if offset > 0 {
+		need = resp.ContentLength
+	}
+	if err := p.checkDiskSpace(req.FullPath, need); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	hash := sha256.New()
+	digests := newDigester(resp, req)
+	sums := io.MultiWriter(hash, digests)
+	out, err := openStaging(tmpPath, offset, sums)
 	if err != nil {
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+		return DownloadResult{}, f.fail(req, err)
 	}
 	defer out.Close()
 
+	var tracker *sparseTracker
+	if sparse {
+		size := resolveFileSize(resp)
+		if offset > 0 && resp.ContentLength > 0 {
+			size = offset + resp.ContentLength
+		}
+		if tracker, err = newSparseTracker(out, tmpPath, offset, size); err != nil {
+			out.Close()
+			removeStaging(tmpPath)
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+
 	mw := &monitorWriter{
 		id:      req.ID,
+		written: offset,
 		total:   resolveFileSize(resp),
 		monitor: f.monitor,
 	}
+	if wire.decoded.Load() {
+		mw.wire = wire
+	}
+	if offset > 0 {
+		mw.total = UnknownSize
+		if resp.ContentLength > 0 {
+			mw.total = offset + resp.ContentLength
+		}
+	}
+
+	limits := f.speedLimits(req)
+
+	var size int64
+	host := hostOf(url)
+	transferStarted := time.Now()
+	segments := 1
+	if req.Range == nil {
+		segments = p.segmentCount(resp, offset, f.segmentSize(p, host))
+	}
+	releaseConnections := func() {}
+	if segments > 1 {
+		// Every segment past the first needs a connection of its own to the host
+		var extra int
+		extra, releaseConnections = f.acquireConnections(host, segments-1)
+		segments = 1 + extra
+	}
+	if segments > 1 {
+		size = resp.ContentLength
+		err = f.downloadSegments(ctx, url, req.Headers, resp, out, size, segments, mw, limits, tracker)
+		releaseConnections()
+		if err == nil {
+			_, err = io.Copy(sums, io.NewSectionReader(out, 0, size))
+		}
+		if err != nil && (tracker == nil || errors.Is(err, errRemoteChanged)) {
+			out.Close()
+			// A preallocated file with gaps cannot be resumed, nor one mixing versions
+			removeStaging(tmpPath)
+			tracker.remove()
+			return DownloadResult{}, f.fail(req, err)
+		}
+	} else {
+		reader := io.TeeReader(throttle(ctx, resp.Body, limits), mw)
 
-	reader := io.TeeReader(resp.Body, mw)
+		w := io.Writer(out)
+		if tracker != nil {
+			w = &sparseWriter{w: out, tracker: tracker, offset: offset}
+		}
+		size, err = io.Copy(io.MultiWriter(w, sums), reader)
+		size += offset
+	}
+	f.tuneSegments(p, host, size-offset, segments, time.Since(transferStarted), err)
+	if err != nil {
+		out.Close()
+		if ctx.Err() != nil && !isPaused(req) || !canResume(resp) || loadValidator(tmpPath) == "" {
+			// Keep the partial file only if a later attempt can continue it
+			removeStaging(tmpPath)
+			tracker.remove()
+		} else if err := tracker.close(); err != nil {
+			removeStaging(tmpPath)
+			tracker.remove()
+		}
+		return DownloadResult{}, f.fail(req, err)
+	}
 
-	if _, err := io.Copy(out, reader); err != nil {
-		_ = os.Remove(tmpPath)
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+	verified, err := digests.verify(resp, req.Range == nil, hash.Sum(nil))
+	if err == nil {
+		err = checkChecksum(req, digests, hash.Sum(nil))
+	}
+	if err != nil {
+		out.Close()
+		removeStaging(tmpPath)
+		tracker.remove()
+		return DownloadResult{}, f.fail(req, err)
 	}
 
 	if err := out.Close(); err != nil {
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+		removeStaging(tmpPath)
+		tracker.remove()
+		return DownloadResult{}, f.fail(req, err)
 	}
 
-	if err := os.Rename(tmpPath, req.FullPath); err != nil {
-		f.monitor.markAsFailed(req.ID, err)
-		return DownloadResult{}, err
+	if tracker != nil {
+		// Written in place, complete once the map is gone
+		tracker.remove()
+	} else if mode == writeRename {
+		err = f.commitRenamed(tmpPath, &req)
+	} else {
+		err = commitFile(tmpPath, req.FullPath, mode == writeOverwrite)
+	}
+	if err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	_ = os.Remove(tmpPath + validatorSuffix)
+
+	respContentType := resp.Header.Get("Content-Type")
+
+	result := DownloadResult{
+		ID:              req.ID,
+		FileName:        req.FileName,
+		Path:            req.FullPath,
+		MimeType:        f.detectMimeType(req, respContentType, req.FullPath),
+		Vars:            req.Vars,
+		Size:            size,
+		SHA256:          hex.EncodeToString(hash.Sum(nil)),
+		ETag:            resp.Header.Get("ETag"),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		ServerFileName:  dispositionFileName(resp),
+		FinalURL:        finalURL(resp),
+		VerifiedDigests: verified,
+	}
+
+	if err := f.applyDispositionName(req, &result); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if p.correctExtension {
+		if err := f.correctExtension(&result); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+
+	// The file is in place, from here on a cancelled request keeps it as far as it got
+	f.activity.setStage(req.activity, stagePostProc)
+	postCtx, stopPost := postProcessContext(ctx, req)
+	defer stopPost()
+	req.ctx = postCtx
+
+	if err := f.postProcess(postCtx, &result); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if err := f.presetPostProcess(postCtx, req, &result); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if err := f.upload(postCtx, req, &result); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if err := postCtx.Err(); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	if p.doneMarker != "" {
+		if err := writeMarker(result.Path + p.doneMarker); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+
+	if f.cache != nil && req.Range == nil {
+		if err := f.cache.record(cmp.Or(req.mirrorOf, req.URL), result); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+
+	if err := f.resultStore.Save(result); err != nil {
+		return DownloadResult{}, f.fail(req, fmt.Errorf("saving result failed: %w", err))
 	}
 
 	f.monitor.markAsCompleted(req.ID)
 
-	respContentType := resp.Header.Get("Content-Type")
+	return result, nil
+}
 
-	return DownloadResult{
-		ID:       req.ID,
-		FileName: req.FileName,
-		Path:     req.FullPath,
-		MimeType: determineMimeType(req, respContentType, req.FullPath),
-	}, nil
+// fail marks the request as failed, or as cancelled when its context is done,
+// and returns the error to report for it.
+func (f *Fetcher) fail(req DownloadRequest, err error) error {
+	if ctx := req.context(); ctx.Err() != nil {
+		cause := context.Cause(ctx)
+		if isPaused(req) {
+			f.monitor.markAsPaused(req.ID)
+			return cause
+		}
+		f.monitor.markAsCancelled(req.ID, cause)
+		return cause
+	}
+	f.monitor.markAsFailed(req.ID, err)
+	return err
 }
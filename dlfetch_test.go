@@ -0,0 +1,267 @@
+package dlfetch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race: the tests hammer the queue from many goroutines at once.
+
+var testContent = bytes.Repeat([]byte("0123456789abcdef"), 16<<10)
+
+// newSlowServer serves testContent in small chunks, so downloads stay in flight
+// long enough to be paused, cancelled or stopped.
+func newSlowServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(testContent)))
+		for b := testContent; len(b) > 0; b = b[min(len(b), 16<<10):] {
+			if _, err := w.Write(b[:min(len(b), 16<<10)]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// waitTimeout fails the test if f.Wait does not return in time.
+func waitTimeout(t *testing.T, f *Fetcher) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Wait did not return")
+	}
+}
+
+// outcomes records the callbacks of a Fetcher by request ID.
+type outcomes struct {
+	mu        sync.Mutex
+	completed map[int]int
+	failed    map[int]error
+}
+
+func newOutcomes() *outcomes {
+	return &outcomes{completed: make(map[int]int), failed: make(map[int]error)}
+}
+
+func (o *outcomes) options() []FetcherOption {
+	return []FetcherOption{
+		WithOnComplete(func(r DownloadResult) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.completed[r.ID]++
+		}),
+		WithOnError(func(req DownloadRequest, err error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.failed[req.ID] = err
+		}),
+	}
+}
+
+// checkDir fails the test if a staging file was left behind in dir.
+func checkDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), defaultTmpSuffix) {
+			t.Errorf("staging file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestConcurrentEnqueueCancelPauseStop(t *testing.T) {
+	srv := newSlowServer(t)
+	dir := t.TempDir()
+	o := newOutcomes()
+	monitor := NewMonitor()
+	f := New(append(o.options(), WithTargetDir(dir), WithMaxWorkers(4), WithMonitor(monitor))...)
+	f.Start()
+
+	// Results is closed by every Stop, a new one is needed after it
+	done := make(chan struct{})
+	var reader sync.WaitGroup
+	reader.Go(func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for range f.Results() {
+			}
+		}
+	})
+
+	const enqueuers, perEnqueuer = 4, 20
+	const requests = enqueuers * perEnqueuer
+	var wg sync.WaitGroup
+	for e := range enqueuers {
+		wg.Go(func() {
+			for i := range perEnqueuer {
+				id := e*perEnqueuer + i + 1
+				res := f.Enqueue(DownloadRequest{ID: id, URL: fmt.Sprintf("%s/%d", srv.URL, id), FileName: fmt.Sprintf("%d.bin", id)})
+				if res.Error != nil {
+					t.Errorf("enqueue %d: %v", id, res.Error)
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 30 {
+			_ = f.Cancel(rand.IntN(requests) + 1)
+			time.Sleep(time.Millisecond)
+		}
+	})
+	for range 2 {
+		wg.Go(func() {
+			for range 50 {
+				id := rand.IntN(requests) + 1
+				_ = f.Pause(id)
+				time.Sleep(time.Millisecond)
+				if rand.IntN(4) == 0 {
+					// Cancelling a paused request reports it outside of the workers
+					_ = f.Cancel(id)
+				} else {
+					_ = f.Resume(id)
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 10 {
+			time.Sleep(5 * time.Millisecond)
+			f.Stop()
+			time.Sleep(time.Millisecond)
+			f.Start()
+		}
+	})
+	wg.Wait()
+
+	// Whatever is still paused is resumed, then every request must finish
+	f.Start()
+	for id := 1; id <= requests; id++ {
+		_ = f.Resume(id)
+	}
+	waitTimeout(t, f)
+	close(done)
+	f.Stop()
+	reader.Wait()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	snapshot := monitor.GetSnapshot()
+	if len(snapshot.Tasks) != requests {
+		t.Fatalf("monitor has %d tasks, want %d", len(snapshot.Tasks), requests)
+	}
+	for _, task := range snapshot.Tasks {
+		switch task.Status {
+		case StatusCompleted:
+			if o.completed[task.ID] != 1 {
+				t.Errorf("task %d completed, onComplete called %d times", task.ID, o.completed[task.ID])
+			}
+			if b, err := os.ReadFile(filepath.Join(dir, task.FileName)); err != nil || !bytes.Equal(b, testContent) {
+				t.Errorf("task %d: file has %d bytes, %v", task.ID, len(b), err)
+			}
+		case StatusCancelled:
+			if o.completed[task.ID] != 0 || !errors.Is(o.failed[task.ID], ErrCancelled) {
+				t.Errorf("task %d cancelled, completed %d times, error %v", task.ID, o.completed[task.ID], o.failed[task.ID])
+			}
+		default:
+			t.Errorf("task %d ended %s: %s", task.ID, task.Status, task.Error)
+		}
+	}
+	checkDir(t, dir)
+}
+
+// TestConcurrentTargetCreation creates the target files while their downloads
+// are in flight: the check at enqueue time passes, the one before the transfer or
+// the commit must catch them.
+func TestConcurrentTargetCreation(t *testing.T) {
+	local := []byte("local")
+	for _, policy := range []OverwritePolicy{OverwriteError, OverwriteRename, OverwriteSkip} {
+		t.Run(policy.String(), func(t *testing.T) {
+			srv := newSlowServer(t)
+			dir := t.TempDir()
+			o := newOutcomes()
+			f := New(append(o.options(), WithTargetDir(dir), WithMaxWorkers(8), WithOverwritePolicy(policy))...)
+			f.Start()
+			defer f.Stop()
+
+			const requests = 40
+			var wg sync.WaitGroup
+			wg.Go(func() {
+				for id := 1; id <= requests; id++ {
+					f.Enqueue(DownloadRequest{ID: id, URL: fmt.Sprintf("%s/%d", srv.URL, id), FileName: fmt.Sprintf("%d.bin", id)})
+				}
+			})
+			wg.Go(func() {
+				for id := 1; id <= requests; id += 2 {
+					// Like another program would, without clobbering a finished download
+					if out, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%d.bin", id)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644); err == nil {
+						out.Write(local)
+						out.Close()
+					}
+					time.Sleep(time.Duration(rand.IntN(3)) * time.Millisecond)
+				}
+			})
+			wg.Wait()
+			waitTimeout(t, f)
+
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			for id := 1; id <= requests; id++ {
+				b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.bin", id)))
+				if err != nil {
+					t.Errorf("%d.bin: %v", id, err)
+					continue
+				}
+				if id%2 == 1 {
+					// The local file is never clobbered
+					if !bytes.Equal(b, local) && !bytes.Equal(b, testContent) {
+						t.Errorf("%d.bin has %d bytes", id, len(b))
+					}
+					if bytes.Equal(b, testContent) && o.completed[id] != 1 {
+						t.Errorf("%d.bin was downloaded, completed %d times", id, o.completed[id])
+					}
+					if bytes.Equal(b, local) && policy == OverwriteError && o.completed[id] != 0 {
+						t.Errorf("%d.bin kept the local file, yet completed", id)
+					}
+					continue
+				}
+				if !bytes.Equal(b, testContent) || o.completed[id] != 1 {
+					t.Errorf("%d.bin has %d bytes, completed %d times, error %v", id, len(b), o.completed[id], o.failed[id])
+				}
+			}
+			if policy == OverwriteRename && len(o.failed) > 0 {
+				t.Errorf("renamed downloads failed: %v", o.failed)
+			}
+			checkDir(t, dir)
+		})
+	}
+}
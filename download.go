@@ -0,0 +1,56 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// Download fetches a single file right away, bypassing the queue, and blocks until
+// it is on disk or failed. It applies the same file handling, post-processing and
+// limits as queued downloads but does not call onComplete or onError, and works
+// whether or not the Fetcher is started. Cancelling ctx aborts the download.
+func (f *Fetcher) Download(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	req.ctx = ctx
+
+	if err := f.validateRequest(&req); err != nil {
+		if errors.Is(err, errSkipped) {
+			return f.skippedResult(req), nil
+		}
+		return DownloadResult{}, err
+	}
+
+	if err := f.claimTarget(&req, f.currentPolicy().overwriteFor(req) == OverwriteRename); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if err := f.claimID(req.ID); err != nil {
+		f.releasePath(req.FullPath)
+		return DownloadResult{}, err
+	}
+	defer f.releaseID(req.ID)
+
+	if err := f.monitor.add(req); err != nil {
+		f.releasePath(req.FullPath)
+		return DownloadResult{}, err
+	}
+	f.monitor.setQueued(req.ID, false)
+
+	if f.fairShare != nil {
+		defer f.fairShare.forget(req.ID)
+	}
+	return f.run(req)
+}
+
+// DownloadToBuffer fetches a file into memory like Download, without writing
+// anything to the target directory, and returns its content. Use
+// DownloadRequest.Writer to stream larger files elsewhere instead.
+func (f *Fetcher) DownloadToBuffer(ctx context.Context, req DownloadRequest) ([]byte, DownloadResult, error) {
+	var buf bytes.Buffer
+	req.Writer = &buf
+	result, err := f.Download(ctx, req)
+	if err != nil {
+		return nil, result, err
+	}
+	return buf.Bytes(), result, nil
+}
@@ -0,0 +1,89 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrDuplicateID is returned when a request reuses the ID of a request that is
+// still queued, running or paused. The ID of a finished one can be reused.
+var ErrDuplicateID = errors.New("duplicate request id")
+
+// ErrFileExists is returned when the target file of a request already exists
+// and overwriting is disabled.
+var ErrFileExists = errors.New("file already exists")
+
+// ErrPathInUse is returned when another queued or running request
+// already writes to the same target path.
+var ErrPathInUse = errors.New("path is already used by another request")
+
+// ErrInvalidPath is returned when the Path and FileName of a request, once their
+// templates are expanded, point outside of the target directory.
+var ErrInvalidPath = errors.New("path leaves the target directory")
+
+// ErrStopped is returned when a request is enqueued on a stopped Fetcher.
+var ErrStopped = errors.New("fetcher is stopped")
+
+// ErrUnknownSink is returned when a request selects a sink that was not registered.
+var ErrUnknownSink = errors.New("unknown sink")
+
+// ErrUnknownPreset is returned when a request refers to a preset that was not registered.
+var ErrUnknownPreset = errors.New("unknown preset")
+
+// ErrNotModified is returned by EnqueueIfChanged when the remote file did not
+// change since it was last downloaded.
+var ErrNotModified = errors.New("not modified since last download")
+
+// ErrQuotaExceeded is returned when a request would exceed the queue quota of its tenant.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// ErrUnknownID is returned when no queued or running request has the given ID.
+var ErrUnknownID = errors.New("unknown request id")
+
+// ErrCancelled is reported for requests cancelled with Fetcher.Cancel.
+// It matches context.Canceled.
+var ErrCancelled = fmt.Errorf("download cancelled: %w", context.Canceled)
+
+// ErrBatchDeadline is reported for requests of a batch stopped by its deadline,
+// see EnqueueBatch. It matches context.DeadlineExceeded.
+var ErrBatchDeadline = fmt.Errorf("batch deadline passed: %w", context.DeadlineExceeded)
+
+// ErrPaused is the cause of the context of a request stopped by Fetcher.Pause.
+var ErrPaused = errors.New("download paused")
+
+// ErrInsufficientSpace is returned when the target file system has not enough free
+// space for a download, see WithDiskSpaceCheck.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// ErrURLBlocked is returned for URLs rejected by a URL policy, see WithURLPolicy.
+var ErrURLBlocked = errors.New("url blocked by policy")
+
+// ErrRedirect is returned when a redirect is refused, see WithMaxRedirects and
+// WithSameHostRedirects.
+var ErrRedirect = errors.New("redirect not allowed")
+
+// ErrContentMismatch is returned when the start of a download fails its ContentCheck.
+var ErrContentMismatch = errors.New("content does not pass check")
+
+// ErrDigestMismatch is returned when a download does not match a digest sent by the
+// server, e.g. in a Content-MD5 or Repr-Digest header.
+var ErrDigestMismatch = errors.New("content does not match server digest")
+
+// ErrChecksumMismatch is returned when a download does not match the SHA-256 found
+// by the ChecksumLookup, see WithChecksumLookup.
+var ErrChecksumMismatch = errors.New("content does not match known checksum")
+
+// ErrVerifyFailed is returned by Verify for a local file whose size or checksum
+// does not match the manifest.
+var ErrVerifyFailed = errors.New("file does not match manifest")
+
+// HTTPStatusError is returned when a server answers a download with an unexpected status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("failed to download file: %s, status code: %d", e.URL, e.StatusCode)
+}
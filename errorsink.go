@@ -0,0 +1,62 @@
+package dlfetch
+
+import (
+	"errors"
+	"time"
+)
+
+// FailureRecord describes a failed download for error tracking services.
+type FailureRecord struct {
+	Fetcher    string // Name of the Fetcher, see WithName
+	Request    DownloadRequest
+	Attempts   int
+	Err        error
+	Host       string
+	StatusCode int // HTTP status code of the failed response, 0 if there was none
+	StartedAt  time.Time
+	Duration   time.Duration
+}
+
+// ErrorSink receives a record for every failed download, e.g. to forward it to
+// Sentry or Rollbar. ReportFailure is called from the worker goroutines and should
+// not block for long.
+type ErrorSink interface {
+	ReportFailure(FailureRecord)
+}
+
+// ErrorSinkFunc adapts a function to the ErrorSink interface.
+type ErrorSinkFunc func(FailureRecord)
+
+func (fn ErrorSinkFunc) ReportFailure(r FailureRecord) {
+	fn(r)
+}
+
+// WithErrorSink reports every failed download to s, in addition to onError.
+// Downloads cancelled through their context are not reported.
+func WithErrorSink(s ErrorSink) FetcherOption {
+	return func(f *Fetcher) {
+		f.errorSink = s
+	}
+}
+
+// reportFailure passes a failed download to the error sink.
+func (f *Fetcher) reportFailure(req DownloadRequest, err error, started time.Time, attempts int) {
+	if f.errorSink == nil || req.context().Err() != nil {
+		return
+	}
+
+	record := FailureRecord{
+		Fetcher:   f.name,
+		Request:   req,
+		Attempts:  attempts,
+		Err:       err,
+		Host:      hostOf(req.URL),
+		StartedAt: started,
+		Duration:  time.Since(started),
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		record.StatusCode = statusErr.StatusCode
+	}
+	f.errorSink.ReportFailure(record)
+}
@@ -0,0 +1,191 @@
+package dlfetch
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CostEstimate is the outcome of Estimate.
+type CostEstimate struct {
+	Files       int
+	Bytes       int64          // Total size of the files whose size is known
+	UnknownSize int            // Files whose size neither the server nor the manifest tells
+	Failed      int            // Files whose probe failed, see the error of Estimate
+	Hosts       []HostEstimate // By bytes, largest first
+
+	// Duration is the predicted time to download the files of known size at the
+	// configured limits, 0 if neither the limits nor the speed passed to Estimate
+	// bound it. It is a lower bound: retries, latency and slow servers add to it.
+	Duration time.Duration
+	// Bottleneck tells which limit Duration follows: "largest file", "workers",
+	// "bandwidth", "host connections" or "host request rate", followed by the
+	// host for the latter two.
+	Bottleneck string
+}
+
+// HostEstimate is the share of one host in a CostEstimate.
+type HostEstimate struct {
+	Host        string
+	Files       int
+	Bytes       int64
+	UnknownSize int
+	Duration    time.Duration // Predicted time for the files of the host alone
+}
+
+// Estimate probes every file of the manifest with a HEAD request, like Probe,
+// and predicts what downloading them would cost, without downloading or enqueuing
+// anything, so operators can schedule big jobs before committing to them.
+//
+// speed is the expected speed of a single connection in bytes per second, e.g.
+// the BytesPerSecond of MeasureThroughput, 0 if unknown; WithSegments multiplies
+// it and the MaxSpeed of requests caps it. The prediction further honors the
+// worker count, WithMaxBandwidth, WithMaxPerHost or the maximum of WithAutoTune,
+// and WithHostRateLimit and WithPolitenessDelay, which also space out the probes.
+// Failed probes fall back to the manifest Size and are listed in the returned
+// error; the estimate covers the rest.
+func (f *Fetcher) Estimate(ctx context.Context, manifest []ManifestEntry, speed int64) (CostEstimate, error) {
+	workers := max(1, f.workerCount())
+	p := f.currentPolicy()
+	sizes := make([]int64, len(manifest))
+	segments := make([]int, len(manifest))
+	errs := make([]error, len(manifest))
+
+	// Probe as many files at once as there are workers
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, entry := range manifest {
+		sizes[i], segments[i] = UnknownSize, 1
+		if entry.Request.URLProvider != nil {
+			sizes[i] = knownSize(entry.Size)
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			info, err := f.probe(ctx, entry.Request)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", redactedURL(entry.Request.URL), err)
+				sizes[i] = knownSize(entry.Size)
+				return
+			}
+			if info.Size > 0 {
+				sizes[i] = rangeSize(info.Size, entry.Request.Range)
+				if info.AcceptRanges && entry.Request.Range == nil && p.segments > 1 {
+					segments[i] = int(max(1, min(int64(p.segments), info.Size/minSegmentSize)))
+				}
+			} else {
+				sizes[i] = knownSize(entry.Size)
+			}
+		}()
+	}
+	wg.Wait()
+
+	estimate := CostEstimate{Files: len(manifest)}
+	hosts := make(map[string]*HostEstimate)
+	seconds := make(map[string]float64) // Time the files of a host take at their speed
+	longest := make(map[string]float64) // Time the largest file of a host takes
+	var totalSeconds, slowest float64
+	for i, entry := range manifest {
+		if errs[i] != nil {
+			estimate.Failed++
+		}
+		host := hostOf(entry.Request.URL)
+		h, ok := hosts[host]
+		if !ok {
+			h = &HostEstimate{Host: host}
+			hosts[host] = h
+		}
+		h.Files++
+		if sizes[i] <= 0 {
+			h.UnknownSize++
+			estimate.UnknownSize++
+			continue
+		}
+		h.Bytes += sizes[i]
+		estimate.Bytes += sizes[i]
+
+		// Segments multiply the speed, MaxSpeed caps them all together
+		rate := speed * int64(segments[i])
+		if limit := entry.Request.MaxSpeed; limit > 0 && (rate <= 0 || limit < rate) {
+			rate = limit
+		}
+		if rate > 0 {
+			t := float64(sizes[i]) / float64(rate)
+			seconds[host] += t
+			totalSeconds += t
+			longest[host] = max(longest[host], t)
+			slowest = max(slowest, t)
+		}
+	}
+
+	// The slowest of the limits decides
+	bound := func(d time.Duration, bottleneck string) {
+		if d > estimate.Duration {
+			estimate.Duration = d
+			estimate.Bottleneck = bottleneck
+		}
+	}
+	bound(durationOf(slowest), "largest file")
+	bound(durationOf(totalSeconds/float64(workers)), "workers")
+	if bandwidth := f.bandwidth.limit(); bandwidth > 0 {
+		bound(durationOf(float64(estimate.Bytes)/float64(bandwidth)), "bandwidth")
+	}
+	perHost := workers
+	if f.hostLimiter != nil {
+		perHost = min(perHost, f.hostLimiter.max)
+	}
+	var interval time.Duration
+	if f.hostPacer != nil {
+		f.hostPacer.mu.Lock()
+		interval = f.hostPacer.interval
+		f.hostPacer.mu.Unlock()
+	}
+	for _, h := range hosts {
+		estimate.Hosts = append(estimate.Hosts, *h)
+	}
+	slices.SortFunc(estimate.Hosts, func(a, b HostEstimate) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Host, b.Host))
+	})
+	for i := range estimate.Hosts {
+		h := &estimate.Hosts[i]
+		connections := durationOf(seconds[h.Host] / float64(min(perHost, h.Files)))
+		requests := interval * time.Duration(h.Files-1)
+		h.Duration = max(connections, requests, durationOf(longest[h.Host]))
+		bound(connections, "host connections "+h.Host)
+		bound(requests, "host request rate "+h.Host)
+	}
+
+	return estimate, errors.Join(errs...)
+}
+
+// rangeSize returns how much of a file of the given size r selects, all of it if
+// r is nil.
+func rangeSize(size int64, r *ByteRange) int64 {
+	if r == nil {
+		return size
+	}
+	size = max(0, size-r.Offset)
+	if r.Length > 0 {
+		size = min(size, r.Length)
+	}
+	return size
+}
+
+// knownSize returns size, or UnknownSize if it is not positive.
+func knownSize(size int64) int64 {
+	if size <= 0 {
+		return UnknownSize
+	}
+	return size
+}
+
+func durationOf(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -50,27 +50,22 @@ func main() {
 
 	log.Println("Download queued successfully, waiting for completion...")
 
-	// Check EventSignal to get updates on the download progress
-	for {
-		<-downloadMonitor.EventSignal()
-
-		snapshot := downloadMonitor.GetSnapshot()
-		data, err := json.MarshalIndent(snapshot, "", "  ")
-		if err != nil {
-			log.Printf("Error marshaling snapshot: %v", err)
-		} else {
+	// Log the progress whenever the monitor reports a change,
+	// until the event signal is closed by stopping the fetcher
+	go func() {
+		for range downloadMonitor.EventSignal() {
+			snapshot := downloadMonitor.GetSnapshot()
+			data, err := json.MarshalIndent(snapshot, "", "  ")
+			if err != nil {
+				log.Printf("Error marshaling snapshot: %v", err)
+				continue
+			}
 			log.Printf("=> Snapshot:\n%s\n", string(data))
 		}
+	}()
 
-		// Exit on download complete
-		if snapshot.Count.Completed+snapshot.Count.Failed == snapshot.Count.Total &&
-			snapshot.Count.Total > 0 {
-			break
-		}
-	}
+	// Wait for the queued downloads to finish, then stop the fetcher
+	fetcher.Drain()
 
 	fmt.Println("All downloads processed.")
-
-	// Stop the fetcher and wait for workers to exit
-	fetcher.Stop()
 }
@@ -0,0 +1,81 @@
+package dlfetch
+
+import (
+	"errors"
+	"mime"
+	"path/filepath"
+)
+
+// preferredExtensions picks the usual extension for types that have several.
+var preferredExtensions = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/tiff":       ".tiff",
+	"text/plain":       ".txt",
+	"text/html":        ".html",
+	"audio/mpeg":       ".mp3",
+	"video/mpeg":       ".mpeg",
+	"video/quicktime":  ".mov",
+	"application/gzip": ".gz",
+	"application/zip":  ".zip",
+}
+
+// WithExtensionCorrection adds the usual extension of their MIME type to completed
+// files without one. Files that have an extension keep it, since servers often send
+// a generic or wrong type, e.g. text/plain for a .tar.gz. The name the file had
+// first is kept in DownloadResult.OriginalFileName. Files of generic types such as
+// application/octet-stream are left alone, as are files whose new name is taken.
+func WithExtensionCorrection(enable bool) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.correctExtension = enable
+	}
+}
+
+// correctExtension adds the extension of its MIME type to the file of result if
+// it has none.
+func (f *Fetcher) correctExtension(result *DownloadResult) error {
+	if filepath.Ext(result.FileName) != "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(result.MimeType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return nil
+	}
+	ext := extensionFor(mediaType)
+	if ext == "" {
+		return nil
+	}
+
+	name := result.FileName + ext
+	dest := filepath.Join(filepath.Dir(result.Path), name)
+	// A queued request may be about to write there
+	if f.claimPath(dest) != nil {
+		return nil
+	}
+	defer f.releasePath(dest)
+	// Fails rather than replacing a file that is there
+	if err := tryCommit(result.Path, dest); err != nil {
+		if errors.Is(err, ErrFileExists) {
+			return nil
+		}
+		return err
+	}
+
+	if result.OriginalFileName == "" {
+		result.OriginalFileName = result.FileName
+	}
+	result.FileName = name
+	result.Path = dest
+	return nil
+}
+
+// extensionFor returns the usual extension of a media type, empty if none is known.
+func extensionFor(mediaType string) string {
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
@@ -0,0 +1,225 @@
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// fairShareInterval is how often the bandwidth is redistributed.
+	fairShareInterval = 500 * time.Millisecond
+	// fairShareHeadroom is how much more than it used a download held back by
+	// something else, e.g. a slow server, keeps for itself.
+	fairShareHeadroom = 1.5
+	// fairShareFloor is the least a download is given, so it can show it wants more.
+	fairShareFloor = 4 << 10
+)
+
+// WithFairBandwidth shares the limits of WithMaxBandwidth and the throttle hook
+// evenly between the running downloads instead of first come, first served, so a
+// large file on many connections does not leave small downloads crawling. Downloads
+// that cannot use their share, e.g. because the server is slow, leave the rest to
+// the others; the shares are adjusted twice a second.
+//
+// DownloadRequest.BandwidthWeight and SetBandwidthWeight give downloads a larger
+// or smaller share, e.g. weight 4 for downloads a user waits for and 1 for
+// background sync gives the former 80% of the bandwidth while both run.
+func WithFairBandwidth() FetcherOption {
+	return func(f *Fetcher) {
+		f.fairShare = &fairShare{
+			limit:   f.fairLimit,
+			tasks:   make(map[*shareTask]bool),
+			running: make(map[int]*shareTask),
+			weights: make(map[int]float64),
+		}
+	}
+}
+
+// SetBandwidthWeight changes the bandwidth weight of a queued, paused or running
+// request, see DownloadRequest.BandwidthWeight. A running download gets its new
+// share right away. It needs WithFairBandwidth, and returns ErrUnknownID if no
+// request with the ID is waiting or running.
+func (f *Fetcher) SetBandwidthWeight(id int, weight float64) error {
+	if f.fairShare == nil {
+		return errors.New("bandwidth weights need WithFairBandwidth")
+	}
+	if weight <= 0 {
+		return fmt.Errorf("invalid bandwidth weight: %v", weight)
+	}
+	if f.fairShare.setWeight(id, weight) {
+		return nil
+	}
+
+	f.cancelsMu.Lock()
+	_, queued := f.cancels[id]
+	_, paused := f.paused[id]
+	f.cancelsMu.Unlock()
+	if !queued && !paused {
+		f.fairShare.forget(id)
+		return fmt.Errorf("%w: %d", ErrUnknownID, id)
+	}
+	return nil
+}
+
+// fairShare splits a bandwidth limit between the downloads running at a time by
+// giving each one a token bucket of its own, whose rates add up to the limit.
+type fairShare struct {
+	mu      sync.Mutex
+	limit   func() int64 // The limit to share, zero or less for none
+	tasks   map[*shareTask]bool
+	running map[int]*shareTask // Tasks by request ID
+	weights map[int]float64    // Weights set with SetBandwidthWeight by request ID, kept across retries
+	stop    chan struct{}      // Stops the redistribution, nil while no download runs
+	last    time.Time          // Last redistribution
+}
+
+// shareTask is the share of one download.
+type shareTask struct {
+	id     int
+	bucket *tokenBucket
+	weight float64
+}
+
+// join adds a download of req and returns the bucket limiting it to its share.
+func (s *fairShare) join(req DownloadRequest) *shareTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weight, ok := s.weights[req.ID]
+	if !ok {
+		weight = req.BandwidthWeight
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	t := &shareTask{id: req.ID, bucket: newTokenBucket(0), weight: weight}
+	s.tasks[t] = true
+	s.running[req.ID] = t
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+		go s.run(s.stop)
+	}
+	// A newcomer starts with an even share, the next round corrects it
+	s.rebalance(true)
+	return t
+}
+
+// leave removes a download and gives its share to the others.
+func (s *fairShare) leave(t *shareTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, t)
+	if s.running[t.id] == t {
+		delete(s.running, t.id)
+	}
+	if len(s.tasks) == 0 {
+		close(s.stop)
+		s.stop = nil
+		return
+	}
+	s.rebalance(false)
+}
+
+// setWeight sets the weight of the request with the given ID, reporting whether
+// it is running.
+func (s *fairShare) setWeight(id int, weight float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.weights[id] = weight
+	t, ok := s.running[id]
+	if ok {
+		t.weight = weight
+		s.rebalance(true)
+	}
+	return ok
+}
+
+// forget drops the weight set for a request that is done.
+func (s *fairShare) forget(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.weights, id)
+}
+
+func (s *fairShare) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(fairShareInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.rebalance(false)
+			s.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// rebalance redistributes the limit by max-min fairness: downloads that used
+// clearly less than their share keep what they used plus some headroom, the rest
+// is split between the others by weight. With even set, the usage is ignored and
+// every download gets its weighted share.
+func (s *fairShare) rebalance(even bool) {
+	now := time.Now()
+	elapsed := now.Sub(s.last).Seconds()
+	s.last = now
+
+	limit := float64(s.limit())
+	if limit <= 0 {
+		for t := range s.tasks {
+			t.bucket.retune(0)
+			t.bucket.takeUsed()
+		}
+		return
+	}
+
+	// Downloads that may get more than they demand
+	open := make(map[*shareTask]float64, len(s.tasks))
+	for t := range s.tasks {
+		used := float64(t.bucket.takeUsed())
+		rate := t.bucket.limitRate()
+		demand := -1.0 // Unknown or unlimited
+		// Too short an interval tells nothing about the demand
+		if !even && elapsed >= fairShareInterval.Seconds()/2 && rate > 0 && used/elapsed < 0.9*rate {
+			demand = max(used/elapsed*fairShareHeadroom, fairShareFloor)
+		}
+		open[t] = demand
+	}
+
+	remaining := limit
+	for {
+		var weights float64
+		for t := range open {
+			weights += t.weight
+		}
+		settled := false
+		for t, demand := range open {
+			if share := remaining * t.weight / weights; demand >= 0 && demand < share {
+				t.bucket.retune(demand)
+				remaining -= demand
+				delete(open, t)
+				settled = true
+			}
+		}
+		if !settled {
+			for t := range open {
+				t.bucket.retune(max(remaining*t.weight/weights, fairShareFloor))
+			}
+			return
+		}
+	}
+}
+
+// fairLimit returns the limit shared by WithFairBandwidth, the lower of the
+// bandwidth limit and the throttle hook's.
+func (f *Fetcher) fairLimit() int64 {
+	limit := f.bandwidth.limit()
+	if f.throttle != nil {
+		if hook := f.throttle.bandwidth.limit(); hook > 0 && (limit <= 0 || hook < limit) {
+			limit = hook
+		}
+	}
+	return limit
+}
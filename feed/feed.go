@@ -0,0 +1,220 @@
+// Package feed discovers enclosures in podcast/RSS and Atom feeds and
+// enqueues the ones that were not downloaded before on a dlfetch.Fetcher.
+package feed
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+// Item is a feed entry with a downloadable enclosure.
+type Item struct {
+	GUID      string
+	Title     string
+	URL       string // Enclosure URL
+	MimeType  string
+	Length    int64 // Declared enclosure size, 0 if unknown
+	Published time.Time
+}
+
+type rssFeed struct {
+	Items []struct {
+		GUID      string `xml:"guid"`
+		Title     string `xml:"title"`
+		PubDate   string `xml:"pubDate"`
+		Enclosure *struct {
+			URL    string `xml:"url,attr"`
+			Type   string `xml:"type,attr"`
+			Length string `xml:"length,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		ID        string `xml:"id"`
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Links     []struct {
+			Rel    string `xml:"rel,attr"`
+			Href   string `xml:"href,attr"`
+			Type   string `xml:"type,attr"`
+			Length string `xml:"length,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// Parse reads an RSS 2.0 or Atom document and returns the entries that have an enclosure.
+// Entries without a GUID are identified by their enclosure URL.
+func Parse(r io.Reader) ([]Item, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+
+	var items []Item
+	switch root.XMLName.Local {
+	case "rss":
+		var doc rssFeed
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid rss feed: %w", err)
+		}
+		for _, it := range doc.Items {
+			if it.Enclosure == nil || it.Enclosure.URL == "" {
+				continue
+			}
+			items = append(items, newItem(it.GUID, it.Title, it.Enclosure.URL, it.Enclosure.Type, it.Enclosure.Length, it.PubDate))
+		}
+
+	case "feed":
+		var doc atomFeed
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid atom feed: %w", err)
+		}
+		for _, e := range doc.Entries {
+			published := e.Published
+			if published == "" {
+				published = e.Updated
+			}
+			for _, l := range e.Links {
+				if l.Rel != "enclosure" || l.Href == "" {
+					continue
+				}
+				items = append(items, newItem(e.ID, e.Title, l.Href, l.Type, l.Length, published))
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported feed format: <%s>", root.XMLName.Local)
+	}
+
+	return items, nil
+}
+
+func newItem(guid, title, url, mimeType, length, published string) Item {
+	item := Item{
+		GUID:     strings.TrimSpace(guid),
+		Title:    strings.TrimSpace(title),
+		URL:      strings.TrimSpace(url),
+		MimeType: mimeType,
+	}
+	if item.GUID == "" {
+		item.GUID = item.URL
+	}
+	if n, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64); err == nil && n > 0 {
+		item.Length = n
+	}
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339} {
+		if t, err := time.Parse(layout, strings.TrimSpace(published)); err == nil {
+			item.Published = t
+			break
+		}
+	}
+	return item
+}
+
+// Poller fetches a feed and enqueues enclosures whose GUID is not in the Store yet.
+type Poller struct {
+	Fetcher *dlfetch.Fetcher
+	Store   *Store
+	Client  *http.Client // Optional, defaults to http.DefaultClient
+
+	// Request builds the download request for a new item. Optional; by default the
+	// request ID is derived from the GUID, the file is named after the enclosure URL
+	// and the item's GUID and title are available as Vars "guid" and "title".
+	Request func(Item) dlfetch.DownloadRequest
+}
+
+// Poll fetches the feed at feedURL and enqueues every new enclosure.
+// GUIDs are recorded in the Store as soon as their request is queued; call
+// Store.Forget from the Fetcher's onError callback (using the "guid" var) to
+// retry a failed episode on the next poll.
+func (p *Poller) Poll(ctx context.Context, feedURL string) ([]dlfetch.EnqueueResult, error) {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed: %s, status code: %d", feedURL, resp.StatusCode)
+	}
+
+	items, err := Parse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	build := p.Request
+	if build == nil {
+		build = DefaultRequest
+	}
+
+	var results []dlfetch.EnqueueResult
+	for _, item := range items {
+		if p.Store.Has(item.GUID) {
+			continue
+		}
+		result := p.Fetcher.Enqueue(build(item))
+		results = append(results, result)
+		if result.Queued {
+			if err := p.Store.Add(item.GUID); err != nil {
+				return results, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// DefaultRequest is the request built for a feed item when Poller.Request is not set.
+func DefaultRequest(item Item) dlfetch.DownloadRequest {
+	h := fnv.New64a()
+	h.Write([]byte(item.GUID))
+
+	return dlfetch.DownloadRequest{
+		ID:       int(h.Sum64() & math.MaxInt32),
+		URL:      item.URL,
+		FileName: enclosureName(item.URL),
+		MimeType: item.MimeType,
+		Vars: map[string]string{
+			"guid":  item.GUID,
+			"title": item.Title,
+		},
+	}
+}
+
+// enclosureName returns the last path segment of the URL, ignoring the query string.
+func enclosureName(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i != -1 {
+		rawURL = rawURL[:i]
+	}
+	return path.Base(rawURL)
+}
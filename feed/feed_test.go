@@ -0,0 +1,183 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+const rssDoc = `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+	<item>
+		<guid> ep-2 </guid>
+		<title>Episode 2</title>
+		<pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+		<enclosure url="https://cdn.example.com/ep2.mp3?token=abc" type="audio/mpeg" length="1234"/>
+	</item>
+	<item>
+		<title>Episode 1</title>
+		<enclosure url="https://cdn.example.com/ep1.mp3" type="audio/mpeg" length="unknown"/>
+	</item>
+	<item><title>Announcement</title></item>
+</channel></rss>`
+
+const atomDoc = `<?xml version="1.0"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<entry>
+		<id>urn:ep-1</id>
+		<title>Episode 1</title>
+		<updated>2024-01-02T10:00:00Z</updated>
+		<link rel="alternate" href="https://example.com/ep1"/>
+		<link rel="enclosure" href="https://cdn.example.com/ep1.ogg" type="audio/ogg" length="99"/>
+		<link rel="enclosure" href="https://cdn.example.com/ep1.mp3" type="audio/mpeg"/>
+	</entry>
+</feed>`
+
+func TestParse(t *testing.T) {
+	published := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		doc  string
+		want []Item
+		err  string
+	}{
+		{
+			name: "rss",
+			doc:  rssDoc,
+			want: []Item{
+				{GUID: "ep-2", Title: "Episode 2", URL: "https://cdn.example.com/ep2.mp3?token=abc", MimeType: "audio/mpeg", Length: 1234, Published: published},
+				{GUID: "https://cdn.example.com/ep1.mp3", Title: "Episode 1", URL: "https://cdn.example.com/ep1.mp3", MimeType: "audio/mpeg"},
+			},
+		},
+		{
+			name: "atom",
+			doc:  atomDoc,
+			want: []Item{
+				{GUID: "urn:ep-1", Title: "Episode 1", URL: "https://cdn.example.com/ep1.ogg", MimeType: "audio/ogg", Length: 99, Published: published},
+				{GUID: "urn:ep-1", Title: "Episode 1", URL: "https://cdn.example.com/ep1.mp3", MimeType: "audio/mpeg", Published: published},
+			},
+		},
+		{name: "no items", doc: `<rss><channel></channel></rss>`},
+		{name: "other format", doc: `<html></html>`, err: "unsupported feed format: <html>"},
+		{name: "not xml", doc: `{"items":[]}`, err: "invalid feed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := Parse(strings.NewReader(tt.doc))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range items {
+				// Compare instants, not locations
+				if !items[i].Published.Equal(tt.want[i].Published) {
+					t.Errorf("item %d published %v, want %v", i, items[i].Published, tt.want[i].Published)
+				}
+				items[i].Published = tt.want[i].Published
+			}
+			if !reflect.DeepEqual(items, tt.want) {
+				t.Errorf("parsed %+v, want %+v", items, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultRequest(t *testing.T) {
+	item := Item{GUID: "ep-2", Title: "Episode 2", URL: "https://cdn.example.com/a/ep2.mp3?token=abc#t=10", MimeType: "audio/mpeg"}
+	req := DefaultRequest(item)
+	if req.FileName != "ep2.mp3" || req.URL != item.URL || req.MimeType != "audio/mpeg" {
+		t.Errorf("request %+v", req)
+	}
+	if req.Vars["guid"] != "ep-2" || req.Vars["title"] != "Episode 2" {
+		t.Errorf("vars %v", req.Vars)
+	}
+	if req.ID <= 0 || DefaultRequest(item).ID != req.ID || DefaultRequest(Item{GUID: "ep-3"}).ID == req.ID {
+		t.Errorf("ID %d is not derived from the GUID", req.ID)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guids.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, guid := range []string{"b", "a", "c"} {
+		if err := s.Add(guid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Forget("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has("a") || !s.Has("b") || s.Has("c") {
+		t.Error("store did not keep its GUIDs")
+	}
+	if data, _ := os.ReadFile(path); strings.Join(strings.Fields(string(data)), "") != `["a","b"]` {
+		t.Errorf("stored %s", data)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil {
+		t.Error("opened a corrupt store")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(rssDoc))
+	}))
+	defer srv.Close()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "guids.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("ep-2"); err != nil {
+		t.Fatal(err)
+	}
+	p := &Poller{Fetcher: dlfetch.New(dlfetch.WithTargetDir(t.TempDir())), Store: store}
+
+	results, err := p.Poll(context.Background(), srv.URL+"/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Queued {
+		t.Fatalf("results %+v, want episode 1 queued", results)
+	}
+	if !store.Has("https://cdn.example.com/ep1.mp3") {
+		t.Error("queued GUID not recorded")
+	}
+	// Everything is known on the next poll
+	if results, err := p.Poll(context.Background(), srv.URL+"/feed.xml"); err != nil || len(results) != 0 {
+		t.Errorf("second poll: %+v, %v", results, err)
+	}
+
+	if _, err := p.Poll(context.Background(), srv.URL+"/missing.xml"); err == nil || !strings.Contains(err.Error(), "status code: 404") {
+		t.Errorf("poll of a missing feed: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package feed
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Store remembers the GUIDs of feed items that were already downloaded.
+// It is persisted as a JSON array in a file, rewritten on every change.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	guids map[string]struct{}
+}
+
+// OpenStore loads the GUID store at path. A missing file is an empty store.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		guids: make(map[string]struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var guids []string
+	if err := json.Unmarshal(data, &guids); err != nil {
+		return nil, err
+	}
+	for _, g := range guids {
+		s.guids[g] = struct{}{}
+	}
+	return s, nil
+}
+
+// Has reports whether the GUID was recorded.
+func (s *Store) Has(guid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.guids[guid]
+	return ok
+}
+
+// Add records the GUID.
+func (s *Store) Add(guid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guids[guid] = struct{}{}
+	return s.save()
+}
+
+// Forget removes the GUID, so the item is enqueued again on the next poll.
+func (s *Store) Forget(guid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.guids, guid)
+	return s.save()
+}
+
+// save writes the store through a temporary file so a crash never leaves it truncated.
+func (s *Store) save() error {
+	guids := make([]string, 0, len(s.guids))
+	for g := range s.guids {
+		guids = append(guids, g)
+	}
+	sort.Strings(guids)
+
+	data, err := json.MarshalIndent(guids, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,43 @@
+package dlfetch
+
+import "time"
+
+// Special values of the remaining time passed to Formatter.FormatETA.
+const (
+	ETAUnknown     time.Duration = -1 // The size of the download is not known
+	ETACalculating time.Duration = -2 // No speed measured yet
+)
+
+// Formatter turns the human-facing values of a TaskMonitor into text, the ETA and
+// the Error of a DownloadTask, so applications can localize their download UIs.
+// FormatError can tell errors apart with errors.Is and errors.As, e.g. ErrCancelled
+// or *HTTPStatusError.
+type Formatter interface {
+	FormatETA(remaining time.Duration) string // remaining may be ETAUnknown or ETACalculating
+	FormatError(err error) string
+}
+
+// DefaultFormatter formats in English: ETAs like "1m30s", "unknown" or
+// "calculating...", and errors by their Error method.
+type DefaultFormatter struct{}
+
+func (DefaultFormatter) FormatETA(remaining time.Duration) string {
+	switch remaining {
+	case ETAUnknown:
+		return "unknown"
+	case ETACalculating:
+		return "calculating..."
+	}
+	return remaining.Truncate(time.Second).String()
+}
+
+func (DefaultFormatter) FormatError(err error) string {
+	return err.Error()
+}
+
+// WithFormatter formats the ETA and Error of tasks with f instead of DefaultFormatter.
+func WithFormatter(f Formatter) MonitorOption {
+	return func(m *TaskMonitor) {
+		m.formatter = f
+	}
+}
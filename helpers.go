@@ -1,10 +1,17 @@
 package dlfetch
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -18,13 +25,27 @@ func checkFileExists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
+// fileExists reports whether a file exists at path. Unlike checkFileExists it
+// returns the errors that leave it open, e.g. a missing permission.
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	}
+	return false, err
+}
+
 // ensureFileName ensures that the DownloadRequest has a valid FileName.
 // If FileName is empty, it extracts the file name from the URL.
 func ensureFileName(req *DownloadRequest) {
 	if req.FileName != "" {
 		return
 	}
-	req.FileName = filepath.Base(req.URL)
+	req.FileName = fileNameFromURL(req.URL)
+	req.nameFromURL = true
 }
 
 // ensureDir ensures that the directory for the given path exists.
@@ -33,6 +54,25 @@ func ensureDir(path string) error {
 	return os.MkdirAll(dir, 0755)
 }
 
+// stagingPath returns the path of the in-progress file for the given target path.
+func (p policy) stagingPath(path string) string {
+	dir, name := filepath.Split(path)
+	if p.hiddenStaging {
+		name = "." + name
+	}
+	staging := filepath.Join(dir, name+p.tmpSuffix)
+	if staging == filepath.Clean(path) {
+		// Never stage into the target itself
+		staging += defaultTmpSuffix
+	}
+	return staging
+}
+
+// writeMarker creates an empty marker file at the given path.
+func writeMarker(path string) error {
+	return os.WriteFile(path, nil, 0644)
+}
+
 // determineMimeType returns the most accurate MIME type for a downloaded file.
 func determineMimeType(req DownloadRequest, respContentType string, filePath string) string {
 	if respContentType != "" && respContentType != "application/octet-stream" {
@@ -47,10 +87,26 @@ func determineMimeType(req DownloadRequest, respContentType string, filePath str
 		}
 	}
 	// fallback: detect from file bytes
-	file, _ := os.Open(filePath)
+	if mt := sniffMimeType(filePath); mt != "" {
+		return mt
+	}
+	return "application/octet-stream"
+}
+
+// sniffMimeType detects the MIME type from the first bytes of the file.
+// It returns an empty string if the file cannot be read.
+func sniffMimeType(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
 	defer file.Close()
+
 	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ""
+	}
 	return http.DetectContentType(buf[:n])
 }
 
@@ -81,20 +137,90 @@ func (d *DownloadResult) IsAudio() bool {
 	return d.isOfType("audio")
 }
 
-// validateRequest checks if the file name is not nil or empty
+// validateRequest applies the request's preset, checks if the file name is not nil or empty
 // also checks if file already exists
 func (f *Fetcher) validateRequest(req *DownloadRequest) error {
-	ensureFileName(req)
+	if req.URL == "" && req.URLProvider != nil && req.FileName == "" {
+		return errors.New("a request with only a URLProvider needs a FileName")
+	}
+
+	if r := req.Range; r != nil && (r.Offset < 0 || r.Length < 0) {
+		return fmt.Errorf("invalid byte range: offset %d, length %d", r.Offset, r.Length)
+	}
+
+	if err := f.applyPreset(req); err != nil {
+		return err
+	}
 
-	req.FullPath = filepath.Join(f.targetDir, req.Path, req.FileName)
+	if err := f.validateURLs(*req); err != nil {
+		return err
+	}
 
-	if !f.enableOverwrite && checkFileExists(req.FullPath) {
-		return fmt.Errorf("file already exists: %s", req.FullPath)
+	if err := f.resolvePath(req); err != nil {
+		return err
+	}
+
+	if err := f.validateSink(*req); err != nil {
+		return err
+	}
+
+	if err := f.validateProxy(*req); err != nil {
+		return err
+	}
+
+	if !isStreamTarget(*req) && checkFileExists(req.FullPath) {
+		switch f.currentPolicy().overwriteFor(*req) {
+		case OverwriteError:
+			return fmt.Errorf("%w: %s", ErrFileExists, req.FullPath)
+		case OverwriteSkip:
+			return errSkipped
+		}
 	}
 
 	return nil
 }
 
+// resolvePath expands templates and fills in the FileName and the computed FullPath of the request.
+func (f *Fetcher) resolvePath(req *DownloadRequest) error {
+	if err := expandRequestTemplates(req); err != nil {
+		return err
+	}
+	ensureFileName(req)
+
+	p := f.currentPolicy()
+
+	var mirrorDir string
+	if p.mirrorRemotePath {
+		mirrorDir = remotePathDir(req.URL)
+	}
+	req.FullPath = filepath.Join(p.targetDir, req.Path, mirrorDir, req.FileName)
+	switch {
+	case req.Writer != nil:
+		// Nothing is written to the target directory
+		req.FullPath = ""
+	case req.FileName == StdoutFileName:
+		req.FullPath = StdoutFileName
+	default:
+		// Template variables may come from anywhere, e.g. the clients of a server, so
+		// the expanded path is checked rather than the templates
+		if rel, err := filepath.Rel(p.targetDir, req.FullPath); err != nil || rel == "." || !filepath.IsLocal(rel) {
+			return fmt.Errorf("%w: %s", ErrInvalidPath, filepath.Join(req.Path, req.FileName))
+		}
+	}
+	return nil
+}
+
+// remotePathDir returns the host and directory part of the URL as a relative
+// local path. Dot segments are resolved so the result never escapes its parent.
+func remotePathDir(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	dir := path.Dir(path.Clean("/" + u.Path))
+	return filepath.Join(u.Hostname(), filepath.FromSlash(dir))
+}
+
 // resolveFileSize attempts to find the file size from various headers.
 // Returns -1 if the size cannot be determined (e.g., chunked transfer).
 func resolveFileSize(resp *http.Response) int64 {
@@ -110,14 +236,18 @@ func resolveFileSize(resp *http.Response) int64 {
 	}
 
 	// Checkinng Content-Range
-	if cr := resp.Header.Get("Content-Range"); cr != "" {
-		// Handle both "bytes 0-999/1000" and "bytes */1000"
-		if idx := strings.LastIndex(cr, "/"); idx != -1 {
-			totalStr := strings.TrimSpace(cr[idx+1:])
-			if totalStr != "*" {
-				if size, err := strconv.ParseInt(totalStr, 10, 64); err == nil && size > 0 {
-					return size
-				}
+	return contentRangeTotal(resp.Header.Get("Content-Range"))
+}
+
+// contentRangeTotal returns the complete length given in a Content-Range header,
+// UnknownSize if it has none.
+func contentRangeTotal(cr string) int64 {
+	// Handle both "bytes 0-999/1000" and "bytes */1000"
+	if idx := strings.LastIndex(cr, "/"); idx != -1 {
+		totalStr := strings.TrimSpace(cr[idx+1:])
+		if totalStr != "*" {
+			if size, err := strconv.ParseInt(totalStr, 10, 64); err == nil && size > 0 {
+				return size
 			}
 		}
 	}
@@ -125,3 +255,50 @@ func resolveFileSize(resp *http.Response) int64 {
 	// Unknown size
 	return UnknownSize
 }
+
+// setHeaders adds the request's custom headers to an outgoing request.
+// A "Host" entry overrides the host sent to the server.
+func setHeaders(httpReq *http.Request, headers map[string]string) {
+	for key, value := range headers {
+		if strings.EqualFold(key, "Host") {
+			httpReq.Host = value
+			continue
+		}
+		httpReq.Header.Set(key, value)
+	}
+}
+
+// redactedURL returns rawURL for logs, traces and webhooks, without a password
+// or query, which carries the signature of presigned URLs.
+func redactedURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		rawURL, _, _ = strings.Cut(rawURL, "?")
+		return rawURL
+	}
+	return redactURL(u)
+}
+
+// redactURL is redactedURL for a parsed URL.
+func redactURL(u *url.URL) string {
+	stripped := *u
+	stripped.RawQuery = ""
+	stripped.ForceQuery = false
+	return stripped.Redacted()
+}
+
+// hashFile returns the hex encoded SHA-256 and the size of the file at path.
+func hashFile(path string) (string, int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, in)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,468 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hostLimiter caps the number of simultaneous downloads per host.
+// With autoTune set, each host's cap adapts between min and max: it grows while
+// more connections still raise the host's throughput and is halved on errors
+// that point at congestion or rate limiting. The smallest segment worth its own
+// connection adapts to the speed of the host's connections as well.
+//
+// Workers check for a free slot before they run a request. Requests for a busy
+// host are parked and go back on the queue once a slot frees up, so a worker is
+// never held up by one host while requests for others wait.
+type hostLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	hosts    map[string]*hostState
+	min      int
+	max      int
+	autoTune bool
+}
+
+// hostState tracks one host's active downloads and, when tuning, its recent performance.
+type hostState struct {
+	active int
+	limit  int
+
+	windowStart time.Time
+	windowDone  int
+	windowBytes int64
+	lastRate    float64 // Bytes per second of the previous window
+
+	segmentSize int64 // Smallest segment worth its own connection, 0 until measured
+
+	parked []*parkedRequest // Waiting for a free slot, oldest first
+}
+
+// parkedRequest is a request parked until its host has a free slot.
+type parkedRequest struct {
+	f    *Fetcher
+	req  DownloadRequest
+	stop func() bool // Stops watching the request's context, nil when not watched
+}
+
+func newHostLimiter(min, max int, autoTune bool) *hostLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	l := &hostLimiter{
+		hosts:    make(map[string]*hostState),
+		min:      min,
+		max:      max,
+		autoTune: autoTune,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// WithAutoTune lets the Fetcher find the best number of simultaneous downloads per host
+// on its own, between minPerHost and maxPerHost. Each host starts at minPerHost; its limit
+// grows by one while the extra connection still raises the host's throughput, and is
+// halved when downloads fail with signs of congestion (timeouts, resets, 429 and 5xx).
+// The worker count stays the overall cap.
+//
+// With WithSegments, the size a download needs per segment to be split adapts to
+// each host as well: it is what one connection to the host fetches in about two
+// seconds, doubled on congestion, between the bounds of WithSegmentSizeBounds.
+func WithAutoTune(minPerHost, maxPerHost int) FetcherOption {
+	return func(f *Fetcher) {
+		f.hostLimiter = newHostLimiter(minPerHost, maxPerHost, true)
+	}
+}
+
+// WithMaxPerHost limits the number of simultaneous connections to the same host to n,
+// so bulk downloads from one origin do not get the client banned. Downloads over the
+// limit wait for a running one of the host to finish. Every segment of a WithSegments
+// download counts as a connection, and a download is only split into as many
+// segments as the host has free slots. It replaces WithAutoTune.
+func WithMaxPerHost(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.hostLimiter = newHostLimiter(n, n, false)
+	}
+}
+
+func (l *hostLimiter) state(host string) *hostState {
+	s, ok := l.hosts[host]
+	if !ok {
+		s = &hostState{limit: l.min, windowStart: time.Now()}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// admit reports whether the host of the request has a free slot. If it has none
+// the request is parked and handed back to f's queue once one frees up or the
+// request is cancelled, and admit returns false. Cancelled requests are always
+// admitted, they fail right away.
+func (l *hostLimiter) admit(f *Fetcher, req DownloadRequest) bool {
+	if req.context().Err() != nil {
+		return true
+	}
+	host := hostOf(req.URL)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s := l.state(host); s.active < s.limit {
+		return true
+	}
+	l.park(host, &parkedRequest{f: f, req: req})
+	return false
+}
+
+// park adds a request to the host's parked ones. Must be called with mu held.
+func (l *hostLimiter) park(host string, p *parkedRequest) {
+	s := l.state(host)
+	s.parked = append(s.parked, p)
+	if p.req.context().Err() != nil {
+		// Cancelled while its Fetcher is stopped, Start queues it again
+		return
+	}
+	p.stop = context.AfterFunc(p.req.context(), func() {
+		l.mu.Lock()
+		i := slices.Index(s.parked, p)
+		if i >= 0 {
+			s.parked = slices.Delete(s.parked, i, i+1)
+		}
+		l.mu.Unlock()
+		if i >= 0 {
+			l.requeue(host, p)
+		}
+	})
+}
+
+// wake queues as many parked requests of the host again as it has free slots.
+// Must be called with mu held.
+func (l *hostLimiter) wake(host string, s *hostState) {
+	n := min(len(s.parked), s.limit-s.active)
+	if n <= 0 {
+		return
+	}
+	woken := slices.Clone(s.parked[:n])
+	s.parked = slices.Delete(s.parked, 0, n)
+	for _, p := range woken {
+		if p.stop != nil {
+			p.stop()
+		}
+		go l.requeue(host, p)
+	}
+}
+
+// requeue puts a parked request back on its Fetcher's queue, or parks it again
+// while the Fetcher is stopped.
+func (l *hostLimiter) requeue(host string, p *parkedRequest) {
+	if err := p.f.send(p.req); err != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.park(host, &parkedRequest{f: p.f, req: p.req})
+	}
+}
+
+// takeParked removes and returns the parked requests of f, used to queue them
+// again after a restart when no download is running that would wake them.
+func (l *hostLimiter) takeParked(f *Fetcher) []DownloadRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var parked []DownloadRequest
+	for _, s := range l.hosts {
+		s.parked = slices.DeleteFunc(s.parked, func(p *parkedRequest) bool {
+			if p.f != f {
+				return false
+			}
+			if p.stop != nil {
+				p.stop()
+			}
+			parked = append(parked, p.req)
+			return true
+		})
+	}
+	return parked
+}
+
+// admitHosts reports whether the per-host limits leave room for the request. If
+// they do not the request is parked until they do.
+func (f *Fetcher) admitHosts(req DownloadRequest) bool {
+	if f.hostLimiter != nil && !f.hostLimiter.admit(f, req) {
+		return false
+	}
+	return f.shared == nil || f.shared.hosts == nil || f.shared.hosts.admit(f, req)
+}
+
+// requeueParkedHosts queues the requests parked for a busy host again, used after
+// a restart.
+func (f *Fetcher) requeueParkedHosts() {
+	for _, l := range []*hostLimiter{f.hostLimiter, f.sharedHosts()} {
+		if l == nil {
+			continue
+		}
+		if parked := l.takeParked(f); len(parked) > 0 {
+			go func() {
+				for _, req := range parked {
+					l.requeue(hostOf(req.URL), &parkedRequest{f: f, req: req})
+				}
+			}()
+		}
+	}
+}
+
+// sharedHosts returns the per-host limits of the shared limiter, nil if there are none.
+func (f *Fetcher) sharedHosts() *hostLimiter {
+	if f.shared == nil {
+		return nil
+	}
+	return f.shared.hosts
+}
+
+// acquire waits until the host has a free slot and takes it. It returns the
+// error of ctx if ctx is done first.
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	if err := waitCond(ctx, l.cond, func() bool { return s.active < s.limit }); err != nil {
+		return err
+	}
+	s.active++
+	return nil
+}
+
+// tryAcquire takes up to n free slots of the host without waiting and returns
+// how many it took.
+func (l *hostLimiter) tryAcquire(host string, n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	n = max(0, min(n, s.limit-s.active))
+	s.active += n
+	return n
+}
+
+// releaseSlots frees n slots taken by tryAcquire. Unlike release it leaves the
+// host's limit alone, the download they were taken for reports its outcome.
+func (l *hostLimiter) releaseSlots(host string, n int) {
+	if n == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	s.active -= n
+	l.cond.Broadcast()
+	l.wake(host, s)
+}
+
+// acquireConnections takes slots for up to n extra connections to host, such as
+// the segments of a download, without waiting. It returns how many it took and a
+// function that frees them.
+func (f *Fetcher) acquireConnections(host string, n int) (int, func()) {
+	own, shared := f.hostLimiter, f.sharedHosts()
+	if own != nil {
+		n = own.tryAcquire(host, n)
+	}
+	if shared != nil {
+		taken := shared.tryAcquire(host, n)
+		if own != nil {
+			own.releaseSlots(host, n-taken)
+		}
+		n = taken
+	}
+	return n, func() {
+		if own != nil {
+			own.releaseSlots(host, n)
+		}
+		if shared != nil {
+			shared.releaseSlots(host, n)
+		}
+	}
+}
+
+// release frees the slot taken by acquire and, when tuning, feeds the
+// outcome of the download into the host's limit.
+func (l *hostLimiter) release(host string, bytes int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	s.active--
+	if l.autoTune {
+		l.adjust(s, bytes, err)
+	}
+	l.cond.Broadcast()
+	l.wake(host, s)
+}
+
+// waitCond waits on c until ready returns true or ctx is done, in which case it
+// returns the error of ctx. c.L must be held.
+func waitCond(ctx context.Context, c *sync.Cond, ready func() bool) error {
+	if ready() {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.L.Lock()
+		defer c.L.Unlock()
+		c.Broadcast()
+	})
+	defer stop()
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.Wait()
+	}
+	return nil
+}
+
+// adjust updates the host's limit after a download. Must be called with mu held.
+func (l *hostLimiter) adjust(s *hostState, bytes int64, err error) {
+	if err != nil {
+		if isCongestionError(err) {
+			s.limit = max(l.min, s.limit/2)
+			s.resetWindow()
+			s.lastRate = 0
+		}
+		return
+	}
+
+	s.windowDone++
+	s.windowBytes += bytes
+
+	// Judge a limit once a full round of downloads finished with it
+	if s.windowDone < s.limit {
+		return
+	}
+	elapsed := time.Since(s.windowStart).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(s.windowBytes) / elapsed
+
+	switch {
+	case s.lastRate == 0 || rate > s.lastRate*1.05:
+		s.limit = min(l.max, s.limit+1)
+	case rate < s.lastRate*0.9:
+		s.limit = max(l.min, s.limit-1)
+	}
+	s.lastRate = rate
+	s.resetWindow()
+}
+
+// segmentTime is how long a segment should take at least at the speed of the
+// host's connections for the extra request to pay off.
+const segmentTime = 2 * time.Second
+
+// segmentSize returns the smallest segment worth its own connection to the host,
+// between lo and hi. Until a download of the host is measured it is lo.
+func (l *hostLimiter) segmentSize(host string, lo, hi int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return min(hi, max(lo, l.state(host).segmentSize))
+}
+
+// tuneSegments feeds a download of bytes over the given number of connections into
+// the host's segment size: the faster each connection, the larger a segment has to
+// be to pay off. Congestion doubles it, so fewer connections are opened.
+func (l *hostLimiter) tuneSegments(host string, bytes int64, connections int, elapsed time.Duration, err error, lo, hi int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	size := max(lo, s.segmentSize)
+	switch {
+	case err != nil:
+		if isCongestionError(err) {
+			size *= 2
+		}
+	case bytes >= lo && elapsed > 0:
+		// Too small transfers are dominated by latency, not speed
+		perConnection := float64(bytes) / float64(max(1, connections)) / elapsed.Seconds()
+		measured := int64(perConnection * segmentTime.Seconds())
+		if s.segmentSize == 0 {
+			size = measured
+		} else {
+			size = (size + measured) / 2
+		}
+	default:
+		return
+	}
+	s.segmentSize = min(hi, max(lo, size))
+}
+
+func (s *hostState) resetWindow() {
+	s.windowStart = time.Now()
+	s.windowDone = 0
+	s.windowBytes = 0
+}
+
+// limits returns the current limit of every host seen so far.
+func (l *hostLimiter) limits() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := make(map[string]int, len(l.hosts))
+	for host, s := range l.hosts {
+		limits[host] = s.limit
+	}
+	return limits
+}
+
+// HostLimits returns the current per-host download limits, or nil when
+// per-host limiting is not enabled.
+func (f *Fetcher) HostLimits() map[string]int {
+	if f.hostLimiter == nil {
+		return nil
+	}
+	return f.hostLimiter.limits()
+}
+
+// isCongestionError reports whether err suggests the host or the link is overloaded,
+// as opposed to a problem with the request itself: a 429 or 5xx status, a timeout,
+// a refused or reset connection, or a transfer cut short.
+func isCongestionError(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	// Cancelled or expired downloads and refused URLs or redirects are of our own making
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrURLBlocked) || errors.Is(err, ErrRedirect) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// Every *url.Error is a net.Error, whatever it wraps
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// hostOf returns the host of a URL, or an empty string if it cannot be parsed.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
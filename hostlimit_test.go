@@ -0,0 +1,46 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsCongestionError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"429", &HTTPStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"503", fmt.Errorf("download: %w", &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}), true},
+		{"404", &HTTPStatusError{StatusCode: http.StatusNotFound}, false},
+		{"reset", urlErr(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"refused", urlErr(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"timeout", urlErr(&net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}), true},
+		{"cut short", io.ErrUnexpectedEOF, true},
+		{"no such host", urlErr(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}), false},
+		{"unsupported scheme", urlErr(errors.New("unsupported protocol scheme")), false},
+		{"blocked", urlErr(fmt.Errorf("%w: 10.0.0.1", ErrURLBlocked)), false},
+		{"redirect", urlErr(ErrRedirect), false},
+		{"cancelled", urlErr(context.Canceled), false},
+		{"deadline", urlErr(context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCongestionError(tt.err); got != tt.want {
+				t.Errorf("isCongestionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Package remote holds what the packages serving a Fetcher to other processes,
+// api, control and nativemsg, have in common.
+package remote
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hritikr/dlfetch"
+)
+
+// CheckRequest rejects the requests clients may not make. The Fetcher keeps the
+// files of requests below its target directory, but would stream a request named
+// StdoutFileName to the standard output of the server.
+func CheckRequest(req dlfetch.DownloadRequest) error {
+	if req.URL == "" {
+		return errors.New("invalid download: url is required")
+	}
+	if req.FileName == dlfetch.StdoutFileName {
+		return errors.New("invalid download: cannot stream to standard output")
+	}
+	return nil
+}
+
+// IDs picks the IDs of requests that clients enqueue without one.
+type IDs struct {
+	mu   sync.Mutex // Serializes picking and enqueuing
+	next int
+}
+
+// Enqueue enqueues req on f. A request without an ID gets one that no task of m
+// uses, picked and enqueued at once so concurrent calls get different IDs. It
+// returns the ID of the request.
+func (ids *IDs) Enqueue(f *dlfetch.Fetcher, m dlfetch.Monitor, req dlfetch.DownloadRequest) (int, dlfetch.EnqueueResult) {
+	if req.ID != 0 {
+		return req.ID, f.Enqueue(req)
+	}
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	for _, task := range m.GetSnapshot().Tasks {
+		ids.next = max(ids.next, task.ID)
+	}
+	ids.next++
+	req.ID = ids.next
+	return req.ID, f.Enqueue(req)
+}
+
+// Task looks up the task with the given ID in m.
+func Task(m dlfetch.Monitor, id int) (dlfetch.DownloadTask, bool) {
+	for _, task := range m.GetSnapshot().Tasks {
+		if task.ID == id {
+			return task, true
+		}
+	}
+	return dlfetch.DownloadTask{}, false
+}
+
+// SameTask reports whether a and b are equal, comparing CompletedAt by value.
+func SameTask(a, b dlfetch.DownloadTask) bool {
+	ac, bc := a.CompletedAt, b.CompletedAt
+	a.CompletedAt, b.CompletedAt = nil, nil
+	if a != b || (ac == nil) != (bc == nil) {
+		return false
+	}
+	return ac == nil || ac.Equal(*bc)
+}
+
+// IsFinished reports whether a task with the given status is done for good.
+func IsFinished(status dlfetch.DownloadStatus) bool {
+	return status == dlfetch.StatusCompleted || status == dlfetch.StatusFailed || status == dlfetch.StatusCancelled
+}
+
+// ErrorKind classifies the errors of Enqueue, for the servers to map to their
+// status codes.
+type ErrorKind int
+
+const (
+	Invalid     ErrorKind = iota // The request is malformed or refers to unknown presets, sinks...
+	Conflict                     // The ID or target path is taken
+	Exhausted                    // The quota of the tenant is used up
+	Forbidden                    // A URL policy blocks the URL
+	Unavailable                  // The Fetcher is stopped
+)
+
+// KindOf returns the kind of an Enqueue error.
+func KindOf(err error) ErrorKind {
+	switch {
+	case errors.Is(err, dlfetch.ErrDuplicateID), errors.Is(err, dlfetch.ErrPathInUse), errors.Is(err, dlfetch.ErrFileExists):
+		return Conflict
+	case errors.Is(err, dlfetch.ErrQuotaExceeded):
+		return Exhausted
+	case errors.Is(err, dlfetch.ErrURLBlocked):
+		return Forbidden
+	case errors.Is(err, dlfetch.ErrStopped):
+		return Unavailable
+	}
+	return Invalid
+}
@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+func TestCheckRequest(t *testing.T) {
+	tests := []struct {
+		req dlfetch.DownloadRequest
+		ok  bool
+	}{
+		{dlfetch.DownloadRequest{URL: "http://h/x"}, true},
+		{dlfetch.DownloadRequest{URL: "http://h/x", FileName: "a/b.bin"}, true},
+		{dlfetch.DownloadRequest{FileName: "x.bin"}, false},
+		{dlfetch.DownloadRequest{URL: "http://h/x", FileName: dlfetch.StdoutFileName}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckRequest(tt.req); (err == nil) != tt.ok {
+			t.Errorf("CheckRequest(%+v): %v", tt.req, err)
+		}
+	}
+}
+
+func TestEnqueueIDs(t *testing.T) {
+	monitor := dlfetch.NewMonitor()
+	f := dlfetch.New(dlfetch.WithTargetDir(t.TempDir()), dlfetch.WithMonitor(monitor))
+	if res := f.Enqueue(dlfetch.DownloadRequest{ID: 5, URL: "http://127.0.0.1:1/x", FileName: "five"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+
+	var ids IDs
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			id, res := ids.Enqueue(f, monitor, dlfetch.DownloadRequest{URL: "http://127.0.0.1:1/x", FileName: fmt.Sprint(i)})
+			if res.Error != nil {
+				t.Error(res.Error)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if id <= 5 || seen[id] {
+				t.Errorf("picked ID %d", id)
+			}
+			seen[id] = true
+		})
+	}
+	wg.Wait()
+
+	if id, res := ids.Enqueue(f, monitor, dlfetch.DownloadRequest{ID: 5, URL: "http://127.0.0.1:1/x", FileName: "again"}); id != 5 || !errors.Is(res.Error, dlfetch.ErrDuplicateID) {
+		t.Errorf("enqueue with a taken ID: %d, %v", id, res.Error)
+	}
+	if task, ok := Task(monitor, 5); !ok || task.ID != 5 {
+		t.Errorf("task 5: %+v, %v", task, ok)
+	}
+	if _, ok := Task(monitor, 99); ok {
+		t.Error("found task 99")
+	}
+}
+
+func TestSameTask(t *testing.T) {
+	now := time.Now()
+	sameInstant := now.In(time.FixedZone("other", 3600))
+	later := now.Add(time.Second)
+	tests := []struct {
+		a, b dlfetch.DownloadTask
+		want bool
+	}{
+		{dlfetch.DownloadTask{ID: 1}, dlfetch.DownloadTask{ID: 1}, true},
+		{dlfetch.DownloadTask{ID: 1}, dlfetch.DownloadTask{ID: 1, DoneBytes: 1}, false},
+		{dlfetch.DownloadTask{CompletedAt: &now}, dlfetch.DownloadTask{CompletedAt: &sameInstant}, true},
+		{dlfetch.DownloadTask{CompletedAt: &now}, dlfetch.DownloadTask{CompletedAt: &later}, false},
+		{dlfetch.DownloadTask{CompletedAt: &now}, dlfetch.DownloadTask{}, false},
+	}
+	for i, tt := range tests {
+		if got := SameTask(tt.a, tt.b); got != tt.want {
+			t.Errorf("case %d: SameTask = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{fmt.Errorf("enqueue: %w", dlfetch.ErrDuplicateID), Conflict},
+		{dlfetch.ErrPathInUse, Conflict},
+		{dlfetch.ErrFileExists, Conflict},
+		{dlfetch.ErrQuotaExceeded, Exhausted},
+		{dlfetch.ErrURLBlocked, Forbidden},
+		{dlfetch.ErrStopped, Unavailable},
+		{errors.New("unknown preset"), Invalid},
+	}
+	for _, tt := range tests {
+		if got := KindOf(tt.err); got != tt.want {
+			t.Errorf("KindOf(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
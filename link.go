@@ -0,0 +1,78 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// LinkType selects how Link places a completed file into additional locations.
+type LinkType int
+
+const (
+	HardLink LinkType = iota // Same file under another name, must be on the same filesystem
+	SymLink                  // Symbolic link to the absolute path of the file
+)
+
+// Link returns a post-processor that makes a completed file available in additional
+// locations without copying it, e.g. a per-tag view next to a flat archive. Each
+// destination is a template with the same data as Relocate:
+//
+//	dlfetch.Link(dlfetch.HardLink, "/archive/{{.FileName}}", "/tags/{{.Vars.tag}}/{{.FileName}}")
+//
+// Where a link cannot be created (hard links across filesystems, symlinks without the
+// privilege on Windows) the file is copied instead. The file itself stays where it is;
+// place Link after Relocate to link the relocated file. An existing destination is
+// only accepted if it already is the same file.
+func Link(linkType LinkType, destTemplates ...string) (PostProcessor, error) {
+	for _, dt := range destTemplates {
+		if _, err := template.New("link").Parse(dt); err != nil {
+			return nil, fmt.Errorf("invalid link template: %w", err)
+		}
+	}
+
+	return PostProcessorFunc(func(ctx context.Context, result *DownloadResult) error {
+		data := newRelocateData(*result)
+		for _, dt := range destTemplates {
+			dest, err := expandTemplate("link", dt, data)
+			if err != nil {
+				return err
+			}
+			if err := linkFile(linkType, result.Path, filepath.Clean(dest)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}), nil
+}
+
+// linkFile links dst to src, falling back to a copy.
+func linkFile(linkType LinkType, src, dst string) error {
+	if info, err := os.Stat(dst); err == nil {
+		if srcInfo, err := os.Stat(src); err == nil && os.SameFile(info, srcInfo) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrFileExists, dst)
+	}
+	if err := ensureDir(dst); err != nil {
+		return err
+	}
+
+	var err error
+	switch linkType {
+	case SymLink:
+		var abs string
+		if abs, err = filepath.Abs(src); err == nil {
+			err = os.Symlink(abs, dst)
+		}
+	default:
+		err = os.Link(src, dst)
+	}
+	if err == nil {
+		return nil
+	}
+
+	return copyFile(src, dst)
+}
@@ -0,0 +1,38 @@
+package dlfetch
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// WithLogger logs the life of every download to l: enqueued and started at debug
+// level, completed, paused and cancelled at info level, retried and failed at warn
+// level. The records carry the request as attributes, e.g. "id", "url", "path",
+// "attempt" and "error", and "fetcher" with the name of WithName. Without a
+// logger the Fetcher logs nothing.
+func WithLogger(l *slog.Logger) FetcherOption {
+	return func(f *Fetcher) {
+		f.logger = l
+	}
+}
+
+// logRequest returns the logger with the attributes of req.
+func (f *Fetcher) logRequest(req DownloadRequest) *slog.Logger {
+	return f.logger.With("id", req.ID, "url", redactedURL(req.URL))
+}
+
+// logOutcome logs how a download ended after attempts attempts.
+func (f *Fetcher) logOutcome(req DownloadRequest, result DownloadResult, err error, attempts int, started time.Time) {
+	l := f.logRequest(req).With("attempts", attempts, "duration", time.Since(started))
+	switch {
+	case err == nil:
+		l.Info("download completed", "path", result.Path, "size", result.Size)
+	case errors.Is(err, ErrPaused):
+		l.Info("download paused")
+	case req.context().Err() != nil:
+		l.Info("download cancelled", "error", err)
+	default:
+		l.Warn("download failed", "path", req.FullPath, "error", err)
+	}
+}
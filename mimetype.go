@@ -0,0 +1,53 @@
+package dlfetch
+
+import (
+	"mime"
+)
+
+// MimeDetector decides the MIME type of a downloaded file from the request,
+// the Content-Type header of the response and the file at path. path is empty for
+// content that is not saved as a single file, such as downloads to a Writer or
+// split into parts.
+type MimeDetector interface {
+	DetectMimeType(req DownloadRequest, contentType, path string) string
+}
+
+// MimeDetectorFunc adapts a function to the MimeDetector interface.
+type MimeDetectorFunc func(req DownloadRequest, contentType, path string) string
+
+func (fn MimeDetectorFunc) DetectMimeType(req DownloadRequest, contentType, path string) string {
+	return fn(req, contentType, path)
+}
+
+// HeaderMimeDetector is the default strategy: the Content-Type header unless it is
+// missing or generic, then the request's MimeType, the file extension and finally
+// the file's content.
+var HeaderMimeDetector MimeDetector = MimeDetectorFunc(determineMimeType)
+
+// SniffMimeDetector trusts the file's magic bytes over the Content-Type header, for
+// servers that send text/plain or similar for binaries. Where the content gives no
+// more than a generic type (plain text or unknown binary data) it falls back to
+// HeaderMimeDetector.
+var SniffMimeDetector MimeDetector = MimeDetectorFunc(func(req DownloadRequest, contentType, path string) string {
+	sniffed, _, err := mime.ParseMediaType(sniffMimeType(path))
+	if err != nil || sniffed == "application/octet-stream" || sniffed == "text/plain" {
+		return determineMimeType(req, contentType, path)
+	}
+	return sniffed
+})
+
+// WithMimeDetector replaces the strategy that sets DownloadResult.MimeType,
+// e.g. with SniffMimeDetector.
+func WithMimeDetector(d MimeDetector) FetcherOption {
+	return func(f *Fetcher) {
+		f.mimeDetector = d
+	}
+}
+
+// detectMimeType determines the MIME type of a downloaded file with the configured detector.
+func (f *Fetcher) detectMimeType(req DownloadRequest, contentType, path string) string {
+	if f.mimeDetector != nil {
+		return f.mimeDetector.DetectMimeType(req, contentType, path)
+	}
+	return determineMimeType(req, contentType, path)
+}
@@ -0,0 +1,42 @@
+package dlfetch
+
+import (
+	"errors"
+)
+
+// attemptMirrors downloads the request from its URL and, if that fails because of
+// the server, the connection or corrupt content, from each of its mirrors in turn
+// until one succeeds.
+func (f *Fetcher) attemptMirrors(req DownloadRequest) (DownloadResult, error) {
+	result, err := f.attempt(req)
+	for _, mirror := range req.Mirrors {
+		if err == nil || req.context().Err() != nil || !isServerError(err) {
+			break
+		}
+		// The mismatch of a stream target can only be reported, the data is out
+		if errors.As(err, new(streamWrittenError)) {
+			break
+		}
+		// The path was released when the attempt failed
+		if f.claimPath(req.FullPath) != nil {
+			break
+		}
+		f.monitor.markAsPending(req.ID)
+
+		m := req
+		m.URL = mirror
+		m.URLProvider = nil
+		m.mirrorOf = req.URL
+		result, err = f.attempt(m)
+	}
+	return result, err
+}
+
+// isServerError reports whether err is down to the server or the connection to it,
+// so that another server could succeed. Content failing its checksum or digest
+// counts, the server may hold a corrupt or outdated copy.
+func isServerError(err error) bool {
+	var statusErr *HTTPStatusError
+	return errors.As(err, &statusErr) || isCongestionError(err) ||
+		errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrDigestMismatch)
+}
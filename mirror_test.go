@@ -0,0 +1,58 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMirrorFailover(t *testing.T) {
+	content := []byte("the content")
+	sum := md5.Sum(content)
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		w.Write([]byte("the corrupt"))
+	}))
+	defer corrupt.Close()
+	var mirrorHits atomic.Int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits.Add(1)
+		w.Write(content)
+	}))
+	defer mirror.Close()
+
+	t.Run("file", func(t *testing.T) {
+		mirrorHits.Store(0)
+		dir := t.TempDir()
+		f := New(WithTargetDir(dir))
+		_, err := f.Download(context.Background(), DownloadRequest{ID: 1, URL: corrupt.URL, Mirrors: []string{mirror.URL}, FileName: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "x")); !bytes.Equal(b, content) || mirrorHits.Load() != 1 {
+			t.Errorf("file has %q, mirror hit %d times", b, mirrorHits.Load())
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		mirrorHits.Store(0)
+		var out bytes.Buffer
+		f := New(WithTargetDir(t.TempDir()))
+		_, err := f.Download(context.Background(), DownloadRequest{ID: 1, URL: corrupt.URL, Mirrors: []string{mirror.URL}, Writer: &out})
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("error %v, want %v", err, ErrDigestMismatch)
+		}
+		// Failing over would append the mirror's copy to the corrupt one
+		if out.String() != "the corrupt" || mirrorHits.Load() != 0 {
+			t.Errorf("stream has %q, mirror hit %d times", out.String(), mirrorHits.Load())
+		}
+	})
+}
@@ -1,17 +1,29 @@
 package dlfetch
 
 import (
+	"fmt"
+	"math"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 )
 
 type Monitor interface {
-	add(DownloadRequest)
-	update(id int, done, total int64, ds float64, eta string)
+	add(DownloadRequest) error
+	remove(id int)
+	update(id int, done, total int64, ds float64, eta time.Duration)
+	setTotal(id int, total int64)
+	updateNetwork(id int, received, total int64)
+	setName(name string)
+	open()
 	close()
 	markAsCompleted(id int)
 	markAsFailed(id int, err error)
+	markAsCancelled(id int, err error)
+	markAsPaused(id int)
+	markAsPending(id int)
+	setQueued(id int, queued bool)
 	GetSnapshot() MonitorSnapshot
 	EventSignal() <-chan struct{}
 }
@@ -20,19 +32,72 @@ type TaskMonitor struct {
 	mu          sync.RWMutex
 	tasks       map[int]*DownloadTask
 	eventSignal chan struct{}
+	closed      bool
+	name        string         // Name of the Fetcher using the monitor
+	queued      map[int]uint64 // Tasks waiting in the queue, by ID, with the order they were queued in
+	queueSeq    uint64
+	tombstones  []Tombstone // Finished tasks removed by Clear, nil unless kept
+	keepCleared bool        // Keep tombstones of cleared tasks, see WithTombstones
+	formatter   Formatter   // Formats ETAs and errors, see WithFormatter
+}
+
+// MonitorOption configures a TaskMonitor.
+type MonitorOption func(*TaskMonitor)
+
+// WithTombstones keeps a Tombstone of every task removed by Clear, so the counts of
+// snapshots keep including them, e.g. for dashboards tracking long-run totals.
+func WithTombstones() MonitorOption {
+	return func(m *TaskMonitor) {
+		m.keepCleared = true
+	}
 }
 
 // Creates a TaskMonitor
-func NewMonitor() *TaskMonitor {
-	return &TaskMonitor{
+func NewMonitor(opts ...MonitorOption) *TaskMonitor {
+	m := &TaskMonitor{
 		tasks:       make(map[int]*DownloadTask),
 		eventSignal: make(chan struct{}, 1),
+		queued:      make(map[int]uint64),
+		formatter:   DefaultFormatter{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Clear removes the completed, failed and cancelled tasks that finished before
+// the given time, so a long-running monitor does not grow without bounds, and
+// returns how many it removed. With WithTombstones they leave a Tombstone behind.
+func (m *TaskMonitor) Clear(before time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for id, t := range m.tasks {
+		if t.CompletedAt == nil || !t.CompletedAt.Before(before) {
+			continue
+		}
+		if !t.Status.finished() {
+			continue
+		}
+		if m.keepCleared {
+			m.tombstones = append(m.tombstones, Tombstone{ID: id, Status: t.Status, CompletedAt: *t.CompletedAt})
+		}
+		delete(m.tasks, id)
+		removed++
 	}
+	if removed > 0 {
+		m.signalEvent()
+	}
+	return removed
 }
 
 // EventSignal returns a read-only channel that signals
 // whenever the TaskMonitor's state changes.
+// After the Fetcher is stopped and started again, a new channel is returned.
 func (m *TaskMonitor) EventSignal() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.eventSignal
 }
 
@@ -40,7 +105,11 @@ func (m *TaskMonitor) EventSignal() <-chan struct{} {
 // to notify listeners that the TaskMonitor has changed.
 // If the channel already has a pending signal, it does nothing
 // to avoid blocking or sending duplicate notifications.
+// Nothing is sent once the monitor has been closed.
 func (m *TaskMonitor) signalEvent() {
+	if m.closed {
+		return
+	}
 	select {
 	case m.eventSignal <- struct{}{}:
 	default:
@@ -51,18 +120,39 @@ func (m *TaskMonitor) close() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	select {
-	case <-m.eventSignal:
-		// already closed or drained
-	default:
-		close(m.eventSignal)
+	if m.closed {
+		return
 	}
+	close(m.eventSignal)
+	m.closed = true
 }
 
-// Add downloadRequest to track its progress
-func (m *TaskMonitor) add(req DownloadRequest) {
+// open re-creates the event signal after close, so a restarted
+// Fetcher keeps reporting changes.
+func (m *TaskMonitor) open() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	if !m.closed {
+		return
+	}
+	m.eventSignal = make(chan struct{}, 1)
+	m.closed = false
+}
+
+// Add downloadRequest to track its progress, replacing a finished task with the same ID
+// Returns ErrDuplicateID if an unfinished task with the same ID is tracked, e.g. by another Fetcher
+func (m *TaskMonitor) add(req DownloadRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[req.ID]; ok {
+		if !t.Status.finished() {
+			return fmt.Errorf("%w: %d", ErrDuplicateID, req.ID)
+		}
+		if m.keepCleared && t.CompletedAt != nil {
+			m.tombstones = append(m.tombstones, Tombstone{ID: t.ID, Status: t.Status, CompletedAt: *t.CompletedAt})
+		}
+	}
 	m.tasks[req.ID] = &DownloadTask{
 		ID:         req.ID,
 		FileName:   req.FileName,
@@ -70,11 +160,23 @@ func (m *TaskMonitor) add(req DownloadRequest) {
 		Status:     StatusPending,
 		EnqueuedAt: time.Now(),
 	}
+	m.queueSeq++
+	m.queued[req.ID] = m.queueSeq
+	m.signalEvent()
+	return nil
+}
+
+// Remove stops tracking a task, used when a request could not be queued after all
+func (m *TaskMonitor) remove(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tasks, id)
+	delete(m.queued, id)
 	m.signalEvent()
 }
 
 // Update the progress and status of a download task
-func (m *TaskMonitor) update(id int, done int64, total int64, ds float64, eta string) {
+func (m *TaskMonitor) update(id int, done int64, total int64, ds float64, eta time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if t, ok := m.tasks[id]; ok {
@@ -87,7 +189,27 @@ func (m *TaskMonitor) update(id int, done int64, total int64, ds float64, eta st
 		t.DoneBytes = done
 		t.TotalBytes = total
 		t.DownloadSpeed = ds
-		t.ETA = eta
+		t.ETA = m.formatter.FormatETA(eta)
+	}
+	m.signalEvent()
+}
+
+// Set the compressed bytes received for a task, see WithContentDecoders
+func (m *TaskMonitor) updateNetwork(id int, received, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[id]; ok {
+		t.NetworkBytes = received
+		t.NetworkTotal = max(total, 0)
+	}
+}
+
+// Set the size of a task that has not started yet, e.g. from a probe
+func (m *TaskMonitor) setTotal(id int, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[id]; ok && t.Status == StatusPending && t.StartedAt.IsZero() {
+		t.TotalBytes = total
 	}
 	m.signalEvent()
 }
@@ -96,12 +218,17 @@ func (m *TaskMonitor) update(id int, done int64, total int64, ds float64, eta st
 func (m *TaskMonitor) markAsCompleted(id int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	delete(m.queued, id)
 	if t, ok := m.tasks[id]; ok {
 		if t.TotalBytes > 0 {
 			t.DoneBytes = t.TotalBytes
 		}
 		t.Status = StatusCompleted
 		now := time.Now()
+		if t.StartedAt.IsZero() {
+			// Nothing was transferred, e.g. an empty or skipped file
+			t.StartedAt = now
+		}
 		t.CompletedAt = &now
 	}
 	m.signalEvent()
@@ -111,19 +238,85 @@ func (m *TaskMonitor) markAsCompleted(id int) {
 func (m *TaskMonitor) markAsFailed(id int, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	delete(m.queued, id)
 	if t, ok := m.tasks[id]; ok {
 		t.Status = StatusFailed
-		t.Error = err.Error()
+		t.Error = m.formatter.FormatError(err)
+		now := time.Now()
+		t.CompletedAt = &now
+	}
+	m.signalEvent()
+}
+
+// Mark task as cancelled by the caller
+func (m *TaskMonitor) markAsCancelled(id int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.queued, id)
+	if t, ok := m.tasks[id]; ok {
+		t.Status = StatusCancelled
+		t.Error = m.formatter.FormatError(err)
+		now := time.Now()
+		t.CompletedAt = &now
+	}
+	m.signalEvent()
+}
+
+// Mark task as paused, keeping its progress
+func (m *TaskMonitor) markAsPaused(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.queued, id)
+	if t, ok := m.tasks[id]; ok {
+		t.Status = StatusPaused
+		t.DownloadSpeed = 0
+		t.ETA = ""
+	}
+	m.signalEvent()
+}
+
+// Mark a resumed or retried task as waiting to start again
+func (m *TaskMonitor) markAsPending(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[id]; ok {
+		t.Status = StatusPending
+		t.EnqueuedAt = time.Now()
+		t.StartedAt = time.Time{}
+		t.CompletedAt = nil
+		t.Error = ""
+	}
+	m.signalEvent()
+}
+
+// Mark a task as waiting in the queue, or as taken from it by a worker
+func (m *TaskMonitor) setQueued(id int, queued bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tasks[id]; !ok {
+		return
+	}
+	if queued {
+		m.queueSeq++
+		m.queued[id] = m.queueSeq
+	} else {
+		delete(m.queued, id)
 	}
 	m.signalEvent()
 }
 
+func (m *TaskMonitor) setName(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.name = name
+}
+
 // GetSnapshot returns a copy of the current state of all download
 func (m *TaskMonitor) GetSnapshot() MonitorSnapshot {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	snapshot := MonitorSnapshot{}
+	snapshot := MonitorSnapshot{Fetcher: m.name}
 
 	var pendingTasks []pendingTask
 
@@ -133,22 +326,41 @@ func (m *TaskMonitor) GetSnapshot() MonitorSnapshot {
 		switch t.Status {
 		case StatusPending:
 			snapshot.Count.Pending++
-			pendingTasks = append(pendingTasks, pendingTask{
-				id:         t.ID,
-				enqueuedAt: t.EnqueuedAt,
-			})
+			if seq, ok := m.queued[t.ID]; ok {
+				pendingTasks = append(pendingTasks, pendingTask{id: t.ID, seq: seq})
+			}
 		case StatusCompleted:
 			snapshot.Count.Completed++
 		case StatusFailed:
 			snapshot.Count.Failed++
+		case StatusCancelled:
+			snapshot.Count.Cancelled++
+		case StatusPaused:
+			snapshot.Count.Paused++
 		case StatusInProgress:
 			snapshot.Count.InProgress++
 		}
 	}
 
-	// Sort pending tasks by enqueue time (FIFO order)
+	// Cleared tasks still count, but are not listed
+	for _, t := range m.tombstones {
+		snapshot.Count.Total++
+		snapshot.Count.Cleared++
+		switch t.Status {
+		case StatusCompleted:
+			snapshot.Count.Completed++
+		case StatusFailed:
+			snapshot.Count.Failed++
+		case StatusCancelled:
+			snapshot.Count.Cancelled++
+		}
+	}
+	snapshot.Tombstones = slices.Clone(m.tombstones)
+
+	// Sort queued tasks in the order they were queued (FIFO order)
+	// Tasks taken by a worker but waiting for a slot or a retry are not in the queue anymore
 	sort.Slice(pendingTasks, func(i, j int) bool {
-		return pendingTasks[i].enqueuedAt.Before(pendingTasks[j].enqueuedAt)
+		return pendingTasks[i].seq < pendingTasks[j].seq
 	})
 
 	queuePositions := make(map[int]int)
@@ -167,42 +379,77 @@ func (m *TaskMonitor) GetSnapshot() MonitorSnapshot {
 	return snapshot
 }
 
+const (
+	// speedSampleInterval is how often monitorWriter takes a speed sample.
+	speedSampleInterval = 250 * time.Millisecond
+	// speedHalfLife is after how long a speed sample counts half as much in the
+	// average, so the speed follows changes without jumping on every hiccup.
+	speedHalfLife = 3 * time.Second
+)
+
 // Monitor Writer
 // This is a custom writer that reports progress to the monitor
+// The speed is an exponentially weighted moving average of samples taken
+// every speedSampleInterval, and the ETA is derived from it
 type monitorWriter struct {
-	id        int
-	total     int64
-	written   int64
-	monitor   Monitor
-	startTime time.Time
+	id      int
+	total   int64
+	written int64
+	monitor Monitor
+	wire    *wireCounter // Compressed bytes received, the speed follows them if set
+
+	sampledAt time.Time // Time of the last speed sample, zero before the first write
+	sampled   int64     // progress at sampledAt
+	speed     float64   // Average speed in bytes per second, 0 until the first sample
 }
 
 func (mw *monitorWriter) Write(p []byte) (int, error) {
 	n := len(p)
+	now := time.Now()
 	mw.written += int64(n)
 
-	// Set startTime, when we start the download
-	if mw.startTime.IsZero() {
-		mw.startTime = time.Now()
+	// The speed and ETA of a compressed response follow the network, not the file
+	progress, total := mw.written, mw.total
+	if mw.wire != nil {
+		progress, total = mw.wire.received.Load(), mw.wire.total.Load()
 	}
 
-	elapsed := time.Since(mw.startTime).Seconds()
-	speedBPS := float64(mw.written) / elapsed
+	// Bytes already on disk when a download resumes do not count towards the speed
+	if mw.sampledAt.IsZero() {
+		mw.sampledAt = now
+		mw.sampled = mw.written - int64(n)
+		if mw.wire != nil {
+			mw.sampled = 0 // Counts the current response only
+		}
+	}
 
-	var eta string
-	if mw.total > 0 {
-		remainingBytes := mw.total - mw.written
-		if speedBPS > 0 {
-			etaSec := float64(remainingBytes) / speedBPS
-			eta = time.Duration(etaSec * float64(time.Second)).Truncate(time.Second).String()
+	if elapsed := now.Sub(mw.sampledAt); elapsed >= speedSampleInterval {
+		rate := float64(progress-mw.sampled) / elapsed.Seconds()
+		if mw.speed == 0 {
+			mw.speed = rate
 		} else {
-			eta = "calculating..."
+			weight := 1 - math.Exp2(-elapsed.Seconds()/speedHalfLife.Seconds())
+			mw.speed += weight * (rate - mw.speed)
 		}
-	} else {
-		eta = "unknown"
+		mw.sampledAt = now
+		mw.sampled = progress
+	}
+
+	var eta time.Duration
+	switch {
+	case total <= 0:
+		eta = ETAUnknown
+	case mw.speed > 0:
+		remaining := float64(max(0, total-progress)) / mw.speed
+		eta = time.Duration(remaining * float64(time.Second))
+	default:
+		eta = ETACalculating
 	}
 
-	mw.monitor.update(mw.id, mw.written, mw.total, speedBPS, eta)
+	if mw.wire != nil {
+		mw.monitor.updateNetwork(mw.id, progress, total)
+	}
+	mw.monitor.update(mw.id, mw.written, mw.total, mw.speed, eta)
 	return n, nil
 }
 
@@ -211,10 +458,19 @@ func (mw *monitorWriter) Write(p []byte) (int, error) {
 
 type noopMonitor struct{}
 
-func (n *noopMonitor) add(DownloadRequest)                       {}
-func (n *noopMonitor) update(int, int64, int64, float64, string) {}
-func (n *noopMonitor) close()                                    {}
-func (n *noopMonitor) markAsCompleted(int)                       {}
-func (n *noopMonitor) markAsFailed(int, error)                   {}
-func (n *noopMonitor) GetSnapshot() MonitorSnapshot              { return MonitorSnapshot{} }
-func (n *noopMonitor) EventSignal() <-chan struct{}              { return nil }
+func (n *noopMonitor) add(DownloadRequest) error                        { return nil }
+func (n *noopMonitor) remove(int)                                       {}
+func (n *noopMonitor) update(int, int64, int64, float64, time.Duration) {}
+func (n *noopMonitor) setTotal(int, int64)                              {}
+func (n *noopMonitor) updateNetwork(int, int64, int64)                  {}
+func (n *noopMonitor) setName(string)                                   {}
+func (n *noopMonitor) open()                                            {}
+func (n *noopMonitor) close()                                           {}
+func (n *noopMonitor) markAsCompleted(int)                              {}
+func (n *noopMonitor) markAsFailed(int, error)                          {}
+func (n *noopMonitor) markAsCancelled(int, error)                       {}
+func (n *noopMonitor) markAsPaused(int)                                 {}
+func (n *noopMonitor) markAsPending(int)                                {}
+func (n *noopMonitor) setQueued(int, bool)                              {}
+func (n *noopMonitor) GetSnapshot() MonitorSnapshot                     { return MonitorSnapshot{} }
+func (n *noopMonitor) EventSignal() <-chan struct{}                     { return nil }
@@ -0,0 +1,57 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	for name, monitor := range map[string]Monitor{"no monitor": nil, "task monitor": NewMonitor()} {
+		t.Run(name, func(t *testing.T) {
+			options := []FetcherOption{WithTargetDir(t.TempDir()), WithEnableOverwrite(true)}
+			if monitor != nil {
+				options = append(options, WithMonitor(monitor))
+			}
+			var f *Fetcher
+			requeued := make(chan EnqueueResult, 1)
+			options = append(options, WithOnComplete(func(r DownloadResult) {
+				if r.ID == 1 && r.FileName == "a" {
+					// The ID is free once its download finished
+					requeued <- f.Enqueue(DownloadRequest{ID: 1, URL: srv.URL, FileName: "b"})
+				}
+			}))
+			f = New(options...)
+
+			if res := f.Enqueue(DownloadRequest{ID: 1, URL: srv.URL, FileName: "a"}); res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if res := f.Enqueue(DownloadRequest{ID: 1, URL: srv.URL, FileName: "c"}); !errors.Is(res.Error, ErrDuplicateID) {
+				t.Errorf("enqueue of a queued ID: %v", res.Error)
+			}
+			if _, err := f.Download(context.Background(), DownloadRequest{ID: 1, URL: srv.URL, FileName: "c"}); !errors.Is(err, ErrDuplicateID) {
+				t.Errorf("download with a queued ID: %v", err)
+			}
+
+			f.Start()
+			defer f.Stop()
+			if res := <-requeued; res.Error != nil {
+				t.Errorf("enqueue from the callback: %v", res.Error)
+			}
+			waitTimeout(t, f)
+
+			for range 2 {
+				if _, err := f.Download(context.Background(), DownloadRequest{URL: srv.URL, FileName: "d"}); err != nil {
+					t.Errorf("download without an ID: %v", err)
+				}
+			}
+		})
+	}
+}
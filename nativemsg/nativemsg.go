@@ -0,0 +1,276 @@
+// Package nativemsg lets a browser extension hand downloads off to a
+// dlfetch.Fetcher over native messaging, the stdin/stdout protocol of Chrome and
+// Firefox, and reports their progress back to the browser.
+//
+// The browser starts the native messaging host registered in a host manifest and
+// talks to it as long as the extension keeps its port open. A host is a small
+// program that runs the Fetcher and calls Serve:
+//
+//	monitor := dlfetch.NewMonitor()
+//	f := dlfetch.New(dlfetch.WithMonitor(monitor), dlfetch.WithTargetDir(downloads))
+//	f.Start()
+//	err := nativemsg.Serve(ctx, f, monitor, os.Stdin, os.Stdout, nativemsg.Options{})
+//	f.Drain()
+//
+// Every message is a JSON object, see Message, prefixed with its length as a
+// 32-bit integer in native byte order. The extension sends "download", "cancel",
+// "pause", "resume" and "list" messages; the host answers each with "ok" or
+// "error", and sends "progress" messages for the downloads it accepted until they
+// completed, failed or were cancelled.
+package nativemsg
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+	"github.com/hritikr/dlfetch/internal/remote"
+)
+
+// maxMessageSize is the size limit of messages from the browser, which Chrome
+// sets to 64 MiB.
+const maxMessageSize = 64 << 20
+
+// defaultInterval is how often progress is reported by default.
+const defaultInterval = 500 * time.Millisecond
+
+// Message is a message exchanged with the extension. Its Type selects which of
+// the other fields are used.
+type Message struct {
+	Type string `json:"type"`
+	ID   int    `json:"id,omitempty"` // Download the message is about, chosen by the extension
+
+	// Set by the extension on "download"
+	URL      string            `json:"url,omitempty"`
+	FileName string            `json:"fileName,omitempty"` // Relative to the target directory, empty to name the file after the server's answer
+	Referrer string            `json:"referrer,omitempty"` // Sent as Referer header
+	Headers  map[string]string `json:"headers,omitempty"`  // e.g. the Cookie or Authorization header of the page
+
+	// Set by the host on "progress"
+	Status     dlfetch.DownloadStatus `json:"status,omitempty"`
+	DoneBytes  int64                  `json:"doneBytes,omitempty"`
+	TotalBytes int64                  `json:"totalBytes,omitempty"`
+	Speed      float64                `json:"speed,omitempty"` // Bytes per second
+	ETA        string                 `json:"eta,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+
+	// Set by the host on "error" and on "progress" of failed downloads
+	Error string `json:"error,omitempty"`
+
+	// Set by the host in the answer to "list"
+	Tasks []dlfetch.DownloadTask `json:"tasks,omitempty"`
+}
+
+// Options configures Serve.
+type Options struct {
+	// Interval is how often the progress of running downloads is reported,
+	// 0 for twice a second.
+	Interval time.Duration
+
+	// Prepare, if set, adjusts the requests of the extension before they are
+	// enqueued, e.g. to set their Path or Preset. An error rejects the request.
+	Prepare func(req *dlfetch.DownloadRequest) error
+}
+
+// Serve reads the messages of the extension from r and answers on w until the
+// browser closes the connection, which returns nil, or ctx is done. Downloads go
+// through f and their progress is read from m, which has to be the monitor of f.
+// Downloads still running when Serve returns continue, so the caller can wait for
+// them with Drain.
+func Serve(ctx context.Context, f *dlfetch.Fetcher, m dlfetch.Monitor, r io.Reader, w io.Writer, opts Options) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	s := &server{f: f, m: m, w: bufio.NewWriter(w), opts: opts, reported: make(map[int]dlfetch.DownloadTask)}
+
+	messages := make(chan Message)
+	readErr := make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			msg, err := ReadMessage(br)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-messages:
+			if err := s.handle(msg); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := s.reportProgress(); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				// The browser closed the port, tell it nothing more
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ReadMessage reads one length-prefixed message.
+func ReadMessage(r io.Reader) (Message, error) {
+	var size uint32
+	if err := binary.Read(r, binary.NativeEndian, &size); err != nil {
+		return Message{}, err
+	}
+	if size > maxMessageSize {
+		return Message{}, fmt.Errorf("message of %d bytes exceeds the limit of %d", size, maxMessageSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Message{}, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, fmt.Errorf("invalid message: %w", err)
+	}
+	return msg, nil
+}
+
+// WriteMessage writes one length-prefixed message.
+func WriteMessage(w io.Writer, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+type server struct {
+	f        *dlfetch.Fetcher
+	m        dlfetch.Monitor
+	w        *bufio.Writer
+	opts     Options
+	reported map[int]dlfetch.DownloadTask // Last reported state of the downloads of the extension
+}
+
+// send writes msg and flushes it, the browser reads messages as they come.
+func (s *server) send(msg Message) error {
+	if err := WriteMessage(s.w, msg); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// handle carries out a message of the extension and answers it.
+func (s *server) handle(msg Message) error {
+	var err error
+	switch msg.Type {
+	case "download":
+		err = s.download(msg)
+	case "cancel":
+		err = s.f.Cancel(msg.ID)
+	case "pause":
+		err = s.f.Pause(msg.ID)
+	case "resume":
+		err = s.f.Resume(msg.ID)
+	case "list":
+		return s.send(Message{Type: "list", Tasks: s.m.GetSnapshot().Tasks})
+	default:
+		err = fmt.Errorf("unknown message type %q", msg.Type)
+	}
+	if err != nil {
+		return s.send(Message{Type: "error", ID: msg.ID, Error: err.Error()})
+	}
+	return s.send(Message{Type: "ok", ID: msg.ID})
+}
+
+func (s *server) download(msg Message) error {
+	req := dlfetch.DownloadRequest{ID: msg.ID, URL: msg.URL, FileName: msg.FileName, Headers: msg.Headers}
+	if msg.Referrer != "" {
+		if req.Headers == nil {
+			req.Headers = make(map[string]string)
+		}
+		req.Headers["Referer"] = msg.Referrer
+	}
+	if err := remote.CheckRequest(req); err != nil {
+		return err
+	}
+	if s.opts.Prepare != nil {
+		if err := s.opts.Prepare(&req); err != nil {
+			return err
+		}
+	}
+	result := s.f.Enqueue(req)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.Skipped {
+		return errors.New("download skipped")
+	}
+	s.reported[req.ID] = dlfetch.DownloadTask{}
+	return nil
+}
+
+// reportProgress sends the downloads of the extension that changed since they
+// were last reported, and stops following those that are done.
+func (s *server) reportProgress() error {
+	if len(s.reported) == 0 {
+		return nil
+	}
+	tasks := make(map[int]dlfetch.DownloadTask)
+	for _, task := range s.m.GetSnapshot().Tasks {
+		tasks[task.ID] = task
+	}
+	for id, last := range s.reported {
+		task, ok := tasks[id]
+		if !ok {
+			// Removed from the monitor, e.g. by Clear
+			delete(s.reported, id)
+			continue
+		}
+		if task.Status == last.Status && task.DoneBytes == last.DoneBytes && task.TotalBytes == last.TotalBytes {
+			continue
+		}
+		err := s.send(Message{
+			Type:       "progress",
+			ID:         id,
+			Status:     task.Status,
+			DoneBytes:  task.DoneBytes,
+			TotalBytes: task.TotalBytes,
+			Speed:      task.DownloadSpeed,
+			ETA:        task.ETA,
+			Path:       task.FilePath,
+			Error:      task.Error,
+		})
+		if err != nil {
+			return err
+		}
+		switch task.Status {
+		case dlfetch.StatusCompleted, dlfetch.StatusFailed, dlfetch.StatusCancelled:
+			delete(s.reported, id)
+		default:
+			s.reported[id] = task
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package nativemsg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+func TestMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	sent := Message{Type: "download", ID: 7, URL: "http://127.0.0.1:1/x", Headers: map[string]string{"Cookie": "a=b"}}
+	if err := WriteMessage(&buf, sent); err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.NativeEndian.Uint32(buf.Bytes()); int(size) != buf.Len()-4 {
+		t.Errorf("length prefix %d for %d bytes", size, buf.Len()-4)
+	}
+	got, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("read %+v, want %+v", got, sent)
+	}
+
+	frame := func(size uint32, body string) io.Reader {
+		return bytes.NewReader(append(binary.NativeEndian.AppendUint32(nil, size), body...))
+	}
+	tests := []struct {
+		name string
+		r    io.Reader
+		err  string
+	}{
+		{"closed", bytes.NewReader(nil), io.EOF.Error()},
+		{"short prefix", bytes.NewReader([]byte{1, 0}), io.ErrUnexpectedEOF.Error()},
+		{"too large", frame(maxMessageSize+1, ""), "exceeds the limit"},
+		{"truncated", frame(10, `{"type"`), io.ErrUnexpectedEOF.Error()},
+		{"not json", frame(3, "abc"), "invalid message"},
+	}
+	for _, tt := range tests {
+		if _, err := ReadMessage(tt.r); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	var in, out bytes.Buffer
+	for _, msg := range []Message{
+		{Type: "download", ID: 1, URL: "http://127.0.0.1:1/x", FileName: "x.bin"},
+		{Type: "download", ID: 2, URL: "http://127.0.0.1:1/x", FileName: "../x.bin"},
+		{Type: "pause", ID: 1},
+		{Type: "list"},
+		{Type: "shutdown"},
+	} {
+		if err := WriteMessage(&in, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	monitor := dlfetch.NewMonitor()
+	f := dlfetch.New(dlfetch.WithTargetDir(t.TempDir()), dlfetch.WithMonitor(monitor))
+	// The browser closing the port ends Serve without an error
+	if err := Serve(context.Background(), f, monitor, &in, &out, Options{Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	var answers []string
+	for {
+		msg, err := ReadMessage(&out)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		answers = append(answers, msg.Type)
+		if msg.Type == "list" && (len(msg.Tasks) != 1 || msg.Tasks[0].ID != 1) {
+			t.Errorf("listed %+v", msg.Tasks)
+		}
+	}
+	if want := []string{"ok", "error", "ok", "list", "error"}; !reflect.DeepEqual(answers, want) {
+		t.Errorf("answered %v, want %v", answers, want)
+	}
+}
+
+func TestDownloadPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		ok       bool
+	}{
+		{"plain", "x.bin", true},
+		{"subdirectory", "a/b/x.bin", true},
+		{"server name", "", true},
+		{"dot dot", "../x.bin", false},
+		{"dot dot below", "a/../x.bin", true},
+		{"stdout", dlfetch.StdoutFileName, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := dlfetch.NewMonitor()
+			s := &server{
+				f:        dlfetch.New(dlfetch.WithTargetDir(t.TempDir()), dlfetch.WithMonitor(monitor)),
+				m:        monitor,
+				w:        bufio.NewWriter(new(bytes.Buffer)),
+				reported: make(map[int]dlfetch.DownloadTask),
+			}
+			err := s.download(Message{Type: "download", ID: 1, URL: "http://127.0.0.1:1/x", FileName: tt.fileName})
+			if (err == nil) != tt.ok {
+				t.Fatalf("download of %q: %v", tt.fileName, err)
+			}
+			if _, reported := s.reported[1]; reported != tt.ok {
+				t.Errorf("following the download: %v", reported)
+			}
+		})
+	}
+
+	s := &server{f: dlfetch.New(), m: dlfetch.NewMonitor(), reported: make(map[int]dlfetch.DownloadTask)}
+	if err := s.download(Message{Type: "download", ID: 1}); err == nil {
+		t.Error("download without a URL succeeded")
+	}
+}
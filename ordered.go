@@ -0,0 +1,85 @@
+package dlfetch
+
+import "sync"
+
+// completionOrderer buffers completion callbacks and runs them
+// strictly in sequence order.
+type completionOrderer struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]func()
+	skipped map[uint64]bool // Sequence numbers passed over, see skip
+	ready   []func()        // Unblocked callbacks, in order
+	running bool            // A caller of deliver or skip is running ready
+}
+
+func newCompletionOrderer() *completionOrderer {
+	return &completionOrderer{
+		pending: make(map[uint64]func()),
+		skipped: make(map[uint64]bool),
+	}
+}
+
+// deliver registers the callback for the given sequence number and runs
+// every callback that is now unblocked. The callback of a skipped number is
+// not held back. Callbacks run one at a time and in order, outside of the
+// lock, so they may enqueue requests in turn.
+func (o *completionOrderer) deliver(seq uint64, fn func()) {
+	o.mu.Lock()
+	if seq < o.next {
+		o.ready = append(o.ready, fn)
+	} else {
+		o.pending[seq] = fn
+		o.advance()
+	}
+	o.run()
+}
+
+// skip stops the sequence number from holding back later callbacks, e.g. for a
+// request that was paused or never queued. A callback delivered for it later
+// runs as soon as possible.
+func (o *completionOrderer) skip(seq uint64) {
+	o.mu.Lock()
+	if seq >= o.next {
+		o.skipped[seq] = true
+		o.advance()
+	}
+	o.run()
+}
+
+// advance moves the callbacks that are no longer blocked to ready. Must be
+// called with mu held.
+func (o *completionOrderer) advance() {
+	for {
+		if fn, ok := o.pending[o.next]; ok {
+			delete(o.pending, o.next)
+			o.ready = append(o.ready, fn)
+		} else if o.skipped[o.next] {
+			delete(o.skipped, o.next)
+		} else {
+			return
+		}
+		o.next++
+	}
+}
+
+// run runs the ready callbacks and unlocks mu, which must be held. If another
+// caller is already running them, it picks up the new ones as well.
+func (o *completionOrderer) run() {
+	if o.running {
+		o.mu.Unlock()
+		return
+	}
+	o.running = true
+	for len(o.ready) > 0 {
+		ready := o.ready
+		o.ready = nil
+		o.mu.Unlock()
+		for _, fn := range ready {
+			fn()
+		}
+		o.mu.Lock()
+	}
+	o.running = false
+	o.mu.Unlock()
+}
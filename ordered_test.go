@@ -0,0 +1,77 @@
+package dlfetch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompletionOrderer(t *testing.T) {
+	o := newCompletionOrderer()
+	var got []int
+	record := func(n int) func() { return func() { got = append(got, n) } }
+
+	o.deliver(2, record(2))
+	o.deliver(1, record(1))
+	if len(got) != 0 {
+		t.Fatalf("ran %v before 0", got)
+	}
+	o.deliver(0, func() {
+		got = append(got, 0)
+		// Callbacks may deliver in turn, e.g. by enqueuing a request that fails
+		o.skip(3)
+		o.deliver(4, record(4))
+	})
+	o.skip(5)
+	o.deliver(6, record(6))
+	// Reported after its number was skipped, e.g. a resumed request
+	o.deliver(5, record(5))
+	if want := []int{0, 1, 2, 4, 6, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+}
+
+func TestOrderedCompletionPaused(t *testing.T) {
+	slow := newSlowServer(t)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	completed := make(chan int, 2)
+	f := New(WithTargetDir(t.TempDir()), WithMaxWorkers(2), WithOrderedCompletion(),
+		WithOnComplete(func(r DownloadResult) { completed <- r.ID }))
+	if res := f.Enqueue(DownloadRequest{ID: 1, URL: slow.URL, FileName: "slow"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if err := f.Pause(1); err != nil {
+		t.Fatal(err)
+	}
+	if res := f.Enqueue(DownloadRequest{ID: 2, URL: fast.URL, FileName: "fast"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	f.Start()
+	defer f.Stop()
+
+	select {
+	case id := <-completed:
+		if id != 2 {
+			t.Fatalf("completed %d, want 2", id)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the paused request holds back the others")
+	}
+
+	for !f.isPausedID(1) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := f.Resume(1); err != nil {
+		t.Fatal(err)
+	}
+	waitTimeout(t, f)
+	if id := <-completed; id != 1 {
+		t.Errorf("completed %d, want 1", id)
+	}
+}
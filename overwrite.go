@@ -0,0 +1,150 @@
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OverwritePolicy decides what happens when the target file of a request already exists.
+type OverwritePolicy int
+
+const (
+	OverwriteError   OverwritePolicy = iota // Reject the request with ErrFileExists, the default
+	OverwriteSkip                           // Leave the file alone and report the request as skipped
+	OverwriteReplace                        // Replace the file
+	OverwriteRename                         // Save the download as "name (1).ext", "name (2).ext"...
+)
+
+var overwritePolicyNames = map[OverwritePolicy]string{
+	OverwriteError:   "error",
+	OverwriteSkip:    "skip",
+	OverwriteReplace: "overwrite",
+	OverwriteRename:  "rename",
+}
+
+func (p OverwritePolicy) String() string {
+	if name, ok := overwritePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ParseOverwritePolicy parses "error", "skip", "overwrite" or "rename".
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	for p, name := range overwritePolicyNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return OverwriteError, fmt.Errorf("unknown overwrite policy: %s", s)
+}
+
+// WithOverwritePolicy sets what happens when a file with the target name already
+// exists, whether at enqueue time or by the time the download finishes.
+// It replaces WithEnableOverwrite.
+func WithOverwritePolicy(p OverwritePolicy) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.overwrite = p
+	}
+}
+
+// maxNumberedPaths is how many numbered variants of a path OverwriteRename tries.
+const maxNumberedPaths = 1000
+
+// errSkipped signals that a request was not downloaded because of OverwriteSkip.
+var errSkipped = errors.New("target exists, skipped")
+
+// overwriteFor returns the overwrite policy that applies to the request.
+func (p policy) overwriteFor(req DownloadRequest) OverwritePolicy {
+	if req.overwrite {
+		return OverwriteReplace
+	}
+	return p.overwrite
+}
+
+// claimTarget claims the target path of the request like claimPath. With rename set,
+// a path that exists or is claimed by another request is replaced by the first free
+// numbered variant, and the request's FileName and FullPath are updated to match.
+func (f *Fetcher) claimTarget(req *DownloadRequest, rename bool) error {
+	if !rename || req.FullPath == "" {
+		return f.claimPath(req.FullPath)
+	}
+
+	f.pathsMu.Lock()
+	defer f.pathsMu.Unlock()
+
+	for n := range maxNumberedPaths {
+		path := numberedPath(req.FullPath, n)
+		if _, claimed := f.paths[path]; claimed {
+			continue
+		}
+		if exists, err := fileExists(path); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		f.paths[path] = struct{}{}
+		req.FullPath = path
+		req.FileName = filepath.Base(path)
+		return nil
+	}
+	return fmt.Errorf("%w: no free numbered variant of %s", ErrFileExists, req.FullPath)
+}
+
+// commitRenamed moves the staging file to the target path of the request or, if a file
+// appeared there in the meantime, to the first free numbered variant of it. The request's
+// FileName and FullPath are updated to the path used.
+func (f *Fetcher) commitRenamed(tmpPath string, req *DownloadRequest) error {
+	for n := range maxNumberedPaths {
+		path := numberedPath(req.FullPath, n)
+		if n > 0 {
+			if f.claimPath(path) != nil {
+				continue
+			}
+		}
+		err := tryCommit(tmpPath, path)
+		if n > 0 {
+			// Once the file is on disk it is protected by existing
+			f.releasePath(path)
+		}
+		switch {
+		case err == nil:
+			req.FullPath = path
+			req.FileName = filepath.Base(path)
+			return nil
+		case !errors.Is(err, ErrFileExists):
+			_ = os.Remove(tmpPath)
+			return err
+		}
+	}
+	_ = os.Remove(tmpPath)
+	return fmt.Errorf("%w: no free numbered variant of %s", ErrFileExists, req.FullPath)
+}
+
+// numberedPath returns path with " (n)" inserted before its extension, path itself for n = 0.
+func numberedPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// skippedResult describes the existing file of a request skipped because of OverwriteSkip.
+func (f *Fetcher) skippedResult(req DownloadRequest) DownloadResult {
+	result := DownloadResult{
+		ID:       req.ID,
+		FileName: req.FileName,
+		Path:     req.FullPath,
+		Vars:     req.Vars,
+		Skipped:  true,
+	}
+	if info, err := os.Stat(req.FullPath); err == nil {
+		result.Size = info.Size()
+	}
+	result.MimeType = f.detectMimeType(req, "", req.FullPath)
+	return result
+}
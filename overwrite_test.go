@@ -0,0 +1,59 @@
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameTarget(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"x.bin", "x (1).bin", "blocker"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := New(WithTargetDir(dir), WithOverwritePolicy(OverwriteRename))
+
+	res := f.Enqueue(DownloadRequest{ID: 1, URL: "http://127.0.0.1:1/x", FileName: "x.bin"})
+	if res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if _, claimed := f.paths[filepath.Join(dir, "x (2).bin")]; !claimed {
+		t.Errorf("claimed %v, want x (2).bin", f.paths)
+	}
+
+	// A file in place of a directory makes every variant fail to stat with ENOTDIR
+	res = f.Enqueue(DownloadRequest{ID: 2, URL: "http://127.0.0.1:1/x", Path: "blocker", FileName: "x.bin"})
+	if res.Error == nil || errors.Is(res.Error, ErrFileExists) {
+		t.Errorf("enqueue below a file: %v", res.Error)
+	}
+}
+
+func TestCommitRenamedGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "x.bin")
+	for n := range maxNumberedPaths {
+		if err := os.WriteFile(numberedPath(target, n), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(dir, "x.bin.tmp")
+	if err := os.WriteFile(tmp, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(WithTargetDir(dir))
+	req := DownloadRequest{FileName: "x.bin", FullPath: target}
+	if err := f.commitRenamed(tmp, &req); !errors.Is(err, ErrFileExists) {
+		t.Errorf("commit with every variant taken: %v", err)
+	}
+	if err := f.claimTarget(&req, true); !errors.Is(err, ErrFileExists) {
+		t.Errorf("claim with every variant taken: %v", err)
+	}
+	if len(f.paths) != 0 {
+		t.Errorf("paths left claimed: %s", fmt.Sprint(f.paths))
+	}
+}
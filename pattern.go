@@ -0,0 +1,250 @@
+package dlfetch
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxPatternURLs bounds how many URLs a pattern may expand to.
+const maxPatternURLs = 1_000_000
+
+// ExpandPattern expands a URL pattern into one request per URL, like curl's URL
+// globbing, for sharded datasets and numbered files:
+//
+//	{a,b,c}       one of the listed values
+//	{001..120}    a numeric range, zero padded when a bound is; {0..100..10} with a step
+//	{a..z}        a letter range
+//	[001-120]     curl's range syntax, [0-100:10] with a step, [a-z] for letters
+//
+// Patterns combine, the leftmost one varying slowest. A backslash escapes a brace
+// or bracket; brackets that do not hold a range, such as an IPv6 host, are kept.
+// The value each pattern took is stored in the request's Vars under its position,
+// "1" for the first, so FileName templates can use {{index .Vars "1"}}. Request
+// IDs are assigned from 1.
+func ExpandPattern(pattern string) ([]DownloadRequest, error) {
+	parts, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 1
+	for _, p := range parts {
+		if p.values != nil {
+			total *= len(p.values)
+			if total > maxPatternURLs {
+				return nil, fmt.Errorf("pattern %q expands to more than %d URLs", pattern, maxPatternURLs)
+			}
+		}
+	}
+
+	reqs := make([]DownloadRequest, 0, total)
+	for i := range total {
+		var b strings.Builder
+		var vars map[string]string
+		// Mixed radix with the rightmost pattern as the least significant digit
+		rest := i
+		choice := make([]int, len(parts))
+		for j := len(parts) - 1; j >= 0; j-- {
+			if n := len(parts[j].values); n > 0 {
+				choice[j] = rest % n
+				rest /= n
+			}
+		}
+		group := 0
+		for j, p := range parts {
+			if p.values == nil {
+				b.WriteString(p.literal)
+				continue
+			}
+			group++
+			value := p.values[choice[j]]
+			b.WriteString(value)
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			vars[strconv.Itoa(group)] = value
+		}
+		reqs = append(reqs, DownloadRequest{ID: i + 1, URL: b.String(), Vars: vars})
+	}
+	return reqs, nil
+}
+
+// patternPart is a literal piece of a pattern or the values of a glob.
+type patternPart struct {
+	literal string
+	values  []string
+}
+
+// parsePattern splits a pattern into literals and globs.
+func parsePattern(pattern string) ([]patternPart, error) {
+	var parts []patternPart
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, patternPart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern) && strings.IndexByte("{}[]", pattern[i+1]) >= 0:
+			i++
+			literal.WriteByte(pattern[i])
+
+		case c == '{' || c == '[':
+			closing := byte('}')
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(pattern[i+1:], closing)
+			if end < 0 {
+				if c == '{' {
+					return nil, fmt.Errorf("invalid pattern %q: unclosed {", pattern)
+				}
+				literal.WriteByte(c)
+				continue
+			}
+			body := pattern[i+1 : i+1+end]
+
+			var values []string
+			var err error
+			if c == '{' {
+				values, err = expandBrace(body)
+			} else {
+				values, err = expandBracket(body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			if values == nil {
+				// Not a glob, e.g. the brackets of an IPv6 host
+				literal.WriteByte(c)
+				continue
+			}
+			flush()
+			parts = append(parts, patternPart{values: values})
+			i += end + 1
+
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return parts, nil
+}
+
+// expandBrace expands the body of {a,b} or {lo..hi[..step]}.
+func expandBrace(body string) ([]string, error) {
+	if lo, rest, ok := strings.Cut(body, ".."); ok && !strings.Contains(body, ",") {
+		hi, stepText, hasStep := strings.Cut(rest, "..")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step == 0 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		return expandRange(lo, hi, step)
+	}
+	if !strings.Contains(body, ",") {
+		return nil, fmt.Errorf("{%s} is neither a list nor a range", body)
+	}
+	return strings.Split(body, ","), nil
+}
+
+// expandBracket expands the body of [lo-hi[:step]]. It returns nil for brackets
+// that do not hold a range.
+func expandBracket(body string) ([]string, error) {
+	rng, stepText, hasStep := strings.Cut(body, ":")
+	lo, hi, ok := strings.Cut(rng, "-")
+	if !ok || lo == "" || hi == "" || !isRangeBound(lo) || !isRangeBound(hi) {
+		return nil, nil
+	}
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+			return nil, fmt.Errorf("invalid step %q", stepText)
+		}
+	}
+	return expandRange(lo, hi, step)
+}
+
+// isRangeBound reports whether s is a number or a single letter.
+func isRangeBound(s string) bool {
+	if _, err := strconv.Atoi(s); err == nil {
+		return true
+	}
+	return len(s) == 1 && isLetter(s[0])
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// expandRange lists the numbers or letters from lo to hi, counting down when hi
+// is smaller. Numbers are zero padded to the longer bound when a bound has a
+// leading zero.
+func expandRange(lo, hi string, step int) ([]string, error) {
+	step = max(step, -step)
+
+	if len(lo) == 1 && len(hi) == 1 && isLetter(lo[0]) && isLetter(hi[0]) {
+		var values []string
+		for _, c := range countRange(int(lo[0]), int(hi[0]), step) {
+			values = append(values, string(rune(c)))
+		}
+		return values, nil
+	}
+
+	from, errLo := strconv.Atoi(lo)
+	to, errHi := strconv.Atoi(hi)
+	if errLo != nil || errHi != nil {
+		return nil, fmt.Errorf("invalid range %s..%s", lo, hi)
+	}
+	if rangeLen(from, to, step) > maxPatternURLs {
+		return nil, fmt.Errorf("range %s..%s has more than %d values", lo, hi, maxPatternURLs)
+	}
+
+	width := 0
+	if hasLeadingZero(lo) || hasLeadingZero(hi) {
+		width = max(len(lo), len(hi))
+	}
+	var values []string
+	for _, n := range countRange(from, to, step) {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
+
+func hasLeadingZero(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+// countRange counts from from to to in steps of step, in either direction.
+func countRange(from, to, step int) []int {
+	if from > to {
+		step = -step
+	}
+	values := make([]int, 0, rangeLen(from, to, step))
+	// Counting values rather than comparing n with to, which would overflow
+	// near the limits of int and never end
+	for n := from; len(values) < cap(values); n += step {
+		values = append(values, n)
+	}
+	return values
+}
+
+// rangeLen returns how many values countRange yields, computed in uint64 since
+// the distance between the bounds can exceed the range of int.
+func rangeLen(from, to, step int) uint64 {
+	dist := uint64(to) - uint64(from)
+	if from > to {
+		dist = uint64(from) - uint64(to)
+	}
+	return min(dist/uint64(max(step, -step)), math.MaxUint64-1) + 1
+}
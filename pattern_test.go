@@ -0,0 +1,86 @@
+package dlfetch
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"http://h/x", []string{"http://h/x"}},
+		{"http://h/{a,b}", []string{"http://h/a", "http://h/b"}},
+		{"http://h/{1..3}", []string{"http://h/1", "http://h/2", "http://h/3"}},
+		{"http://h/{3..1}", []string{"http://h/3", "http://h/2", "http://h/1"}},
+		{"http://h/{08..10}", []string{"http://h/08", "http://h/09", "http://h/10"}},
+		{"http://h/{0..10..5}", []string{"http://h/0", "http://h/5", "http://h/10"}},
+		{"http://h/{a..c}", []string{"http://h/a", "http://h/b", "http://h/c"}},
+		{"http://h/[1-2][a-b]", []string{"http://h/1a", "http://h/1b", "http://h/2a", "http://h/2b"}},
+		{"http://h/[0-10:5]", []string{"http://h/0", "http://h/5", "http://h/10"}},
+		{"http://h/\\{a,b\\}", []string{"http://h/{a,b}"}},
+		{"http://[::1]:8080/x", []string{"http://[::1]:8080/x"}},
+		{"http://h/[x", []string{"http://h/[x"}},
+		{fmt.Sprintf("http://h/{%d..%d}", math.MaxInt-1, math.MaxInt), []string{
+			fmt.Sprintf("http://h/%d", math.MaxInt-1), fmt.Sprintf("http://h/%d", math.MaxInt),
+		}},
+		{fmt.Sprintf("http://h/{%d..%d}", math.MinInt+1, math.MinInt), []string{
+			fmt.Sprintf("http://h/%d", math.MinInt+1), fmt.Sprintf("http://h/%d", math.MinInt),
+		}},
+	}
+	for _, tt := range tests {
+		reqs, err := ExpandPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("ExpandPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		var got []string
+		for i, req := range reqs {
+			if req.ID != i+1 {
+				t.Errorf("ExpandPattern(%q): request %d has ID %d", tt.pattern, i, req.ID)
+			}
+			got = append(got, req.URL)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExpandPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestExpandPatternVars(t *testing.T) {
+	reqs, err := ExpandPattern("http://h/{a,b}/[1-2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"1": "b", "2": "1"}; !reflect.DeepEqual(reqs[2].Vars, want) {
+		t.Errorf("vars %v, want %v", reqs[2].Vars, want)
+	}
+	if reqs, _ := ExpandPattern("http://h/x"); reqs[0].Vars != nil {
+		t.Errorf("vars %v without a pattern", reqs[0].Vars)
+	}
+}
+
+func TestExpandPatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{"http://h/{a,b", "unclosed {"},
+		{"http://h/{a}", "neither a list nor a range"},
+		{"http://h/{1..x}", "invalid range"},
+		{"http://h/{1..5..0}", "invalid step"},
+		{"http://h/[1-5:-1]", "invalid step"},
+		{"http://h/{0..1000000}", "more than 1000000 values"},
+		{fmt.Sprintf("http://h/{%d..%d}", math.MinInt, math.MaxInt), "more than 1000000 values"},
+		{"http://h/{1..1000}/{1..1001}", "more than 1000000 URLs"},
+	}
+	for _, tt := range tests {
+		if _, err := ExpandPattern(tt.pattern); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ExpandPattern(%q): %v, want %q", tt.pattern, err, tt.err)
+		}
+	}
+}
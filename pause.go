@@ -0,0 +1,97 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Pause stops the request with the given ID, whether it is still queued or already
+// downloading, and keeps what was downloaded so far. The task is marked as paused in
+// the monitor and neither callback is called until it is resumed with Resume.
+// Once the file is in place it can no longer be paused, its post-processing completes.
+// It returns ErrUnknownID if no queued or running request has the ID.
+func (f *Fetcher) Pause(id int) error {
+	f.cancelsMu.Lock()
+	h, ok := f.cancels[id]
+	f.cancelsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownID, id)
+	}
+	h.cancel(ErrPaused)
+	return nil
+}
+
+// Resume puts a paused request back into the queue. Its download continues from the
+// partial file with a Range request where the server supports it.
+// It returns ErrUnknownID if no request with the ID is paused.
+func (f *Fetcher) Resume(id int) error {
+	f.cancelsMu.Lock()
+	req, ok := f.paused[id]
+	if ok {
+		delete(f.paused, id)
+	}
+	f.cancelsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownID, id)
+	}
+
+	err := f.requeue(req)
+	if err != nil {
+		f.cancelsMu.Lock()
+		f.paused[id] = req
+		f.cancelsMu.Unlock()
+	}
+	return err
+}
+
+// requeue puts a paused request back into the queue with a fresh context.
+func (f *Fetcher) requeue(req DownloadRequest) error {
+	if err := f.claimPath(req.FullPath); err != nil {
+		return err
+	}
+	if f.tenants != nil {
+		if err := f.tenants.admit(req.Tenant); err != nil {
+			f.releasePath(req.FullPath)
+			return err
+		}
+	}
+
+	req.ctx = nil
+	f.makeCancellable(&req)
+	f.monitor.markAsPending(req.ID)
+	f.monitor.setQueued(req.ID, true)
+	if err := f.send(req); err != nil {
+		f.forgetCancel(req)
+		if f.tenants != nil {
+			f.tenants.leave(req.Tenant)
+		}
+		f.releasePath(req.FullPath)
+		f.monitor.markAsPaused(req.ID)
+		return err
+	}
+	return nil
+}
+
+// isPaused reports whether the request was stopped by Pause.
+func isPaused(req DownloadRequest) bool {
+	return req.ctx != nil && errors.Is(context.Cause(req.ctx), ErrPaused)
+}
+
+// isPausedID reports whether the request with the given ID is paused.
+func (f *Fetcher) isPausedID(id int) bool {
+	f.cancelsMu.Lock()
+	defer f.cancelsMu.Unlock()
+	_, ok := f.paused[id]
+	return ok
+}
+
+// parkPaused keeps a paused request until it is resumed or cancelled.
+func (f *Fetcher) parkPaused(req DownloadRequest) {
+	f.cancelsMu.Lock()
+	defer f.cancelsMu.Unlock()
+	if f.paused == nil {
+		f.paused = make(map[int]DownloadRequest)
+	}
+	f.paused[req.ID] = req
+}
@@ -0,0 +1,41 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Peek returns the first n bytes of the remote file, e.g. to sniff its type, check a
+// magic number or render a preview before committing to the full download. Only that
+// part is requested with a Range header; if the server sends the whole file anyway,
+// the connection is closed after n bytes. Files shorter than n are returned in full.
+func (f *Fetcher) Peek(ctx context.Context, url string, n int64) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid peek length: %d", n)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Empty file
+		return []byte{}, nil
+	default:
+		return nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, n))
+}
@@ -0,0 +1,34 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+)
+
+// PostProcessor is a step that runs on a completed download before onComplete is called.
+// It may act on the file and update the result, e.g. set a new Path after moving it.
+// Returning an error fails the download.
+type PostProcessor interface {
+	Process(ctx context.Context, result *DownloadResult) error
+}
+
+// PostProcessorFunc adapts an ordinary function to the PostProcessor interface.
+type PostProcessorFunc func(ctx context.Context, result *DownloadResult) error
+
+// Process calls fn(ctx, result).
+func (fn PostProcessorFunc) Process(ctx context.Context, result *DownloadResult) error {
+	return fn(ctx, result)
+}
+
+// postProcess runs the configured post-processors in order, stopping at the first error.
+func (f *Fetcher) postProcess(ctx context.Context, result *DownloadResult) error {
+	for i, p := range f.postProcessors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.Process(ctx, result); err != nil {
+			return fmt.Errorf("post-processor %d failed: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package dlfetch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// writeMode describes how a request's target file will be written.
+type writeMode int
+
+const (
+	writeNew       writeMode = iota // Nothing on disk yet, download from scratch
+	writeOverwrite                  // Target exists and will be replaced
+	writeRename                     // Target exists, the download is saved under a numbered name
+	writeSkip                       // Target exists and is left alone
+)
+
+// claimPath reserves the target path of a request so no other queued or running
+// request can write to it at the same time. The claim is held from Enqueue until
+// the download has been processed. Requests without a target file, which have an
+// empty path, claim nothing.
+func (f *Fetcher) claimPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	f.pathsMu.Lock()
+	defer f.pathsMu.Unlock()
+
+	if _, ok := f.paths[path]; ok {
+		return fmt.Errorf("%w: %s", ErrPathInUse, path)
+	}
+	f.paths[path] = struct{}{}
+	return nil
+}
+
+// releasePath frees a path claimed with claimPath.
+func (f *Fetcher) releasePath(path string) {
+	f.pathsMu.Lock()
+	defer f.pathsMu.Unlock()
+	delete(f.paths, path)
+}
+
+// checkPreconditions decides, right before the transfer starts, how the target
+// file of the request should be written. The file may have been created by
+// another program or process since the request was validated at enqueue time.
+func checkPreconditions(req DownloadRequest, overwrite OverwritePolicy) (writeMode, error) {
+	if !checkFileExists(req.FullPath) {
+		if overwrite == OverwriteRename {
+			// Another file may still show up before the download is done
+			return writeRename, nil
+		}
+		return writeNew, nil
+	}
+	switch overwrite {
+	case OverwriteReplace:
+		return writeOverwrite, nil
+	case OverwriteRename:
+		return writeRename, nil
+	case OverwriteSkip:
+		return writeSkip, nil
+	}
+	return writeNew, fmt.Errorf("%w: id=%d, name=%s, path=%s", ErrFileExists, req.ID, req.FileName, req.FullPath)
+}
+
+// commitFile moves the finished staging file to its final path.
+// Unless overwrite is set the final path is never clobbered: the file is
+// hard linked into place, which fails atomically if the target was created in
+// the meantime. Filesystems without hard links fall back to check-then-rename.
+func commitFile(tmpPath, finalPath string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(tmpPath, finalPath)
+	}
+
+	err := tryCommit(tmpPath, finalPath)
+	if errors.Is(err, ErrFileExists) {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
+
+// tryCommit moves the staging file to finalPath unless something exists there,
+// in which case the staging file is kept and an ErrFileExists error returned.
+func tryCommit(tmpPath, finalPath string) error {
+	err := os.Link(tmpPath, finalPath)
+	switch {
+	case err == nil:
+		return os.Remove(tmpPath)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%w: %s", ErrFileExists, finalPath)
+	}
+
+	if exists, err := fileExists(finalPath); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("%w: %s", ErrFileExists, finalPath)
+	}
+	return os.Rename(tmpPath, finalPath)
+}
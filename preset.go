@@ -0,0 +1,92 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+)
+
+// Preset bundles per-request settings shared by a category of downloads,
+// so requests only need to name it, e.g. Preset: "podcast".
+// Values set on the request itself take precedence.
+type Preset struct {
+	Path           string            // Target subdirectory, used when the request has no Path
+	Vars           map[string]string // Default template variables, merged under the request's Vars
+	Sink           string            // Sink to upload to, used when the request has no Sink
+	MaxSpeed       int64             // Speed limit in bytes per second, used when the request has no MaxSpeed
+	Headers        map[string]string // Default HTTP headers, merged under the request's Headers
+	Retries        int               // Retries of transient failures instead of those set with WithRetries, negative for none
+	PostProcessors []PostProcessor   // Run after the Fetcher-wide post-processors
+}
+
+// WithPreset registers a named preset that requests can refer to through DownloadRequest.Preset.
+func WithPreset(name string, p Preset) FetcherOption {
+	return func(f *Fetcher) {
+		if f.presets == nil {
+			f.presets = make(map[string]Preset)
+		}
+		f.presets[name] = p
+	}
+}
+
+// applyPreset fills in the request's unset fields from its preset.
+func (f *Fetcher) applyPreset(req *DownloadRequest) error {
+	if req.Preset == "" {
+		return nil
+	}
+	p, ok := f.presets[req.Preset]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPreset, req.Preset)
+	}
+
+	if req.Path == "" {
+		req.Path = p.Path
+	}
+	if req.Sink == "" {
+		req.Sink = p.Sink
+	}
+	if req.MaxSpeed == 0 {
+		req.MaxSpeed = p.MaxSpeed
+	}
+	req.Vars = mergeDefaults(p.Vars, req.Vars)
+	req.Headers = mergeDefaults(p.Headers, req.Headers)
+	return nil
+}
+
+// mergeDefaults returns the entries of defaults overridden by those of m.
+func mergeDefaults(defaults, m map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return m
+	}
+	merged := make(map[string]string, len(defaults)+len(m))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range m {
+		merged[k] = v
+	}
+	return merged
+}
+
+// retriesFor returns how often transient failures of the request are retried.
+func (f *Fetcher) retriesFor(req DownloadRequest) int {
+	if n := f.presets[req.Preset].Retries; n != 0 {
+		return max(0, n)
+	}
+	return f.currentPolicy().retries
+}
+
+// presetPostProcess runs the post-processors of the request's preset.
+func (f *Fetcher) presetPostProcess(ctx context.Context, req DownloadRequest, result *DownloadResult) error {
+	if req.Preset == "" {
+		return nil
+	}
+	for i, p := range f.presets[req.Preset].PostProcessors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.Process(ctx, result); err != nil {
+			return fmt.Errorf("preset %s post-processor %d failed: %w", req.Preset, i, err)
+		}
+	}
+	return nil
+}
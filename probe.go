@@ -0,0 +1,162 @@
+package dlfetch
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ProbeResult describes a remote file without downloading it.
+type ProbeResult struct {
+	URL          string // URL the file is served from, after following redirects
+	Size         int64  // Size in bytes, UnknownSize if the server does not tell
+	ContentType  string
+	LastModified string
+	ETag         string
+	AcceptRanges bool   // The server supports range requests, so downloads can resume and be segmented
+	FileName     string // File name from the Content-Disposition header, if any
+}
+
+// WithProbeOnEnqueue probes every enqueued request in the background, so the
+// monitor shows its TotalBytes while it still waits in the queue. Requests with a
+// URLProvider are not probed. Failed probes are ignored; the download reports the
+// actual problem. Probes run one after another on as many goroutines as there are
+// workers, and each takes a connection of the per-host limits and of congestion
+// control and slow start like a download does.
+func WithProbeOnEnqueue() FetcherOption {
+	return func(f *Fetcher) {
+		f.probes = &probePool{}
+	}
+}
+
+// probePool holds the requests waiting to be probed and counts the goroutines
+// probing them.
+type probePool struct {
+	mu      sync.Mutex
+	pending []DownloadRequest
+	active  int
+}
+
+// Probe asks the server for the size, type, modification time and range support of
+// the file at url with a HEAD request. Servers that reject HEAD are asked for its
+// first byte instead.
+func (f *Fetcher) Probe(ctx context.Context, url string) (ProbeResult, error) {
+	return f.probe(ctx, DownloadRequest{URL: url})
+}
+
+// probe probes the URL of req with its headers and proxy.
+func (f *Fetcher) probe(ctx context.Context, req DownloadRequest) (ProbeResult, error) {
+	ctx = withProxy(ctx, req)
+
+	resp, err := f.probeRequest(ctx, req, http.MethodHead)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented || resp.StatusCode == http.StatusForbidden) {
+		resp, err = f.probeRequest(ctx, req, http.MethodGet)
+	}
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return ProbeResult{}, &HTTPStatusError{URL: req.URL, StatusCode: resp.StatusCode}
+	}
+
+	size := resolveFileSize(resp)
+	if resp.StatusCode == http.StatusPartialContent {
+		// The body holds only the first byte
+		size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	}
+	return ProbeResult{
+		URL:          finalURL(resp),
+		Size:         size,
+		ContentType:  resp.Header.Get("Content-Type"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ETag:         resp.Header.Get("ETag"),
+		AcceptRanges: canResume(resp),
+		FileName:     dispositionFileName(resp),
+	}, nil
+}
+
+// probeRequest sends a HEAD request, or a GET request for the first byte, and
+// closes the body.
+func (f *Fetcher) probeRequest(ctx context.Context, req DownloadRequest, method string) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, req.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setHeaders(httpReq, req.Headers)
+	if method == http.MethodGet {
+		httpReq.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// probeQueued probes a queued request and tells the monitor how much it will
+// download, once the pool gets to it.
+func (f *Fetcher) probeQueued(req DownloadRequest) {
+	if req.URLProvider != nil {
+		return
+	}
+	p := f.probes
+	p.mu.Lock()
+	p.pending = append(p.pending, req)
+	spawn := p.active < max(1, f.workerCount())
+	if spawn {
+		p.active++
+	}
+	p.mu.Unlock()
+	if spawn {
+		go f.runProbes()
+	}
+}
+
+// runProbes probes pending requests until there are none left.
+func (f *Fetcher) runProbes() {
+	p := f.probes
+	for {
+		p.mu.Lock()
+		if len(p.pending) == 0 {
+			p.active--
+			p.mu.Unlock()
+			return
+		}
+		req := p.pending[0]
+		p.pending[0] = DownloadRequest{}
+		p.pending = p.pending[1:]
+		p.mu.Unlock()
+
+		f.probeOne(req)
+	}
+}
+
+// probeOne probes a request within the host and congestion limits.
+func (f *Fetcher) probeOne(req DownloadRequest) {
+	ctx := req.context()
+	host := hostOf(req.URL)
+	for _, l := range []*hostLimiter{f.sharedHosts(), f.hostLimiter} {
+		if l == nil {
+			continue
+		}
+		if err := l.acquire(ctx, host); err != nil {
+			return
+		}
+		defer l.releaseSlots(host, 1)
+	}
+	if f.congestion != nil {
+		if err := f.congestion.acquire(ctx); err != nil {
+			return
+		}
+		defer f.congestion.releaseSlot()
+	}
+
+	info, err := f.probe(ctx, req)
+	if err != nil || info.Size <= 0 {
+		return
+	}
+	f.monitor.setTotal(req.ID, rangeSize(info.Size, req.Range))
+}
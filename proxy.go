@@ -0,0 +1,109 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// proxyKey is the context key under which a request's own proxy is passed to the transport.
+type proxyKey struct{}
+
+// WithProxy routes all downloads through the proxy at rawURL. The http, https, socks5
+// and socks5h schemes are supported, e.g. "socks5://127.0.0.1:9050" for Tor; user
+// info in the URL is used to authenticate. Requests can pick another proxy through
+// DownloadRequest.Proxy. Without a proxy the HTTP client's own proxy settings apply.
+// It only takes effect when the client's transport is an *http.Transport.
+func WithProxy(rawURL string) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.proxy = rawURL
+	}
+}
+
+// parseProxy parses and checks a proxy URL.
+func parseProxy(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q", rawURL, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", rawURL)
+	}
+	return u, nil
+}
+
+// validateProxy checks the proxy of the request and the Fetcher-wide one.
+func (f *Fetcher) validateProxy(req DownloadRequest) error {
+	for _, rawURL := range []string{f.currentPolicy().proxy, req.Proxy} {
+		if rawURL == "" {
+			continue
+		}
+		u, err := parseProxy(rawURL)
+		if err != nil {
+			return err
+		}
+		if err := f.checkProxyAllowed(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkProxyAllowed refuses proxies when private networks are blocked: the proxy
+// resolves the hosts it connects to itself, beyond the reach of the dial time check.
+func (f *Fetcher) checkProxyAllowed(u *url.URL) error {
+	if f.dialer == nil || !f.dialer.blockPrivate {
+		return nil
+	}
+	return fmt.Errorf("%w: proxy %s: proxies cannot be used while private networks are blocked", ErrURLBlocked, u.Redacted())
+}
+
+// withProxy attaches the request's proxy to ctx for the transport to pick up.
+func withProxy(ctx context.Context, req DownloadRequest) context.Context {
+	if req.Proxy == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyKey{}, req.Proxy)
+}
+
+// wrapProxy makes the transport send requests through the proxy of their download,
+// the Fetcher's proxy, or else the transport's own proxy setting.
+func (f *Fetcher) wrapProxy(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	t = t.Clone()
+	fallback := t.Proxy
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		rawURL, _ := r.Context().Value(proxyKey{}).(string)
+		if rawURL == "" {
+			rawURL = f.currentPolicy().proxy
+		}
+		var u *url.URL
+		var err error
+		switch {
+		case rawURL != "":
+			u, err = parseProxy(rawURL)
+		case fallback != nil:
+			u, err = fallback(r)
+		}
+		if err != nil || u == nil {
+			return u, err
+		}
+		if err := f.checkProxyAllowed(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	return t
+}
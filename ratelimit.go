@@ -0,0 +1,96 @@
+package dlfetch
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithHostRateLimit limits the HTTP requests sent to the same host name to
+// requestsPerSecond, spread out evenly, so crawling-style workloads do not trip
+// rate limits of web application firewalls. Every request counts, including
+// those for segments, resumes and conditional checks.
+func WithHostRateLimit(requestsPerSecond float64) FetcherOption {
+	return func(f *Fetcher) {
+		if requestsPerSecond > 0 {
+			p := f.ensureHostPacer()
+			p.interval = max(p.interval, time.Duration(float64(time.Second)/requestsPerSecond))
+		}
+	}
+}
+
+// WithPolitenessDelay waits at least d between the starts of two requests to the
+// same host name. Combined with WithHostRateLimit the stricter spacing applies.
+func WithPolitenessDelay(d time.Duration) FetcherOption {
+	return func(f *Fetcher) {
+		if d > 0 {
+			p := f.ensureHostPacer()
+			p.interval = max(p.interval, d)
+		}
+	}
+}
+
+func (f *Fetcher) ensureHostPacer() *hostPacer {
+	if f.hostPacer == nil {
+		f.hostPacer = &hostPacer{next: make(map[string]time.Time)}
+		f.transportWrappers = append(f.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			return &pacedTransport{base: base, pacer: f.hostPacer}
+		})
+	}
+	return f.hostPacer
+}
+
+// hostPacer spaces out the requests to each host by interval.
+type hostPacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time // Earliest start of the next request per host
+}
+
+// reserve books the next free slot of the host and returns when it starts.
+func (p *hostPacer) reserve(host string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for h, t := range p.next {
+		// Forget hosts that have been quiet for a while
+		if now.Sub(t) > p.interval {
+			delete(p.next, h)
+		}
+	}
+
+	slot := now
+	if next := p.next[host]; next.After(now) {
+		slot = next
+	}
+	p.next[host] = slot.Add(p.interval)
+	return slot
+}
+
+// pacedTransport delays requests until their host's slot comes up.
+type pacedTransport struct {
+	base  http.RoundTripper
+	pacer *hostPacer
+}
+
+func (t *pacedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := time.Until(t.pacer.reserve(req.URL.Hostname())); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,149 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// Rclone gives access to rclone remotes by running the rclone binary.
+// It can act as a source for "rclone://remote/path/to/file" request URLs
+// (see WithRclone) and as an upload Sink (see Rclone.Sink).
+type Rclone struct {
+	Binary string   // Path to the rclone binary, defaults to "rclone" on PATH
+	Args   []string // Extra global flags, e.g. "--config", "/etc/rclone.conf"
+}
+
+// WithRclone routes request URLs with the "rclone" scheme through rclone,
+// so "rclone://myremote/bucket/file.zip" reads "myremote:bucket/file.zip".
+// All other URLs keep using the configured HTTP client.
+func WithRclone(r *Rclone) FetcherOption {
+	return func(f *Fetcher) {
+		f.rclone = r
+	}
+}
+
+// remotePath converts an rclone:// URL into rclone's "remote:path" notation.
+func (r *Rclone) remotePath(req *http.Request) string {
+	return req.URL.Host + ":" + strings.TrimPrefix(req.URL.Path, "/")
+}
+
+func (r *Rclone) command(ctx context.Context, args ...string) *exec.Cmd {
+	binary := r.Binary
+	if binary == "" {
+		binary = "rclone"
+	}
+	return exec.CommandContext(ctx, binary, append(append([]string{}, r.Args...), args...)...)
+}
+
+// RoundTrip streams the remote file from "rclone cat". Range headers are ignored,
+// the full file is always returned. Errors reported by rclone surface when
+// reading the body.
+func (r *Rclone) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("rclone source only supports GET, got %s", req.Method)
+	}
+
+	var stderr bytes.Buffer
+	cmd := r.command(req.Context(), "cat", r.remotePath(req))
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          &rcloneBody{ReadCloser: stdout, cmd: cmd, stderr: &stderr},
+		ContentLength: UnknownSize,
+		Request:       req,
+	}, nil
+}
+
+// rcloneBody reports the exit status of rclone when the output ends.
+type rcloneBody struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (b *rcloneBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) && !b.done {
+		b.done = true
+		if waitErr := b.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("rclone cat failed: %w: %s", waitErr, strings.TrimSpace(b.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (b *rcloneBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.done {
+		b.done = true
+		_ = b.cmd.Process.Kill()
+		_ = b.cmd.Wait()
+	}
+	return err
+}
+
+// Sink returns a Sink that uploads files below the given remote location,
+// e.g. "myremote:bucket/incoming", using "rclone rcat".
+func (r *Rclone) Sink(remote string) Sink {
+	return &rcloneSink{rclone: r, remote: strings.TrimSuffix(remote, "/")}
+}
+
+type rcloneSink struct {
+	rclone *Rclone
+	remote string
+}
+
+func (s *rcloneSink) Upload(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) (string, error) {
+	dest := s.remote + "/" + key
+	if strings.HasSuffix(s.remote, ":") {
+		dest = s.remote + key
+	}
+
+	var stderr bytes.Buffer
+	cmd := s.rclone.command(ctx, "rcat", "--size", fmt.Sprint(size), dest)
+	cmd.Stdin = body
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("rclone rcat failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return dest, nil
+}
+
+// schemeTransport sends requests with the given scheme to a dedicated
+// RoundTripper and everything else to the base transport.
+type schemeTransport struct {
+	base    http.RoundTripper
+	scheme  string
+	handler http.RoundTripper
+}
+
+func (t *schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == t.scheme {
+		return t.handler.RoundTrip(req)
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,66 @@
+package dlfetch
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// redirectPolicy limits which redirects the HTTP client follows.
+type redirectPolicy struct {
+	max      int  // Hops to follow, negative for the client's default
+	sameHost bool // Refuse redirects to another host
+}
+
+func (f *Fetcher) ensureRedirects() *redirectPolicy {
+	if f.redirects == nil {
+		f.redirects = &redirectPolicy{max: -1}
+	}
+	return f.redirects
+}
+
+// WithMaxRedirects follows at most n redirects per request, 0 to follow none.
+// Requests exceeding the limit fail with an error matching ErrRedirect. Without it
+// the HTTP client's policy applies, by default up to 10 redirects.
+func WithMaxRedirects(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.ensureRedirects().max = max(0, n)
+	}
+}
+
+// WithSameHostRedirects refuses redirects to a host other than the one of the
+// original URL, failing with an error matching ErrRedirect. A switch from http to
+// https on the same host is allowed.
+func WithSameHostRedirects() FetcherOption {
+	return func(f *Fetcher) {
+		f.ensureRedirects().sameHost = true
+	}
+}
+
+// checkRedirect returns a CheckRedirect function that applies the policy before
+// next, the client's own one.
+func (p *redirectPolicy) checkRedirect(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if p.max >= 0 && len(via) > p.max {
+			return fmt.Errorf("%w: more than %d redirects", ErrRedirect, p.max)
+		}
+		if p.sameHost && req.URL.Hostname() != via[0].URL.Hostname() {
+			return fmt.Errorf("%w: %s redirects to another host: %s", ErrRedirect, redactURL(via[0].URL), redactURL(req.URL))
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		if p.max < 0 && len(via) >= 10 {
+			// The default policy of net/http
+			return fmt.Errorf("%w: stopped after 10 redirects", ErrRedirect)
+		}
+		return nil
+	}
+}
+
+// finalURL returns the URL the response was served from after redirects.
+func finalURL(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	return resp.Request.URL.String()
+}
@@ -0,0 +1,150 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// currentPolicy returns a snapshot of the settings that may change while running.
+func (f *Fetcher) currentPolicy() policy {
+	f.policyMu.RLock()
+	defer f.policyMu.RUnlock()
+	return f.policy
+}
+
+// Reload applies a new configuration to a running Fetcher without dropping
+// queued or in-flight downloads. The worker count, the bandwidth limit, retries,
+// the proxy and the file handling settings (target dir, overwrite, staging names,
+// markers, mirroring, segments, content types) are replaced by those in cfg, zero
+// values meaning the defaults as in New. Changed settings apply to requests
+// enqueued or started after the reload; downloads already in progress finish with
+// the settings they started with.
+//
+// The other settings are only set up at construction. If cfg changes any of them
+// from the configuration of NewFromConfig or the last Reload, the zero Config for
+// Fetchers created with New, Reload fails with an error naming them and changes
+// nothing.
+func (f *Fetcher) Reload(cfg Config) error {
+	options, err := cfg.Options()
+	if err != nil {
+		return err
+	}
+	fresh := New(options...)
+
+	f.policyMu.Lock()
+	if fixed := fixedChanges(f.config, cfg); len(fixed) > 0 {
+		f.policyMu.Unlock()
+		return fmt.Errorf("reload: %s can only be changed by creating a new Fetcher", strings.Join(fixed, ", "))
+	}
+	f.policy = fresh.policy
+	f.config = cfg
+	f.policyMu.Unlock()
+
+	f.bandwidth.setRate(fresh.bandwidth.limit())
+	f.setWorkers(fresh.maxWorkers)
+	return nil
+}
+
+// fixedChanges returns the names of the settings only applied at construction that
+// differ between old and cfg.
+func fixedChanges(old, cfg Config) []string {
+	var changed []string
+	for _, s := range []struct {
+		name    string
+		changed bool
+	}{
+		{"maxPerHost", old.MaxPerHost != cfg.MaxPerHost},
+		{"blockPrivate", old.BlockPrivate != cfg.BlockPrivate},
+		{"maxRedirects", old.MaxRedirects != cfg.MaxRedirects},
+		{"sameHostRedirects", old.SameHostRedirects != cfg.SameHostRedirects},
+		{"webhookURL", old.WebhookURL != cfg.WebhookURL},
+		{"webhookSecret", old.WebhookSecret != cfg.WebhookSecret},
+		{"fairBandwidth", old.FairBandwidth != cfg.FairBandwidth},
+		{"decodeContent", old.DecodeContent != cfg.DecodeContent},
+		{"relocate", old.Relocate != cfg.Relocate},
+		{"orderedCompletion", old.OrderedCompletion != cfg.OrderedCompletion},
+		{"sniffMimeType", old.SniffMimeType != cfg.SniffMimeType},
+	} {
+		if s.changed {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
+
+// ReloadOnSignal reloads the configuration file at path whenever the process
+// receives SIGHUP, until ctx is cancelled. Errors while loading or applying
+// the file are passed to onError and leave the current settings in place.
+// On platforms without SIGHUP, such as js/wasm, it only waits for ctx.
+func (f *Fetcher) ReloadOnSignal(ctx context.Context, path string, onError func(error)) {
+	if len(reloadSignals) == 0 {
+		<-ctx.Done()
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, reloadSignals...)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+			cfg, err := LoadConfig(path)
+			if err == nil {
+				err = f.Reload(cfg)
+			}
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// setWorkers changes the size of the worker pool. On a running Fetcher workers
+// are started or retired right away; a retired worker finishes its current download first.
+func (f *Fetcher) setWorkers(n int) {
+	f.lifecycleMu.Lock()
+	defer f.lifecycleMu.Unlock()
+
+	f.maxWorkers = n
+	if f.congestion != nil {
+		f.congestion.setMax(n)
+	}
+
+	f.stateMu.RLock()
+	running := f.state == stateRunning
+	stopChan := f.stopChan
+	f.stateMu.RUnlock()
+
+	if !running {
+		return
+	}
+
+	for len(f.workerQuits) < n {
+		f.spawnWorker(stopChan)
+	}
+	for len(f.workerQuits) > n {
+		last := len(f.workerQuits) - 1
+		close(f.workerQuits[last])
+		f.workerQuits = f.workerQuits[:last]
+	}
+}
+
+// workerCount returns the configured number of workers.
+func (f *Fetcher) workerCount() int {
+	f.lifecycleMu.Lock()
+	defer f.lifecycleMu.Unlock()
+	return f.maxWorkers
+}
+
+// spawnWorker starts one worker. Must be called with lifecycleMu held.
+func (f *Fetcher) spawnWorker(stopChan <-chan struct{}) {
+	quit := make(chan struct{})
+	f.workerQuits = append(f.workerQuits, quit)
+	f.wg.Add(1)
+	go f.worker(stopChan, quit)
+}
@@ -0,0 +1,42 @@
+package dlfetch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	f := New()
+	if err := f.Reload(Config{Workers: 2, Retries: 3, Proxy: "http://127.0.0.1:3128"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := f.currentPolicy(); p.retries != 3 || p.proxy != "http://127.0.0.1:3128" || f.workerCount() != 2 {
+		t.Errorf("reloaded retries %d, proxy %q, workers %d", p.retries, p.proxy, f.workerCount())
+	}
+
+	err := f.Reload(Config{Retries: 5, MaxPerHost: 2, WebhookURL: "http://127.0.0.1/hook"})
+	if err == nil || !strings.Contains(err.Error(), "maxPerHost, webhookURL can only be changed") {
+		t.Fatalf("reloading fixed settings: %v", err)
+	}
+	if p := f.currentPolicy(); p.retries != 3 {
+		t.Errorf("failed reload changed retries to %d", p.retries)
+	}
+
+	// Fixed settings the Fetcher was created with can be reloaded unchanged
+	path := filepath.Join(t.TempDir(), "dlfetch.yaml")
+	if err := os.WriteFile(path, []byte("maxPerHost: 2\nfairBandwidth: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = NewFromConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(Config{MaxPerHost: 2, FairBandwidth: true, Retries: 1}); err != nil {
+		t.Errorf("reloading unchanged fixed settings: %v", err)
+	}
+	if err := f.Reload(Config{Retries: 1}); err == nil || !strings.Contains(err.Error(), "maxPerHost, fairBandwidth") {
+		t.Errorf("dropping fixed settings: %v", err)
+	}
+}
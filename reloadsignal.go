@@ -0,0 +1,11 @@
+//go:build !js
+
+package dlfetch
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals ReloadOnSignal reloads on.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,9 @@
+//go:build js
+
+package dlfetch
+
+import "os"
+
+// reloadSignals is empty, there is no SIGHUP on this platform and ReloadOnSignal
+// never reloads.
+var reloadSignals []os.Signal
@@ -0,0 +1,119 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// relocateData is the data available to Relocate destination templates.
+type relocateData struct {
+	DownloadResult
+	Base string // FileName without extension
+	Ext  string // Extension of FileName including the dot
+	Date string // Current date as YYYY-MM-DD
+}
+
+func newRelocateData(result DownloadResult) relocateData {
+	ext := filepath.Ext(result.FileName)
+	return relocateData{
+		DownloadResult: result,
+		Base:           strings.TrimSuffix(result.FileName, ext),
+		Ext:            ext,
+		Date:           time.Now().Format("2006-01-02"),
+	}
+}
+
+// Relocate returns a post-processor that moves completed files to a final location,
+// separating download staging from organization. The destination is a text/template
+// evaluated per file, with access to the DownloadResult fields (including the
+// request's Vars) plus Base, Ext and Date:
+//
+//	dlfetch.Relocate("/library/{{.Vars.show}}/{{.Date}}/{{.Base}}{{.Ext}}")
+//
+// Parent directories are created as needed. Moves across filesystems fall back to a
+// copy followed by removing the source. An existing destination file is never replaced.
+func Relocate(destTemplate string) (PostProcessor, error) {
+	if _, err := template.New("relocate").Parse(destTemplate); err != nil {
+		return nil, fmt.Errorf("invalid relocate template: %w", err)
+	}
+
+	return PostProcessorFunc(func(ctx context.Context, result *DownloadResult) error {
+		dest, err := expandTemplate("relocate", destTemplate, newRelocateData(*result))
+		if err != nil {
+			return err
+		}
+		dest = filepath.Clean(dest)
+
+		if err := moveFile(result.Path, dest); err != nil {
+			return err
+		}
+		result.Path = dest
+		result.FileName = filepath.Base(dest)
+		return nil
+	}), nil
+}
+
+// moveFile moves src to dst without replacing an existing dst, failing with
+// ErrFileExists then. When a rename is not possible (different filesystems) the
+// file is copied instead.
+func moveFile(src, dst string) error {
+	if err := ensureDir(dst); err != nil {
+		return err
+	}
+	err := tryCommit(src, dst)
+	if err == nil || errors.Is(err, ErrFileExists) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s", ErrFileExists, dst)
+		}
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to a new file dst and syncs it to disk. If dst exists it
+// fails with fs.ErrExist; if the copy fails, the new file is removed.
+func copyFile(src, dst string) error {
+	return copyFileFlags(src, dst, os.O_EXCL)
+}
+
+// copyFileTo copies src to dst, replacing dst if it exists.
+func copyFileTo(src, dst string) error {
+	return copyFileFlags(src, dst, os.O_TRUNC)
+}
+
+func copyFileFlags(src, dst string, flag int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && flag&os.O_EXCL != 0 {
+		// Only a file this call created is removed
+		_ = os.Remove(dst)
+	}
+	return err
+}
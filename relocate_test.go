@@ -0,0 +1,51 @@
+package dlfetch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	check := func(path, want string) {
+		t.Helper()
+		if b, err := os.ReadFile(path); err != nil || string(b) != want {
+			t.Errorf("%s has %q, %v, want %q", filepath.Base(path), b, err, want)
+		}
+	}
+
+	src, dst := write("src", "new"), write("dst", "theirs")
+	if err := moveFile(src, dst); !errors.Is(err, ErrFileExists) {
+		t.Errorf("moving onto a file: %v", err)
+	}
+	check(src, "new")
+	check(dst, "theirs")
+
+	// The copy of moves across filesystems neither replaces nor removes a file
+	if err := copyFile(src, dst); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("copying onto a file: %v", err)
+	}
+	check(dst, "theirs")
+	if err := copyFile(filepath.Join(dir, "missing"), filepath.Join(dir, "copy")); err == nil || checkFileExists(filepath.Join(dir, "copy")) {
+		t.Errorf("failed copy left a file behind: %v", err)
+	}
+
+	moved := filepath.Join(dir, "a", "b", "moved")
+	if err := moveFile(src, moved); err != nil {
+		t.Fatal(err)
+	}
+	check(moved, "new")
+	if checkFileExists(src) {
+		t.Error("source kept after the move")
+	}
+}
@@ -0,0 +1,152 @@
+package dlfetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Repair checks the files of the manifest like Verify, but first tries to fix files
+// that fail the check in place: for entries with block hashes only the blocks that
+// do not match are fetched again with range requests. The requests of files that
+// are missing, have no block hashes or still do not match after the repair are
+// returned for a full download with EnqueueMany, together with the reasons.
+func (f *Fetcher) Repair(ctx context.Context, manifest []ManifestEntry) ([]DownloadRequest, error) {
+	return f.verify(ctx, manifest, true)
+}
+
+// repairBlocks fetches the blocks of the file whose hash does not match the entry
+// and writes them over the damaged ones, each only once it matches its hash. The
+// blocks are requested with If-Match, or If-Unmodified-Since, so they all come from
+// the version of the remote file the first one came from. A file that is too long is
+// cut to the expected size once all blocks are repaired; the blocks a short one
+// lacks do not match and are fetched as well.
+func (f *Fetcher) repairBlocks(ctx context.Context, req DownloadRequest, entry ManifestEntry) error {
+	if entry.BlockSize <= 0 || entry.Size <= 0 {
+		return fmt.Errorf("block repair of %s needs Size and BlockSize", req.FullPath)
+	}
+	if blocks := (entry.Size + entry.BlockSize - 1) / entry.BlockSize; int64(len(entry.Blocks)) != blocks {
+		return fmt.Errorf("block repair of %s: %d block hashes for %d blocks", req.FullPath, len(entry.Blocks), blocks)
+	}
+
+	// Keep queued downloads of the same file away while it is patched
+	if err := f.claimPath(req.FullPath); err != nil {
+		return err
+	}
+	defer f.releasePath(req.FullPath)
+
+	url := req.URL
+	if req.URLProvider != nil {
+		var err error
+		if url, err = req.URLProvider(ctx); err != nil {
+			return fmt.Errorf("failed to get download url: %w", err)
+		}
+	}
+
+	out, err := os.OpenFile(req.FullPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	ctx = withProxy(ctx, req)
+	limits := f.speedLimits(req)
+	var version http.Header // Validators of the first fetched block
+	for i, want := range entry.Blocks {
+		start := int64(i) * entry.BlockSize
+		length := min(entry.BlockSize, entry.Size-start)
+
+		// Past the end of a short file the section reads less and does not match
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(out, start, length)); err != nil {
+			return err
+		}
+		if strings.EqualFold(hex.EncodeToString(h.Sum(nil)), want) {
+			continue
+		}
+		block, header, err := f.fetchBlock(ctx, url, req.Headers, start, length, version, limits)
+		if err != nil {
+			return fmt.Errorf("repairing block %d of %s: %w", i, req.FullPath, err)
+		}
+		if sum := sha256.Sum256(block); !strings.EqualFold(hex.EncodeToString(sum[:]), want) {
+			return fmt.Errorf("repairing block %d of %s: %w: the fetched block does not match its hash", i, req.FullPath, ErrChecksumMismatch)
+		}
+		if _, err := out.WriteAt(block, start); err != nil {
+			return err
+		}
+		if version == nil {
+			version = header
+		}
+	}
+	if err := out.Truncate(entry.Size); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// fetchBlock downloads the length bytes at start. With version set, the block is
+// only sent if the remote file still has the ETag or Last-Modified in it. It
+// returns the block and the headers of the response.
+func (f *Fetcher) fetchBlock(ctx context.Context, url string, headers map[string]string, start, length int64, version http.Header, limits []*tokenBucket) ([]byte, http.Header, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	setHeaders(httpReq, headers)
+	httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	if etag := version.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		httpReq.Header.Set("If-Match", etag)
+	} else if modified := version.Get("Last-Modified"); modified != "" {
+		httpReq.Header.Set("If-Unmodified-Since", modified)
+	}
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		// 412 once the remote file changed
+		return nil, nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	if got, ok := parseContentRangeStart(resp.Header.Get("Content-Range")); !ok || got != start {
+		return nil, nil, fmt.Errorf("unexpected content range for block at %d: %q", start, resp.Header.Get("Content-Range"))
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(throttle(ctx, resp.Body, limits), block); err != nil {
+		return nil, nil, err
+	}
+	return block, resp.Header, nil
+}
+
+// BlockHashes returns the hex encoded SHA-256 of every blockSize bytes of the file
+// at path, for the Blocks of a ManifestEntry.
+func BlockHashes(path string, blockSize int64) ([]string, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var blocks []string
+	for {
+		h := sha256.New()
+		n, err := io.Copy(h, io.LimitReader(in, blockSize))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return blocks, nil
+		}
+		blocks = append(blocks, hex.EncodeToString(h.Sum(nil)))
+	}
+}
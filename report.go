@@ -0,0 +1,212 @@
+package dlfetch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportFormat selects the output format of Fetcher.Report.
+type ReportFormat string
+
+const (
+	ReportCSV  ReportFormat = "csv"
+	ReportJSON ReportFormat = "json"
+
+	// ReportSHA256SUMS lists the completed downloads in the format of sha256sum,
+	// with paths relative to the target directory, see WriteChecksumManifest.
+	ReportSHA256SUMS ReportFormat = "sha256sums"
+)
+
+// ReportEntry is the outcome of one processed request.
+type ReportEntry struct {
+	Fetcher  string         `json:"fetcher,omitempty"` // Name of the Fetcher, see WithName
+	ID       int            `json:"id"`
+	URL      string         `json:"url"`
+	Path     string         `json:"path"`
+	Size     int64          `json:"size"`
+	Duration time.Duration  `json:"duration"` // Nanoseconds in JSON
+	Speed    float64        `json:"speed"`    // Average bytes per second
+	SHA256   string         `json:"sha256,omitempty"`
+	Status   DownloadStatus `json:"status"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// defaultReportLimit is the number of entries the report keeps by default.
+const defaultReportLimit = 10000
+
+// reportLog collects the outcome of the latest processed requests. Once limit
+// entries are in, each new one replaces the oldest.
+type reportLog struct {
+	mu      sync.Mutex
+	limit   int // 0 keeps every entry
+	entries []ReportEntry
+	oldest  int // Index of the oldest entry once the log is full
+}
+
+func (l *reportLog) add(entry ReportEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit > 0 && len(l.entries) >= l.limit {
+		l.entries[l.oldest] = entry
+		l.oldest = (l.oldest + 1) % len(l.entries)
+		return
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// list returns the entries in the order they were added, emptying the log if
+// reset is set.
+func (l *reportLog) list(reset bool) []ReportEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := append(slices.Clone(l.entries[l.oldest:]), l.entries[:l.oldest]...)
+	if reset {
+		l.entries, l.oldest = nil, 0
+	}
+	return entries
+}
+
+// WithReportLimit keeps the outcome of the latest n requests in the report, 10000
+// by default, so a long-running Fetcher does not hold on to every request it ever
+// processed. n <= 0 keeps them all. Callers that need the full history can collect
+// it with ResetReport.
+func WithReportLimit(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.report.limit = max(0, n)
+	}
+}
+
+// record adds the outcome of a processed request to the report.
+func (f *Fetcher) record(req DownloadRequest, result DownloadResult, err error, duration time.Duration) {
+	entry := ReportEntry{
+		Fetcher:  f.name,
+		ID:       req.ID,
+		URL:      req.URL,
+		Path:     req.FullPath,
+		Duration: duration,
+		Status:   StatusCompleted,
+	}
+	if err != nil {
+		entry.Status = StatusFailed
+		if req.context().Err() != nil {
+			entry.Status = StatusCancelled
+		}
+		entry.Error = err.Error()
+	} else {
+		entry.Path = result.Path
+		entry.Size = result.Size
+		entry.SHA256 = result.SHA256
+		if duration > 0 {
+			entry.Speed = float64(result.Size) / duration.Seconds()
+		}
+	}
+
+	f.report.add(entry)
+}
+
+// ReportEntries returns the outcome of every request processed so far, in
+// completion order, up to the limit set with WithReportLimit.
+func (f *Fetcher) ReportEntries() []ReportEntry {
+	return f.report.list(false)
+}
+
+// ResetReport returns the entries of the report like ReportEntries and empties it,
+// so that a long-running Fetcher can hand them off, e.g. to a file or a database,
+// and later reports, stats and manifests start over.
+func (f *Fetcher) ResetReport() []ReportEntry {
+	return f.report.list(true)
+}
+
+// Report summarizes every request processed so far (URL, path, size, duration,
+// speed, checksum, status) as CSV or JSON, e.g. to attach to a data delivery.
+func (f *Fetcher) Report(format ReportFormat) ([]byte, error) {
+	entries := f.ReportEntries()
+
+	switch format {
+	case ReportJSON:
+		return json.MarshalIndent(entries, "", "  ")
+
+	case ReportCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"id", "url", "path", "size", "duration_seconds", "speed_bps", "sha256", "status", "error"})
+		for _, e := range entries {
+			_ = w.Write([]string{
+				strconv.Itoa(e.ID),
+				e.URL,
+				e.Path,
+				strconv.FormatInt(e.Size, 10),
+				strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64),
+				strconv.FormatFloat(e.Speed, 'f', 0, 64),
+				e.SHA256,
+				string(e.Status),
+				e.Error,
+			})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+
+	case ReportSHA256SUMS:
+		return f.checksumManifest(entries), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// WriteChecksumManifest writes a SHA256SUMS style manifest of every download completed
+// so far into the target directory under name, so recipients of the directory can
+// check it with "sha256sum -c <name>". Call it once a batch is done, e.g. after Wait.
+func (f *Fetcher) WriteChecksumManifest(name string) error {
+	p := f.currentPolicy()
+	path := filepath.Join(p.targetDir, name)
+	if err := ensureDir(path); err != nil {
+		return err
+	}
+	tmpPath := p.stagingPath(path)
+	if err := os.WriteFile(tmpPath, f.checksumManifest(f.ReportEntries()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// checksumManifest formats the completed entries as sha256sum output. A path
+// downloaded more than once is listed with its latest checksum.
+func (f *Fetcher) checksumManifest(entries []ReportEntry) []byte {
+	targetDir := f.currentPolicy().targetDir
+
+	var paths []string
+	sums := make(map[string]string)
+	for _, e := range entries {
+		if e.Status != StatusCompleted || e.SHA256 == "" || strings.HasSuffix(e.Path, ManifestSuffix) {
+			// Split downloads have no file the checksum could be checked against
+			continue
+		}
+		path := e.Path
+		if rel, err := filepath.Rel(targetDir, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+		path = filepath.ToSlash(path)
+		if _, ok := sums[path]; !ok {
+			paths = append(paths, path)
+		}
+		sums[path] = e.SHA256
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[path], path)
+	}
+	return buf.Bytes()
+}
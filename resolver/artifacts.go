@@ -0,0 +1,222 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hritikr/dlfetch"
+)
+
+const (
+	defaultMavenRepo   = "https://repo1.maven.org/maven2"
+	defaultNPMRegistry = "https://registry.npmjs.org"
+	defaultPyPIIndex   = "https://pypi.org/pypi"
+)
+
+// Artifact is a resolved package file together with the checksum published by its registry.
+// The checksum is also set on the request's Vars under the algorithm name, e.g. "sha256".
+type Artifact struct {
+	Request      dlfetch.DownloadRequest
+	ChecksumAlgo string // "sha1", "sha256" or "sha512"
+	Checksum     string // Hex encoded
+}
+
+func newArtifact(coords, downloadURL, fileName, algo, checksum string) Artifact {
+	vars := map[string]string{"coordinates": coords}
+	if checksum != "" {
+		vars[algo] = checksum
+	}
+	return Artifact{
+		Request: dlfetch.DownloadRequest{
+			ID:       requestID(downloadURL),
+			URL:      downloadURL,
+			FileName: fileName,
+			Vars:     vars,
+		},
+		ChecksumAlgo: algo,
+		Checksum:     checksum,
+	}
+}
+
+// Maven resolves Maven coordinates against a Maven 2 layout repository.
+type Maven struct {
+	RepoURL string       // Optional, defaults to Maven Central
+	Client  *http.Client // Optional, defaults to http.DefaultClient
+}
+
+// MavenURL builds the URL of an artifact from "group:artifact:version[:classifier][@extension]"
+// coordinates. The extension defaults to "jar".
+func MavenURL(repoURL, coords string) (string, error) {
+	coords, ext, _ := strings.Cut(coords, "@")
+	if ext == "" {
+		ext = "jar"
+	}
+	parts := strings.Split(coords, ":")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("invalid maven coordinates: %s", coords)
+	}
+	group, artifact, version := parts[0], parts[1], parts[2]
+
+	name := artifact + "-" + version
+	if len(parts) == 4 && parts[3] != "" {
+		name += "-" + parts[3]
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s.%s",
+		strings.TrimSuffix(repoURL, "/"), strings.ReplaceAll(group, ".", "/"), artifact, version, name, ext), nil
+}
+
+// Resolve builds the artifact URL and reads the expected checksum from the
+// ".sha256" sidecar file, falling back to ".sha1" for older artifacts.
+func (m *Maven) Resolve(ctx context.Context, coords string) (Artifact, error) {
+	repo := m.RepoURL
+	if repo == "" {
+		repo = defaultMavenRepo
+	}
+	artifactURL, err := MavenURL(repo, coords)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	var lastErr error
+	for _, algo := range []string{"sha256", "sha1"} {
+		sum, err := getText(ctx, m.Client, artifactURL+"."+algo)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// Sidecars sometimes contain "<hash>  <filename>"
+		fields := strings.Fields(sum)
+		if len(fields) == 0 {
+			lastErr = fmt.Errorf("empty %s checksum at %s", algo, artifactURL+"."+algo)
+			continue
+		}
+		sum = strings.ToLower(fields[0])
+		return newArtifact(coords, artifactURL, artifactURL[strings.LastIndex(artifactURL, "/")+1:], algo, sum), nil
+	}
+	return Artifact{}, fmt.Errorf("no checksum published for %s: %w", coords, lastErr)
+}
+
+// NPM resolves package specs against an npm registry.
+type NPM struct {
+	RegistryURL string       // Optional, defaults to the public npm registry
+	Token       string       // Optional, for private registries
+	Client      *http.Client // Optional, defaults to http.DefaultClient
+}
+
+// Resolve returns the tarball of a "name@version" or "@scope/name@version" spec
+// with the SHA-512 from the registry's integrity field (SHA-1 for old packages).
+func (n *NPM) Resolve(ctx context.Context, spec string) (Artifact, error) {
+	at := strings.LastIndex(spec, "@")
+	if at <= 0 || at == len(spec)-1 {
+		return Artifact{}, fmt.Errorf("invalid npm spec, expected name@version: %s", spec)
+	}
+	name, version := spec[:at], spec[at+1:]
+
+	registry := strings.TrimSuffix(n.RegistryURL, "/")
+	if registry == "" {
+		registry = defaultNPMRegistry
+	}
+	header := http.Header{}
+	if n.Token != "" {
+		header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	var meta struct {
+		Dist struct {
+			Tarball   string `json:"tarball"`
+			Integrity string `json:"integrity"`
+			Shasum    string `json:"shasum"`
+		} `json:"dist"`
+	}
+	metaURL := fmt.Sprintf("%s/%s/%s", registry, url.PathEscape(name), url.PathEscape(version))
+	if err := getJSON(ctx, n.Client, metaURL, header, &meta); err != nil {
+		return Artifact{}, err
+	}
+	if meta.Dist.Tarball == "" {
+		return Artifact{}, fmt.Errorf("no tarball published for %s", spec)
+	}
+
+	fileName := meta.Dist.Tarball[strings.LastIndex(meta.Dist.Tarball, "/")+1:]
+	if algo, sum, ok := strings.Cut(meta.Dist.Integrity, "-"); ok && algo == "sha512" {
+		raw, err := base64.StdEncoding.DecodeString(sum)
+		if err == nil {
+			return newArtifact(spec, meta.Dist.Tarball, fileName, "sha512", hex.EncodeToString(raw)), nil
+		}
+	}
+	return newArtifact(spec, meta.Dist.Tarball, fileName, "sha1", strings.ToLower(meta.Dist.Shasum)), nil
+}
+
+// PyPI resolves releases against the PyPI JSON API.
+type PyPI struct {
+	IndexURL string       // Optional, defaults to https://pypi.org/pypi
+	Client   *http.Client // Optional, defaults to http.DefaultClient
+}
+
+// Resolve returns the files of a "name==version" release whose file names match one of
+// the glob patterns (all wheels and sdists when no pattern is given), with their SHA-256.
+func (p *PyPI) Resolve(ctx context.Context, spec string, patterns ...string) ([]Artifact, error) {
+	name, version, ok := strings.Cut(spec, "==")
+	if !ok || name == "" || version == "" {
+		return nil, fmt.Errorf("invalid pypi spec, expected name==version: %s", spec)
+	}
+
+	index := strings.TrimSuffix(p.IndexURL, "/")
+	if index == "" {
+		index = defaultPyPIIndex
+	}
+
+	var release struct {
+		URLs []struct {
+			URL      string `json:"url"`
+			Filename string `json:"filename"`
+			Digests  struct {
+				SHA256 string `json:"sha256"`
+			} `json:"digests"`
+		} `json:"urls"`
+	}
+	releaseURL := fmt.Sprintf("%s/%s/%s/json", index, url.PathEscape(name), url.PathEscape(version))
+	if err := getJSON(ctx, p.Client, releaseURL, nil, &release); err != nil {
+		return nil, err
+	}
+
+	var artifacts []Artifact
+	for _, file := range release.URLs {
+		ok, err := matchAny(file.Filename, patterns)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			artifacts = append(artifacts, newArtifact(spec, file.URL, file.Filename, "sha256", file.Digests.SHA256))
+		}
+	}
+	return artifacts, nil
+}
+
+// getText fetches a small text document, such as a checksum sidecar file.
+func getText(ctx context.Context, client *http.Client, url string) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request failed: %s, status code: %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return strings.TrimSpace(string(body)), err
+}
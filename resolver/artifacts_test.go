@@ -0,0 +1,136 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMavenURL(t *testing.T) {
+	tests := []struct {
+		coords, want string
+	}{
+		{"org.example:lib:1.0", "https://repo/org/example/lib/1.0/lib-1.0.jar"},
+		{"org.example:lib:1.0:sources", "https://repo/org/example/lib/1.0/lib-1.0-sources.jar"},
+		{"org.example:lib:1.0@pom", "https://repo/org/example/lib/1.0/lib-1.0.pom"},
+		{"org.example:lib:1.0:linux@so", "https://repo/org/example/lib/1.0/lib-1.0-linux.so"},
+		{"org.example:lib", ""},
+		{"org.example::1.0", ""},
+		{"a:b:c:d:e", ""},
+	}
+	for _, tt := range tests {
+		got, err := MavenURL("https://repo/", tt.coords)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("MavenURL(%q) = %q, %v, want %q", tt.coords, got, err, tt.want)
+		}
+	}
+}
+
+func TestMavenResolve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/example/new/1.0/new-1.0.jar.sha256":
+			fmt.Fprint(w, "ABCDEF  new-1.0.jar\n")
+		case "/org/example/old/1.0/old-1.0.jar.sha1":
+			fmt.Fprint(w, "123456")
+		case "/org/example/empty/1.0/empty-1.0.jar.sha256":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	m := &Maven{RepoURL: srv.URL}
+	tests := []struct {
+		coords, algo, sum string
+	}{
+		{"org.example:new:1.0", "sha256", "abcdef"},
+		{"org.example:old:1.0", "sha1", "123456"},
+		{"org.example:empty:1.0", "", ""},
+		{"org.example:missing:1.0", "", ""},
+	}
+	for _, tt := range tests {
+		a, err := m.Resolve(context.Background(), tt.coords)
+		if tt.algo == "" {
+			if err == nil {
+				t.Errorf("resolved %s without a checksum", tt.coords)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.ChecksumAlgo != tt.algo || a.Checksum != tt.sum || a.Request.Vars[tt.algo] != tt.sum || a.Request.Vars["coordinates"] != tt.coords {
+			t.Errorf("resolved %s to %+v", tt.coords, a)
+		}
+	}
+}
+
+func TestNPMResolve(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/@scope%2Fpkg/1.0.0":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "private", http.StatusUnauthorized)
+				return
+			}
+			// base64 of the bytes 0x01 0x02 0x03
+			fmt.Fprintf(w, `{"dist":{"tarball":"%s/@scope/pkg/-/pkg-1.0.0.tgz","integrity":"sha512-AQID","shasum":"ffff"}}`, srv.URL)
+		case "/old/0.1.0":
+			fmt.Fprintf(w, `{"dist":{"tarball":"%s/old/-/old-0.1.0.tgz","shasum":"ABCD"}}`, srv.URL)
+		case "/none/1.0.0":
+			fmt.Fprint(w, `{"dist":{}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	n := &NPM{RegistryURL: srv.URL, Token: "secret"}
+	a, err := n.Resolve(context.Background(), "@scope/pkg@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ChecksumAlgo != "sha512" || a.Checksum != "010203" || a.Request.FileName != "pkg-1.0.0.tgz" {
+		t.Errorf("resolved %+v", a)
+	}
+	if a, err := n.Resolve(context.Background(), "old@0.1.0"); err != nil || a.ChecksumAlgo != "sha1" || a.Checksum != "abcd" {
+		t.Errorf("resolved %+v, %v", a, err)
+	}
+	for _, spec := range []string{"none@1.0.0", "pkg", "@scope/pkg", "pkg@"} {
+		if _, err := n.Resolve(context.Background(), spec); err == nil {
+			t.Errorf("resolved %q", spec)
+		}
+	}
+}
+
+func TestPyPIResolve(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pkg/1.0/json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"urls":[
+			{"url":"https://files/pkg-1.0-py3-none-any.whl","filename":"pkg-1.0-py3-none-any.whl","digests":{"sha256":"aa"}},
+			{"url":"https://files/pkg-1.0.tar.gz","filename":"pkg-1.0.tar.gz","digests":{"sha256":"bb"}}]}`)
+	}))
+	defer srv.Close()
+
+	p := &PyPI{IndexURL: srv.URL}
+	all, err := p.Resolve(context.Background(), "pkg==1.0")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("resolved %+v, %v", all, err)
+	}
+	wheels, err := p.Resolve(context.Background(), "pkg==1.0", "*.whl")
+	if err != nil || len(wheels) != 1 || wheels[0].Checksum != "aa" || wheels[0].Request.URL != "https://files/pkg-1.0-py3-none-any.whl" {
+		t.Errorf("resolved %+v, %v", wheels, err)
+	}
+	for _, spec := range []string{"pkg", "pkg==", "==1.0", "pkg==2.0"} {
+		if _, err := p.Resolve(context.Background(), spec); err == nil {
+			t.Errorf("resolved %q", spec)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hritikr/dlfetch"
+)
+
+const defaultGitHubAPI = "https://api.github.com"
+
+// GitHub resolves GitHub release references into asset download requests.
+type GitHub struct {
+	Token  string       // Optional, required for private repositories
+	Client *http.Client // Optional, defaults to http.DefaultClient
+	APIURL string       // Optional, e.g. a GitHub Enterprise API address
+}
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		ID                 int64  `json:"id"`
+		Name               string `json:"name"`
+		ContentType        string `json:"content_type"`
+		URL                string `json:"url"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// Resolve expands a release reference into one request per asset whose name matches
+// one of the glob patterns (all assets when no pattern is given). Accepted references:
+//
+//	github://owner/repo@v1.2.3
+//	github://owner/repo                         (latest release)
+//	https://github.com/owner/repo/releases/tag/v1.2.3
+//	https://github.com/owner/repo/releases/latest
+//
+// Request IDs are the asset IDs. Without a Token the public download URLs are used.
+// With a Token, assets are fetched through the API and the resulting request URLs are
+// the pre-signed storage locations GitHub redirects to, which expire after a few
+// minutes, so enqueue them right away.
+func (g *GitHub) Resolve(ctx context.Context, ref string, patterns ...string) ([]dlfetch.DownloadRequest, error) {
+	owner, repo, tag, err := parseGitHubRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	api := strings.TrimSuffix(g.APIURL, "/")
+	if api == "" {
+		api = defaultGitHubAPI
+	}
+	releaseURL := fmt.Sprintf("%s/repos/%s/%s/releases/latest", api, url.PathEscape(owner), url.PathEscape(repo))
+	if tag != "" {
+		releaseURL = fmt.Sprintf("%s/repos/%s/%s/releases/tags/%s", api, url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(tag))
+	}
+
+	var release githubRelease
+	if err := getJSON(ctx, g.Client, releaseURL, g.header("application/vnd.github+json"), &release); err != nil {
+		return nil, err
+	}
+
+	var reqs []dlfetch.DownloadRequest
+	for _, asset := range release.Assets {
+		ok, err := matchAny(asset.Name, patterns)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		downloadURL := asset.BrowserDownloadURL
+		if g.Token != "" {
+			if downloadURL, err = g.signedAssetURL(ctx, asset.URL); err != nil {
+				return nil, err
+			}
+		}
+
+		reqs = append(reqs, dlfetch.DownloadRequest{
+			ID:       int(asset.ID),
+			URL:      downloadURL,
+			FileName: asset.Name,
+			MimeType: asset.ContentType,
+			Vars: map[string]string{
+				"owner": owner,
+				"repo":  repo,
+				"tag":   release.TagName,
+			},
+		})
+	}
+	return reqs, nil
+}
+
+// signedAssetURL asks the API for the binary of an asset and returns the
+// pre-signed location it redirects to, so the token never leaves GitHub.
+func (g *GitHub) signedAssetURL(ctx context.Context, assetURL string) (string, error) {
+	client := http.DefaultClient
+	if g.Client != nil {
+		client = g.Client
+	}
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = g.header("application/octet-stream")
+
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusFound, http.StatusMovedPermanently, http.StatusSeeOther, http.StatusTemporaryRedirect:
+		location, err := resp.Location()
+		if err != nil {
+			return "", err
+		}
+		return location.String(), nil
+	case http.StatusOK:
+		// Served directly (e.g. some Enterprise setups), needs the token per request
+		return "", errors.New("asset is not served through a redirect, cannot download without auth headers: " + assetURL)
+	default:
+		return "", fmt.Errorf("failed to resolve asset: %s, status code: %d", assetURL, resp.StatusCode)
+	}
+}
+
+func (g *GitHub) header(accept string) http.Header {
+	h := http.Header{}
+	h.Set("Accept", accept)
+	h.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.Token != "" {
+		h.Set("Authorization", "Bearer "+g.Token)
+	}
+	return h
+}
+
+// parseGitHubRef splits a github:// reference or release page URL into its parts.
+// An empty tag means the latest release.
+func parseGitHubRef(ref string) (owner, repo, tag string, err error) {
+	invalid := fmt.Errorf("invalid github release reference: %s", ref)
+
+	if rest, ok := strings.CutPrefix(ref, "github://"); ok {
+		rest, tag, _ = strings.Cut(rest, "@")
+		owner, repo, ok = strings.Cut(rest, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return "", "", "", invalid
+		}
+		return owner, repo, tag, nil
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil || u.Host != "github.com" {
+		return "", "", "", invalid
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[2] == "releases" && parts[3] == "latest":
+		return parts[0], parts[1], "", nil
+	case len(parts) >= 5 && parts[2] == "releases" && parts[3] == "tag":
+		return parts[0], parts[1], strings.Join(parts[4:], "/"), nil
+	}
+	return "", "", "", invalid
+}
@@ -0,0 +1,84 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseGitHubRef(t *testing.T) {
+	tests := []struct {
+		ref              string
+		owner, repo, tag string
+		ok               bool
+	}{
+		{"github://owner/repo@v1.2.3", "owner", "repo", "v1.2.3", true},
+		{"github://owner/repo", "owner", "repo", "", true},
+		{"https://github.com/owner/repo/releases/tag/v1.2.3", "owner", "repo", "v1.2.3", true},
+		{"https://github.com/owner/repo/releases/tag/release/2024", "owner", "repo", "release/2024", true},
+		{"https://github.com/owner/repo/releases/latest", "owner", "repo", "", true},
+		{"github://owner", "", "", "", false},
+		{"github://owner/", "", "", "", false},
+		{"github://owner/repo/extra", "", "", "", false},
+		{"https://github.com/owner/repo", "", "", "", false},
+		{"https://gitlab.com/owner/repo/releases/latest", "", "", "", false},
+	}
+	for _, tt := range tests {
+		owner, repo, tag, err := parseGitHubRef(tt.ref)
+		if (err == nil) != tt.ok || owner != tt.owner || repo != tt.repo || tag != tt.tag {
+			t.Errorf("parseGitHubRef(%q) = %q, %q, %q, %v", tt.ref, owner, repo, tag, err)
+		}
+	}
+}
+
+func TestGitHubResolve(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo/releases/tags/v1":
+			fmt.Fprintf(w, `{"tag_name":"v1","assets":[
+				{"id":11,"name":"tool-linux.tar.gz","content_type":"application/gzip","url":"%[1]s/assets/11","browser_download_url":"%[1]s/download/tool-linux.tar.gz"},
+				{"id":12,"name":"checksums.txt","content_type":"text/plain","url":"%[1]s/assets/12","browser_download_url":"%[1]s/download/checksums.txt"}]}`, srv.URL)
+		case "/assets/11":
+			if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/octet-stream" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "https://objects.example.com/11?sig=abc", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	g := &GitHub{APIURL: srv.URL + "/"}
+	reqs, err := g.Resolve(context.Background(), "github://owner/repo@v1", "*.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"owner": "owner", "repo": "repo", "tag": "v1"}
+	if len(reqs) != 1 || reqs[0].ID != 11 || reqs[0].URL != srv.URL+"/download/tool-linux.tar.gz" ||
+		reqs[0].FileName != "tool-linux.tar.gz" || reqs[0].MimeType != "application/gzip" || !reflect.DeepEqual(reqs[0].Vars, want) {
+		t.Fatalf("resolved %+v", reqs)
+	}
+
+	// With a token the pre-signed location is used, the token stays with the API
+	g.Token = "secret"
+	reqs, err = g.Resolve(context.Background(), "github://owner/repo@v1", "tool-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 || reqs[0].URL != "https://objects.example.com/11?sig=abc" {
+		t.Errorf("resolved %+v with a token", reqs)
+	}
+
+	if _, err := g.Resolve(context.Background(), "github://owner/repo@v1", "["); err == nil {
+		t.Error("resolved with an invalid pattern")
+	}
+	if _, err := g.Resolve(context.Background(), "github://owner/missing@v1"); err == nil {
+		t.Error("resolved a missing release")
+	}
+}
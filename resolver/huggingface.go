@@ -0,0 +1,211 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/hritikr/dlfetch"
+)
+
+const defaultHuggingFaceEndpoint = "https://huggingface.co"
+
+// HuggingFace resolves Hugging Face Hub repositories into file download requests.
+type HuggingFace struct {
+	Token    string       // Optional, required for private and gated repositories
+	Client   *http.Client // Optional, defaults to http.DefaultClient
+	Endpoint string       // Optional, e.g. a mirror of the hub
+}
+
+type hfTreeEntry struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *struct {
+		OID string `json:"oid"`
+	} `json:"lfs"`
+}
+
+// Resolve lists the files of a repository and returns one request per file whose
+// path matches one of the glob patterns (all files when no pattern is given).
+// References have the form "hf://[datasets/|spaces/]owner/name[@revision]", e.g.
+// "hf://datasets/owner/corpus@main". The repository layout is kept through the
+// request Path, and the SHA-256 of LFS files is available as the "sha256" var.
+//
+// The hub only accepts the token on its own host, so configure the Fetcher with
+// an HTTP client using Transport to authenticate the downloads.
+func (h *HuggingFace) Resolve(ctx context.Context, ref string, patterns ...string) ([]dlfetch.DownloadRequest, error) {
+	kind, repo, revision, err := parseHuggingFaceRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := h.endpoint()
+	apiKind := "models"
+	urlPrefix := ""
+	if kind != "" {
+		apiKind = kind
+		urlPrefix = kind + "/"
+	}
+
+	next := fmt.Sprintf("%s/api/%s/%s/tree/%s?recursive=true", endpoint, apiKind, repo, url.PathEscape(revision))
+
+	var reqs []dlfetch.DownloadRequest
+	for next != "" {
+		var entries []hfTreeEntry
+		if next, err = h.getPage(ctx, next, &entries); err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.Type != "file" {
+				continue
+			}
+			ok, err := matchAny(e.Path, patterns)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			dir, name := path.Split(e.Path)
+			vars := map[string]string{
+				"repo":     repo,
+				"revision": revision,
+			}
+			if e.LFS != nil {
+				vars["sha256"] = e.LFS.OID
+			}
+
+			reqs = append(reqs, dlfetch.DownloadRequest{
+				ID:       requestID(ref, e.Path),
+				URL:      fmt.Sprintf("%s/%s%s/resolve/%s/%s", endpoint, urlPrefix, repo, url.PathEscape(revision), escapePath(e.Path)),
+				FileName: name,
+				Path:     strings.TrimSuffix(dir, "/"),
+				Vars:     vars,
+			})
+		}
+	}
+	return reqs, nil
+}
+
+// getPage fetches one page of the tree listing and returns the URL of the next page, if any.
+func (h *HuggingFace) getPage(ctx context.Context, pageURL string, v any) (string, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("request failed: %s, status code: %d, body: %s", pageURL, resp.StatusCode, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextLink(resp.Header.Get("Link")), nil
+}
+
+// Transport returns a RoundTripper that adds the token to requests for the hub's
+// host only, so it is not leaked to the CDN hosts large files redirect to.
+// A nil base uses http.DefaultTransport.
+func (h *HuggingFace) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	host := ""
+	if u, err := url.Parse(h.endpoint()); err == nil {
+		host = u.Host
+	}
+	return &bearerTransport{base: base, host: host, token: h.Token}
+}
+
+func (h *HuggingFace) endpoint() string {
+	if h.Endpoint == "" {
+		return defaultHuggingFaceEndpoint
+	}
+	return strings.TrimSuffix(h.Endpoint, "/")
+}
+
+// bearerTransport authenticates requests to a single host.
+type bearerTransport struct {
+	base  http.RoundTripper
+	host  string
+	token string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" || req.URL.Host != t.host || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// parseHuggingFaceRef splits "hf://[datasets/|spaces/]owner/name[@revision]".
+// kind is empty for models and revision defaults to "main".
+func parseHuggingFaceRef(ref string) (kind, repo, revision string, err error) {
+	rest, ok := strings.CutPrefix(ref, "hf://")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid hugging face reference: %s", ref)
+	}
+	rest, revision, _ = strings.Cut(rest, "@")
+	if revision == "" {
+		revision = "main"
+	}
+	for _, k := range []string{"datasets", "spaces", "models"} {
+		if r, ok := strings.CutPrefix(rest, k+"/"); ok {
+			kind, rest = k, r
+			break
+		}
+	}
+	if kind == "models" {
+		kind = ""
+	}
+	if strings.Count(rest, "/") > 1 || strings.HasPrefix(rest, "/") || strings.HasSuffix(rest, "/") || rest == "" {
+		return "", "", "", fmt.Errorf("invalid hugging face reference: %s", ref)
+	}
+	return kind, rest, revision, nil
+}
+
+// nextLink returns the URL with rel="next" from a Link header.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(target), "<>")
+	}
+	return ""
+}
+
+// escapePath escapes each segment of a slash separated path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
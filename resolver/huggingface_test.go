@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHuggingFaceRef(t *testing.T) {
+	tests := []struct {
+		ref                  string
+		kind, repo, revision string
+		ok                   bool
+	}{
+		{"hf://owner/model", "", "owner/model", "main", true},
+		{"hf://models/owner/model@v2", "", "owner/model", "v2", true},
+		{"hf://datasets/owner/corpus@refs/convert/parquet", "datasets", "owner/corpus", "refs/convert/parquet", true},
+		{"hf://spaces/owner/app", "spaces", "owner/app", "main", true},
+		{"hf://gpt2", "", "gpt2", "main", true},
+		{"hf://owner/model/extra", "", "", "", false},
+		{"hf://datasets/", "", "", "", false},
+		{"hf:///owner", "", "", "", false},
+		{"https://huggingface.co/owner/model", "", "", "", false},
+	}
+	for _, tt := range tests {
+		kind, repo, revision, err := parseHuggingFaceRef(tt.ref)
+		if (err == nil) != tt.ok || kind != tt.kind || repo != tt.repo || (tt.ok && revision != tt.revision) {
+			t.Errorf("parseHuggingFaceRef(%q) = %q, %q, %q, %v", tt.ref, kind, repo, revision, err)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{`<https://h/api?cursor=2>; rel="next"`, "https://h/api?cursor=2"},
+		{`<https://h/prev>; rel="prev", <https://h/next>; rel="next"`, "https://h/next"},
+		{`<https://h/last>; rel="last"`, ""},
+	}
+	for _, tt := range tests {
+		if got := nextLink(tt.header); got != tt.want {
+			t.Errorf("nextLink(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHuggingFaceResolve(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "gated", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/datasets/owner/corpus/tree/v1?recursive=true&cursor=2>; rel="next"`, srv.URL))
+			fmt.Fprint(w, `[{"type":"directory","path":"data"},{"type":"file","path":"README.md","size":10}]`)
+		case "2":
+			fmt.Fprint(w, `[{"type":"file","path":"data/train 1.parquet","size":99,"lfs":{"oid":"abc123"}}]`)
+		}
+	}))
+	defer srv.Close()
+
+	h := &HuggingFace{Token: "secret", Endpoint: srv.URL}
+	reqs, err := h.Resolve(context.Background(), "hf://datasets/owner/corpus@v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("resolved %+v", reqs)
+	}
+	req := reqs[1]
+	if req.URL != srv.URL+"/datasets/owner/corpus/resolve/v1/data/train%201.parquet" || req.FileName != "train 1.parquet" ||
+		req.Path != "data" || req.Vars["sha256"] != "abc123" || req.Vars["revision"] != "v1" {
+		t.Errorf("resolved %+v", req)
+	}
+	if reqs[0].Path != "" || reqs[0].Vars["sha256"] != "" {
+		t.Errorf("resolved %+v", reqs[0])
+	}
+	if reqs[0].ID == reqs[1].ID {
+		t.Error("files share a request ID")
+	}
+
+	reqs, err = h.Resolve(context.Background(), "hf://datasets/owner/corpus@v1", "data/*")
+	if err != nil || len(reqs) != 1 {
+		t.Errorf("resolved %+v with a pattern, %v", reqs, err)
+	}
+	h.Token = ""
+	if _, err := h.Resolve(context.Background(), "hf://datasets/owner/corpus@v1"); err == nil {
+		t.Error("resolved a gated repository without a token")
+	}
+}
+
+func TestHuggingFaceTransport(t *testing.T) {
+	var got http.Header
+	base := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	transport := (&HuggingFace{Token: "secret", Endpoint: "https://hub.example.com"}).Transport(base)
+
+	tests := []struct {
+		url, auth, want string
+	}{
+		{"https://hub.example.com/owner/model/resolve/main/x", "", "Bearer secret"},
+		{"https://cdn.example.com/x", "", ""},
+		{"https://hub.example.com/x", "Basic other", "Basic other"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if auth := got.Get("Authorization"); auth != tt.want {
+			t.Errorf("%s sent with Authorization %q, want %q", tt.url, auth, tt.want)
+		}
+		if tt.auth == "" && req.Header.Get("Authorization") != "" {
+			t.Errorf("%s: the request was modified", tt.url)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,72 @@
+// Package resolver expands references to hosted content, such as GitHub
+// releases, into ready-to-enqueue dlfetch download requests.
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"path"
+)
+
+// requestID derives a stable request ID from the given parts, for
+// resolvers whose sources have no numeric identifiers.
+func requestID(parts ...string) int {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return int(h.Sum64() & math.MaxInt32)
+}
+
+// matchAny reports whether name matches one of the glob patterns.
+// No patterns matches everything.
+func matchAny(name string, patterns []string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, p := range patterns {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getJSON fetches url and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		for _, value := range values {
+			req.Header.Add(k, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("request failed: %s, status code: %d, body: %s", url, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
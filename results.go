@@ -0,0 +1,45 @@
+package dlfetch
+
+// DownloadOutcome is the outcome of a processed request as delivered by Results.
+// Err is nil for completed downloads, in which case Result is set.
+type DownloadOutcome struct {
+	Fetcher string // Name of the Fetcher, see WithName
+	Request DownloadRequest
+	Result  DownloadResult
+	Err     error
+}
+
+// Results returns a channel that receives the outcome of every processed request,
+// as an alternative to onComplete and onError for callers that prefer select loops.
+// Outcomes are only delivered once Results has been called, in the same order as the
+// callbacks. The channel must be read continuously: like a slow callback, a full channel
+// holds up the workers. It is closed by Stop; calling Results after Stop returns a new one.
+func (f *Fetcher) Results() <-chan DownloadOutcome {
+	f.resultsMu.Lock()
+	defer f.resultsMu.Unlock()
+	if f.results == nil {
+		f.results = make(chan DownloadOutcome, defaultQueueSize)
+	}
+	return f.results
+}
+
+// publish sends an outcome to the Results channel if there is one. Outcomes are
+// not only published by workers but also by Cancel for paused requests, which can
+// run while Stop closes the channel; the read lock keeps it open during the send.
+func (f *Fetcher) publish(outcome DownloadOutcome) {
+	f.resultsMu.RLock()
+	defer f.resultsMu.RUnlock()
+	if f.results != nil {
+		f.results <- outcome
+	}
+}
+
+// closeResults closes the Results channel once no worker can publish to it anymore.
+func (f *Fetcher) closeResults() {
+	f.resultsMu.Lock()
+	defer f.resultsMu.Unlock()
+	if f.results != nil {
+		close(f.results)
+		f.results = nil
+	}
+}
@@ -0,0 +1,206 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// validatorSuffix names the file next to a staging file that holds the validator
+// of the response the staging file was written from.
+const validatorSuffix = ".validator"
+
+// openDownload requests the file, or only the byte range rng of it if set, resuming
+// from a staging file left behind by an interrupted download when there is one. The
+// partial file is continued with a Range request that carries the ETag or
+// Last-Modified of the response it was written from as If-Range, so a remote file
+// that changed since is sent in full; when the server ignores the range, rejects it
+// or answers with a different one, the download starts over, as does a partial
+// file without a validator. Servers that ignore the range of rng get the rest of the
+// body skipped and cut to the range. headers are sent along with every request.
+// It returns the response and the offset its body starts at in the staging file.
+func (f *Fetcher) openDownload(ctx context.Context, url, tmpPath string, rng *ByteRange, headers map[string]string) (*http.Response, int64, error) {
+	var offset int64
+	var validator string
+	if info, err := os.Stat(tmpPath); err == nil && info.Mode().IsRegular() {
+		offset = info.Size()
+		validator = loadValidator(tmpPath)
+	}
+	if rng != nil && rng.Length > 0 && offset >= rng.Length || validator == "" {
+		offset = 0
+	}
+
+	for {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		setHeaders(httpReq, headers)
+		from := offset
+		if rng != nil {
+			from += rng.Offset
+		}
+		if from > 0 || rng != nil {
+			end := ""
+			if rng != nil && rng.Length > 0 {
+				end = strconv.FormatInt(rng.Offset+rng.Length-1, 10)
+			}
+			httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", from, end))
+			if offset > 0 {
+				httpReq.Header.Set("If-Range", validator)
+			}
+		}
+
+		resp, err := f.requestClient.Do(httpReq)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if from == 0 && rng == nil {
+			saveValidator(tmpPath, resp)
+			return resp, 0, nil
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			if start, ok := parseContentRangeStart(resp.Header.Get("Content-Range")); ok && start == from {
+				if offset == 0 {
+					saveValidator(tmpPath, resp)
+				}
+				return resp, offset, nil
+			}
+			if offset == 0 {
+				resp.Body.Close()
+				return nil, 0, fmt.Errorf("unexpected content range: %q, requested start: %d", resp.Header.Get("Content-Range"), from)
+			}
+		case http.StatusRequestedRangeNotSatisfiable:
+			if offset == 0 {
+				// The requested range itself is outside the file
+				return resp, 0, nil
+			}
+			// The partial file does not fit the remote file anymore
+		case http.StatusOK:
+			// No range support or a changed file, the full body follows
+			saveValidator(tmpPath, resp)
+			if rng != nil {
+				if err := cutToRange(resp, *rng); err != nil {
+					resp.Body.Close()
+					return nil, 0, err
+				}
+			}
+			return resp, 0, nil
+		default:
+			return resp, 0, nil
+		}
+
+		resp.Body.Close()
+		offset = 0
+	}
+}
+
+// responseValidator returns the ETag of resp, or its Last-Modified if the ETag is
+// missing or weak, which If-Range does not accept.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// saveValidator stores the validator of resp next to the staging file written
+// from it, or removes a stale one if resp has none. An empty tmpPath means there is
+// no staging file.
+func saveValidator(tmpPath string, resp *http.Response) {
+	if tmpPath == "" {
+		return
+	}
+	if v := responseValidator(resp); v != "" && resp.StatusCode < 300 {
+		_ = os.WriteFile(tmpPath+validatorSuffix, []byte(v), 0644)
+		return
+	}
+	_ = os.Remove(tmpPath + validatorSuffix)
+}
+
+// loadValidator returns the validator stored next to the staging file, empty if
+// there is none.
+func loadValidator(tmpPath string) string {
+	data, err := os.ReadFile(tmpPath + validatorSuffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// removeStaging removes a staging file along with its validator.
+func removeStaging(tmpPath string) {
+	_ = os.Remove(tmpPath)
+	_ = os.Remove(tmpPath + validatorSuffix)
+}
+
+// cutToRange makes a full response body look like a response to the range request.
+func cutToRange(resp *http.Response, rng ByteRange) error {
+	if _, err := io.CopyN(io.Discard, resp.Body, rng.Offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &HTTPStatusError{URL: resp.Request.URL.String(), StatusCode: http.StatusRequestedRangeNotSatisfiable}
+		}
+		return err
+	}
+
+	remaining := int64(-1)
+	if resp.ContentLength >= 0 {
+		remaining = resp.ContentLength - rng.Offset
+	}
+	if rng.Length > 0 {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, rng.Length), resp.Body}
+		if remaining < 0 || rng.Length < remaining {
+			remaining = rng.Length
+		}
+	}
+	resp.ContentLength = remaining
+	resp.Header.Del("Content-Length")
+	return nil
+}
+
+// openStaging opens the staging file for writing from offset, feeding the
+// bytes already on disk into h so the checksum covers the whole file.
+func openStaging(tmpPath string, offset int64, h io.Writer) (*os.File, error) {
+	if offset == 0 {
+		return os.Create(tmpPath)
+	}
+
+	out, err := os.OpenFile(tmpPath, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(h, out, offset); err != nil {
+		out.Close()
+		return nil, err
+	}
+	// Anything past offset was not part of the response, drop it
+	if err := out.Truncate(offset); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+// canResume reports whether a partial download of resp can be continued later.
+func canResume(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusPartialContent {
+		return true
+	}
+	for _, unit := range strings.Split(resp.Header.Get("Accept-Ranges"), ",") {
+		if strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+			return true
+		}
+	}
+	return false
+}
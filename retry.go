@@ -0,0 +1,98 @@
+package dlfetch
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// WithRetries retries downloads that fail with a transient error (timeouts, dropped
+// connections, 429 and 5xx responses) up to n times, waiting 1s, 2s, 4s... up to
+// 30s in between. Retries continue from the partial file where the server supports
+// it. The worker waits with the request, callbacks only see the final outcome.
+// Presets can set their own count, see Preset.Retries.
+func WithRetries(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.retries = n
+	}
+}
+
+// RetryBudget caps the retries of a group of requests, e.g. all requests of one batch,
+// so a systematically broken server does not cause a retry for every single file.
+// Assign the same budget to the requests through DownloadRequest.RetryBudget; once it
+// is used up their failures are final. It is safe for concurrent use.
+type RetryBudget struct {
+	mu   sync.Mutex
+	left int
+}
+
+// NewRetryBudget creates a budget that allows max retries in total.
+func NewRetryBudget(max int) *RetryBudget {
+	return &RetryBudget{left: max}
+}
+
+// Remaining returns how many retries are left.
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.left
+}
+
+// take uses up one retry, reporting false when none are left.
+func (b *RetryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.left <= 0 {
+		return false
+	}
+	b.left--
+	return true
+}
+
+// retryDelay returns how long to wait before the given retry, starting at 1.
+func retryDelay(retry int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < retry && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// awaitRetry decides whether the failed attempt is retried and waits out the delay.
+// It returns false when the failure is final; err is then the error to report, which
+// changes when the request was cancelled or paused while waiting.
+func (f *Fetcher) awaitRetry(req DownloadRequest, attempt int, err error) (bool, error) {
+	ctx := req.context()
+	if attempt > f.retriesFor(req) || ctx.Err() != nil || !isCongestionError(err) {
+		return false, err
+	}
+	if req.RetryBudget != nil && !req.RetryBudget.take() {
+		return false, err
+	}
+
+	delay := retryDelay(attempt)
+	f.logRequest(req).Warn("download failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+	f.monitor.markAsPending(req.ID)
+	f.activity.setStage(req.activity, stageRetry)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		if !isPaused(req) {
+			removeStaging(f.currentPolicy().stagingPath(req.FullPath))
+		}
+		return false, f.fail(req, err)
+	}
+
+	// The path was released when the attempt failed
+	if claimErr := f.claimPath(req.FullPath); claimErr != nil {
+		f.monitor.markAsFailed(req.ID, err)
+		return false, err
+	}
+	return true, nil
+}
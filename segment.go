@@ -0,0 +1,180 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// minSegmentSize keeps segments large enough that the extra requests pay off.
+const minSegmentSize = 1 << 20
+
+// maxSegmentSize is the default upper bound of the segment size WithAutoTune finds.
+const maxSegmentSize = 64 << 20
+
+// errRemoteChanged is returned when a segment comes from another version of the
+// file than the response the download started with.
+var errRemoteChanged = errors.New("remote file changed during the download")
+
+// WithSegments splits large downloads into up to n byte ranges that are fetched
+// over concurrent connections and written into a preallocated file. This speeds up
+// servers that throttle each connection. Only responses that advertise range support,
+// have a known size of at least 1 MiB per segment and an ETag or Last-Modified header
+// are split: the segments are requested with it as If-Range, and the download fails
+// if the file changed in the meantime.
+func WithSegments(n int) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.segments = n
+	}
+}
+
+// WithSegmentSizeBounds bounds the size per segment that WithAutoTune finds for
+// each host, 1 MiB and 64 MiB by default. A download is split only into as many
+// segments as it has room for at that size.
+func WithSegmentSizeBounds(minSize, maxSize int64) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.minSegment = max(1, minSize)
+		f.policy.maxSegment = max(f.policy.minSegment, maxSize)
+	}
+}
+
+// segmentBounds returns the bounds of the tuned segment size.
+func (p policy) segmentBounds() (lo, hi int64) {
+	if p.minSegment == 0 {
+		return minSegmentSize, maxSegmentSize
+	}
+	return p.minSegment, p.maxSegment
+}
+
+// segmentCount returns how many segments of at least segmentSize bytes to download
+// resp in, 1 meaning a plain download.
+func (p policy) segmentCount(resp *http.Response, offset, segmentSize int64) int {
+	if p.segments < 2 || offset > 0 || resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 || !canResume(resp) || responseValidator(resp) == "" {
+		return 1
+	}
+	return int(max(1, min(int64(p.segments), resp.ContentLength/segmentSize)))
+}
+
+// segmentSize returns the smallest segment worth its own connection to host.
+func (f *Fetcher) segmentSize(p policy, host string) int64 {
+	if f.hostLimiter == nil || !f.hostLimiter.autoTune {
+		return minSegmentSize
+	}
+	lo, hi := p.segmentBounds()
+	return f.hostLimiter.segmentSize(host, lo, hi)
+}
+
+// tuneSegments feeds a transfer of bytes over the given number of connections
+// into the segment size of host, when tuning.
+func (f *Fetcher) tuneSegments(p policy, host string, bytes int64, connections int, elapsed time.Duration, err error) {
+	if f.hostLimiter == nil || !f.hostLimiter.autoTune {
+		return
+	}
+	lo, hi := p.segmentBounds()
+	f.hostLimiter.tuneSegments(host, bytes, connections, elapsed, err, lo, hi)
+}
+
+// downloadSegments writes size bytes into out in n segments. The first segment is
+// read from resp, which is already open, the others are fetched with range requests.
+// Progress is reported to progress, which is called from several goroutines in turn.
+func (f *Fetcher) downloadSegments(ctx context.Context, url string, headers map[string]string, resp *http.Response, out *os.File, size int64, n int, progress io.Writer, limits []*tokenBucket, sparse *sparseTracker) error {
+	if err := out.Truncate(size); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Unblock the first segment when another one fails
+	stop := context.AfterFunc(ctx, func() { resp.Body.Close() })
+	defer stop()
+
+	progress = &lockedWriter{w: progress}
+	segLen := (size + int64(n) - 1) / int64(n)
+	validator := responseValidator(resp)
+
+	errs := make(chan error, n)
+	go func() {
+		errs <- copySegment(out, throttle(ctx, resp.Body, limits), 0, min(segLen, size), progress, sparse)
+	}()
+	for i := 1; i < n; i++ {
+		start := int64(i) * segLen
+		length := min(segLen, size-start)
+		go func() {
+			errs <- f.fetchSegment(ctx, url, headers, validator, out, start, length, progress, limits, sparse)
+		}()
+	}
+
+	var firstErr error
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	return firstErr
+}
+
+// fetchSegment downloads length bytes starting at start into the same range of out.
+// The range is only accepted from the version of the file validator identifies.
+func (f *Fetcher) fetchSegment(ctx context.Context, url string, headers map[string]string, validator string, out *os.File, start, length int64, progress io.Writer, limits []*tokenBucket, sparse *sparseTracker) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	setHeaders(httpReq, headers)
+	httpReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	httpReq.Header.Set("If-Range", validator)
+
+	resp, err := f.requestClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// The whole file is sent when it no longer matches If-Range
+		return fmt.Errorf("%w: segment at %d answered with the whole file", errRemoteChanged, start)
+	case resp.StatusCode != http.StatusPartialContent:
+		return &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	if v := responseValidator(resp); v != "" && v != validator {
+		return fmt.Errorf("%w: segment at %d is of %s, not %s", errRemoteChanged, start, v, validator)
+	}
+	if got, ok := parseContentRangeStart(resp.Header.Get("Content-Range")); !ok || got != start {
+		return fmt.Errorf("unexpected content range for segment at %d: %q", start, resp.Header.Get("Content-Range"))
+	}
+
+	return copySegment(out, throttle(ctx, resp.Body, limits), start, length, progress, sparse)
+}
+
+// copySegment copies exactly length bytes from r to out at offset start, marking
+// them in sparse if it is set.
+func copySegment(out *os.File, r io.Reader, start, length int64, progress io.Writer, sparse *sparseTracker) error {
+	w := &sparseWriter{w: io.NewOffsetWriter(out, start), tracker: sparse, offset: start}
+	n, err := io.Copy(w, io.TeeReader(io.LimitReader(r, length), progress))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// lockedWriter serializes writes from concurrent segments.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
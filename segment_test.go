@@ -0,0 +1,76 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSegmentsChangedFile(t *testing.T) {
+	v1 := bytes.Repeat([]byte("1"), 4<<20)
+	v2 := bytes.Repeat([]byte("2"), 4<<20)
+	serve := func(ifRange bool) http.HandlerFunc {
+		var requests atomic.Int32
+		return func(w http.ResponseWriter, r *http.Request) {
+			// The file is replaced after the first request
+			content, etag := v2, `"v2"`
+			if requests.Add(1) == 1 {
+				content, etag = v1, `"v1"`
+			}
+			if !ifRange {
+				r.Header.Del("If-Range")
+			}
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+		}
+	}
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"If-Range honored": serve(true),
+		"If-Range ignored": serve(false),
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			dir := t.TempDir()
+			f := New(WithTargetDir(dir), WithSegments(4))
+			_, err := f.Download(context.Background(), DownloadRequest{URL: srv.URL, FileName: "file"})
+			if !errors.Is(err, errRemoteChanged) {
+				t.Fatalf("download of a changing file: %v", err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("left %d files behind", len(entries))
+			}
+		})
+	}
+
+	// Without a validator the response is not split
+	var ranges atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(v1))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	f := New(WithTargetDir(dir), WithSegments(4))
+	if _, err := f.Download(context.Background(), DownloadRequest{URL: srv.URL, FileName: "file"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := ranges.Load(); n != 0 {
+		t.Errorf("sent %d range requests without a validator", n)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file"))
+	if err != nil || strings.Trim(string(got), "1") != "" || len(got) != len(v1) {
+		t.Errorf("downloaded %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package service runs a dlfetch.Fetcher as a long-running system service. Under
+// systemd it reports readiness and stopping and pings the watchdog; under the
+// Windows service control manager it runs as a service. In both cases it drains
+// the Fetcher gracefully when the host asks it to stop.
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+// Options configures Run.
+type Options struct {
+	// Name is the name the service is installed under with the Windows service
+	// control manager. It is not used elsewhere.
+	Name string
+
+	// StopTimeout bounds how long running downloads may take to finish on
+	// shutdown, 0 waits for them. Downloads cut off are resumed from their
+	// partial file the next time they are enqueued.
+	StopTimeout time.Duration
+
+	// Health, if set, is consulted before every watchdog ping. While it returns
+	// an error no pings are sent, so a service configured with WatchdogSec is
+	// restarted by systemd once it stays unhealthy.
+	Health func() error
+}
+
+// Run starts f and keeps it running until ctx is cancelled or the host asks the
+// service to stop: SIGINT or SIGTERM, or a stop or shutdown request of the Windows
+// service control manager. It then stops accepting requests, waits for the queued
+// and running ones as Drain does, within StopTimeout, and returns. Outside of
+// systemd or the service control manager it runs like a plain console program.
+func Run(ctx context.Context, f *dlfetch.Fetcher, opts Options) error {
+	return run(ctx, f, opts)
+}
+
+// notifier tells the service manager about state changes.
+type notifier interface {
+	ready()
+	stopping()
+	alive()                          // Watchdog ping
+	watchdogInterval() time.Duration // How often alive is expected, 0 when not at all
+}
+
+// serve runs f until ctx is done or a stop signal arrives, then drains it.
+func serve(ctx context.Context, f *dlfetch.Fetcher, opts Options, n notifier) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	f.Start()
+	n.ready()
+
+	var ping <-chan time.Time
+	if interval := n.watchdogInterval(); interval > 0 {
+		// Ping twice per interval, as systemd recommends
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case <-ping:
+			if opts.Health == nil || opts.Health() == nil {
+				n.alive()
+			}
+		case <-ctx.Done():
+			n.stopping()
+			return drain(f, opts.StopTimeout)
+		}
+	}
+}
+
+// drain waits for f to drain, for at most timeout if it is positive.
+func drain(f *dlfetch.Fetcher, timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		f.Drain()
+		close(done)
+	}()
+	if timeout <= 0 {
+		<-done
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("downloads still running after %s", timeout)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+// recorder is a notifier that records the state changes.
+type recorder struct {
+	mu       sync.Mutex
+	events   []string
+	pings    atomic.Int32
+	interval time.Duration
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) ready()                          { r.record("ready") }
+func (r *recorder) stopping()                       { r.record("stopping") }
+func (r *recorder) alive()                          { r.pings.Add(1) }
+func (r *recorder) watchdogInterval() time.Duration { return r.interval }
+
+func TestServe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	var healthy atomic.Bool
+	n := &recorder{interval: 10 * time.Millisecond}
+	f := dlfetch.New(dlfetch.WithTargetDir(t.TempDir()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, f, Options{Health: func() error {
+			if !healthy.Load() {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}}, n)
+	}()
+
+	// No pings while unhealthy
+	time.Sleep(50 * time.Millisecond)
+	if pings := n.pings.Load(); pings != 0 {
+		t.Errorf("%d pings while unhealthy", pings)
+	}
+	healthy.Store(true)
+	for n.pings.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if res := f.Enqueue(dlfetch.DownloadRequest{ID: 1, URL: srv.URL, FileName: "x"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := "ready stopping"; strings.Join(n.events, " ") != want {
+		t.Errorf("notified %v, want %s", n.events, want)
+	}
+	if res := f.Enqueue(dlfetch.DownloadRequest{ID: 2, URL: srv.URL, FileName: "y"}); res.Error == nil {
+		t.Error("enqueued after the service stopped")
+	}
+}
+
+func TestServeStopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("x"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	f := dlfetch.New(dlfetch.WithTargetDir(t.TempDir()))
+	if res := f.Enqueue(dlfetch.DownloadRequest{ID: 1, URL: srv.URL, FileName: "x"}); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := serve(ctx, f, Options{StopTimeout: 50 * time.Millisecond}, &recorder{})
+	if err == nil || !strings.Contains(err.Error(), "still running after 50ms") {
+		t.Errorf("stop with a download running: %v", err)
+	}
+	close(release)
+	f.Stop()
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/hritikr/dlfetch"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+
+	errorServiceSpecificError = 1066
+
+	// Returned by StartServiceCtrlDispatcher when the process was not started by the SCM
+	errFailedServiceControllerConnect syscall.Errno = 1063
+)
+
+// stopWaitHint tells the SCM how long to wait between progress reports while stopping.
+const stopWaitHint = 30 * time.Second
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+func run(ctx context.Context, f *dlfetch.Fetcher, opts Options) error {
+	name, err := syscall.UTF16PtrFromString(opts.Name)
+	if err != nil {
+		return err
+	}
+
+	s := &windowsService{ctx: ctx, fetcher: f, opts: opts, name: name}
+	table := []serviceTableEntry{
+		{name: name, proc: syscall.NewCallback(s.main)},
+		{},
+	}
+
+	// The dispatcher connects the calling thread to the SCM and blocks until the service stopped
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ok, _, callErr := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if ok == 0 {
+		if errors.Is(callErr, errFailedServiceControllerConnect) {
+			// Started from a console, not as a service
+			return serve(ctx, f, opts, console{})
+		}
+		return callErr
+	}
+	return s.err
+}
+
+// windowsService runs the Fetcher under the service control manager.
+type windowsService struct {
+	ctx     context.Context
+	fetcher *dlfetch.Fetcher
+	opts    Options
+	name    *uint16
+
+	cancel  context.CancelFunc
+	handle  uintptr
+	err     error
+	stopped chan struct{} // Closed once serve returned
+
+	mu         sync.Mutex
+	checkPoint uint32
+}
+
+// main is the ServiceMain function, called by the SCM on a thread of its own.
+func (s *windowsService) main(argc, argv uintptr) uintptr {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	s.cancel = cancel
+
+	handle, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(s.name)), syscall.NewCallback(s.control), 0)
+	if handle == 0 {
+		s.err = err
+		return 0
+	}
+	s.handle = handle
+	s.setStatus(serviceStartPending, 0)
+
+	s.stopped = make(chan struct{})
+	s.err = serve(ctx, s.fetcher, s.opts, s)
+	close(s.stopped)
+
+	var exitCode uint32
+	if s.err != nil {
+		exitCode = 1
+	}
+	s.setStatus(serviceStopped, exitCode)
+	return 0
+}
+
+// control is the HandlerEx function receiving the requests of the SCM.
+func (s *windowsService) control(code, _, _, _ uintptr) uintptr {
+	switch code {
+	case serviceControlStop, serviceControlShutdown:
+		s.cancel()
+	}
+	return 0
+}
+
+func (s *windowsService) ready() { s.setStatus(serviceRunning, 0) }
+
+// stopping reports the stop and keeps reporting progress while the downloads
+// finish, so the SCM does not consider the service hung.
+func (s *windowsService) stopping() {
+	s.setStatus(serviceStopPending, 0)
+	go func() {
+		ticker := time.NewTicker(stopWaitHint / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.setStatus(serviceStopPending, 0)
+			case <-s.stopped:
+				return
+			}
+		}
+	}()
+}
+
+// alive does nothing, the SCM has no watchdog.
+func (s *windowsService) alive() {}
+
+func (s *windowsService) watchdogInterval() time.Duration { return 0 }
+
+// setStatus reports the state of the service to the SCM.
+func (s *windowsService) setStatus(state, exitCode uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := serviceStatus{
+		serviceType:  serviceWin32OwnProcess,
+		currentState: state,
+	}
+	switch state {
+	case serviceRunning:
+		status.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStartPending, serviceStopPending:
+		s.checkPoint++
+		status.checkPoint = s.checkPoint
+		status.waitHint = uint32(max(stopWaitHint, s.opts.StopTimeout).Milliseconds())
+	}
+	if exitCode != 0 {
+		status.win32ExitCode = errorServiceSpecificError
+		status.serviceSpecificExitCode = exitCode
+	}
+	_, _, _ = procSetServiceStatus.Call(s.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// console is the notifier of a process that does not run as a service.
+type console struct{}
+
+func (console) ready()                          {}
+func (console) stopping()                       {}
+func (console) alive()                          {}
+func (console) watchdogInterval() time.Duration { return 0 }
@@ -0,0 +1,59 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hritikr/dlfetch"
+)
+
+func run(ctx context.Context, f *dlfetch.Fetcher, opts Options) error {
+	return serve(ctx, f, opts, systemd{socket: os.Getenv("NOTIFY_SOCKET")})
+}
+
+// systemd implements the sd_notify protocol. Without NOTIFY_SOCKET, i.e. when
+// not started by systemd with Type=notify, it does nothing.
+type systemd struct {
+	socket string
+}
+
+func (s systemd) ready()    { s.notify("READY=1") }
+func (s systemd) stopping() { s.notify("STOPPING=1\nSTATUS=Waiting for downloads to finish") }
+func (s systemd) alive()    { s.notify("WATCHDOG=1") }
+
+// watchdogInterval reads the interval systemd expects pings in, set with WatchdogSec.
+func (s systemd) watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// Meant for another process
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 || s.socket == "" {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notify sends state to systemd. Errors are ignored, like sd_notify's callers
+// commonly do: the service works the same when the message is lost.
+func (s systemd) notify(state string) {
+	if s.socket == "" {
+		return
+	}
+	name := s.socket
+	if name[0] == '@' {
+		// Abstract socket namespace
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte(state))
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	s := systemd{socket: path}
+	s.ready()
+	s.alive()
+	s.stopping()
+	for _, want := range []string{"READY=1", "WATCHDOG=1", "STOPPING=1\nSTATUS=Waiting for downloads to finish"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("notified %q, want %q", got, want)
+		}
+	}
+
+	// Without systemd, or with a socket that is gone, notifications are dropped
+	systemd{}.ready()
+	systemd{socket: filepath.Join(t.TempDir(), "missing")}.ready()
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		socket, usec, pid string
+		want              time.Duration
+	}{
+		{"/run/notify", "2000000", "", 2 * time.Second},
+		{"/run/notify", "2000000", self, 2 * time.Second},
+		{"/run/notify", "2000000", "1", 0},
+		{"", "2000000", "", 0},
+		{"/run/notify", "", "", 0},
+		{"/run/notify", "-5", "", 0},
+		{"/run/notify", "soon", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := (systemd{socket: tt.socket}).watchdogInterval(); got != tt.want {
+			t.Errorf("socket %q, WATCHDOG_USEC %q, WATCHDOG_PID %q: %v, want %v", tt.socket, tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
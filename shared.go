@@ -0,0 +1,33 @@
+package dlfetch
+
+// SharedLimiter holds limits that apply to several Fetchers together, so that
+// splitting work into separate pools does not multiply the bandwidth used or the
+// connections opened to a host. Each Fetcher's own limits still apply on top.
+type SharedLimiter struct {
+	bandwidth *tokenBucket
+	hosts     *hostLimiter // nil when the number of downloads per host is not limited
+}
+
+// NewSharedLimiter creates a limiter that caps the combined download speed of the
+// Fetchers using it at maxBandwidth bytes per second and their simultaneous downloads
+// per host at maxPerHost. Zero or less means no limit.
+func NewSharedLimiter(maxBandwidth int64, maxPerHost int) *SharedLimiter {
+	s := &SharedLimiter{bandwidth: newTokenBucket(maxBandwidth)}
+	if maxPerHost > 0 {
+		s.hosts = newHostLimiter(maxPerHost, maxPerHost, false)
+	}
+	return s
+}
+
+// SetMaxBandwidth changes the combined speed limit, running downloads pick it up right away.
+func (s *SharedLimiter) SetMaxBandwidth(bytesPerSec int64) {
+	s.bandwidth.setRate(bytesPerSec)
+}
+
+// WithSharedLimiter subjects the Fetcher's downloads to s, which can be passed to
+// several Fetchers.
+func WithSharedLimiter(s *SharedLimiter) FetcherOption {
+	return func(f *Fetcher) {
+		f.shared = s
+	}
+}
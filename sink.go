@@ -0,0 +1,65 @@
+package dlfetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Sink uploads completed downloads to remote storage.
+// Upload stores the content under key and returns the location of the stored object.
+type Sink interface {
+	Upload(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) (string, error)
+}
+
+// WithSink registers a sink under a name. Requests select it through
+// DownloadRequest.Sink, so different requests can go to different storage.
+func WithSink(name string, s Sink) FetcherOption {
+	return func(f *Fetcher) {
+		if f.sinks == nil {
+			f.sinks = make(map[string]Sink)
+		}
+		f.sinks[name] = s
+	}
+}
+
+// validateSink checks that the sink selected by the request is registered.
+func (f *Fetcher) validateSink(req DownloadRequest) error {
+	if req.Sink == "" {
+		return nil
+	}
+	if _, ok := f.sinks[req.Sink]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSink, req.Sink)
+	}
+	return nil
+}
+
+// upload sends the completed file to the sink selected by the request and
+// records the remote location in the result. The local file is kept.
+func (f *Fetcher) upload(ctx context.Context, req DownloadRequest, result *DownloadResult) error {
+	if req.Sink == "" {
+		return nil
+	}
+
+	file, err := os.Open(result.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(filepath.ToSlash(req.Path), result.FileName)
+	location, err := f.sinks[req.Sink].Upload(ctx, key, file, info.Size(), result.MimeType)
+	if err != nil {
+		return fmt.Errorf("upload to sink %s failed: %w", req.Sink, err)
+	}
+	result.RemoteURL = location
+	return nil
+}
@@ -0,0 +1,53 @@
+package dlfetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AzureBlobSink uploads files to an Azure Blob Storage container.
+// It authenticates with a SAS token that is part of the container URL,
+// so no account key is needed in the process.
+type AzureBlobSink struct {
+	ContainerURL string       // e.g. https://account.blob.core.windows.net/container?sv=...&sig=...
+	Client       *http.Client // Optional, defaults to http.DefaultClient
+}
+
+// NewAzureBlobSink creates a sink for the container addressed by a SAS URL.
+// The SAS must grant create and write permissions.
+func NewAzureBlobSink(containerURL string) *AzureBlobSink {
+	return &AzureBlobSink{
+		ContainerURL: containerURL,
+	}
+}
+
+// Upload stores the file as a block blob named key and returns the blob URL without the SAS.
+func (s *AzureBlobSink) Upload(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) (string, error) {
+	container, err := url.Parse(s.ContainerURL)
+	if err != nil {
+		return "", err
+	}
+	blob := *container
+	blob.Path = strings.TrimSuffix(container.Path, "/") + "/" + key
+	blob.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, blob.String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if contentType != "" {
+		req.Header.Set("x-ms-blob-content-type", contentType)
+	}
+
+	if err := doUpload(s.Client, req); err != nil {
+		return "", err
+	}
+
+	blob.RawQuery = ""
+	return blob.String(), nil
+}
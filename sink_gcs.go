@@ -0,0 +1,84 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const defaultGCSEndpoint = "https://storage.googleapis.com"
+
+// GCSSink uploads files to a Google Cloud Storage bucket using the JSON API.
+type GCSSink struct {
+	Bucket   string                                    // Target bucket
+	Token    func(ctx context.Context) (string, error) // Returns an OAuth2 access token
+	Client   *http.Client                              // Optional, defaults to http.DefaultClient
+	Endpoint string                                    // Optional, e.g. an emulator address
+}
+
+// NewGCSSink creates a sink for the given bucket. token is called for every
+// upload and should return a valid OAuth2 access token.
+func NewGCSSink(bucket string, token func(ctx context.Context) (string, error)) *GCSSink {
+	return &GCSSink{
+		Bucket: bucket,
+		Token:  token,
+	}
+}
+
+// Upload stores the file as an object named key and returns its gs:// URL.
+func (s *GCSSink) Upload(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) (string, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCSEndpoint
+	}
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		endpoint, url.PathEscape(s.Bucket), url.QueryEscape(key))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.Token != nil {
+		token, err := s.Token(ctx)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if err := doUpload(s.Client, req); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gs://%s/%s", s.Bucket, key), nil
+}
+
+// doUpload sends an upload request and turns non-2xx responses into errors.
+func doUpload(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(req.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload failed: %s, status code: %d, body: %s", redactURL(req.URL), resp.StatusCode, body)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,267 @@
+package dlfetch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// SparseMapSuffix is appended to the path of a download written in place to
+	// name its completion map, see WithSparseFiles.
+	SparseMapSuffix = ".dlmap"
+
+	// sparseBlockSize is the granularity of the completion map.
+	sparseBlockSize = 1 << 20
+	// sparseFlushInterval is how often the completion map is written while it changes.
+	sparseFlushInterval = 250 * time.Millisecond
+)
+
+// sparseMagic starts every completion map.
+var sparseMagic = []byte("DLMAP\x00\x00\x01")
+
+// WithSparseFiles writes downloads in place instead of staging them: the file is
+// created at its final path with its final size right away, sparse where the file
+// system supports it, and filled as the data arrives, so applications can read the
+// completed head of a video while its tail still downloads.
+//
+// Next to the file a completion map named after it with SparseMapSuffix records
+// which parts are complete, see ReadSparseMap; it is removed once the download
+// completes. An interrupted download resumes from its completed head. A file that
+// is replaced is truncated as soon as its download starts, and requests with
+// OverwriteRename or a Range are staged as usual.
+func WithSparseFiles() FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.sparse = true
+	}
+}
+
+// SparseMap tells which parts of a download written in place with
+// WithSparseFiles are complete.
+//
+// The map file starts with the 8 bytes "DLMAP\x00\x00\x01", followed by the size
+// of the file, 0 if unknown, and the block size as big-endian uint64s, and a
+// bitmap with one bit per block, set once the block is complete: block i is bit
+// i%8, counting from the least significant, of byte i/8.
+type SparseMap struct {
+	Size      int64 // Final size of the file, 0 if the server did not announce it
+	BlockSize int64
+	blocks    []byte
+}
+
+// ReadSparseMap reads the completion map of the download at path, the path of the
+// file itself. It returns an error matching fs.ErrNotExist if there is none, which
+// means the file is complete if it exists.
+func ReadSparseMap(path string) (*SparseMap, error) {
+	data, err := os.ReadFile(path + SparseMapSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 24 || !bytes.Equal(data[:8], sparseMagic) {
+		return nil, fmt.Errorf("invalid completion map %s", path+SparseMapSuffix)
+	}
+	m := &SparseMap{
+		Size:      int64(binary.BigEndian.Uint64(data[8:])),
+		BlockSize: int64(binary.BigEndian.Uint64(data[16:])),
+		blocks:    data[24:],
+	}
+	if m.BlockSize <= 0 {
+		return nil, fmt.Errorf("invalid completion map %s", path+SparseMapSuffix)
+	}
+	return m, nil
+}
+
+// complete reports whether block i is complete.
+func (m *SparseMap) complete(i int64) bool {
+	return i/8 < int64(len(m.blocks)) && m.blocks[i/8]&(1<<(i%8)) != 0
+}
+
+// Available reports whether the n bytes at off are complete.
+func (m *SparseMap) Available(off, n int64) bool {
+	if n <= 0 {
+		return true
+	}
+	for i := off / m.BlockSize; i <= (off+n-1)/m.BlockSize; i++ {
+		if !m.complete(i) {
+			return false
+		}
+	}
+	return m.Size <= 0 || off+n <= m.Size
+}
+
+// Head returns how many bytes from the start of the file are complete.
+func (m *SparseMap) Head() int64 {
+	var i int64
+	for m.complete(i) {
+		i++
+	}
+	head := i * m.BlockSize
+	if m.Size > 0 {
+		head = min(head, m.Size)
+	}
+	return head
+}
+
+// sparseTracker maintains the completion map of a download written in place.
+type sparseTracker struct {
+	mu     sync.Mutex
+	path   string  // Path of the map file
+	size   int64   // 0 if unknown
+	filled []int64 // Bytes written per block
+	dirty  bool
+	stop   chan struct{} // Stops the periodic writes
+	once   sync.Once
+}
+
+// prepareSparse makes the file at path ready to be resumed by openDownload: a
+// partial download keeps its completed head, anything else is discarded.
+func prepareSparse(path string) error {
+	m, err := ReadSparseMap(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	head := int64(0)
+	if m != nil && m.BlockSize == sparseBlockSize {
+		head = m.Head()
+	}
+	if err := os.Truncate(path, head); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// newSparseTracker starts the completion map of the file at path, whose first
+// offset bytes are complete, and sizes the file to size if it is known.
+func newSparseTracker(out *os.File, path string, offset, size int64) (*sparseTracker, error) {
+	if size > 0 {
+		if err := out.Truncate(size); err != nil {
+			return nil, err
+		}
+	}
+	t := &sparseTracker{path: path + SparseMapSuffix, size: max(size, 0), dirty: true, stop: make(chan struct{})}
+	t.mark(0, offset)
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+	go t.run()
+	return t, nil
+}
+
+// run writes the map periodically until close.
+func (t *sparseTracker) run() {
+	ticker := time.NewTicker(sparseFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = t.flush()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// blockLen returns the length of block i, which is shorter at the end of the file.
+func (t *sparseTracker) blockLen(i int) int64 {
+	if t.size <= 0 {
+		return sparseBlockSize
+	}
+	return min(sparseBlockSize, t.size-int64(i)*sparseBlockSize)
+}
+
+// mark records that the n bytes at off were written. Writes must not overlap. t
+// may be nil.
+func (t *sparseTracker) mark(off, n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for n > 0 {
+		i := int(off / sparseBlockSize)
+		for len(t.filled) <= i {
+			t.filled = append(t.filled, 0)
+		}
+		chunk := min(n, sparseBlockSize-off%sparseBlockSize)
+		t.filled[i] += chunk
+		off += chunk
+		n -= chunk
+	}
+	t.dirty = true
+}
+
+// close stops the periodic writes and writes the map a last time, so an
+// interrupted download can be resumed from it. t may be nil.
+func (t *sparseTracker) close() error {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() { close(t.stop) })
+	return t.flush()
+}
+
+// flush writes the map if it changed.
+func (t *sparseTracker) flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writeLocked()
+}
+
+// writeLocked replaces the map file atomically, so readers never see half of it.
+func (t *sparseTracker) writeLocked() error {
+	if !t.dirty {
+		return nil
+	}
+	data := make([]byte, 24, 24+(len(t.filled)+7)/8)
+	copy(data, sparseMagic)
+	binary.BigEndian.PutUint64(data[8:], uint64(t.size))
+	binary.BigEndian.PutUint64(data[16:], sparseBlockSize)
+	bits := make([]byte, (len(t.filled)+7)/8)
+	for i, filled := range t.filled {
+		if filled >= t.blockLen(i) {
+			bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	data = append(data, bits...)
+
+	tmp := t.path + defaultTmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// remove deletes the map, once the download is complete or discarded.
+func (t *sparseTracker) remove() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() { close(t.stop) })
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = false
+	_ = os.Remove(t.path)
+}
+
+// sparseWriter writes sequentially to a file from offset and marks what it wrote.
+type sparseWriter struct {
+	w       io.Writer
+	tracker *sparseTracker
+	offset  int64
+}
+
+func (sw *sparseWriter) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	sw.tracker.mark(sw.offset, int64(n))
+	sw.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,169 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSparseTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	const size = 2*sparseBlockSize + sparseBlockSize/2
+	tracker, err := newSparseTracker(out, path, 100, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := out.Stat(); info.Size() != size {
+		t.Errorf("file of %d bytes, want %d", info.Size(), size)
+	}
+	// The rest of the first block, across the second, and the short last block
+	tracker.mark(100, sparseBlockSize-100)
+	tracker.mark(2*sparseBlockSize, sparseBlockSize/2)
+	tracker.mark(sparseBlockSize, 10)
+	if err := tracker.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadSparseMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size != size || m.BlockSize != sparseBlockSize || m.Head() != sparseBlockSize {
+		t.Errorf("map of size %d, block size %d, head %d", m.Size, m.BlockSize, m.Head())
+	}
+	tests := []struct {
+		off, n int64
+		want   bool
+	}{
+		{0, 0, true},
+		{0, sparseBlockSize, true},
+		{0, sparseBlockSize + 1, false},
+		{sparseBlockSize, 10, false},
+		{2 * sparseBlockSize, sparseBlockSize / 2, true},
+		{2 * sparseBlockSize, sparseBlockSize/2 + 1, false},
+		{size, 1, false},
+	}
+	for _, tt := range tests {
+		if got := m.Available(tt.off, tt.n); got != tt.want {
+			t.Errorf("Available(%d, %d) = %v, want %v", tt.off, tt.n, got, tt.want)
+		}
+	}
+
+	tracker.remove()
+	if _, err := ReadSparseMap(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("map after remove: %v", err)
+	}
+}
+
+func TestReadSparseMap(t *testing.T) {
+	header := func(size, blockSize uint64) []byte {
+		data := append([]byte(nil), sparseMagic...)
+		data = binary.BigEndian.AppendUint64(data, size)
+		return binary.BigEndian.AppendUint64(data, blockSize)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"empty bitmap", header(0, 4), true},
+		{"short", header(0, 4)[:23], false},
+		{"magic", append([]byte("DLMAP\x00\x00\x02"), header(0, 4)[8:]...), false},
+		{"zero block size", header(10, 0), false},
+		{"negative block size", header(10, 1<<63), false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path+SparseMapSuffix, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadSparseMap(path); (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+
+	// Unknown size, the head ends at the first incomplete block
+	m := &SparseMap{BlockSize: 4, blocks: []byte{0b1011}}
+	if head := m.Head(); head != 8 {
+		t.Errorf("head %d, want 8", head)
+	}
+	if !m.Available(12, 4) || m.Available(8, 4) {
+		t.Error("availability of the blocks around a gap")
+	}
+}
+
+func TestPrepareSparse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, make([]byte, 3*sparseBlockSize), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := newSparseTracker(out, path, 0, 3*sparseBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.mark(0, sparseBlockSize)
+	tracker.mark(2*sparseBlockSize, sparseBlockSize)
+	tracker.close()
+	out.Close()
+
+	if err := prepareSparse(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() != sparseBlockSize {
+		t.Errorf("kept %d bytes, want the head of %d", info.Size(), sparseBlockSize)
+	}
+	// Without a map the file is discarded
+	os.Remove(path + SparseMapSuffix)
+	if err := prepareSparse(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("kept %d bytes without a map", info.Size())
+	}
+	if err := prepareSparse(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("prepare of a missing file: %v", err)
+	}
+}
+
+func TestSparseDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*sparseBlockSize/16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	for _, segments := range []int{1, 3} {
+		dir := t.TempDir()
+		f := New(WithTargetDir(dir), WithSparseFiles(), WithSegments(segments))
+		result, err := f.Download(context.Background(), DownloadRequest{URL: srv.URL, FileName: "file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(result.Path); !bytes.Equal(got, content) {
+			t.Errorf("%d segments: downloaded %d bytes that differ", segments, len(got))
+		}
+		if _, err := ReadSparseMap(result.Path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%d segments: map left after the download: %v", segments, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package dlfetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ManifestSuffix is appended to the target path of a split download to name its manifest.
+const ManifestSuffix = ".parts.json"
+
+// PartsManifest describes a download written as numbered parts, see WithSplitParts.
+// The original file is the concatenation of Parts in order.
+type PartsManifest struct {
+	FileName string `json:"fileName"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	PartSize int64  `json:"partSize"`
+	Parts    []Part `json:"parts"`
+}
+
+// Part is one file of a split download.
+type Part struct {
+	Name   string `json:"name"` // File name, in the directory of the manifest
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// WithSplitParts writes downloads as numbered parts of at most size bytes
+// ("file.bin.001", "file.bin.002"...) next to a manifest "file.bin.parts.json",
+// for storage with a per-file size limit. The file is never stored in one piece,
+// so split downloads are not resumed, segmented or post-processed and the result's
+// Path is the manifest. Existing parts are only replaced with OverwriteReplace.
+// JoinParts puts the file back together.
+func WithSplitParts(size int64) FetcherOption {
+	return func(f *Fetcher) {
+		f.policy.partSize = size
+	}
+}
+
+// splitDownload writes the response body into parts and the manifest describing them.
+func (f *Fetcher) splitDownload(ctx context.Context, req DownloadRequest, p policy) (DownloadResult, error) {
+	if err := ensureDir(req.FullPath); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	manifestPath := req.FullPath + ManifestSuffix
+	overwrite := p.overwriteFor(req) == OverwriteReplace
+	if !overwrite && checkFileExists(manifestPath) {
+		return DownloadResult{}, f.fail(req, fmt.Errorf("%w: %s", ErrFileExists, manifestPath))
+	}
+
+	resp, err := f.openFresh(ctx, req)
+	if err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	defer resp.Body.Close()
+
+	total := resolveFileSize(resp)
+	if err := p.checkDiskSpace(req.FullPath, total); err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	width := 3
+	if total > 0 {
+		width = max(width, len(strconv.FormatInt((total+p.partSize-1)/p.partSize, 10)))
+	}
+	pw := &partWriter{
+		base:      req.FullPath,
+		width:     width,
+		partSize:  p.partSize,
+		overwrite: overwrite,
+		staging:   p.stagingPath,
+	}
+	mw := &monitorWriter{
+		id:      req.ID,
+		total:   total,
+		monitor: f.monitor,
+	}
+	hash := sha256.New()
+	digests := newDigester(resp, req)
+	reader := io.TeeReader(throttle(ctx, resp.Body, f.speedLimits(req)), mw)
+
+	size, err := io.Copy(io.MultiWriter(pw, hash, digests), reader)
+	var verified []string
+	if err == nil {
+		verified, err = digests.verify(resp, req.Range == nil, hash.Sum(nil))
+	}
+	if err == nil {
+		err = checkChecksum(req, digests, hash.Sum(nil))
+	}
+	if err == nil {
+		err = pw.Close()
+	}
+	if err != nil {
+		pw.abort()
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	manifest := PartsManifest{
+		FileName: req.FileName,
+		Size:     size,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+		PartSize: p.partSize,
+		Parts:    pw.parts,
+	}
+	if err := writeManifest(manifestPath, manifest, p, overwrite); err != nil {
+		pw.abort()
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	result := DownloadResult{
+		ID:              req.ID,
+		FileName:        req.FileName,
+		Path:            manifestPath,
+		MimeType:        f.detectMimeType(req, resp.Header.Get("Content-Type"), ""),
+		Vars:            req.Vars,
+		Size:            size,
+		SHA256:          manifest.SHA256,
+		ETag:            resp.Header.Get("ETag"),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		ServerFileName:  dispositionFileName(resp),
+		FinalURL:        finalURL(resp),
+		VerifiedDigests: verified,
+	}
+
+	if p.doneMarker != "" {
+		if err := writeMarker(manifestPath + p.doneMarker); err != nil {
+			return DownloadResult{}, f.fail(req, err)
+		}
+	}
+
+	if err := f.resultStore.Save(result); err != nil {
+		return DownloadResult{}, f.fail(req, fmt.Errorf("saving result failed: %w", err))
+	}
+
+	f.monitor.markAsCompleted(req.ID)
+
+	return result, nil
+}
+
+// writeManifest stages and commits the manifest like a downloaded file.
+func writeManifest(path string, manifest PartsManifest, p policy, overwrite bool) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := p.stagingPath(path)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return commitFile(tmpPath, path, overwrite)
+}
+
+// partWriter writes everything it is given into consecutive parts of partSize bytes.
+// Each part is staged and moved into place once it is full.
+type partWriter struct {
+	base      string
+	width     int
+	partSize  int64
+	overwrite bool
+	staging   func(path string) string
+
+	parts   []Part
+	out     *os.File
+	hash    hash.Hash
+	written int64
+}
+
+func (w *partWriter) path(i int) string {
+	return fmt.Sprintf("%s.%0*d", w.base, w.width, i)
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		if w.out == nil {
+			if err := w.open(); err != nil {
+				return n, err
+			}
+		}
+		chunk := p[:min(int64(len(p)), w.partSize-w.written)]
+		m, err := w.out.Write(chunk)
+		w.hash.Write(chunk[:m])
+		w.written += int64(m)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		if w.written == w.partSize {
+			if err := w.finish(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *partWriter) open() error {
+	path := w.path(len(w.parts) + 1)
+	if !w.overwrite && checkFileExists(path) {
+		return fmt.Errorf("%w: %s", ErrFileExists, path)
+	}
+	out, err := os.Create(w.staging(path))
+	if err != nil {
+		return err
+	}
+	w.out = out
+	w.hash = sha256.New()
+	w.written = 0
+	return nil
+}
+
+// finish moves the current part into place.
+func (w *partWriter) finish() error {
+	out := w.out
+	w.out = nil
+	path := w.path(len(w.parts) + 1)
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+	if err := commitFile(out.Name(), path, w.overwrite); err != nil {
+		return err
+	}
+	w.parts = append(w.parts, Part{
+		Name:   filepath.Base(path),
+		Size:   w.written,
+		SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+	})
+	return nil
+}
+
+// Close moves the last, partly filled part into place. An empty download still gets one part.
+func (w *partWriter) Close() error {
+	if w.out == nil && len(w.parts) == 0 {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.out == nil {
+		return nil
+	}
+	return w.finish()
+}
+
+// abort removes the parts written so far.
+func (w *partWriter) abort() {
+	if w.out != nil {
+		w.out.Close()
+		_ = os.Remove(w.out.Name())
+		w.out = nil
+	}
+	for _, part := range w.parts {
+		_ = os.Remove(filepath.Join(filepath.Dir(w.base), part.Name))
+	}
+	w.parts = nil
+}
+
+// JoinParts reassembles a split download from its manifest into dst, checking
+// the size and checksum of every part and of the whole file.
+func JoinParts(manifestPath, dst string) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var manifest PartsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid parts manifest %s: %w", manifestPath, err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	total := sha256.New()
+	for _, part := range manifest.Parts {
+		if err := appendPart(io.MultiWriter(out, total), filepath.Join(filepath.Dir(manifestPath), part.Name), part); err != nil {
+			out.Close()
+			_ = os.Remove(dst)
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(total.Sum(nil)); sum != manifest.SHA256 {
+		_ = os.Remove(dst)
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", manifest.FileName, sum, manifest.SHA256)
+	}
+	return nil
+}
+
+// appendPart copies one part to w and verifies it.
+func appendPart(w io.Writer, path string, part Part) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), in)
+	if err != nil {
+		return err
+	}
+	if n != part.Size {
+		return fmt.Errorf("part %s has %d bytes, want %d", part.Name, n, part.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != part.SHA256 {
+		return fmt.Errorf("checksum mismatch for part %s: got %s, want %s", part.Name, sum, part.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package dlfetch
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultSizeBuckets are the upper bounds in bytes of the file size classes used by
+// SizeStats when no bounds are given: up to 64 KiB, 1 MiB, 16 MiB, 256 MiB and above.
+var DefaultSizeBuckets = []int64{64 << 10, 1 << 20, 16 << 20, 256 << 20}
+
+var (
+	durationBounds = []float64{0.1, 0.5, 1, 5, 30, 120}      // Seconds
+	speedBounds    = []float64{100e3, 1e6, 10e6, 100e6, 1e9} // Bytes per second
+)
+
+// Histogram counts observations in buckets. Counts[i] holds the observations up to
+// Bounds[i]; the last count holds those above the last bound.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int     `json:"counts"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Mean   float64   `json:"mean"`
+	P50    float64   `json:"p50"`
+	P90    float64   `json:"p90"`
+}
+
+// SizeStats describes the completed downloads of one file size class.
+type SizeStats struct {
+	MinSize  int64     `json:"minSize"` // Inclusive
+	MaxSize  int64     `json:"maxSize"` // Inclusive, -1 for the open-ended last class
+	Count    int       `json:"count"`
+	Bytes    int64     `json:"bytes"`
+	Duration Histogram `json:"duration"` // Seconds
+	Speed    Histogram `json:"speed"`    // Bytes per second
+}
+
+// SizeStats groups the completed downloads recorded so far by file size and
+// summarizes their duration and speed per group, e.g. to tell whether small
+// files are slow because of per-request overhead. bounds are the ascending
+// upper bounds of the size classes, DefaultSizeBuckets if none are given.
+func (f *Fetcher) SizeStats(bounds ...int64) []SizeStats {
+	if len(bounds) == 0 {
+		bounds = DefaultSizeBuckets
+	}
+
+	durations := make([][]float64, len(bounds)+1)
+	speeds := make([][]float64, len(bounds)+1)
+	stats := make([]SizeStats, len(bounds)+1)
+	for i := range stats {
+		if i > 0 {
+			stats[i].MinSize = bounds[i-1] + 1
+		}
+		stats[i].MaxSize = -1
+		if i < len(bounds) {
+			stats[i].MaxSize = bounds[i]
+		}
+	}
+
+	for _, e := range f.ReportEntries() {
+		if e.Status != StatusCompleted {
+			continue
+		}
+		i := sort.Search(len(bounds), func(i int) bool { return e.Size <= bounds[i] })
+		stats[i].Count++
+		stats[i].Bytes += e.Size
+		durations[i] = append(durations[i], e.Duration.Seconds())
+		speeds[i] = append(speeds[i], e.Speed)
+	}
+
+	for i := range stats {
+		stats[i].Duration = newHistogram(durationBounds, durations[i])
+		stats[i].Speed = newHistogram(speedBounds, speeds[i])
+	}
+	return stats
+}
+
+// newHistogram buckets values by bounds and computes summary statistics.
+func newHistogram(bounds, values []float64) Histogram {
+	h := Histogram{
+		Bounds: bounds,
+		Counts: make([]int, len(bounds)+1),
+	}
+	if len(values) == 0 {
+		return h
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		h.Counts[sort.SearchFloat64s(bounds, v)]++
+		sum += v
+	}
+	h.Min = sorted[0]
+	h.Max = sorted[len(sorted)-1]
+	h.Mean = sum / float64(len(sorted))
+	h.P50 = percentile(sorted, 0.5)
+	h.P90 = percentile(sorted, 0.9)
+	return h
+}
+
+// percentile returns the nearest-rank percentile p (0-1) of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
@@ -0,0 +1,80 @@
+package dlfetch
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResultStore persists the result of every completed download, e.g. to keep a record
+// of all downloads in a database. Save is called from the worker goroutines once the
+// file is in place and uploaded; returning an error fails the download.
+type ResultStore interface {
+	Save(DownloadResult) error
+}
+
+// WithResultStore saves every completed download to s before onComplete is called.
+func WithResultStore(s ResultStore) FetcherOption {
+	return func(f *Fetcher) {
+		f.resultStore = s
+	}
+}
+
+// NopResultStore discards all results. It is the default ResultStore.
+type NopResultStore struct{}
+
+// Save does nothing.
+func (NopResultStore) Save(DownloadResult) error {
+	return nil
+}
+
+// sqlResultColumns are the columns SQLResultStore writes, in order.
+var sqlResultColumns = []string{
+	"id", "file_name", "path", "mime_type", "size", "sha256",
+	"remote_url", "etag", "last_modified", "completed_at",
+}
+
+// SQLResultStore inserts one row per completed download into a table with the columns
+// id (integer), file_name, path, mime_type (text), size (bigint), sha256, remote_url,
+// etag, last_modified (text) and completed_at (timestamp). The database driver has to
+// be registered by the caller.
+type SQLResultStore struct {
+	DB          *sql.DB
+	Table       string
+	Placeholder func(n int) string // Returns the placeholder of the n-th argument, starting at 1; defaults to "?"
+}
+
+// NewSQLResultStore creates a store that writes to table using "?" placeholders,
+// as expected by MySQL and SQLite drivers. Set Placeholder to DollarPlaceholder for Postgres.
+func NewSQLResultStore(db *sql.DB, table string) *SQLResultStore {
+	return &SQLResultStore{
+		DB:    db,
+		Table: table,
+	}
+}
+
+// DollarPlaceholder numbers placeholders as $1, $2, ... like Postgres expects.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Save inserts a row for the result.
+func (s *SQLResultStore) Save(result DownloadResult) error {
+	placeholder := s.Placeholder
+	if placeholder == nil {
+		placeholder = func(int) string { return "?" }
+	}
+	placeholders := make([]string, len(sqlResultColumns))
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		s.Table, strings.Join(sqlResultColumns, ", "), strings.Join(placeholders, ", "))
+	_, err := s.DB.Exec(query,
+		result.ID, result.FileName, result.Path, result.MimeType, result.Size, result.SHA256,
+		result.RemoteURL, result.ETag, result.LastModified, time.Now().UTC())
+	return err
+}
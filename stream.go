@@ -0,0 +1,167 @@
+package dlfetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// StdoutFileName as a request's FileName writes the download to standard output,
+// like "-O -" on the command line, e.g. to pipe it into another program.
+const StdoutFileName = "-"
+
+// errStreamInterrupted is returned when a stream broke off after data was written
+// to it; such a download is not retried, since the data cannot be taken back.
+var errStreamInterrupted = errors.New("stream interrupted")
+
+// streamWrittenError marks a failure of a stream target that already received the
+// data: another attempt, e.g. from a mirror, would write it a second time.
+type streamWrittenError struct {
+	error
+}
+
+func (e streamWrittenError) Unwrap() error { return e.error }
+
+// isStreamTarget reports whether the request is written straight to its Writer,
+// standard output or an existing named pipe instead of being staged and moved into place.
+func isStreamTarget(req DownloadRequest) bool {
+	if req.Writer != nil || req.FullPath == StdoutFileName {
+		return true
+	}
+	info, err := os.Stat(req.FullPath)
+	return err == nil && info.Mode()&fs.ModeNamedPipe != 0
+}
+
+// openStreamTarget opens the Writer, standard output or the named pipe of the request.
+func openStreamTarget(req DownloadRequest) (io.WriteCloser, error) {
+	if req.Writer != nil {
+		return nopWriteCloser{req.Writer}, nil
+	}
+	if req.FullPath == StdoutFileName {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(req.FullPath, os.O_WRONLY, 0)
+}
+
+// openFresh requests the file of req from the start, without resuming, and checks
+// that the response carries it.
+func (f *Fetcher) openFresh(ctx context.Context, req DownloadRequest) (*http.Response, error) {
+	url := req.URL
+	if req.URLProvider != nil {
+		var err error
+		if url, err = req.URLProvider(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get download url: %w", err)
+		}
+	}
+
+	resp, _, err := f.openDownload(ctx, url, "", req.Range, req.Headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	if err := f.currentPolicy().checkContentType(url, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if c := f.contentCheckFor(req); c != nil {
+		checked, err := c.check(url, resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = checked
+	}
+	return resp, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// streamDownload writes the body to a stream target as it arrives. The speed limits
+// apply and failures before the first byte are retried as usual, but there is no
+// staging file, resume, segmenting or post-processing, and nothing is uploaded.
+func (f *Fetcher) streamDownload(ctx context.Context, req DownloadRequest) (DownloadResult, error) {
+	wire := new(wireCounter)
+	resp, err := f.openFresh(withWireCounter(ctx, wire), req)
+	if err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	defer resp.Body.Close()
+
+	// Opening a named pipe blocks until a reader shows up
+	out, err := openStreamTarget(req)
+	if err != nil {
+		return DownloadResult{}, f.fail(req, err)
+	}
+	defer out.Close()
+
+	mw := &monitorWriter{
+		id:      req.ID,
+		total:   resolveFileSize(resp),
+		monitor: f.monitor,
+	}
+	if wire.decoded.Load() {
+		mw.wire = wire
+	}
+	hash := sha256.New()
+	digests := newDigester(resp, req)
+	reader := io.TeeReader(throttle(ctx, resp.Body, f.speedLimits(req)), mw)
+
+	size, err := io.Copy(io.MultiWriter(out, hash, digests), reader)
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		if size > 0 {
+			err = fmt.Errorf("%w after %d bytes: %v", errStreamInterrupted, size, err)
+		}
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	// The data is already out, a mismatch can only be reported
+	verified, err := digests.verify(resp, req.Range == nil, hash.Sum(nil))
+	if err == nil {
+		err = checkChecksum(req, digests, hash.Sum(nil))
+	}
+	if err != nil {
+		if size > 0 {
+			err = streamWrittenError{err}
+		}
+		return DownloadResult{}, f.fail(req, err)
+	}
+
+	result := DownloadResult{
+		ID:              req.ID,
+		FileName:        req.FileName,
+		Path:            req.FullPath,
+		MimeType:        f.detectMimeType(req, resp.Header.Get("Content-Type"), ""),
+		Vars:            req.Vars,
+		Size:            size,
+		SHA256:          hex.EncodeToString(hash.Sum(nil)),
+		ETag:            resp.Header.Get("ETag"),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		ServerFileName:  dispositionFileName(resp),
+		FinalURL:        finalURL(resp),
+		VerifiedDigests: verified,
+	}
+
+	if err := f.resultStore.Save(result); err != nil {
+		return DownloadResult{}, f.fail(req, fmt.Errorf("saving result failed: %w", err))
+	}
+
+	f.monitor.markAsCompleted(req.ID)
+
+	return result, nil
+}
@@ -0,0 +1,278 @@
+package dlfetch
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"net/http"
+	"sync"
+)
+
+// DownloadInfo describes the response behind a Stream.
+type DownloadInfo struct {
+	URL          string // URL the content is served from, after following redirects
+	Size         int64  // Size in bytes, UnknownSize if the server does not tell
+	ContentType  string
+	LastModified string
+	ETag         string
+	FileName     string // File name from the Content-Disposition header, if any
+}
+
+// Stream opens the file of req for reading instead of downloading it to disk, e.g.
+// to pipe it straight into a parser. Like Download it works whether or not the
+// Fetcher is started and bypasses the queue. The monitor tracks the stream under
+// req.ID, and the speed limits, the host limits and the content checks apply.
+//
+// Failures before the first byte are retried as configured with WithRetries. When
+// the connection breaks off later and the server supports range requests and sends
+// an ETag or Last-Modified header, the stream reconnects and continues where it
+// left off, so the reader only sees an error once the retries are used up. The
+// caller must close the reader; cancelling ctx aborts it. At the end the content is
+// checked against the digests the server sent, like a download's VerifiedDigests,
+// and the one of WithChecksumLookup, and Read returns an error matching
+// ErrDigestMismatch or ErrChecksumMismatch instead of io.EOF if it fails.
+func (f *Fetcher) Stream(ctx context.Context, req DownloadRequest) (io.ReadCloser, *DownloadInfo, error) {
+	req.ctx = ctx
+	// Keeps the request off the target directory, nothing is written there
+	req.Writer = io.Discard
+	if err := f.validateRequest(&req); err != nil {
+		return nil, nil, err
+	}
+	if err := f.claimID(req.ID); err != nil {
+		return nil, nil, err
+	}
+	if err := f.monitor.add(req); err != nil {
+		f.releaseID(req.ID)
+		return nil, nil, err
+	}
+	f.monitor.setQueued(req.ID, false)
+
+	s := &streamReader{f: f, req: req, ctx: ctx, host: hostOf(req.URL)}
+	if f.shared != nil && f.shared.hosts != nil {
+		if err := f.shared.hosts.acquire(ctx, s.host); err != nil {
+			f.releaseID(req.ID)
+			return nil, nil, f.fail(req, err)
+		}
+	}
+	if f.hostLimiter != nil {
+		if err := f.hostLimiter.acquire(ctx, s.host); err != nil {
+			if f.shared != nil && f.shared.hosts != nil {
+				f.shared.hosts.release(s.host, 0, err)
+			}
+			f.releaseID(req.ID)
+			return nil, nil, f.fail(req, err)
+		}
+	}
+	if f.fairShare != nil {
+		req.share = f.fairShare.join(req)
+		s.req.share = req.share
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		err := f.lookupChecksum(ctx, &req)
+		if err == nil {
+			if resp, err = f.openFresh(ctx, req); err == nil {
+				break
+			}
+		}
+		if retry, err := f.awaitRetry(req, attempt, f.fail(req, err)); !retry {
+			s.release(err)
+			return nil, nil, err
+		}
+	}
+	s.attempts = 1
+	s.req.checksum = req.checksum
+
+	s.url = finalURL(resp)
+	s.info = &DownloadInfo{
+		URL:          s.url,
+		Size:         resolveFileSize(resp),
+		ContentType:  resp.Header.Get("Content-Type"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ETag:         resp.Header.Get("ETag"),
+		FileName:     dispositionFileName(resp),
+	}
+	s.validator = s.info.ETag
+	if s.validator == "" {
+		s.validator = s.info.LastModified
+	}
+	s.resumable = canResume(resp) && s.validator != ""
+	s.mw = &monitorWriter{id: req.ID, total: s.info.Size, monitor: f.monitor}
+	s.hash = sha256.New()
+	s.digests = newDigester(resp, req)
+	s.setBody(resp)
+
+	info := *s.info
+	return s, &info, nil
+}
+
+// streamReader is the reader returned by Stream.
+type streamReader struct {
+	f    *Fetcher
+	req  DownloadRequest
+	ctx  context.Context
+	host string
+
+	info      *DownloadInfo
+	url       string // URL to reconnect to
+	validator string // ETag or Last-Modified, sent as If-Range when reconnecting
+	resumable bool
+
+	resp     *http.Response // Current response
+	reader   io.Reader
+	mw       *monitorWriter
+	hash     hash.Hash
+	digests  *digester
+	attempts int
+
+	mu     sync.Mutex
+	done   bool
+	err    error // Final error of a failed stream
+	closed bool
+}
+
+func (s *streamReader) setBody(resp *http.Response) {
+	s.resp = resp
+	body := throttle(s.ctx, resp.Body, s.f.speedLimits(s.req))
+	s.reader = io.TeeReader(body, io.MultiWriter(s.mw, s.hash, s.digests))
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errors.New("read from closed stream")
+	}
+	if s.done {
+		return 0, cmp.Or(s.err, io.EOF)
+	}
+
+	for {
+		n, err := s.reader.Read(p)
+		if err == nil || n > 0 && err != io.EOF {
+			return n, nil
+		}
+		if err == io.EOF {
+			if s.info.Size > 0 && s.mw.written < s.info.Size {
+				err = io.ErrUnexpectedEOF
+			} else {
+				// The trailers are in, the content can be checked against them
+				_, err := s.digests.verify(s.resp, s.req.Range == nil, s.hash.Sum(nil))
+				if err == nil {
+					err = checkChecksum(s.req, s.digests, s.hash.Sum(nil))
+				}
+				if err != nil {
+					err = s.f.fail(s.req, err)
+					s.finish(err)
+					return n, err
+				}
+				s.finish(nil)
+				return n, io.EOF
+			}
+		}
+		if n > 0 {
+			// Report the data first, the reconnect happens on the next call
+			return n, nil
+		}
+		if err = s.reconnect(err); err != nil {
+			s.finish(err)
+			return 0, err
+		}
+	}
+}
+
+// reconnect continues the stream after the connection broke off with err, or
+// returns the error to report.
+func (s *streamReader) reconnect(err error) error {
+	s.resp.Body.Close()
+	if !s.resumable {
+		return s.f.fail(s.req, err)
+	}
+	retry, err := s.f.awaitRetry(s.req, s.attempts, s.f.fail(s.req, err))
+	if !retry {
+		return err
+	}
+	s.attempts++
+
+	headers := maps.Clone(s.req.Headers)
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	// Makes the server send the whole file, and the reconnect fail, if it changed
+	headers["If-Range"] = s.validator
+	rng := &ByteRange{Offset: s.mw.written}
+	if r := s.req.Range; r != nil {
+		rng.Offset += r.Offset
+		if r.Length > 0 {
+			rng.Length = r.Length - s.mw.written
+		}
+	}
+
+	resp, _, err := s.f.openDownload(s.ctx, s.url, "", rng, headers)
+	if err != nil {
+		return s.f.fail(s.req, err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return s.f.fail(s.req, fmt.Errorf("%s changed while streaming", s.url))
+	}
+	s.setBody(resp)
+	return nil
+}
+
+// finish marks the stream as done and gives back the host slots.
+func (s *streamReader) finish(err error) {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.err = err
+	if err == nil {
+		s.f.monitor.markAsCompleted(s.req.ID)
+	}
+	s.release(err)
+}
+
+func (s *streamReader) release(err error) {
+	s.f.releaseID(s.req.ID)
+	if s.req.share != nil {
+		s.f.fairShare.leave(s.req.share)
+		s.f.fairShare.forget(s.req.ID)
+	}
+	if s.f.hostLimiter != nil {
+		s.f.hostLimiter.release(s.host, s.written(), err)
+	}
+	if s.f.shared != nil && s.f.shared.hosts != nil {
+		s.f.shared.hosts.release(s.host, s.written(), err)
+	}
+}
+
+func (s *streamReader) written() int64 {
+	if s.mw == nil {
+		return 0
+	}
+	return s.mw.written
+}
+
+// Close closes the connection. A stream closed before its end is reported to the
+// monitor as cancelled.
+func (s *streamReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.resp.Body.Close()
+	if !s.done {
+		s.f.monitor.markAsCancelled(s.req.ID, context.Canceled)
+		s.finish(context.Canceled)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package dlfetch
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// requestTemplateData is the data available to templates in a request's FileName and Path.
+type requestTemplateData struct {
+	ID   int
+	URL  string
+	Host string
+	Vars map[string]string
+}
+
+// expandRequestTemplates renders FileName and Path of the request as text/templates
+// when they contain template actions, e.g. FileName: "{{.Vars.show}}-{{.ID}}.mp3".
+func expandRequestTemplates(req *DownloadRequest) error {
+	data := requestTemplateData{
+		ID:   req.ID,
+		URL:  req.URL,
+		Vars: req.Vars,
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		data.Host = u.Hostname()
+	}
+
+	var err error
+	if req.FileName, err = expandTemplate("filename", req.FileName, data); err != nil {
+		return err
+	}
+	if req.Path, err = expandTemplate("path", req.Path, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// expandTemplate renders text as a template with data, leaving plain strings untouched.
+// Referencing a missing variable is an error.
+func expandTemplate(name, text string, data any) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to expand %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
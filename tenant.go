@@ -0,0 +1,191 @@
+package dlfetch
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TenantQuota limits the share of a Fetcher one tenant can use, so a large job of one
+// user cannot starve the others. Zero values mean no limit.
+type TenantQuota struct {
+	MaxActive    int   // Downloads running at once
+	MaxQueued    int   // Requests accepted but not finished yet, running ones included
+	MaxBandwidth int64 // Combined download speed in bytes per second
+}
+
+// WithTenantQuota sets the quota of the tenant named in DownloadRequest.Tenant.
+func WithTenantQuota(tenant string, q TenantQuota) FetcherOption {
+	return func(f *Fetcher) {
+		f.ensureTenants().quotas[tenant] = q
+	}
+}
+
+// WithDefaultTenantQuota sets the quota of tenants without one of their own.
+// Requests without a Tenant are not subject to any quota.
+func WithDefaultTenantQuota(q TenantQuota) FetcherOption {
+	return func(f *Fetcher) {
+		f.ensureTenants().defaultQuota = q
+	}
+}
+
+func (f *Fetcher) ensureTenants() *tenantLimiter {
+	if f.tenants == nil {
+		f.tenants = &tenantLimiter{
+			quotas:  make(map[string]TenantQuota),
+			tenants: make(map[string]*tenantState),
+		}
+	}
+	return f.tenants
+}
+
+// tenantLimiter enforces the tenant quotas. A worker that picks up a request of a
+// tenant at its MaxActive parks it and moves on to the next request, so workers are
+// never held up by a busy tenant; the parked request is run by the worker that
+// finishes the tenant's next download.
+type tenantLimiter struct {
+	mu           sync.Mutex
+	quotas       map[string]TenantQuota
+	defaultQuota TenantQuota
+	tenants      map[string]*tenantState
+}
+
+type tenantState struct {
+	active    int
+	queued    int
+	parked    []DownloadRequest
+	bandwidth *tokenBucket
+}
+
+func (l *tenantLimiter) quota(tenant string) TenantQuota {
+	if q, ok := l.quotas[tenant]; ok {
+		return q
+	}
+	return l.defaultQuota
+}
+
+func (l *tenantLimiter) state(tenant string) *tenantState {
+	st, ok := l.tenants[tenant]
+	if !ok {
+		st = &tenantState{bandwidth: newTokenBucket(l.quota(tenant).MaxBandwidth)}
+		l.tenants[tenant] = st
+	}
+	return st
+}
+
+// cleanup forgets idle tenants.
+func (l *tenantLimiter) cleanup(tenant string) {
+	if st := l.tenants[tenant]; st != nil && st.active == 0 && st.queued == 0 && len(st.parked) == 0 {
+		delete(l.tenants, tenant)
+	}
+}
+
+// admit counts an accepted request against the tenant's MaxQueued.
+func (l *tenantLimiter) admit(tenant string) error {
+	if tenant == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.state(tenant)
+	if q := l.quota(tenant); q.MaxQueued > 0 && st.queued >= q.MaxQueued {
+		return fmt.Errorf("%w: tenant %s has %d requests queued", ErrQuotaExceeded, tenant, st.queued)
+	}
+	st.queued++
+	return nil
+}
+
+// leave undoes admit for a request that was not queued after all.
+func (l *tenantLimiter) leave(tenant string) {
+	if tenant == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.state(tenant).queued--
+	l.cleanup(tenant)
+}
+
+// acquire reserves a download slot for the request. If the tenant has none left
+// the request is parked and acquire returns false.
+func (l *tenantLimiter) acquire(req DownloadRequest) bool {
+	if req.Tenant == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.state(req.Tenant)
+	if q := l.quota(req.Tenant); q.MaxActive > 0 && st.active >= q.MaxActive {
+		st.parked = append(st.parked, req)
+		return false
+	}
+	st.active++
+	return true
+}
+
+// release frees the slot of a finished download. If a request of the tenant is
+// parked, the slot passes on to it and it is returned for the caller to run.
+func (l *tenantLimiter) release(tenant string) (DownloadRequest, bool) {
+	if tenant == "" {
+		return DownloadRequest{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.state(tenant)
+	st.queued--
+	if len(st.parked) > 0 {
+		next := st.parked[0]
+		st.parked = st.parked[1:]
+		return next, true
+	}
+	st.active--
+	l.cleanup(tenant)
+	return DownloadRequest{}, false
+}
+
+// unpark gives back the slot handed over by release without running the request,
+// which is parked again at the front.
+func (l *tenantLimiter) unpark(req DownloadRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.state(req.Tenant)
+	st.active--
+	st.parked = append([]DownloadRequest{req}, st.parked...)
+}
+
+// park adds a request to the parked ones without taking a slot.
+func (l *tenantLimiter) park(req DownloadRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st := l.state(req.Tenant)
+	st.parked = append(st.parked, req)
+}
+
+// takeParked removes and returns all parked requests, used to queue them again
+// after a restart when no download is running that would pick them up.
+func (l *tenantLimiter) takeParked() []DownloadRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var parked []DownloadRequest
+	for _, st := range l.tenants {
+		parked = append(parked, st.parked...)
+		st.parked = nil
+	}
+	return parked
+}
+
+// bucket returns the bandwidth limit of the tenant, nil for requests without one.
+func (l *tenantLimiter) bucket(tenant string) *tokenBucket {
+	if tenant == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state(tenant).bandwidth
+}
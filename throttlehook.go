@@ -0,0 +1,93 @@
+package dlfetch
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Throttle scales a Fetcher down, e.g. while a laptop runs on battery or a device
+// runs hot. The zero value means full speed.
+type Throttle struct {
+	Workers   float64 // Share of the workers that may download at once, 0 for all; at least one always may
+	Bandwidth int64   // Aggregate bytes per second on top of WithMaxBandwidth, 0 for no extra limit
+}
+
+// ThrottleFunc reports how far to scale down, typically derived from the battery
+// level or thermal state of the device.
+type ThrottleFunc func() Throttle
+
+// WithThrottleHook consults fn when the Fetcher starts and then every interval
+// while it runs, targeting long bulk downloads on laptops and embedded devices.
+// A smaller share of workers applies to downloads starting afterwards, running
+// downloads finish; the bandwidth limit applies to running downloads right away.
+func WithThrottleHook(interval time.Duration, fn ThrottleFunc) FetcherOption {
+	return func(f *Fetcher) {
+		t := &throttleHook{interval: interval, fn: fn, bandwidth: newTokenBucket(0)}
+		t.cond = sync.NewCond(&t.mu)
+		f.throttle = t
+	}
+}
+
+// throttleHook applies the Throttle returned by the hook.
+type throttleHook struct {
+	interval  time.Duration
+	fn        ThrottleFunc
+	bandwidth *tokenBucket
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int // Downloads allowed at once, 0 for no limit
+}
+
+// run polls the hook every interval until stopChan is closed. workers returns
+// the current worker count the share applies to.
+func (t *throttleHook) run(stopChan <-chan struct{}, workers func() int) {
+	ticker := time.NewTicker(max(t.interval, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.apply(t.fn(), workers())
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// apply sets the limits for the given Throttle.
+func (t *throttleHook) apply(th Throttle, workers int) {
+	if th.Bandwidth != t.bandwidth.limit() {
+		t.bandwidth.setRate(th.Bandwidth)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit = 0
+	if th.Workers > 0 && th.Workers < 1 {
+		t.limit = max(1, int(math.Ceil(float64(workers)*th.Workers)))
+	}
+	t.cond.Broadcast()
+}
+
+// acquire waits until a download may start. It returns the error of ctx if ctx
+// is done first.
+func (t *throttleHook) acquire(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := waitCond(ctx, t.cond, func() bool { return t.limit == 0 || t.active < t.limit }); err != nil {
+		return err
+	}
+	t.active++
+	return nil
+}
+
+// release frees the slot of a finished download.
+func (t *throttleHook) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	t.cond.Broadcast()
+}
@@ -0,0 +1,71 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ThroughputResult is the outcome of MeasureThroughput.
+type ThroughputResult struct {
+	Latency        time.Duration // Time until the response headers arrived
+	Bytes          int64         // Bytes received during the measurement
+	Duration       time.Duration // Time spent receiving the body
+	BytesPerSecond float64
+}
+
+// MeasureThroughput downloads url without storing it, for at most the given duration
+// or until the body ends, using the Fetcher's HTTP client. Apps can use it to
+// calibrate worker counts and other settings for a link or an origin.
+func (f *Fetcher) MeasureThroughput(ctx context.Context, url string, duration time.Duration) (ThroughputResult, error) {
+	var result ThroughputResult
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return result, err
+	}
+
+	started := time.Now()
+	resp, err := f.requestClient.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	result.Latency = time.Since(started)
+
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("failed to measure throughput: %s, status code: %d", url, resp.StatusCode)
+	}
+
+	// Abort the transfer once the measurement window is over
+	timer := time.AfterFunc(duration, cancel)
+	defer timer.Stop()
+
+	bodyStarted := time.Now()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		result.Bytes += int64(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				return result, err
+			}
+			break
+		}
+	}
+
+	result.Duration = time.Since(bodyStarted)
+	if result.Duration > duration {
+		result.Duration = duration
+	}
+	if result.Duration > 0 {
+		result.BytesPerSecond = float64(result.Bytes) / result.Duration.Seconds()
+	}
+	return result, nil
+}
@@ -0,0 +1,125 @@
+package dlfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Tracer starts the spans of downloads, see WithTracer. It mirrors the part of the
+// OpenTelemetry API dlfetch needs, so an adapter over a trace.Tracer and the
+// global propagator takes a few lines, without dlfetch depending on OpenTelemetry:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attrs map[string]any) (context.Context, dlfetch.Span) {
+//		ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(toKeyValues(attrs)...))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	func (t otelTracer) Inject(ctx context.Context, h http.Header) {
+//		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+//	}
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, and returns a
+	// context carrying the new span. Attribute values are strings, ints, int64s
+	// or bools.
+	Start(ctx context.Context, name string, attrs map[string]any) (context.Context, Span)
+	// Inject adds the trace context of the span in ctx to the headers of an
+	// outbound request, e.g. as W3C traceparent.
+	Inject(ctx context.Context, h http.Header)
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	SetAttributes(attrs map[string]any)
+	RecordError(err error) // Also marks the span as failed
+	End()
+}
+
+// WithTracer traces each download as a span "dlfetch.download", with a child span
+// "dlfetch.attempt" per attempt and one per HTTP request within it, redirects
+// included, named after the method. The trace context is propagated to the
+// servers in the headers of the requests. Downloads continue the trace of the
+// context passed to Download and EnqueueCtx.
+func WithTracer(t Tracer) FetcherOption {
+	return func(f *Fetcher) {
+		f.tracer = t
+		f.transportWrappers = append(f.transportWrappers, func(base http.RoundTripper) http.RoundTripper {
+			return &tracingTransport{base: base, tracer: t}
+		})
+	}
+}
+
+// startSpan starts a span as a child of the context of req and makes it the new
+// context of req. The returned function ends the span, recording err; a pause is
+// not a failure, the download continues in a new span once resumed.
+func (f *Fetcher) startSpan(req *DownloadRequest, name string, attrs map[string]any) func(err error, attrs map[string]any) {
+	if f.tracer == nil {
+		return func(error, map[string]any) {}
+	}
+	ctx, span := f.tracer.Start(req.context(), name, attrs)
+	req.ctx = ctx
+	return func(err error, attrs map[string]any) {
+		if len(attrs) > 0 {
+			span.SetAttributes(attrs)
+		}
+		if errors.Is(err, ErrPaused) {
+			span.SetAttributes(map[string]any{"dlfetch.paused": true})
+		} else if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// downloadSpanAttributes describes the result of a download on its span.
+func downloadSpanAttributes(result DownloadResult, attempts int) map[string]any {
+	attrs := map[string]any{"dlfetch.attempts": attempts}
+	if result.Size > 0 {
+		attrs["dlfetch.size"] = result.Size
+	}
+	if result.Path != "" {
+		attrs["dlfetch.path"] = result.Path
+	}
+	return attrs
+}
+
+// tracingTransport starts a span per HTTP request and propagates its trace context.
+type tracingTransport struct {
+	base   http.RoundTripper
+	tracer Tracer
+}
+
+func (t *tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	attrs := map[string]any{
+		"http.request.method": r.Method,
+		"url.full":            redactURL(r.URL),
+		"server.address":      r.URL.Hostname(),
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		attrs["http.request.header.range"] = rng
+	}
+	ctx, span := t.tracer.Start(r.Context(), r.Method, attrs)
+
+	// A RoundTripper must not modify the request
+	r = r.Clone(ctx)
+	t.tracer.Inject(ctx, r.Header)
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	attrs = map[string]any{"http.response.status_code": resp.StatusCode}
+	if loc := resp.Header.Get("Location"); loc != "" {
+		attrs["http.response.header.location"] = redactedURL(loc)
+	}
+	span.SetAttributes(attrs)
+	if resp.StatusCode >= 400 {
+		span.RecordError(&HTTPStatusError{URL: redactURL(r.URL), StatusCode: resp.StatusCode})
+	}
+	// The body is streamed long after RoundTrip returns, the span covers until
+	// the headers arrived
+	span.End()
+	return resp, nil
+}
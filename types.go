@@ -1,6 +1,10 @@
 package dlfetch
 
-import "time"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type DownloadRequest struct {
 	ID       int
@@ -8,20 +12,92 @@ type DownloadRequest struct {
 	FileName string
 	Path     string // Path will be optional; if empty, use only FileName and targetDir
 	MimeType string
-	FullPath string // Computed after enqueuing
+	FullPath string            // Computed after enqueuing
+	Sink     string            // Name of a sink registered with WithSink to upload the completed file to
+	Vars     map[string]string // Template variables for FileName, Path and post-processors
+	Preset   string            // Name of a preset registered with WithPreset
+	MaxSpeed int64             // Bytes per second for this download on top of the global limit, 0 for no own limit
+	Tenant   string            // User or job the request belongs to, see WithTenantQuota
+	Range    *ByteRange        // Download only this part of the remote file, nil for all of it
+	Headers  map[string]string // Extra HTTP headers sent with the download, e.g. Referer or Authorization
+	Proxy    string            // Proxy URL for this download instead of the one set with WithProxy
+	Mirrors  []string          // Alternative URLs of the same file, tried in order when URL fails
+
+	// Writer, if set, receives the content instead of a file in the target directory,
+	// e.g. a buffer, a socket or an encryption layer. It is written to as the data
+	// arrives and is not closed. Like a stream to standard output, such a download is
+	// not staged, resumed, segmented or post-processed.
+	Writer io.Writer
+
+	// ContentCheck, if set, asserts what the start of the content looks like, see
+	// WithContentCheck. It replaces the Fetcher-wide check.
+	ContentCheck *ContentCheck
+
+	// BandwidthWeight is the share of the bandwidth this download gets relative to
+	// the others running with WithFairBandwidth, 0 for 1.
+	BandwidthWeight float64
+
+	// RetryBudget, if set, limits the retries of this request together with all
+	// other requests sharing the budget, see WithRetries.
+	RetryBudget *RetryBudget
+
+	// URLProvider, if set, returns the URL to download from when a worker starts the
+	// download, e.g. to presign a short-lived URL only once the request leaves the queue.
+	// URL is then only used to name the file and group requests by host; if it is empty
+	// FileName is required.
+	URLProvider func(ctx context.Context) (string, error)
+
+	seq         uint64                  // Enqueue order, used for ordered completion
+	ctx         context.Context         // Set by EnqueueCtx, nil means the download cannot be cancelled
+	cancel      context.CancelCauseFunc // Cancels ctx, set once the request is queued
+	parent      context.Context         // Context given to EnqueueCtx, ctx is derived from it
+	overwrite   bool                    // Replace the file regardless of the overwrite setting, set by EnqueueIfChanged
+	mirrorOf    string                  // URL of the request when URL is one of its mirrors
+	nameFromURL bool                    // FileName was derived from the URL, a Content-Disposition name replaces it
+	batch       *Batch                  // Batch the request was enqueued with, see EnqueueBatch
+	share       *shareTask              // Share of the bandwidth while running, see WithFairBandwidth
+	activity    *taskActivity           // Stage and progress while being worked on, see DebugState
+	checksum    string                  // Expected hex encoded digest, see WithChecksumLookup and ParseBatch
+	checksumAlg string                  // Algorithm of checksum as in digestAlgorithms, SHA-256 if empty
+}
+
+// context returns the context the request was enqueued with.
+func (r DownloadRequest) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ByteRange selects a part of a remote file.
+type ByteRange struct {
+	Offset int64 // First byte
+	Length int64 // Number of bytes, 0 for up to the end of the file
 }
 
 type EnqueueResult struct {
 	Request DownloadRequest
 	Queued  bool
+	Skipped bool // The target file exists and was left alone, see OverwriteSkip
 	Error   error
 }
 
 type DownloadResult struct {
-	ID       int
-	FileName string
-	Path     string
-	MimeType string
+	ID               int
+	FileName         string
+	Path             string
+	MimeType         string
+	RemoteURL        string            // Location of the uploaded copy when the request used a sink
+	Vars             map[string]string // Template variables carried over from the request
+	Size             int64             // Number of bytes written
+	SHA256           string            // Hex encoded SHA-256 of the downloaded content
+	ETag             string            // ETag header of the response, if any
+	LastModified     string            // Last-Modified header of the response, if any
+	OriginalFileName string            // FileName before the file was renamed after the server's name or its type, empty if it was not
+	ServerFileName   string            // File name from the Content-Disposition header, if any
+	FinalURL         string            // URL the file was served from, after following redirects
+	VerifiedDigests  []string          // Algorithms of the server-sent digests the content matched, e.g. "md5" or "sha-256"
+	Skipped          bool              // The file existed and was left alone, see OverwriteSkip
 }
 
 // Download Monitoring
@@ -32,22 +108,31 @@ const (
 	StatusInProgress DownloadStatus = "in_progress"
 	StatusCompleted  DownloadStatus = "completed"
 	StatusFailed     DownloadStatus = "failed"
+	StatusCancelled  DownloadStatus = "cancelled"
+	StatusPaused     DownloadStatus = "paused"
 )
 
+// finished reports whether a task with the status is done for good.
+func (s DownloadStatus) finished() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
 type DownloadTask struct {
 	ID            int            `json:"id"`
 	FileName      string         `json:"fileName"`
 	FilePath      string         `json:"filePath"`
 	TotalBytes    int64          `json:"totalBytes"`
 	DoneBytes     int64          `json:"doneBytes"`
+	NetworkBytes  int64          `json:"networkBytes,omitempty"` // Compressed bytes received, set with WithContentDecoders for compressed responses
+	NetworkTotal  int64          `json:"networkTotal,omitempty"` // Compressed size, 0 if unknown or not compressed
 	Status        DownloadStatus `json:"status"`
 	Error         string         `json:"error,omitempty"`
-	StartedAt     time.Time      `json:"startedAt"`
-	CompletedAt   *time.Time     `json:"completedAt,omitempty"`
+	StartedAt     time.Time      `json:"startedAt"`             // When the first byte arrived, zero while pending
+	CompletedAt   *time.Time     `json:"completedAt,omitempty"` // When the task completed, failed or was cancelled
 	DownloadSpeed float64        `json:"downloadSpeed"`
 	ETA           string         `json:"eta"`
-	QueuePosition int            `json:"queuePosition"`
-	EnqueuedAt    time.Time      `json:"enqueuedAt"`
+	QueuePosition int            `json:"queuePosition"` // 1 for the next task a worker takes from the queue, 0 when not queued
+	EnqueuedAt    time.Time      `json:"enqueuedAt"`    // When the task was queued, or queued again for a retry or after a pause
 }
 
 type TaskStatusCount struct {
@@ -56,14 +141,27 @@ type TaskStatusCount struct {
 	InProgress int `json:"in_progress"`
 	Completed  int `json:"completed"`
 	Failed     int `json:"failed"`
+	Cancelled  int `json:"cancelled"`
+	Paused     int `json:"paused"`
+	Cleared    int `json:"cleared"` // Tasks removed by TaskMonitor.Clear that left a tombstone, included in the counts above
 }
 
 type MonitorSnapshot struct {
-	Tasks []DownloadTask  `json:"tasks"`
-	Count TaskStatusCount `json:"count"`
+	Fetcher string          `json:"fetcher,omitempty"` // Name of the Fetcher, see WithName
+	Tasks   []DownloadTask  `json:"tasks"`
+	Count   TaskStatusCount `json:"count"`
+
+	Tombstones []Tombstone `json:"tombstones,omitempty"` // Tasks removed by TaskMonitor.Clear, see WithTombstones
+}
+
+// Tombstone is what remains of a task removed by TaskMonitor.Clear.
+type Tombstone struct {
+	ID          int            `json:"id"`
+	Status      DownloadStatus `json:"status"`
+	CompletedAt time.Time      `json:"completedAt"`
 }
 
 type pendingTask struct {
-	id         int
-	enqueuedAt time.Time
+	id  int
+	seq uint64 // Order in which the task was queued
 }
@@ -0,0 +1,132 @@
+package dlfetch
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// URLPolicy decides whether a URL may be requested, returning an error to block it.
+type URLPolicy func(*url.URL) error
+
+// WithURLPolicy checks every URL the Fetcher requests against p: request URLs and
+// mirrors when they are enqueued, and every outgoing HTTP request including
+// redirects and URLs from a URLProvider before it is sent. Blocked URLs fail with
+// an error matching ErrURLBlocked. Several policies can be added, all must pass.
+// Services downloading user-supplied URLs can combine HTTPOnly, BlockLocalhost and
+// BlockPrivateIPs, or use WithBlockPrivateNetworks, which also checks where host
+// names resolve to.
+func WithURLPolicy(p func(*url.URL) error) FetcherOption {
+	return func(f *Fetcher) {
+		f.urlPolicies = append(f.urlPolicies, p)
+	}
+}
+
+// WithBlockPrivateNetworks keeps downloads away from the loopback, private,
+// link-local and other non-public networks, as needed against server-side request
+// forgery: URLs naming such hosts are blocked, and connections to addresses in these
+// networks are refused at dial time, whatever a host name resolved to. Proxies are
+// refused as well, those of WithProxy, DownloadRequest.Proxy and the environment
+// alike, since a proxy resolves and connects to hosts on its own where the check
+// cannot follow. The dial time check only applies when the client uses an
+// *http.Transport.
+func WithBlockPrivateNetworks() FetcherOption {
+	return func(f *Fetcher) {
+		f.urlPolicies = append(f.urlPolicies, BlockLocalhost, BlockPrivateIPs)
+		if f.dialer == nil {
+			f.dialer = &dialer{}
+		}
+		f.dialer.blockPrivate = true
+	}
+}
+
+// HTTPOnly is a URLPolicy that blocks schemes other than http and https.
+func HTTPOnly(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+	return nil
+}
+
+// BlockLocalhost is a URLPolicy that blocks localhost and loopback addresses.
+func BlockLocalhost(u *url.URL) error {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("host %s is local", u.Hostname())
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && addr.Unmap().IsLoopback() {
+		return fmt.Errorf("address %s is local", host)
+	}
+	return nil
+}
+
+// BlockPrivateIPs is a URLPolicy that blocks URLs whose host is an IP address
+// outside the public internet, e.g. 10.0.0.1 or the cloud metadata address
+// 169.254.169.254. Host names are not resolved, see WithBlockPrivateNetworks.
+func BlockPrivateIPs(u *url.URL) error {
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !isPublicAddr(addr) {
+		return fmt.Errorf("address %s is not public", addr)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether addr can be reached on the public internet.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkURL runs the URL policies on rawURL.
+func (f *Fetcher) checkURL(rawURL string) error {
+	if len(f.urlPolicies) == 0 {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return f.checkParsedURL(u)
+}
+
+func (f *Fetcher) checkParsedURL(u *url.URL) error {
+	for _, p := range f.urlPolicies {
+		if err := p(u); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrURLBlocked, redactURL(u), err)
+		}
+	}
+	return nil
+}
+
+// validateURLs checks the URL and mirrors of the request against the URL policies.
+func (f *Fetcher) validateURLs(req DownloadRequest) error {
+	for _, rawURL := range append([]string{req.URL}, req.Mirrors...) {
+		if rawURL == "" {
+			continue
+		}
+		if err := f.checkURL(rawURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policyTransport checks every outgoing request against the URL policies.
+type policyTransport struct {
+	base    http.RoundTripper
+	fetcher *Fetcher
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.fetcher.checkParsedURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,126 @@
+package dlfetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestEntry is a file that should exist locally, together with the request
+// that downloads it.
+type ManifestEntry struct {
+	Request DownloadRequest // Its FullPath is computed like Enqueue does
+	Size    int64           // Expected size, 0 or less to skip the check
+	SHA256  string          // Expected hex encoded SHA-256, empty to skip the check
+
+	// BlockSize and Blocks, if set, allow Repair to fetch only the damaged parts of
+	// the file: Blocks holds the hex encoded SHA-256 of every BlockSize bytes of it,
+	// the last block may be shorter. They need Size to be set.
+	BlockSize int64
+	Blocks    []string
+}
+
+// Verify checks the local files of the manifest against their expected size and
+// checksum without downloading anything. It returns the requests of the files
+// that are missing or do not match, ready to be passed to EnqueueMany to repair
+// them; mismatching files are replaced whatever the overwrite setting. The error
+// lists why each of them failed and is nil if all files are fine.
+func (f *Fetcher) Verify(manifest []ManifestEntry) ([]DownloadRequest, error) {
+	return f.verify(context.Background(), manifest, false)
+}
+
+// verify checks the files of the manifest and, with repair set, tries to fix
+// damaged files that have block hashes before giving up on them.
+func (f *Fetcher) verify(ctx context.Context, manifest []ManifestEntry, repairBlocks bool) ([]DownloadRequest, error) {
+	var repair []DownloadRequest
+	var errs []error
+	for _, entry := range manifest {
+		req := entry.Request
+		if err := f.resolvePath(&req); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", req.URL, err))
+			continue
+		}
+		err := verifyFile(req.FullPath, entry.Size, entry.SHA256)
+		if err != nil && repairBlocks && len(entry.Blocks) > 0 && !errors.Is(err, fs.ErrNotExist) {
+			if err = f.repairBlocks(ctx, req, entry); err == nil {
+				err = verifyFile(req.FullPath, entry.Size, entry.SHA256)
+			}
+		}
+		if err != nil {
+			req.overwrite = true
+			repair = append(repair, req)
+			errs = append(errs, err)
+		}
+	}
+	return repair, errors.Join(errs...)
+}
+
+// verifyFile checks the size and checksum of the file at path.
+func verifyFile(path string, size int64, sum string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if size > 0 && info.Size() != size {
+		return fmt.Errorf("%w: %s has %d bytes, want %d", ErrVerifyFailed, path, info.Size(), size)
+	}
+	if sum == "" {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, in); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, sum) {
+		return fmt.Errorf("%w: checksum of %s is %s, want %s", ErrVerifyFailed, path, got, sum)
+	}
+	return nil
+}
+
+// ManifestFromReport turns the completed entries of a report, e.g. one saved with
+// Report(ReportJSON) after an earlier run, into a manifest for Verify. Entries
+// outside the target directory and split downloads are left out.
+func (f *Fetcher) ManifestFromReport(entries []ReportEntry) []ManifestEntry {
+	p := f.currentPolicy()
+
+	var manifest []ManifestEntry
+	for _, e := range entries {
+		if e.Status != StatusCompleted || strings.HasSuffix(e.Path, ManifestSuffix) {
+			continue
+		}
+		rel, err := filepath.Rel(p.targetDir, e.Path)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		dir := filepath.Dir(rel)
+		if p.mirrorRemotePath {
+			// resolvePath adds the remote directory again
+			dir = strings.TrimSuffix(dir, remotePathDir(e.URL))
+		}
+		manifest = append(manifest, ManifestEntry{
+			Request: DownloadRequest{
+				ID:       e.ID,
+				URL:      e.URL,
+				FileName: filepath.Base(rel),
+				Path:     dir,
+			},
+			Size:   e.Size,
+			SHA256: e.SHA256,
+		})
+	}
+	return manifest
+}
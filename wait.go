@@ -0,0 +1,42 @@
+package dlfetch
+
+// Wait blocks until every enqueued request has completed or failed, including
+// requests enqueued while waiting. Paused requests count as unfinished until they
+// are resumed and done, or cancelled. Waiting on a Fetcher that is not running
+// blocks until it is started and works through its queue.
+func (f *Fetcher) Wait() {
+	f.outstandingMu.Lock()
+	defer f.outstandingMu.Unlock()
+	for f.outstanding > 0 {
+		f.idle.Wait()
+	}
+}
+
+// Drain stops accepting new requests, waits for the queued and running ones to
+// finish and then stops the Fetcher. Enqueue returns ErrStopped from the moment
+// Drain is called. Like Wait it needs a running Fetcher to return.
+func (f *Fetcher) Drain() {
+	f.stateMu.Lock()
+	f.draining = true
+	f.stateMu.Unlock()
+
+	f.Wait()
+	f.Stop()
+}
+
+// track counts a request that was handed to the queue.
+func (f *Fetcher) track() {
+	f.outstandingMu.Lock()
+	defer f.outstandingMu.Unlock()
+	f.outstanding++
+}
+
+// untrack marks a tracked request as finished.
+func (f *Fetcher) untrack() {
+	f.outstandingMu.Lock()
+	defer f.outstandingMu.Unlock()
+	f.outstanding--
+	if f.outstanding == 0 {
+		f.idle.Broadcast()
+	}
+}
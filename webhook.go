@@ -0,0 +1,225 @@
+package dlfetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook events.
+const (
+	WebhookCompleted = "download.completed"
+	WebhookFailed    = "download.failed"
+)
+
+// Headers of webhook deliveries.
+const (
+	WebhookEventHeader     = "X-Dlfetch-Event"
+	WebhookDeliveryHeader  = "X-Dlfetch-Delivery"  // Unique per payload and kept across retries, to discard duplicates
+	WebhookTimestampHeader = "X-Dlfetch-Timestamp" // Unix time the payload was signed at
+	WebhookSignatureHeader = "X-Dlfetch-Signature" // "sha256=" and the hex encoded HMAC, see Webhook.Secret
+)
+
+const (
+	defaultWebhookRetries = 3
+	webhookTimeout        = 10 * time.Second
+)
+
+// Webhook posts a JSON WebhookPayload to URL for every queued download that
+// completes or fails, so services can react to downloads asynchronously, see
+// WithWebhook.
+type Webhook struct {
+	URL     string
+	Headers map[string]string // Extra headers, e.g. Authorization
+	Client  *http.Client      // nil for http.DefaultClient
+
+	// Secret, if set, signs every delivery: the X-Dlfetch-Signature header holds
+	// the HMAC-SHA256 of the X-Dlfetch-Timestamp header, a dot and the body, keyed
+	// with Secret. Receivers check it with VerifyWebhook.
+	Secret string
+
+	// Retries is how often a delivery is retried after a network error or a 429 or
+	// 5xx response, waiting 1s, 2s, 4s... in between. 0 retries 3 times, a negative
+	// value never.
+	Retries int
+}
+
+// WebhookPayload is the body of a webhook delivery.
+type WebhookPayload struct {
+	Event      string            `json:"event"`             // WebhookCompleted or WebhookFailed
+	Fetcher    string            `json:"fetcher,omitempty"` // Name of the Fetcher, see WithName
+	ID         int               `json:"id"`
+	URL        string            `json:"url"` // Without password and query
+	FileName   string            `json:"fileName,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Size       int64             `json:"size,omitempty"`
+	SHA256     string            `json:"sha256,omitempty"`
+	MimeType   string            `json:"mimeType,omitempty"`
+	Vars       map[string]string `json:"vars,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	StatusCode int               `json:"statusCode,omitempty"` // HTTP status code of a failed response
+	Time       time.Time         `json:"time"`
+}
+
+// WithWebhook delivers the outcome of every queued download to w, along with
+// onComplete and onError. Deliveries run in the background and do not hold up the
+// workers; Stop waits for those still being retried. Downloads cancelled through
+// their context and downloads started with Download are not delivered. The option
+// can be given several times for several webhooks.
+func WithWebhook(w Webhook) FetcherOption {
+	return func(f *Fetcher) {
+		f.webhooks.hooks = append(f.webhooks.hooks, w)
+	}
+}
+
+// webhooks delivers outcomes to the webhooks of a Fetcher.
+type webhooks struct {
+	hooks []Webhook
+	wg    sync.WaitGroup // Deliveries in flight
+}
+
+// send delivers the outcome of req to every webhook.
+func (w *webhooks) send(f *Fetcher, req DownloadRequest, result DownloadResult, err error) {
+	if len(w.hooks) == 0 || err != nil && req.context().Err() != nil {
+		return
+	}
+
+	payload := WebhookPayload{
+		Event:   WebhookCompleted,
+		Fetcher: f.name,
+		ID:      req.ID,
+		URL:     redactedURL(req.URL),
+		Vars:    req.Vars,
+		Time:    time.Now().UTC(),
+	}
+	if err != nil {
+		payload.Event = WebhookFailed
+		payload.FileName = req.FileName
+		payload.Path = req.FullPath
+		payload.Error = err.Error()
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			payload.StatusCode = statusErr.StatusCode
+		}
+	} else {
+		payload.FileName = result.FileName
+		payload.Path = result.Path
+		payload.Size = result.Size
+		payload.SHA256 = result.SHA256
+		payload.MimeType = result.MimeType
+		payload.Vars = result.Vars
+	}
+	body, jsonErr := json.Marshal(payload)
+	if jsonErr != nil {
+		f.logger.Error("encoding webhook payload failed", "id", req.ID, "error", jsonErr)
+		return
+	}
+	delivery := rand.Text()
+
+	for _, hook := range w.hooks {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			if err := hook.deliver(payload.Event, delivery, body); err != nil {
+				f.logger.Warn("webhook delivery failed", "id", req.ID, "webhook", redactedURL(hook.URL), "error", err)
+			}
+		}()
+	}
+}
+
+// wait blocks until all deliveries are done.
+func (w *webhooks) wait() {
+	w.wg.Wait()
+}
+
+// deliver posts body, retrying as configured.
+func (h Webhook) deliver(event, delivery string, body []byte) error {
+	retries := h.Retries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(event, delivery, body)
+		if err == nil || !retry || attempt > retries {
+			return err
+		}
+		time.Sleep(retryDelay(attempt))
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying.
+func (h Webhook) post(event, delivery string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, delivery)
+	if h.Secret != "" {
+		// Signed anew on every attempt, so the timestamp stays fresh
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+webhookSignature(h.Secret, timestamp, body))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook delivery with the headers h and
+// the body, for receivers of a Webhook with a Secret. Deliveries signed longer than
+// maxAge ago are rejected to prevent replays, 0 accepts any age.
+func VerifyWebhook(secret string, h http.Header, body []byte, maxAge time.Duration) error {
+	timestamp := h.Get(WebhookTimestampHeader)
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("webhook delivery is not signed")
+	}
+	if maxAge > 0 && time.Since(time.Unix(signed, 0)) > maxAge {
+		return errors.New("webhook delivery is too old")
+	}
+	got, ok := bytes.CutPrefix([]byte(h.Get(WebhookSignatureHeader)), []byte("sha256="))
+	want := webhookSignature(secret, timestamp, body)
+	if !ok || !hmac.Equal(got, []byte(want)) {
+		return errors.New("webhook signature does not match")
+	}
+	return nil
+}